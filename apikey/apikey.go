@@ -0,0 +1,356 @@
+package apikey
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/conditions"
+	"github.com/TykTechnologies/tyk-k8s/crd"
+	"github.com/TykTechnologies/tyk-k8s/logger"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	resource = "apikeys"
+
+	kind = "ApiKey"
+
+	// finalizer keeps deleted ApiKeys around until their key is revoked
+	finalizer = "tyk.io/revoke-key"
+	// defaultSecretKey is the secret data key holding the key when the spec sets none
+	defaultSecretKey = "key"
+)
+
+var log = logger.GetLogger("apikey")
+var ctrl *Controller
+
+// Config for the ApiKey controller
+type Config struct {
+	// Enabled creates gateway keys for ApiKey resources and stores them in secrets
+	Enabled     bool `yaml:"enabled"`
+	SyncSeconds int  `yaml:"syncSeconds"`
+	// AllowedPolicies are policy IDs keys may apply per namespace, on top of the policies
+	// generated for the ingresses of the namespace
+	AllowedPolicies map[string][]string `yaml:"allowedPolicies"`
+}
+
+// SecretRef is the secret the key is written to, in the namespace of the ApiKey
+type SecretRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+type Spec struct {
+	Alias string `json:"alias"`
+	// Policies are the IDs of the policies the key applies
+	Policies []string `json:"policies"`
+	// Expires is an RFC3339 time, keys without it never expire
+	Expires  string            `json:"expires"`
+	MetaData map[string]string `json:"metadata"`
+	Secret   *SecretRef        `json:"secret"`
+}
+
+type Status struct {
+	// KeyHash identifies the key when the gateway hashes keys, unhashed keys are read back
+	// from the secret instead so the key itself is not in the status
+	KeyHash    string                 `json:"keyHash,omitempty"`
+	Conditions []conditions.Condition `json:"conditions,omitempty"`
+}
+
+type ApiKey struct {
+	Metadata crd.Metadata `json:"metadata"`
+	Spec     Spec         `json:"spec"`
+	Status   Status       `json:"status"`
+}
+
+type apiKeyList struct {
+	Items []ApiKey `json:"items"`
+}
+
+// Controller reconciles the ApiKeys listed by its poller
+type Controller struct {
+	cfg    *Config
+	poller *crd.Poller
+}
+
+func NewController() *Controller {
+	if ctrl == nil {
+		ctrl = &Controller{poller: crd.NewPoller(kind, resource)}
+	}
+
+	return ctrl
+}
+
+func GetController() *Controller {
+	return NewController()
+}
+
+func (c *Controller) Config(cfg *Config) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	c.cfg = cfg
+}
+
+func (c *Controller) Start() error {
+	if c.cfg == nil || !c.cfg.Enabled {
+		return nil
+	}
+
+	return c.poller.Start(c.cfg.SyncSeconds, c.reconcile)
+}
+
+func (c *Controller) Stop() error {
+	return c.poller.Stop()
+}
+
+func (c *Controller) reconcile() error {
+	aks := &apiKeyList{}
+	if err := c.poller.List(aks); err != nil {
+		return err
+	}
+
+	for i := range aks.Items {
+		ak := &aks.Items[i]
+		if ak.Metadata.DeletionTimestamp != "" {
+			c.revoke(ak)
+			continue
+		}
+
+		st := c.sync(ak)
+		if conditions.SameJSON(st, ak.Status) {
+			continue
+		}
+
+		if err := c.poller.PatchStatus(&ak.Metadata, st); err != nil {
+			log.Error("failed to update api key status: ", err)
+		}
+	}
+
+	return nil
+}
+
+// revoke deletes the key of a deleted ApiKey and then lets it go, the secret is removed by
+// the garbage collector through its owner reference
+func (c *Controller) revoke(ak *ApiKey) {
+	if !ak.Metadata.HasFinalizer(finalizer) {
+		return
+	}
+
+	id, hashed := ak.Status.KeyHash, true
+	if id == "" {
+		sec, err := c.secret(ak)
+		if err != nil {
+			log.Errorf("failed to read key of %s: %v", ak.source(), err)
+			return
+		}
+		id, hashed = secretKey(ak, sec), false
+	}
+
+	if id != "" {
+		if err := tyk.DeleteKey(id, hashed); err != nil {
+			log.Errorf("failed to revoke key of %s: %v", ak.source(), err)
+			return
+		}
+	}
+
+	if err := c.poller.RemoveFinalizer(&ak.Metadata, finalizer); err != nil {
+		log.Errorf("failed to remove finalizer of %s: %v", ak.source(), err)
+	}
+}
+
+// secret returns the target secret, nil when it doesn't exist. Secrets the ApiKey doesn't
+// own are refused so a key can't overwrite unrelated credentials
+func (c *Controller) secret(ak *ApiKey) (*v1.Secret, error) {
+	sec, err := c.poller.Client().CoreV1().Secrets(ak.Metadata.Namespace).Get(ak.secretName(), v12.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range sec.OwnerReferences {
+		if string(o.UID) == ak.Metadata.UID {
+			return sec, nil
+		}
+	}
+
+	return nil, fmt.Errorf("secret %s/%s exists and is not owned by the ApiKey", ak.Metadata.Namespace, sec.Name)
+}
+
+func secretKey(ak *ApiKey, sec *v1.Secret) string {
+	if sec == nil {
+		return ""
+	}
+
+	return string(sec.Data[ak.secretDataKey()])
+}
+
+func (c *Controller) sync(ak *ApiKey) Status {
+	st := ak.Status
+	result := func(reason string, err error) Status {
+		st.Conditions = conditions.Reconciled(st.Conditions, ak.Metadata.Generation, reason, err, true)
+		return st
+	}
+
+	spec, err := ak.keySpec()
+	if err != nil {
+		return result("InvalidSpec", err)
+	}
+
+	ns := ak.Metadata.Namespace
+	if err := tyk.CheckPolicies(ns, spec.Policies, c.cfg.AllowedPolicies[ns]); err != nil {
+		return result("PolicyNotAllowed", err)
+	}
+
+	sec, err := c.secret(ak)
+	if err != nil {
+		return result("SecretConflict", err)
+	}
+
+	if err := c.poller.AddFinalizer(&ak.Metadata, finalizer); err != nil {
+		return result("SyncFailed", err)
+	}
+
+	key := secretKey(ak, sec)
+	if key == "" {
+		// the key can't be read back from its hash, a lost secret gets a new key and the
+		// old one is revoked
+		if st.KeyHash != "" {
+			if err := tyk.DeleteKey(st.KeyHash, true); err != nil {
+				return result("SyncFailed", err)
+			}
+			st.KeyHash = ""
+		}
+
+		k, err := tyk.CreateKey(spec)
+		if err != nil {
+			return result("SyncFailed", err)
+		}
+
+		st.KeyHash = k.Hash
+		if err := c.writeSecret(ak, sec, k.Key); err != nil {
+			// without the secret the key is unusable, revoke it and try again next time
+			if err := tyk.DeleteKey(k.Key, false); err != nil {
+				log.Errorf("failed to revoke unsaved key of %s: %v", ak.source(), err)
+			}
+			st.KeyHash = ""
+			return result("SyncFailed", err)
+		}
+
+		return result("", nil)
+	}
+
+	// keys follow spec changes, the generation tells whether the spec moved on
+	ready := conditions.Get(st.Conditions, conditions.Ready)
+	if ready.Status == conditions.True && ready.ObservedGeneration == ak.Metadata.Generation {
+		return st
+	}
+
+	id, hashed := key, false
+	if st.KeyHash != "" {
+		id, hashed = st.KeyHash, true
+	}
+
+	if err := tyk.UpdateKey(id, hashed, spec); err != nil {
+		return result("SyncFailed", err)
+	}
+
+	return result("", nil)
+}
+
+func (c *Controller) writeSecret(ak *ApiKey, sec *v1.Secret, key string) error {
+	if sec != nil {
+		sec = sec.DeepCopy()
+		if sec.Data == nil {
+			sec.Data = map[string][]byte{}
+		}
+		sec.Data[ak.secretDataKey()] = []byte(key)
+		_, err := c.poller.Client().CoreV1().Secrets(sec.Namespace).Update(sec)
+		return err
+	}
+
+	yes := true
+	sec = &v1.Secret{
+		ObjectMeta: v12.ObjectMeta{
+			Name:      ak.secretName(),
+			Namespace: ak.Metadata.Namespace,
+			OwnerReferences: []v12.OwnerReference{{
+				APIVersion: crd.APIVersion,
+				Kind:       kind,
+				Name:       ak.Metadata.Name,
+				UID:        types.UID(ak.Metadata.UID),
+				Controller: &yes,
+			}},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{ak.secretDataKey(): []byte(key)},
+	}
+
+	_, err := c.poller.Client().CoreV1().Secrets(sec.Namespace).Create(sec)
+	return err
+}
+
+func (ak *ApiKey) source() string {
+	return fmt.Sprintf("%s/%s/%s", kind, ak.Metadata.Namespace, ak.Metadata.Name)
+}
+
+func (ak *ApiKey) secretName() string {
+	if ak.Spec.Secret != nil && ak.Spec.Secret.Name != "" {
+		return ak.Spec.Secret.Name
+	}
+
+	return ak.Metadata.Name
+}
+
+func (ak *ApiKey) secretDataKey() string {
+	if ak.Spec.Secret != nil && ak.Spec.Secret.Key != "" {
+		return ak.Spec.Secret.Key
+	}
+
+	return defaultSecretKey
+}
+
+// keySpec converts the resource into the key session, the resource is recorded in the
+// key metadata so keys can be traced back to it
+func (ak *ApiKey) keySpec() (*tyk.KeySpec, error) {
+	s := ak.Spec
+	if len(s.Policies) == 0 {
+		return nil, fmt.Errorf("spec.policies needs at least one policy")
+	}
+
+	if _, ok := s.MetaData[tyk.SourceKey]; ok {
+		return nil, fmt.Errorf("spec.metadata can't set %s", tyk.SourceKey)
+	}
+
+	ks := &tyk.KeySpec{
+		Alias:    s.Alias,
+		Policies: s.Policies,
+		MetaData: map[string]string{tyk.SourceKey: ak.source()},
+	}
+
+	if ks.Alias == "" {
+		ks.Alias = ak.Metadata.Namespace + "/" + ak.Metadata.Name
+	}
+
+	for k, v := range s.MetaData {
+		ks.MetaData[k] = v
+	}
+
+	if s.Expires != "" {
+		t, err := time.Parse(time.RFC3339, s.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("spec.expires is not an RFC3339 time: %v", err)
+		}
+		ks.Expires = t.Unix()
+	}
+
+	return ks, nil
+}
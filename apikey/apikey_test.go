@@ -0,0 +1,88 @@
+package apikey
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TykTechnologies/tyk-k8s/conditions"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+)
+
+const webKey = `{
+  "metadata": {"name": "web", "namespace": "shop", "uid": "u1", "generation": 2, "finalizers": ["other", "tyk.io/revoke-key"]},
+  "spec": {
+    "policies": ["pol-1"],
+    "expires": "2030-01-01T00:00:00Z",
+    "metadata": {"team": "shop"},
+    "secret": {"name": "web-credentials"}
+  }
+}`
+
+func fixture(t *testing.T) *ApiKey {
+	ak := &ApiKey{}
+	if err := json.Unmarshal([]byte(webKey), ak); err != nil {
+		t.Fatal(err)
+	}
+	return ak
+}
+
+func TestKeySpec(t *testing.T) {
+	ak := fixture(t)
+	ks, err := ak.keySpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ks.Alias != "shop/web" || ks.Expires != 1893456000 || ks.Policies[0] != "pol-1" ||
+		ks.MetaData["team"] != "shop" || ks.MetaData[tyk.SourceKey] != "ApiKey/shop/web" {
+		t.Fatalf("unexpected key spec: %+v", ks)
+	}
+
+	if ak.secretName() != "web-credentials" || ak.secretDataKey() != "key" {
+		t.Fatal("unexpected secret: ", ak.secretName(), ak.secretDataKey())
+	}
+
+	ak.Spec.Expires = "tomorrow"
+	if _, err := ak.keySpec(); err == nil {
+		t.Fatal("invalid expiry times should be refused")
+	}
+
+	ak = fixture(t)
+	ak.Spec.MetaData[tyk.SourceKey] = "ApiKey/other/key"
+	if _, err := ak.keySpec(); err == nil {
+		t.Fatal("metadata should not be able to claim other keys")
+	}
+
+	ak = fixture(t)
+	ak.Spec.Policies = nil
+	if _, err := ak.keySpec(); err == nil {
+		t.Fatal("a policy is required")
+	}
+}
+
+func TestPoliciesOfOtherNamespaces(t *testing.T) {
+	theirs := tyk.PolicyID("Ingress/admin/web")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Error("nothing should be written, got ", r.Method, r.URL)
+		}
+		fmt.Fprintf(w, `{"Data": [{"_id": "m1", "id": "%s", "tags": ["%s:Ingress/admin/web"]}]}`, theirs, tyk.SourceKey)
+	}))
+	defer srv.Close()
+	tyk.Init(&tyk.TykConf{URL: srv.URL, Secret: "s", Org: "org"})
+
+	c := &Controller{cfg: &Config{}}
+	ak := fixture(t)
+	ak.Spec.Policies = []string{theirs}
+
+	st := c.sync(ak)
+	if e := conditions.Get(st.Conditions, conditions.Error); e.Status != conditions.True || e.Reason != "PolicyNotAllowed" {
+		t.Fatal("policies of other namespaces should be refused, got ", st.Conditions)
+	}
+	if st.KeyHash != "" {
+		t.Fatal("no key should be created, got ", st.KeyHash)
+	}
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"github.com/spf13/cobra"
+)
+
+var flushSlug string
+var flushIngress string
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "manages the Tyk cache of managed APIs",
+}
+
+// flushCmd busts stale cached responses after a deploy, the running controller serves the
+// same action on POST /cache/flush of the admin server
+var flushCmd = &cobra.Command{
+	Use:   "flush",
+	Short: "invalidates the cache of a managed API",
+	Long: `Invalidates the Tyk cache of a managed API, by slug or of every API of an ingress:
+
+	tyk-k8s cache flush --slug default-products
+	tyk-k8s cache flush --ingress default/products`,
+	Run: func(cmd *cobra.Command, args []string) {
+		flushed, err := flushCache(flushSlug, flushIngress)
+		for _, s := range flushed {
+			fmt.Println("flushed", s)
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	flushCmd.Flags().StringVar(&flushSlug, "slug", "", "slug of the API")
+	flushCmd.Flags().StringVar(&flushIngress, "ingress", "", "namespace/name of the ingress")
+	cacheCmd.AddCommand(flushCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
+
+// flushCache flushes the API of the slug or the APIs of the "<namespace>/<name>" ingress
+func flushCache(slug, ing string) ([]string, error) {
+	source := ""
+	if ing != "" {
+		parts := strings.Split(ing, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("ingress %q should be <namespace>/<name>", ing)
+		}
+		source = "Ingress/" + ing
+	}
+
+	return tyk.FlushCache(slug, source)
+}
+
+func cacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	flushed, err := flushCache(r.URL.Query().Get("slug"), r.URL.Query().Get("ingress"))
+	res := map[string]interface{}{"status": "ok", "flushed": flushed}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		log.Error(err)
+		res["status"] = "error"
+		res["message"] = err.Error()
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
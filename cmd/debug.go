@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"github.com/TykTechnologies/tyk-k8s/ingress"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"github.com/TykTechnologies/tyk-k8s/webserver"
+)
+
+// addDebugRoutes serves pprof and the runtime stats, they are only added with the debug
+// flag of the server config and only on the loopback debug listener as profiles expose
+// the internals of the process
+func addDebugRoutes(s *webserver.WebServer) {
+	s.AddRoute("GET", "/debug/pprof/", pprof.Index)
+	s.AddRoute("GET", "/debug/pprof/cmdline", pprof.Cmdline)
+	s.AddRoute("GET", "/debug/pprof/profile", pprof.Profile)
+	s.AddRoute("GET", "/debug/pprof/symbol", pprof.Symbol)
+	s.AddRoute("GET", "/debug/pprof/trace", pprof.Trace)
+	s.AddRoute("GET", "/debug/pprof/{profile}", pprof.Index)
+	s.AddRoute("GET", "/debug/stats", statsHandler)
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	mem := runtime.MemStats{}
+	runtime.ReadMemStats(&mem)
+
+	stats := map[string]interface{}{
+		"goroutines": runtime.NumGoroutine(),
+		"memory": map[string]uint64{
+			"heap_alloc_bytes": mem.HeapAlloc,
+			"heap_objects":     mem.HeapObjects,
+			"sys_bytes":        mem.Sys,
+			"gc_runs":          uint64(mem.NumGC),
+		},
+		"tyk":     tyk.CacheStats(),
+		"ingress": ingress.Controller().Stats(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/TykTechnologies/tyk-k8s/istio"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/spf13/cobra"
+)
+
+var importFiles []string
+var importApply bool
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "converts resources of other gateways into Tyk APIs",
+}
+
+// istioCmd prints the converted definitions so they can be reviewed, --apply pushes them
+var istioCmd = &cobra.Command{
+	Use:   "istio",
+	Short: "converts Istio VirtualServices and DestinationRules",
+	Long: `Converts Istio VirtualServices and DestinationRules into Tyk API definitions:
+
+	tyk-k8s import istio -f virtual-services.yaml -f destination-rules.yaml
+
+the definitions are printed unless --apply is set, anything that has no Tyk
+equivalent is reported as a warning on stderr`,
+	Run: func(cmd *cobra.Command, args []string) {
+		res := &istio.Resources{}
+		for _, f := range importFiles {
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			r, err := istio.Parse(data)
+			if err != nil {
+				log.Fatalf("%s: %v", f, err)
+			}
+			res.VirtualServices = append(res.VirtualServices, r.VirtualServices...)
+			res.DestinationRules = append(res.DestinationRules, r.DestinationRules...)
+		}
+
+		conv := istio.NewConverter(res)
+		apis := conv.Convert()
+		for _, w := range conv.Warnings {
+			fmt.Fprintln(os.Stderr, "warning:", w)
+		}
+
+		if importApply {
+			svcs := map[string]*tyk.APIDefOptions{}
+			for _, o := range apis {
+				svcs[o.Slug] = o
+			}
+
+			if err := tyk.UpdateAPIs(svcs); err != nil {
+				log.Fatal(err)
+			}
+			log.Infof("imported %d APIs", len(apis))
+			return
+		}
+
+		defs := make([]*apidef.APIDefinition, 0, len(apis))
+		for _, o := range apis {
+			def, err := tyk.RenderDefinition(o)
+			if err != nil {
+				log.Fatalf("%s: %v", o.Name, err)
+			}
+			defs = append(defs, def)
+		}
+
+		out, err := json.MarshalIndent(defs, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+	},
+}
+
+func init() {
+	istioCmd.Flags().StringSliceVarP(&importFiles, "file", "f", nil, "manifest files to convert")
+	istioCmd.Flags().BoolVar(&importApply, "apply", false, "push the converted APIs to Tyk")
+	importCmd.AddCommand(istioCmd)
+	rootCmd.AddCommand(importCmd)
+}
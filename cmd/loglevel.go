@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/TykTechnologies/tyk-k8s/logger"
+)
+
+// logLevelsHandler lists the module log levels, PUT /log/levels?module=ingress&level=debug
+// changes one until the next restart. It is served by the admin server only
+func logLevelsHandler(w http.ResponseWriter, r *http.Request) {
+	res := map[string]interface{}{"status": "ok"}
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPut {
+		err := logger.SetLevel(r.URL.Query().Get("module"), r.URL.Query().Get("level"))
+		if err != nil {
+			res["status"] = "error"
+			res["message"] = err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+
+		log.Infof("log level of %s set to %s", r.URL.Query().Get("module"), r.URL.Query().Get("level"))
+	}
+
+	res["levels"] = logger.Levels()
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/TykTechnologies/tyk-k8s/ingress"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var planJSON bool
+
+// planCmd prints what a sync of the managed ingresses would change for review before a
+// rollout, the running controller serves the same plan on GET /plan of the admin server
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "prints the API changes a sync would make",
+	Long: `Prints the APIs a full sync of the managed ingresses would create, update
+and delete, with the paths of the changed fields of every update. Values are
+left out as they can be read from secrets. Nothing is changed:
+
+	tyk-k8s plan
+	tyk-k8s plan --json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		iConf := &ingress.Config{}
+		if err := viper.UnmarshalKey("Ingress", iConf); err != nil {
+			log.Fatalf("couldn't read ingress config: %v", err)
+		}
+
+		ingress.NewController().Config(iConf)
+		plan, err := ingress.Controller().Plan()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if !planJSON {
+			fmt.Print(plan.String())
+			return
+		}
+
+		out, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+	},
+}
+
+func init() {
+	planCmd.Flags().BoolVar(&planJSON, "json", false, "print the plan as JSON")
+	rootCmd.AddCommand(planCmd)
+}
+
+func planHandler(w http.ResponseWriter, r *http.Request) {
+	plan, err := ingress.Controller().Plan()
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, plan.String())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
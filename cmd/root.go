@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/TykTechnologies/tyk-k8s/logger"
+	"github.com/TykTechnologies/tyk-k8s/notify"
+	"github.com/TykTechnologies/tyk-k8s/reporting"
 	"github.com/TykTechnologies/tyk-k8s/tyk"
 	"os"
 	"strings"
@@ -71,6 +74,37 @@ func initConfig() {
 		viper.Set(key, val)
 	}
 
+	lConf := &logger.Config{}
+	if err := viper.UnmarshalKey("Logging", lConf); err != nil {
+		log.Fatalf("couldn't read logging config: %v", err)
+	}
+
+	if err := logger.Configure(lConf); err != nil {
+		log.Fatalf("couldn't configure logging: %v", err)
+	}
+
+	rConf := &reporting.Config{}
+	if err := viper.UnmarshalKey("ErrorReporting", rConf); err != nil {
+		log.Fatalf("couldn't read error reporting config: %v", err)
+	}
+
+	if err := reporting.Init(rConf); err != nil {
+		log.Fatalf("couldn't configure error reporting: %v", err)
+	}
+
+	nConf := &notify.Config{}
+	if err := viper.UnmarshalKey("Notifications", nConf); err != nil {
+		log.Fatalf("couldn't read notifications config: %v", err)
+	}
+
+	if err := notify.Init(nConf); err != nil {
+		log.Fatalf("couldn't configure notifications: %v", err)
+	}
+
 	log.Infof("Using config file: %v", viper.ConfigFileUsed())
-	tyk.Init(nil)
+	// an invalid Tyk config leaves the client degraded instead of exiting, the webhook is
+	// still served and the health endpoint reports the error until the config is fixed
+	if err := tyk.InitWithRetry(nil); err != nil {
+		log.Errorf("couldn't configure the tyk API client, running degraded: %v", err)
+	}
 }
@@ -1,15 +1,27 @@
 package cmd
 
 import (
+	"encoding/json"
+	"github.com/TykTechnologies/tyk-k8s/apikey"
+	"github.com/TykTechnologies/tyk-k8s/gatewayapi"
+	"github.com/TykTechnologies/tyk-k8s/gitpublish"
 	"github.com/TykTechnologies/tyk-k8s/ingress"
 	"github.com/TykTechnologies/tyk-k8s/injector"
+	"github.com/TykTechnologies/tyk-k8s/knative"
 	"github.com/TykTechnologies/tyk-k8s/logger"
+	"github.com/TykTechnologies/tyk-k8s/oauthclient"
+	"github.com/TykTechnologies/tyk-k8s/operator"
+	"github.com/TykTechnologies/tyk-k8s/orglimit"
+	"github.com/TykTechnologies/tyk-k8s/portal"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
 	"github.com/TykTechnologies/tyk-k8s/webserver"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
 )
 
 var log = logger.GetLogger("main")
@@ -39,18 +51,127 @@ var startCmd = &cobra.Command{
 		}
 
 		webserver.Server().AddRoute("POST", "/inject", whs.Serve)
+		webserver.Server().AddRoute("GET", "/health", healthHandler)
+		webserver.Server().AddRoute("GET", "/metrics", metricsHandler)
 
-		// Ingress controller
-		ingress.NewController()
-		err = ingress.Controller().Start()
-		if err != nil {
-			log.Fatal(err)
+		// operational endpoints are on their own listener, local to the pod by default
+		if err := webserver.ConfigAdmin(sConf); err != nil {
+			log.Fatalf("couldn't configure the admin server: %v", err)
 		}
-		log.Info("ingress controller started")
+		webserver.Admin().AddRoute("GET", "/plan", planHandler)
+		webserver.Admin().AddRoute("POST", "/cache/flush", cacheFlushHandler)
+		webserver.Admin().AddRoute("GET", "/log/levels", logLevelsHandler)
+		webserver.Admin().AddRoute("PUT", "/log/levels", logLevelsHandler)
 
+		// the webhooks are served before any controller starts, so admission keeps
+		// working when the Dashboard or the templates are broken
 		go webserver.Server().Start()
+		go webserver.Admin().Start()
+		if sConf.Debug {
+			if err := webserver.ConfigDebug(sConf); err != nil {
+				log.Fatalf("couldn't configure the debug server: %v", err)
+			}
+			addDebugRoutes(webserver.Debug())
+			go webserver.Debug().Start()
+		}
 		log.Info("web server started")
 
+		// Ingress controller
+		iConf := &ingress.Config{}
+		err = viper.UnmarshalKey("Ingress", iConf)
+		if err != nil {
+			log.Fatalf("couldn't read ingress config: %v", err)
+		}
+
+		ingress.NewController().Config(iConf)
+		startController("ingress", ingress.Controller().Start)
+
+		// only ingresses are sharded, the other controllers run on the first shard
+		primary := ingress.Controller().PrimaryShard()
+		if !primary {
+			log.Info("not the first shard, only ingresses are synced")
+		}
+
+		// Gateway API controller
+		gConf := &gatewayapi.Config{}
+		err = viper.UnmarshalKey("GatewayAPI", gConf)
+		if err != nil {
+			log.Fatalf("couldn't read gateway api config: %v", err)
+		}
+
+		gatewayapi.NewController().Config(gConf)
+		startShared(primary, "gatewayapi", gatewayapi.GetController().Start)
+
+		// Knative routes
+		kConf := &knative.Config{}
+		err = viper.UnmarshalKey("Knative", kConf)
+		if err != nil {
+			log.Fatalf("couldn't read knative config: %v", err)
+		}
+
+		knative.NewController().Config(kConf)
+		startShared(primary, "knative", knative.GetController().Start)
+
+		// Tyk Operator ApiDefinitions
+		oConf := &operator.Config{}
+		err = viper.UnmarshalKey("Operator", oConf)
+		if err != nil {
+			log.Fatalf("couldn't read operator config: %v", err)
+		}
+
+		operator.NewController().Config(oConf)
+		startShared(primary, "operator", operator.GetController().Start)
+
+		// PortalCatalogues
+		pConf := &portal.Config{}
+		err = viper.UnmarshalKey("Portal", pConf)
+		if err != nil {
+			log.Fatalf("couldn't read portal config: %v", err)
+		}
+
+		portal.NewController().Config(pConf)
+		startShared(primary, "portal", portal.GetController().Start)
+
+		// ApiKeys
+		akConf := &apikey.Config{}
+		err = viper.UnmarshalKey("ApiKeys", akConf)
+		if err != nil {
+			log.Fatalf("couldn't read api key config: %v", err)
+		}
+
+		apikey.NewController().Config(akConf)
+		startShared(primary, "apikey", apikey.GetController().Start)
+
+		// OAuthClients
+		ocConf := &oauthclient.Config{}
+		err = viper.UnmarshalKey("OAuthClients", ocConf)
+		if err != nil {
+			log.Fatalf("couldn't read oauth client config: %v", err)
+		}
+
+		oauthclient.NewController().Config(ocConf)
+		startShared(primary, "oauthclient", oauthclient.GetController().Start)
+
+		// OrgRateLimits
+		olConf := &orglimit.Config{}
+		err = viper.UnmarshalKey("OrgRateLimits", olConf)
+		if err != nil {
+			log.Fatalf("couldn't read org rate limit config: %v", err)
+		}
+
+		orglimit.NewController().Config(olConf)
+		startShared(primary, "orglimit", orglimit.GetController().Start)
+
+		// Git publishing
+		gpConf := &gitpublish.Config{}
+		err = viper.UnmarshalKey("GitPublish", gpConf)
+		if err != nil {
+			log.Fatalf("couldn't read git publish config: %v", err)
+		}
+
+		gitpublish.NewController().Config(gpConf)
+		startShared(primary, "gitpublish", gitpublish.GetController().Start)
+
 		WaitForCtrlC()
 
 		err = webserver.Server().Stop()
@@ -58,11 +179,73 @@ var startCmd = &cobra.Command{
 			log.Error(err)
 		}
 
+		err = webserver.Admin().Stop()
+		if err != nil {
+			log.Error(err)
+		}
+
+		err = webserver.Debug().Stop()
+		if err != nil {
+			log.Error(err)
+		}
+
+		// writes in flight finish before the controllers stop, later changes are queued
+		log.Info("draining API writes")
+		err = tyk.Drain()
+		if err != nil {
+			log.Error(err)
+		}
+
+		err = ingress.Controller().FlushQueue()
+		if err != nil {
+			log.Error(err)
+		}
+
 		err = ingress.Controller().Stop()
 		if err != nil {
 			log.Error(err)
 		}
 
+		err = gatewayapi.GetController().Stop()
+		if err != nil {
+			log.Error(err)
+		}
+
+		err = knative.GetController().Stop()
+		if err != nil {
+			log.Error(err)
+		}
+
+		err = operator.GetController().Stop()
+		if err != nil {
+			log.Error(err)
+		}
+
+		err = portal.GetController().Stop()
+		if err != nil {
+			log.Error(err)
+		}
+
+		err = apikey.GetController().Stop()
+		if err != nil {
+			log.Error(err)
+		}
+
+		err = oauthclient.GetController().Stop()
+		if err != nil {
+			log.Error(err)
+		}
+
+		err = orglimit.GetController().Stop()
+		if err != nil {
+			log.Error(err)
+		}
+
+		err = gitpublish.GetController().Stop()
+		if err != nil {
+			log.Error(err)
+		}
+
 	},
 }
 
@@ -70,12 +253,79 @@ func init() {
 	rootCmd.AddCommand(startCmd)
 }
 
+// controllerErrors records the controllers that failed to start, they stay stopped until
+// the process is restarted while the webhooks keep being served
+var controllerMu = sync.Mutex{}
+var controllerErrors = map[string]string{}
+
+func startController(name string, start func() error) {
+	if err := start(); err != nil {
+		log.Errorf("%s controller failed to start: %v", name, err)
+		controllerMu.Lock()
+		controllerErrors[name] = err.Error()
+		controllerMu.Unlock()
+		return
+	}
+
+	log.Debugf("%s controller started", name)
+}
+
+// startShared starts a controller that isn't sharded, replicas other than the first
+// shard leave it stopped
+func startShared(primary bool, name string, start func() error) {
+	if !primary {
+		log.Debugf("%s controller runs on the first shard", name)
+		return
+	}
+
+	startController(name, start)
+}
+
+// healthHandler stays up while the Tyk API client is degraded, so the webhook keeps
+// being served and the degraded state is visible to probes and operators
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	status := map[string]string{"status": "ok"}
+	if err := tyk.Ready(); err != nil {
+		status["status"] = "degraded"
+		status["tyk"] = err.Error()
+	}
+
+	controllerMu.Lock()
+	for name, err := range controllerErrors {
+		status["status"] = "degraded"
+		status[name] = err
+	}
+	controllerMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := tyk.WriteCertificateMetrics(w); err != nil {
+		log.Error(err)
+	}
+	if err := tyk.WriteTemplateMetrics(w); err != nil {
+		log.Error(err)
+	}
+	if err := tyk.WriteSkippedMetrics(w); err != nil {
+		log.Error(err)
+	}
+	if err := tyk.WriteRequestMetrics(w); err != nil {
+		log.Error(err)
+	}
+	if err := ingress.Controller().WriteSyncMetrics(w); err != nil {
+		log.Error(err)
+	}
+}
+
 func WaitForCtrlC() {
 	var end_waiter sync.WaitGroup
 	end_waiter.Add(1)
 	var signal_channel chan os.Signal
 	signal_channel = make(chan os.Signal, 1)
-	signal.Notify(signal_channel, os.Interrupt)
+	signal.Notify(signal_channel, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-signal_channel
 		end_waiter.Done()
@@ -0,0 +1,105 @@
+// Package conditions holds the standard Ready, Synced and Error status conditions the
+// custom resources of this controller report, so `kubectl wait --for=condition=Ready`
+// works on them
+package conditions
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	Ready  = "Ready"
+	Synced = "Synced"
+	Error  = "Error"
+
+	True  = "True"
+	False = "False"
+)
+
+var Now = func() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+type Condition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason"`
+	Message            string `json:"message"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime"`
+}
+
+func New(typ string, ok bool, reason, msg string, gen int64) Condition {
+	st := False
+	if ok {
+		st = True
+	}
+
+	return Condition{Type: typ, Status: st, Reason: reason, Message: msg, ObservedGeneration: gen}
+}
+
+// Set replaces the condition of the same type, the transition time only moves when the
+// status changes
+func Set(conds []Condition, cond Condition) []Condition {
+	out := make([]Condition, 0, len(conds)+1)
+	found := false
+	for _, c := range conds {
+		if c.Type != cond.Type {
+			out = append(out, c)
+			continue
+		}
+
+		found = true
+		cond.LastTransitionTime = c.LastTransitionTime
+		if c.Status != cond.Status || cond.LastTransitionTime == "" {
+			cond.LastTransitionTime = Now()
+		}
+		out = append(out, cond)
+	}
+
+	if !found {
+		cond.LastTransitionTime = Now()
+		out = append(out, cond)
+	}
+
+	return out
+}
+
+// Get returns the condition of the type, a zero condition when it is not set
+func Get(conds []Condition, typ string) Condition {
+	for _, c := range conds {
+		if c.Type == typ {
+			return c
+		}
+	}
+
+	return Condition{}
+}
+
+// Reconciled sets the three conditions from the outcome of a reconcile, err is why the
+// generation could not be synced and reason its machine readable form. Ready also needs
+// serving, the resource may be synced before it takes effect
+func Reconciled(conds []Condition, gen int64, reason string, err error, serving bool) []Condition {
+	if err != nil {
+		conds = Set(conds, New(Synced, false, reason, err.Error(), gen))
+		conds = Set(conds, New(Error, true, reason, err.Error(), gen))
+		return Set(conds, New(Ready, false, reason, err.Error(), gen))
+	}
+
+	conds = Set(conds, New(Synced, true, "Synced", "", gen))
+	conds = Set(conds, New(Error, false, "Synced", "", gen))
+	if !serving {
+		return Set(conds, New(Ready, false, "Pending", "waiting for Tyk to serve the resource", gen))
+	}
+
+	return Set(conds, New(Ready, true, "Ready", "", gen))
+}
+
+// SameJSON reports whether two statuses are equal once serialised, used to skip patches
+// that would not change anything
+func SameJSON(a, b interface{}) bool {
+	ra, errA := json.Marshal(a)
+	rb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ra) == string(rb)
+}
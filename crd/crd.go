@@ -0,0 +1,196 @@
+// Package crd holds what the controllers of the tyk.io custom resources share: the
+// resource metadata, finalizer handling and the poller that lists the resources on an
+// interval, as the vendored client has no informers for them
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/logger"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	Group   = "tyk.io"
+	Version = "v1alpha1"
+
+	// APIVersion is the apiVersion of the resources, as used in owner references
+	APIVersion = Group + "/" + Version
+
+	defaultInterval = 30 * time.Second
+)
+
+var log = logger.GetLogger("crd")
+
+type Metadata struct {
+	Name              string   `json:"name"`
+	Namespace         string   `json:"namespace"`
+	UID               string   `json:"uid,omitempty"`
+	Generation        int64    `json:"generation"`
+	CreationTimestamp string   `json:"creationTimestamp,omitempty"`
+	DeletionTimestamp string   `json:"deletionTimestamp,omitempty"`
+	Finalizers        []string `json:"finalizers,omitempty"`
+}
+
+func (m *Metadata) HasFinalizer(f string) bool {
+	for _, mf := range m.Finalizers {
+		if mf == f {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Metadata) FinalizersWithout(f string) []string {
+	out := make([]string, 0, len(m.Finalizers))
+	for _, mf := range m.Finalizers {
+		if mf != f {
+			out = append(out, mf)
+		}
+	}
+
+	return out
+}
+
+// NewClient connects to the cluster the controller runs in, or to the one of the
+// kubeconfig named by TYK_K8S_KUBECONF
+func NewClient() (*kubernetes.Clientset, error) {
+	cfgF := os.Getenv("TYK_K8S_KUBECONF")
+	var config *rest.Config
+	var err error
+
+	if cfgF != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", cfgF)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+// Poller lists one resource on an interval and hands it to the reconcile func of its
+// controller, Kind names the resource in logs
+type Poller struct {
+	Kind     string
+	Resource string
+
+	client *kubernetes.Clientset
+	stopCh chan struct{}
+}
+
+func NewPoller(kind, resource string) *Poller {
+	return &Poller{Kind: kind, Resource: resource}
+}
+
+// Client is the cluster client, nil until the poller is started
+func (p *Poller) Client() *kubernetes.Clientset {
+	return p.client
+}
+
+// Start runs reconcile now and then every syncSeconds, 30 seconds when unset
+func (p *Poller) Start(syncSeconds int, reconcile func() error) error {
+	var err error
+	p.client, err = NewClient()
+	if err != nil {
+		return err
+	}
+
+	interval := defaultInterval
+	if syncSeconds > 0 {
+		interval = time.Duration(syncSeconds) * time.Second
+	}
+
+	log.Infof("Watching %ss", p.Kind)
+	p.stopCh = make(chan struct{})
+	stopCh := p.stopCh
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			if err := reconcile(); err != nil {
+				log.Errorf("%s reconcile failed: %v", p.Kind, err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (p *Poller) Stop() error {
+	if p.stopCh == nil {
+		return nil
+	}
+
+	close(p.stopCh)
+	p.stopCh = nil
+	return nil
+}
+
+func (p *Poller) rest() rest.Interface {
+	return p.client.CoreV1().RESTClient()
+}
+
+// List reads the resources of all namespaces into a list type with an Items field
+func (p *Poller) List(into interface{}) error {
+	raw, err := p.rest().Get().AbsPath("/apis", Group, Version, p.Resource).DoRaw()
+	if err != nil {
+		return fmt.Errorf("failed to list %ss: %v", p.Kind, err)
+	}
+
+	return json.Unmarshal(raw, into)
+}
+
+// Patch merge patches the resource, sub addresses a subresource such as status
+func (p *Poller) Patch(m *Metadata, body interface{}, sub ...string) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	path := append([]string{"/apis", Group, Version, "namespaces", m.Namespace, p.Resource, m.Name}, sub...)
+	_, err = p.rest().Patch(types.MergePatchType).AbsPath(path...).Body(raw).DoRaw()
+	return err
+}
+
+func (p *Poller) PatchStatus(m *Metadata, status interface{}) error {
+	return p.Patch(m, map[string]interface{}{"status": status}, "status")
+}
+
+// AddFinalizer keeps the resource around once deleted until RemoveFinalizer is called,
+// so the controller gets to clean up what it created for it
+func (p *Poller) AddFinalizer(m *Metadata, f string) error {
+	if m.HasFinalizer(f) {
+		return nil
+	}
+
+	return p.setFinalizers(m, append(m.Finalizers, f))
+}
+
+func (p *Poller) RemoveFinalizer(m *Metadata, f string) error {
+	if !m.HasFinalizer(f) {
+		return nil
+	}
+
+	return p.setFinalizers(m, m.FinalizersWithout(f))
+}
+
+func (p *Poller) setFinalizers(m *Metadata, f []string) error {
+	return p.Patch(m, map[string]interface{}{"metadata": map[string]interface{}{"finalizers": f}})
+}
@@ -0,0 +1,18 @@
+package crd
+
+import "testing"
+
+func TestFinalizers(t *testing.T) {
+	m := &Metadata{Finalizers: []string{"other", "tyk.io/revoke-key"}}
+	if !m.HasFinalizer("tyk.io/revoke-key") || m.HasFinalizer("tyk.io/remove-org-limits") {
+		t.Fatal("unexpected finalizers: ", m.Finalizers)
+	}
+
+	f := m.FinalizersWithout("tyk.io/revoke-key")
+	if len(f) != 1 || f[0] != "other" {
+		t.Fatal("only the given finalizer should be removed, got ", f)
+	}
+	if len(m.Finalizers) != 2 {
+		t.Fatal("the metadata should not be changed, got ", m.Finalizers)
+	}
+}
@@ -0,0 +1,146 @@
+package gatewayapi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+)
+
+// redirectTarget is the upstream of redirect-only rules, the reply action answers the
+// request before it is proxied
+const redirectTarget = "http://127.0.0.1"
+
+const (
+	filterHeaders  = "RequestHeaderModifier"
+	filterRewrite  = "URLRewrite"
+	filterRedirect = "RequestRedirect"
+	filterMirror   = "RequestMirror"
+)
+
+// checkFilters reports the first filter of a rule we can't translate, the rule is not
+// programmed at all rather than served without it
+func checkFilters(rule HTTPRouteRule) error {
+	redirects, rewrites := 0, 0
+	for _, f := range rule.Filters {
+		switch f.Type {
+		case filterHeaders:
+		case filterRewrite:
+			rewrites++
+		case filterRedirect:
+			redirects++
+		case filterMirror:
+			return fmt.Errorf("%s is not supported, the gateway can't mirror requests", f.Type)
+		default:
+			return fmt.Errorf("filter %s is not supported", f.Type)
+		}
+	}
+
+	if redirects > 0 && rewrites > 0 {
+		return fmt.Errorf("%s and %s can't be used in the same rule", filterRedirect, filterRewrite)
+	}
+
+	return nil
+}
+
+func isRedirect(rule HTTPRouteRule) bool {
+	for _, f := range rule.Filters {
+		if f.Type == filterRedirect {
+			return true
+		}
+	}
+	return false
+}
+
+func modifiedPath(m *PathModifier) (string, bool, error) {
+	switch m.Type {
+	case "ReplaceFullPath":
+		return m.ReplaceFullPath, false, nil
+	case "ReplacePrefixMatch":
+		return m.ReplacePrefixMatch, true, nil
+	default:
+		return "", false, fmt.Errorf("unsupported path modifier %s", m.Type)
+	}
+}
+
+// ruleFilters translates the filters of a rule for the API generated for the host and
+// path, Tyk replaces headers so added headers overwrite existing values
+func ruleFilters(rule HTTPRouteRule, host, path string) (*tyk.RequestFilters, error) {
+	if len(rule.Filters) == 0 {
+		return nil, nil
+	}
+
+	rf := &tyk.RequestFilters{SetHeaders: map[string]string{}}
+	for _, f := range rule.Filters {
+		switch f.Type {
+		case filterHeaders:
+			if f.RequestHeaderModifier == nil {
+				continue
+			}
+			for _, h := range f.RequestHeaderModifier.Add {
+				rf.SetHeaders[h.Name] = h.Value
+			}
+			for _, h := range f.RequestHeaderModifier.Set {
+				rf.SetHeaders[h.Name] = h.Value
+			}
+			rf.RemoveHeaders = append(rf.RemoveHeaders, f.RequestHeaderModifier.Remove...)
+
+		case filterRewrite:
+			if f.URLRewrite == nil {
+				continue
+			}
+			if f.URLRewrite.Hostname != "" {
+				rf.SetHeaders["Host"] = f.URLRewrite.Hostname
+			}
+			if f.URLRewrite.Path != nil {
+				p, prefix, err := modifiedPath(f.URLRewrite.Path)
+				if err != nil {
+					return nil, err
+				}
+				rf.RewritePath, rf.RewritePrefix = p, prefix
+			}
+
+		case filterRedirect:
+			if f.RequestRedirect == nil {
+				continue
+			}
+			loc, err := redirectLocation(f.RequestRedirect, host, path)
+			if err != nil {
+				return nil, err
+			}
+			rf.RedirectTo, rf.RedirectCode = loc, f.RequestRedirect.StatusCode
+		}
+	}
+
+	return rf, nil
+}
+
+// redirectLocation builds the fixed Location of a redirect, the reply middleware can't
+// use the request so the original host must be known and a prefix replacement redirects
+// to the new prefix itself
+func redirectLocation(r *RedirectFilter, host, path string) (string, error) {
+	hostname := r.Hostname
+	if hostname == "" {
+		hostname = host
+	}
+
+	if hostname == "" || strings.HasPrefix(hostname, "*.") {
+		return "", fmt.Errorf("%s needs a hostname when the route has no exact hostname", filterRedirect)
+	}
+
+	scheme := defaulted(r.Scheme, "http")
+	loc := scheme + "://" + hostname
+	if r.Port != 0 && !(scheme == "http" && r.Port == 80) && !(scheme == "https" && r.Port == 443) {
+		loc += fmt.Sprintf(":%d", r.Port)
+	}
+
+	if r.Path != nil {
+		p, _, err := modifiedPath(r.Path)
+		if err != nil {
+			return "", err
+		}
+		path = p
+	}
+
+	return loc + "/" + strings.TrimLeft(path, "/"), nil
+}
@@ -0,0 +1,335 @@
+package gatewayapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/logger"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const defaultControllerName = "tyk.io/gateway-controller"
+
+var log = logger.GetLogger("gateway-api")
+var ctrl *Controller
+
+// Config for the Gateway API controller
+type Config struct {
+	// Enabled turns the controller on, the Gateway API CRDs must be installed
+	Enabled bool `yaml:"enabled"`
+	// ControllerName is matched against spec.controllerName of GatewayClasses
+	ControllerName string `yaml:"controllerName"`
+	// Addresses are reported on the status of our Gateways, usually the address of the
+	// Tyk gateway service
+	Addresses   []string `yaml:"addresses"`
+	SyncSeconds int      `yaml:"syncSeconds"`
+	// ResyncSeconds syncs the APIs even when the resources didn't change, so edits and
+	// deletes made in Tyk are reverted, defaults to 5 minutes
+	ResyncSeconds int `yaml:"resyncSeconds"`
+}
+
+// Controller reconciles GatewayClasses, Gateways and routes, the vendored client has no
+// informers for the Gateway API so the resources are listed on an interval
+type Controller struct {
+	cfg    *Config
+	client *kubernetes.Clientset
+	stopCh chan struct{}
+
+	mu         sync.Mutex
+	lastSync   string
+	lastSyncAt time.Time
+	certs      map[string]certCache
+}
+
+type certCache struct {
+	version string
+	id      string
+}
+
+func NewController() *Controller {
+	if ctrl == nil {
+		ctrl = &Controller{}
+	}
+
+	return ctrl
+}
+
+func GetController() *Controller {
+	return NewController()
+}
+
+func (c *Controller) Config(cfg *Config) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	c.cfg = cfg
+}
+
+func (c *Controller) controllerName() string {
+	if c.cfg == nil || c.cfg.ControllerName == "" {
+		return defaultControllerName
+	}
+
+	return c.cfg.ControllerName
+}
+
+func (c *Controller) getClient() (*kubernetes.Clientset, error) {
+	cfgF := os.Getenv("TYK_K8S_KUBECONF")
+	var config *rest.Config
+	var err error
+
+	if cfgF != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", cfgF)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+func (c *Controller) Start() error {
+	if c.cfg == nil || !c.cfg.Enabled {
+		return nil
+	}
+
+	var err error
+	c.client, err = c.getClient()
+	if err != nil {
+		return err
+	}
+
+	interval := 15 * time.Second
+	if c.cfg.SyncSeconds > 0 {
+		interval = time.Duration(c.cfg.SyncSeconds) * time.Second
+	}
+
+	log.Info("Watching Gateway API resources for ", c.controllerName())
+	c.stopCh = make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			if err := c.reconcile(); err != nil {
+				log.Error("gateway api reconcile failed: ", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (c *Controller) Stop() error {
+	if c.stopCh == nil {
+		return nil
+	}
+
+	close(c.stopCh)
+	c.stopCh = nil
+	return nil
+}
+
+func (c *Controller) rest() rest.Interface {
+	return c.client.CoreV1().RESTClient()
+}
+
+func (c *Controller) list(resource string, out interface{}) error {
+	raw, err := c.rest().Get().AbsPath("/apis", apiGroup, apiVersion, resource).DoRaw()
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, out)
+}
+
+// patchStatus replaces the status of a resource, namespace is empty for cluster scoped ones
+func (c *Controller) patchStatus(resource, ns, name string, status interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		return err
+	}
+
+	path := []string{"/apis", apiGroup, apiVersion}
+	if ns != "" {
+		path = append(path, "namespaces", ns)
+	}
+	path = append(path, resource, name, "status")
+
+	_, err = c.rest().Patch(types.MergePatchType).AbsPath(path...).Body(body).DoRaw()
+	return err
+}
+
+func (c *Controller) reconcile() error {
+	classes := &gatewayClassList{}
+	if err := c.list("gatewayclasses", classes); err != nil {
+		return fmt.Errorf("failed to list gateway classes: %v", err)
+	}
+
+	gws := &gatewayList{}
+	if err := c.list("gateways", gws); err != nil {
+		return fmt.Errorf("failed to list gateways: %v", err)
+	}
+
+	rts := &httpRouteList{}
+	if err := c.list("httproutes", rts); err != nil {
+		return fmt.Errorf("failed to list http routes: %v", err)
+	}
+
+	p := newPlan(c.controllerName(), c.cfg.Addresses, classes.Items, gws.Items, rts.Items, c)
+	c.writeStatus(p, classes.Items, gws.Items, rts.Items)
+	return c.syncAPIs(p.apis)
+}
+
+// writeStatus only patches resources whose status changed, so the resource versions are
+// not bumped on every interval
+func (c *Controller) writeStatus(p *plan, classes []GatewayClass, gws []Gateway, rts []HTTPRoute) {
+	for _, gc := range classes {
+		st, ok := p.classes[gc.Metadata.Name]
+		if ok && !sameJSON(st, gc.Status) {
+			if err := c.patchStatus("gatewayclasses", "", gc.Metadata.Name, st); err != nil {
+				log.Error("failed to update gateway class status: ", err)
+			}
+		}
+	}
+
+	for _, gw := range gws {
+		st, ok := p.gateways[key(gw.Metadata.Namespace, gw.Metadata.Name)]
+		if ok && !sameJSON(st, gw.Status) {
+			if err := c.patchStatus("gateways", gw.Metadata.Namespace, gw.Metadata.Name, st); err != nil {
+				log.Error("failed to update gateway status: ", err)
+			}
+		}
+	}
+
+	for _, rt := range rts {
+		st, ok := p.routes[key(rt.Metadata.Namespace, rt.Metadata.Name)]
+		if ok && !sameJSON(st, rt.Status) {
+			if err := c.patchStatus("httproutes", rt.Metadata.Namespace, rt.Metadata.Name, st); err != nil {
+				log.Error("failed to update route status: ", err)
+			}
+		}
+	}
+}
+
+// syncAPIs pushes the route APIs to Tyk when they changed since the last interval and
+// removes the route APIs that are no longer wanted
+func (c *Controller) syncAPIs(apis map[string]*tyk.APIDefOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the options are fingerprinted before the sync as UpdateAPIs fills in the existing
+	// definitions
+	raw, err := json.Marshal(apis)
+	if err != nil {
+		return err
+	}
+	if string(raw) == c.lastSync && time.Since(c.lastSyncAt) < c.resyncInterval() {
+		return nil
+	}
+
+	if err := tyk.SyncByTag(routeTag, apis); err != nil {
+		return err
+	}
+
+	c.lastSync = string(raw)
+	c.lastSyncAt = time.Now()
+	return nil
+}
+
+func (c *Controller) resyncInterval() time.Duration {
+	if c.cfg != nil && c.cfg.ResyncSeconds > 0 {
+		return time.Duration(c.cfg.ResyncSeconds) * time.Second
+	}
+	return defaultResync
+}
+
+func sameJSON(a, b interface{}) bool {
+	ra, errA := json.Marshal(a)
+	rb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ra) == string(rb)
+}
+
+func (c *Controller) namespaceLabels(ns string) map[string]string {
+	n, err := c.client.CoreV1().Namespaces().Get(ns, v12.GetOptions{})
+	if err != nil {
+		log.Warning("could not fetch namespace ", ns, ": ", err)
+		return nil
+	}
+
+	return n.Labels
+}
+
+func (c *Controller) serviceExists(ns, name string) bool {
+	_, err := c.client.CoreV1().Services(ns).Get(name, v12.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Warning("could not fetch service ", name, ", assuming it exists: ", err)
+		return true
+	}
+
+	return err == nil
+}
+
+// certificate uploads the listener certificate to Tyk, the ID is cached against the
+// secret version so it is only uploaded again when the secret changes
+func (c *Controller) certificate(ns, name string) (string, error) {
+	sec, err := c.client.CoreV1().Secrets(ns).Get(name, v12.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	k := key(ns, name)
+	c.mu.Lock()
+	cached, ok := c.certs[k]
+	c.mu.Unlock()
+	if ok && cached.version == sec.ResourceVersion {
+		return cached.id, nil
+	}
+
+	crt, ok := sec.Data["tls.crt"]
+	if !ok {
+		return "", errors.New("no certificate found")
+	}
+
+	pk, ok := sec.Data["tls.key"]
+	if !ok {
+		return "", errors.New("no key found")
+	}
+
+	id, err := tyk.CreateCertificate(crt, pk)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tyk.TrackCertificate(fmt.Sprintf("Secret/%s/%s", ns, name), "tls.crt", id, crt); err != nil {
+		log.Warningf("certificate %s is not monitored: %v", id, err)
+	}
+
+	c.mu.Lock()
+	if c.certs == nil {
+		c.certs = map[string]certCache{}
+	}
+	c.certs[k] = certCache{version: sec.ResourceVersion, id: id}
+	c.mu.Unlock()
+
+	return id, nil
+}
@@ -0,0 +1,272 @@
+package gatewayapi
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type fakeResolver struct {
+	labels   map[string]map[string]string
+	services map[string]bool
+	secrets  map[string]string
+}
+
+func (f *fakeResolver) namespaceLabels(ns string) map[string]string {
+	return f.labels[ns]
+}
+
+func (f *fakeResolver) serviceExists(ns, name string) bool {
+	return f.services[key(ns, name)]
+}
+
+func (f *fakeResolver) certificate(ns, name string) (string, error) {
+	id, ok := f.secrets[key(ns, name)]
+	if !ok {
+		return "", errors.New("secret not found")
+	}
+	return id, nil
+}
+
+const fixtureClasses = `{"items": [
+	{"metadata": {"name": "tyk"}, "spec": {"controllerName": "tyk.io/gateway-controller"}},
+	{"metadata": {"name": "other"}, "spec": {"controllerName": "example.com/other"}}
+]}`
+
+const fixtureGateways = `{"items": [
+	{"metadata": {"name": "edge", "namespace": "infra", "generation": 2}, "spec": {"gatewayClassName": "tyk", "listeners": [
+		{"name": "http", "port": 80, "protocol": "HTTP", "hostname": "*.example.com",
+		 "allowedRoutes": {"namespaces": {"from": "Selector", "selector": {"matchLabels": {"expose": "true"}}}}},
+		{"name": "https", "port": 443, "protocol": "HTTPS",
+		 "tls": {"certificateRefs": [{"name": "edge-cert"}]}, "allowedRoutes": {"namespaces": {"from": "All"}}},
+		{"name": "udp", "port": 53, "protocol": "UDP"},
+		{"name": "tcp", "port": 5432, "protocol": "TCP"}
+	]}},
+	{"metadata": {"name": "theirs", "namespace": "infra"}, "spec": {"gatewayClassName": "other", "listeners": []}}
+]}`
+
+const fixtureRoutes = `{"items": [
+	{"metadata": {"name": "shop", "namespace": "apps", "generation": 1}, "spec": {
+		"parentRefs": [{"name": "edge", "namespace": "infra"}, {"name": "theirs", "namespace": "infra"}],
+		"hostnames": ["shop.example.com"],
+		"rules": [
+			{"matches": [{"path": {"type": "PathPrefix", "value": "/cart"}}],
+			 "backendRefs": [{"name": "cart", "port": 8080, "weight": 3}, {"name": "cart-canary", "port": 8080, "weight": 1}]},
+			{"backendRefs": [{"name": "missing", "port": 80}]}
+		]},
+	 "status": {"parents": [{"parentRef": {"name": "theirs", "namespace": "infra"}, "controllerName": "example.com/other",
+		"conditions": [{"type": "Accepted", "status": "True"}]}]}},
+	{"metadata": {"name": "private", "namespace": "internal"}, "spec": {
+		"parentRefs": [{"name": "edge", "namespace": "infra", "sectionName": "http"}],
+		"rules": [{"backendRefs": [{"name": "api", "port": 80}]}]}},
+	{"metadata": {"name": "mirrored", "namespace": "infra"}, "spec": {
+		"parentRefs": [{"name": "edge"}],
+		"rules": [{"filters": [{"type": "RequestMirror"}], "backendRefs": [{"name": "api", "port": 80}]}]}},
+	{"metadata": {"name": "elsewhere", "namespace": "apps"}, "spec": {
+		"parentRefs": [{"name": "theirs", "namespace": "infra"}]}}
+]}`
+
+func testPlan(t *testing.T) *plan {
+	classes := &gatewayClassList{}
+	gws := &gatewayList{}
+	rts := &httpRouteList{}
+	for raw, out := range map[string]interface{}{fixtureClasses: classes, fixtureGateways: gws, fixtureRoutes: rts} {
+		if err := json.Unmarshal([]byte(raw), out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &fakeResolver{
+		labels:   map[string]map[string]string{"apps": {"expose": "true"}},
+		services: map[string]bool{"apps/cart": true, "apps/cart-canary": true, "internal/api": true},
+		secrets:  map[string]string{"infra/edge-cert": "cert-1"},
+	}
+
+	return newPlan(defaultControllerName, []string{"10.0.0.1", "gw.example.com"},
+		classes.Items, gws.Items, rts.Items, r)
+}
+
+func findCondition(conds []Condition, typ string) Condition {
+	for _, c := range conds {
+		if c.Type == typ {
+			return c
+		}
+	}
+	return Condition{}
+}
+
+func TestClassAndGatewayStatus(t *testing.T) {
+	p := testPlan(t)
+	if len(p.classes) != 1 || findCondition(p.classes["tyk"].Conditions, "Accepted").Status != condTrue {
+		t.Fatal("only our gateway class should be accepted, got ", p.classes)
+	}
+
+	if _, ok := p.gateways["infra/theirs"]; ok {
+		t.Fatal("gateways of other classes should be left alone")
+	}
+
+	gw := p.gateways["infra/edge"]
+	if len(gw.Addresses) != 2 || gw.Addresses[0].Type != "IPAddress" || gw.Addresses[1].Type != "Hostname" {
+		t.Fatal("unexpected addresses: ", gw.Addresses)
+	}
+	if c := findCondition(gw.Conditions, "Programmed"); c.Status != condTrue || c.ObservedGeneration != 2 {
+		t.Fatal("gateway should be programmed, got ", c)
+	}
+
+	ls := map[string]ListenerStatus{}
+	for _, l := range gw.Listeners {
+		ls[l.Name] = l
+	}
+
+	// attachment only depends on allowedRoutes, so the mirrored route counts on https
+	// even though it is not accepted
+	if ls["http"].AttachedRoutes != 1 || ls["https"].AttachedRoutes != 2 {
+		t.Fatal("unexpected attached routes: ", ls)
+	}
+	if c := findCondition(ls["udp"].Conditions, "Accepted"); c.Status != condFalse || c.Reason != "UnsupportedProtocol" {
+		t.Fatal("udp listeners should be rejected, got ", c)
+	}
+	if c := findCondition(ls["tcp"].Conditions, "Accepted"); c.Status != condFalse || !strings.Contains(c.Message, "TCP proxy") {
+		t.Fatal("tcp listeners should explain why they are rejected, got ", c)
+	}
+	if len(ls["https"].SupportedKinds) != 1 || ls["https"].SupportedKinds[0].Kind != kindHTTPRoute {
+		t.Fatal("unexpected supported kinds: ", ls["https"].SupportedKinds)
+	}
+}
+
+func TestRouteStatus(t *testing.T) {
+	p := testPlan(t)
+	if _, ok := p.routes["apps/elsewhere"]; ok {
+		t.Fatal("routes of other controllers should be left alone")
+	}
+
+	shop := p.routes["apps/shop"]
+	if len(shop.Parents) != 2 || shop.Parents[0].ControllerName != "example.com/other" {
+		t.Fatal("the status of other controllers should be kept, got ", shop.Parents)
+	}
+
+	ours := shop.Parents[1]
+	if findCondition(ours.Conditions, "Accepted").Status != condTrue {
+		t.Fatal("shop should be accepted, got ", ours.Conditions)
+	}
+	if c := findCondition(ours.Conditions, "ResolvedRefs"); c.Status != condFalse || c.Reason != "BackendNotFound" {
+		t.Fatal("the missing backend should be reported, got ", c)
+	}
+
+	private := p.routes["internal/private"].Parents[0]
+	if c := findCondition(private.Conditions, "Accepted"); c.Status != condFalse || c.Reason != "NotAllowedByListeners" {
+		t.Fatal("the selector should not allow the internal namespace, got ", c)
+	}
+
+	mirrored := p.routes["infra/mirrored"].Parents[0]
+	if c := findCondition(mirrored.Conditions, "Accepted"); c.Status != condFalse || c.Reason != "UnsupportedValue" {
+		t.Fatal("unsupported filters should be reported, got ", c)
+	}
+}
+
+func TestRouteAPIs(t *testing.T) {
+	p := testPlan(t)
+
+	// one API for the cart rule on each listener, the rule with a missing backend is dropped
+	if len(p.apis) != 1 {
+		t.Fatal("expected the http and https listeners to share a host, got ", len(p.apis))
+	}
+
+	for _, o := range p.apis {
+		if o.ListenPath != "/cart" || o.Hostname != "shop.example.com" || o.PathType != "Prefix" {
+			t.Fatal("unexpected api: ", o.ListenPath, o.Hostname, o.PathType)
+		}
+		if strings.Join(o.TargetList, ",") != "http://cart.apps:8080,http://cart.apps:8080,http://cart.apps:8080,http://cart-canary.apps:8080" {
+			t.Fatal("targets should follow the weights, got ", o.TargetList)
+		}
+		if o.Tags[0] != routeTag || o.Tags[1] != "gateway-infra-edge" {
+			t.Fatal("unexpected tags: ", o.Tags)
+		}
+		if o.Source.Kind != kindHTTPRoute || o.Source.Name != "shop" {
+			t.Fatal("unexpected source: ", o.Source)
+		}
+		if strings.ContainsAny(o.Slug, "=+/") {
+			t.Fatal("slugs should not need cleaning: ", o.Slug)
+		}
+	}
+}
+
+func TestIntersectHostnames(t *testing.T) {
+	cases := []struct {
+		listener string
+		route    []string
+		want     string
+		ok       bool
+	}{
+		{"", nil, "", true},
+		{"*.example.com", nil, "*.example.com", true},
+		{"*.example.com", []string{"a.b.example.com", "other.com"}, "a.b.example.com", true},
+		{"shop.example.com", []string{"*.example.com"}, "shop.example.com", true},
+		{"shop.example.com", []string{"cart.example.com"}, "", false},
+	}
+
+	for _, c := range cases {
+		hosts, ok := intersectHostnames(c.listener, c.route)
+		if ok != c.ok || strings.Join(hosts, ",") != c.want {
+			t.Errorf("%s %v: got %v %v", c.listener, c.route, hosts, ok)
+		}
+	}
+}
+
+func TestSetCondition(t *testing.T) {
+	old := []Condition{{Type: "Accepted", Status: condTrue, LastTransitionTime: "then"}}
+	conds := setCondition(old, condition("Accepted", true, "Accepted", "", 3))
+	if conds[0].LastTransitionTime != "then" || conds[0].ObservedGeneration != 3 {
+		t.Fatal("unchanged conditions should keep their transition time, got ", conds[0])
+	}
+
+	conds = setCondition(old, condition("Accepted", false, "Invalid", "", 3))
+	if conds[0].LastTransitionTime == "then" {
+		t.Fatal("transitions should update the time")
+	}
+}
+
+func TestRuleFilters(t *testing.T) {
+	rule := HTTPRouteRule{Filters: []HTTPRouteFilter{
+		{Type: filterHeaders, RequestHeaderModifier: &HeaderModifier{
+			Add: []HTTPHeader{{Name: "X-A", Value: "1"}},
+			Set: []HTTPHeader{{Name: "X-A", Value: "2"}}, Remove: []string{"X-B"}}},
+		{Type: filterRewrite, URLRewrite: &URLRewriteFilter{Hostname: "internal",
+			Path: &PathModifier{Type: "ReplacePrefixMatch", ReplacePrefixMatch: "/v2"}}},
+	}}
+
+	if err := checkFilters(rule); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ruleFilters(rule, "shop.example.com", "/cart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.SetHeaders["X-A"] != "2" || f.SetHeaders["Host"] != "internal" || f.RemoveHeaders[0] != "X-B" {
+		t.Fatal("unexpected headers: ", f.SetHeaders, f.RemoveHeaders)
+	}
+	if f.RewritePath != "/v2" || !f.RewritePrefix {
+		t.Fatal("unexpected rewrite: ", f.RewritePath)
+	}
+
+	redirect := HTTPRouteRule{Filters: []HTTPRouteFilter{{Type: filterRedirect,
+		RequestRedirect: &RedirectFilter{Scheme: "https", Port: 443, StatusCode: 301}}}}
+	f, err = ruleFilters(redirect, "shop.example.com", "/cart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.RedirectTo != "https://shop.example.com/cart" || f.RedirectCode != 301 {
+		t.Fatal("unexpected redirect: ", f.RedirectTo, f.RedirectCode)
+	}
+
+	if _, err := ruleFilters(redirect, "*.example.com", "/"); err == nil {
+		t.Fatal("redirects need an exact hostname")
+	}
+
+	both := HTTPRouteRule{Filters: append(redirect.Filters, rule.Filters[1])}
+	if err := checkFilters(both); err == nil {
+		t.Fatal("redirects and rewrites should not be combined")
+	}
+}
@@ -0,0 +1,599 @@
+package gatewayapi
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	condTrue  = "True"
+	condFalse = "False"
+
+	routeTag = "httproute"
+
+	// defaultResync is how often unchanged APIs are synced again
+	defaultResync = 5 * time.Minute
+)
+
+var now = func() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// resolver looks up the cluster state the plan depends on, it is faked in tests
+type resolver interface {
+	namespaceLabels(ns string) map[string]string
+	serviceExists(ns, name string) bool
+	certificate(ns, name string) (string, error)
+}
+
+// plan is the desired state computed from one listing of the Gateway API resources, the
+// status of every resource we own and the APIs the routes translate into
+type plan struct {
+	classes  map[string]GatewayClassStatus
+	gateways map[string]GatewayStatus
+	routes   map[string]RouteStatus
+	apis     map[string]*tyk.APIDefOptions
+}
+
+// attachment is a listener of one of our gateways that accepted a route
+type attachment struct {
+	gw        *Gateway
+	listener  *Listener
+	hostnames []string
+	certs     []string
+}
+
+func key(ns, name string) string {
+	return ns + "/" + name
+}
+
+func setCondition(conds []Condition, cond Condition) []Condition {
+	out := make([]Condition, 0, len(conds)+1)
+	found := false
+	for _, c := range conds {
+		if c.Type != cond.Type {
+			out = append(out, c)
+			continue
+		}
+
+		found = true
+		cond.LastTransitionTime = c.LastTransitionTime
+		if c.Status != cond.Status || cond.LastTransitionTime == "" {
+			cond.LastTransitionTime = now()
+		}
+		out = append(out, cond)
+	}
+
+	if !found {
+		cond.LastTransitionTime = now()
+		out = append(out, cond)
+	}
+
+	return out
+}
+
+func condition(typ string, ok bool, reason, msg string, gen int64) Condition {
+	st := condFalse
+	if ok {
+		st = condTrue
+	}
+
+	return Condition{Type: typ, Status: st, Reason: reason, Message: msg, ObservedGeneration: gen}
+}
+
+// routeProtocols lists the listener protocols each route kind can attach to, TLSRoute and
+// TCPRoute need the protocol and listen_port fields of TCP proxy APIs which the vendored
+// Tyk API definition does not have yet
+var routeProtocols = map[string][]string{
+	kindHTTPRoute: {"HTTP", "HTTPS"},
+}
+
+func supportsKind(protocol, kind string) bool {
+	for _, p := range routeProtocols[kind] {
+		if p == protocol {
+			return true
+		}
+	}
+
+	return false
+}
+
+// supportedKinds works out the route kinds a listener accepts, invalid is set when the
+// listener asks for kinds we can't serve on its protocol
+func supportedKinds(l *Listener) (kinds []RouteKind, invalid bool) {
+	kinds = make([]RouteKind, 0)
+	if l.AllowedRoutes == nil || len(l.AllowedRoutes.Kinds) == 0 {
+		for k := range routeProtocols {
+			if supportsKind(l.Protocol, k) {
+				kinds = append(kinds, RouteKind{Group: apiGroup, Kind: k})
+			}
+		}
+		sort.Slice(kinds, func(i, j int) bool { return kinds[i].Kind < kinds[j].Kind })
+		return kinds, false
+	}
+
+	for _, k := range l.AllowedRoutes.Kinds {
+		if (k.Group == "" || k.Group == apiGroup) && supportsKind(l.Protocol, k.Kind) {
+			kinds = append(kinds, RouteKind{Group: apiGroup, Kind: k.Kind})
+			continue
+		}
+		invalid = true
+	}
+
+	return kinds, invalid
+}
+
+func listenerAllowsKind(l *Listener, kind string) bool {
+	kinds, _ := supportedKinds(l)
+	for _, k := range kinds {
+		if k.Kind == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+func listenerAllowsNamespace(l *Listener, gwNs, routeNs string, r resolver) bool {
+	from := "Same"
+	var sel *metav1.LabelSelector
+	if l.AllowedRoutes != nil && l.AllowedRoutes.Namespaces != nil {
+		if l.AllowedRoutes.Namespaces.From != "" {
+			from = l.AllowedRoutes.Namespaces.From
+		}
+		sel = l.AllowedRoutes.Namespaces.Selector
+	}
+
+	switch from {
+	case "All":
+		return true
+	case "Selector":
+		if sel == nil {
+			return false
+		}
+		s, err := metav1.LabelSelectorAsSelector(sel)
+		if err != nil {
+			return false
+		}
+		return s.Matches(labels.Set(r.namespaceLabels(routeNs)))
+	default:
+		return gwNs == routeNs
+	}
+}
+
+// hostCovers checks whether a wildcard hostname covers another, Gateway API wildcards
+// match one or more labels
+func hostCovers(wildcard, host string) bool {
+	if !strings.HasPrefix(wildcard, "*.") {
+		return false
+	}
+
+	return strings.HasSuffix(host, wildcard[1:]) && len(host) > len(wildcard)-1
+}
+
+// intersectHostnames returns the hostnames a route is served on by a listener, an empty
+// hostname matches any host and ok is false when they have nothing in common
+func intersectHostnames(listener string, route []string) (hosts []string, ok bool) {
+	if len(route) == 0 {
+		return []string{listener}, true
+	}
+
+	if listener == "" {
+		return route, true
+	}
+
+	for _, h := range route {
+		switch {
+		case h == listener, hostCovers(listener, h):
+			hosts = append(hosts, h)
+		case hostCovers(h, listener):
+			hosts = append(hosts, listener)
+		}
+	}
+
+	return hosts, len(hosts) > 0
+}
+
+func listenerStatus(gw *Gateway, l *Listener, r resolver) (ListenerStatus, []string) {
+	gen := gw.Metadata.Generation
+	var old []Condition
+	for _, ls := range gw.Status.Listeners {
+		if ls.Name == l.Name {
+			old = ls.Conditions
+		}
+	}
+
+	kinds, invalid := supportedKinds(l)
+	st := ListenerStatus{Name: l.Name, SupportedKinds: kinds}
+
+	accepted, reason, msg := true, "Accepted", ""
+	if len(kinds) == 0 && !invalid {
+		accepted, reason, msg = false, "UnsupportedProtocol", "protocol "+l.Protocol+" is not supported"
+		if l.Protocol == "TLS" || l.Protocol == "TCP" {
+			msg += ", TCP proxy APIs are not available in this version"
+		}
+	}
+
+	resolved, rReason, rMsg := !invalid, "ResolvedRefs", ""
+	if invalid {
+		rReason, rMsg = "InvalidRouteKinds", "some of the allowed route kinds are not supported"
+	}
+
+	certs := make([]string, 0)
+	if l.Protocol == "HTTPS" && accepted {
+		if l.TLS == nil || (l.TLS.Mode != "" && l.TLS.Mode != "Terminate") {
+			accepted, reason, msg = false, "UnsupportedValue", "HTTPS listeners must terminate TLS"
+		} else {
+			for _, ref := range l.TLS.CertificateRefs {
+				ns := ref.Namespace
+				if ns == "" {
+					ns = gw.Metadata.Namespace
+				}
+
+				if (ref.Kind != "" && ref.Kind != "Secret") || ref.Group != "" {
+					resolved, rReason, rMsg = false, "InvalidCertificateRef", "certificate refs must be core Secrets"
+					continue
+				}
+
+				if ns != gw.Metadata.Namespace {
+					resolved, rReason, rMsg = false, "RefNotPermitted", "cross-namespace certificate refs are not supported"
+					continue
+				}
+
+				id, err := r.certificate(ns, ref.Name)
+				if err != nil {
+					resolved, rReason, rMsg = false, "InvalidCertificateRef", err.Error()
+					continue
+				}
+				certs = append(certs, id)
+			}
+		}
+	}
+
+	conds := setCondition(old, condition("Accepted", accepted, reason, msg, gen))
+	conds = setCondition(conds, condition("ResolvedRefs", resolved, rReason, rMsg, gen))
+	pReason := "Programmed"
+	if !accepted {
+		pReason = "Invalid"
+	}
+	st.Conditions = setCondition(conds, condition("Programmed", accepted, pReason, "", gen))
+
+	return st, certs
+}
+
+func addressType(addr string) string {
+	if net.ParseIP(addr) != nil {
+		return "IPAddress"
+	}
+	return "Hostname"
+}
+
+func defaulted(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+// attach finds the listeners of the gateway that accept the route for the parent ref,
+// the reason is set when none of them do
+func attach(rt *HTTPRoute, gw *Gateway, ref ParentRef, listeners map[string]ListenerStatus, certs map[string][]string,
+	r resolver) ([]attachment, string) {
+	atts := make([]attachment, 0)
+	reason := "NoMatchingParent"
+	for i := range gw.Spec.Listeners {
+		l := &gw.Spec.Listeners[i]
+		if ref.SectionName != "" && ref.SectionName != l.Name {
+			continue
+		}
+
+		ls := listeners[l.Name]
+		if !isTrue(ls.Conditions, "Accepted") || !listenerAllowsKind(l, kindHTTPRoute) ||
+			!listenerAllowsNamespace(l, gw.Metadata.Namespace, rt.Metadata.Namespace, r) {
+			reason = "NotAllowedByListeners"
+			continue
+		}
+
+		hosts, ok := intersectHostnames(l.Hostname, rt.Spec.Hostnames)
+		if !ok {
+			if reason != "NotAllowedByListeners" {
+				reason = "NoMatchingListenerHostname"
+			}
+			continue
+		}
+
+		atts = append(atts, attachment{gw: gw, listener: l, hostnames: hosts, certs: certs[l.Name]})
+	}
+
+	return atts, reason
+}
+
+func isTrue(conds []Condition, typ string) bool {
+	for _, c := range conds {
+		if c.Type == typ {
+			return c.Status == condTrue
+		}
+	}
+	return false
+}
+
+// backends resolves the backend refs of a rule to targets, the reason is set when any
+// of them could not be resolved
+func backends(rt *HTTPRoute, rule HTTPRouteRule, r resolver) ([]string, string, string) {
+	targets := make([]string, 0)
+	weights := make([]int32, 0)
+	reason, msg := "", ""
+	for _, b := range rule.BackendRefs {
+		ns := defaulted(b.Namespace, rt.Metadata.Namespace)
+		switch {
+		case b.Group != "" || defaulted(b.Kind, kindService) != kindService:
+			reason, msg = "InvalidKind", fmt.Sprintf("backend %s is not a Service", b.Name)
+			continue
+		case ns != rt.Metadata.Namespace:
+			reason, msg = "RefNotPermitted", fmt.Sprintf("cross-namespace backend %s/%s is not supported", ns, b.Name)
+			continue
+		case b.Port == 0:
+			reason, msg = "UnsupportedValue", fmt.Sprintf("backend %s has no port", b.Name)
+			continue
+		case !r.serviceExists(ns, b.Name):
+			reason, msg = "BackendNotFound", fmt.Sprintf("service %s/%s not found", ns, b.Name)
+			continue
+		}
+
+		w := int32(1)
+		if b.Weight != nil {
+			w = *b.Weight
+		}
+		if w <= 0 {
+			continue
+		}
+
+		targets = append(targets, fmt.Sprintf("http://%s.%s:%d", b.Name, ns, b.Port))
+		weights = append(weights, w)
+	}
+
+	return tyk.WeightedTargets(targets, weights), reason, msg
+}
+
+var pathTypes = map[string]string{
+	"PathPrefix":        tyk.PathTypePrefix,
+	"Exact":             tyk.PathTypeExact,
+	"RegularExpression": tyk.PathTypeRegex,
+}
+
+// routeSlug is hex encoded so it is already a clean Dashboard slug
+func routeSlug(rt *HTTPRoute, gw *Gateway, rule, match int, host string) string {
+	hasher := sha1.New()
+	hasher.Write([]byte(fmt.Sprintf("httproute:%s:%s:%d:%d:%s",
+		key(rt.Metadata.Namespace, rt.Metadata.Name), key(gw.Metadata.Namespace, gw.Metadata.Name), rule, match, host)))
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
+func hostToDomain(host string) string {
+	if strings.HasPrefix(host, "*.") {
+		return "{subdomain:.+}" + host[1:]
+	}
+	return host
+}
+
+// gatewayTag lets Tyk gateway segments be mapped to Gateway resources
+func gatewayTag(gw *Gateway) string {
+	return fmt.Sprintf("gateway-%s-%s", gw.Metadata.Namespace, gw.Metadata.Name)
+}
+
+// routeAPIs translates the rules of a route into one API per match and hostname for
+// every gateway it is attached to
+func routeAPIs(rt *HTTPRoute, atts []attachment, targets [][]string) map[string]*tyk.APIDefOptions {
+	apis := map[string]*tyk.APIDefOptions{}
+	for _, att := range atts {
+		for ri, rule := range rt.Spec.Rules {
+			if targets[ri] == nil {
+				continue
+			}
+
+			matches := rule.Matches
+			if len(matches) == 0 {
+				matches = []HTTPRouteMatch{{}}
+			}
+
+			for mi, m := range matches {
+				pt, pth := "PathPrefix", "/"
+				if m.Path != nil {
+					pt, pth = defaulted(m.Path.Type, pt), defaulted(m.Path.Value, pth)
+				}
+
+				for _, h := range att.hostnames {
+					filters, err := ruleFilters(rule, h, pth)
+					if err != nil {
+						log.Warning("skipping ", key(rt.Metadata.Namespace, rt.Metadata.Name), " for ", h, ": ", err)
+						continue
+					}
+
+					slug := routeSlug(rt, att.gw, ri, mi, h)
+					apis[slug] = &tyk.APIDefOptions{
+						Name:          fmt.Sprintf("%s:%s", rt.Metadata.Name, rt.Metadata.Namespace),
+						Slug:          slug,
+						ListenPath:    pth,
+						PathType:      pathTypes[pt],
+						Hostname:      hostToDomain(h),
+						Target:        targets[ri][0],
+						TargetList:    targets[ri],
+						TemplateName:  defaulted(rt.Metadata.Annotations[tyk.TemplateNameKey], tyk.DefaultTemplate),
+						Tags:          []string{routeTag, gatewayTag(att.gw), att.listener.Name},
+						Annotations:   rt.Metadata.Annotations,
+						CertificateID: att.certs,
+						Filters:       filters,
+						Source: &tyk.SourceMeta{
+							Kind:        kindHTTPRoute,
+							Namespace:   rt.Metadata.Namespace,
+							Name:        rt.Metadata.Name,
+							Labels:      rt.Metadata.Labels,
+							Annotations: rt.Metadata.Annotations,
+						},
+					}
+				}
+			}
+		}
+	}
+
+	return apis
+}
+
+// newPlan reconciles one listing of the resources for the controller name, it never
+// touches resources of other controllers
+func newPlan(controller string, addresses []string, classes []GatewayClass, gws []Gateway, rts []HTTPRoute,
+	r resolver) *plan {
+	p := &plan{
+		classes:  map[string]GatewayClassStatus{},
+		gateways: map[string]GatewayStatus{},
+		routes:   map[string]RouteStatus{},
+		apis:     map[string]*tyk.APIDefOptions{},
+	}
+
+	for _, gc := range classes {
+		if gc.Spec.ControllerName != controller {
+			continue
+		}
+
+		p.classes[gc.Metadata.Name] = GatewayClassStatus{
+			Conditions: setCondition(gc.Status.Conditions, condition("Accepted", true, "Accepted", "", gc.Metadata.Generation)),
+		}
+	}
+
+	ours := map[string]*Gateway{}
+	listeners := map[string]map[string]ListenerStatus{}
+	certs := map[string]map[string][]string{}
+	for i := range gws {
+		gw := &gws[i]
+		if _, ok := p.classes[gw.Spec.GatewayClassName]; !ok {
+			continue
+		}
+
+		k := key(gw.Metadata.Namespace, gw.Metadata.Name)
+		ours[k] = gw
+		listeners[k] = map[string]ListenerStatus{}
+		certs[k] = map[string][]string{}
+		for j := range gw.Spec.Listeners {
+			ls, ids := listenerStatus(gw, &gw.Spec.Listeners[j], r)
+			listeners[k][ls.Name] = ls
+			certs[k][ls.Name] = ids
+		}
+	}
+
+	for i := range rts {
+		rt := &rts[i]
+		parents := make([]RouteParentStatus, 0)
+		for _, ps := range rt.Status.Parents {
+			if ps.ControllerName != controller {
+				parents = append(parents, ps)
+			}
+		}
+
+		// rules without a usable target or with filters we can't translate are left nil
+		targets := make([][]string, len(rt.Spec.Rules))
+		bReason, bMsg, fMsg := "", "", ""
+		for ri, rule := range rt.Spec.Rules {
+			if err := checkFilters(rule); err != nil {
+				fMsg = err.Error()
+				continue
+			}
+
+			tgts, reason, msg := backends(rt, rule, r)
+			if reason != "" {
+				bReason, bMsg = reason, msg
+			}
+
+			switch {
+			case len(tgts) > 0:
+				targets[ri] = tgts
+			case isRedirect(rule):
+				targets[ri] = []string{redirectTarget}
+			}
+		}
+
+		mine := false
+		for _, ref := range rt.Spec.ParentRefs {
+			if defaulted(ref.Group, apiGroup) != apiGroup || defaulted(ref.Kind, kindGateway) != kindGateway {
+				continue
+			}
+
+			gw, ok := ours[key(defaulted(ref.Namespace, rt.Metadata.Namespace), ref.Name)]
+			if !ok {
+				continue
+			}
+
+			mine = true
+			gk := key(gw.Metadata.Namespace, gw.Metadata.Name)
+			atts, reason := attach(rt, gw, ref, listeners[gk], certs[gk], r)
+			for _, att := range atts {
+				ls := listeners[gk][att.listener.Name]
+				ls.AttachedRoutes++
+				listeners[gk][att.listener.Name] = ls
+			}
+
+			for slug, o := range routeAPIs(rt, atts, targets) {
+				p.apis[slug] = o
+			}
+
+			var old []Condition
+			for _, ps := range rt.Status.Parents {
+				if ps.ControllerName == controller && ps.ParentRef == ref {
+					old = ps.Conditions
+				}
+			}
+
+			gen := rt.Metadata.Generation
+			accepted := condition("Accepted", true, "Accepted", "", gen)
+			switch {
+			case len(atts) == 0:
+				accepted = condition("Accepted", false, reason, "", gen)
+			case fMsg != "":
+				accepted = condition("Accepted", false, "UnsupportedValue", fMsg, gen)
+			}
+			resolved := condition("ResolvedRefs", true, "ResolvedRefs", "", gen)
+			if bReason != "" {
+				resolved = condition("ResolvedRefs", false, bReason, bMsg, gen)
+			}
+
+			parents = append(parents, RouteParentStatus{
+				ParentRef:      ref,
+				ControllerName: controller,
+				Conditions:     setCondition(setCondition(old, accepted), resolved),
+			})
+		}
+
+		if mine {
+			p.routes[key(rt.Metadata.Namespace, rt.Metadata.Name)] = RouteStatus{Parents: parents}
+		}
+	}
+
+	for k, gw := range ours {
+		st := GatewayStatus{}
+		for _, a := range addresses {
+			st.Addresses = append(st.Addresses, GatewayAddress{Type: addressType(a), Value: a})
+		}
+
+		gen := gw.Metadata.Generation
+		st.Conditions = setCondition(gw.Status.Conditions, condition("Accepted", true, "Accepted", "", gen))
+		programmed := condition("Programmed", true, "Programmed", "", gen)
+		if len(addresses) == 0 {
+			programmed = condition("Programmed", false, "AddressNotAssigned", "no gateway addresses are configured", gen)
+		}
+		st.Conditions = setCondition(st.Conditions, programmed)
+
+		for _, l := range gw.Spec.Listeners {
+			st.Listeners = append(st.Listeners, listeners[k][l.Name])
+		}
+		p.gateways[k] = st
+	}
+
+	return p
+}
@@ -0,0 +1,210 @@
+package gatewayapi
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The vendored client predates the Gateway API, these are the parts of the v1 resources
+// the controller reads and writes, unknown fields are ignored
+
+const (
+	apiGroup   = "gateway.networking.k8s.io"
+	apiVersion = "v1"
+
+	kindGateway   = "Gateway"
+	kindHTTPRoute = "HTTPRoute"
+	kindService   = "Service"
+)
+
+type objectMeta struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Generation  int64             `json:"generation,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type Condition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason"`
+	Message            string `json:"message"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime"`
+}
+
+type GatewayClass struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		ControllerName string `json:"controllerName"`
+	} `json:"spec"`
+	Status GatewayClassStatus `json:"status"`
+}
+
+type GatewayClassStatus struct {
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+type RouteKind struct {
+	Group string `json:"group,omitempty"`
+	Kind  string `json:"kind"`
+}
+
+type RouteNamespaces struct {
+	From     string                `json:"from,omitempty"`
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+type AllowedRoutes struct {
+	Namespaces *RouteNamespaces `json:"namespaces,omitempty"`
+	Kinds      []RouteKind      `json:"kinds,omitempty"`
+}
+
+type SecretRef struct {
+	Group     string `json:"group,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type ListenerTLS struct {
+	Mode            string      `json:"mode,omitempty"`
+	CertificateRefs []SecretRef `json:"certificateRefs,omitempty"`
+}
+
+type Listener struct {
+	Name          string         `json:"name"`
+	Hostname      string         `json:"hostname,omitempty"`
+	Port          int32          `json:"port"`
+	Protocol      string         `json:"protocol"`
+	TLS           *ListenerTLS   `json:"tls,omitempty"`
+	AllowedRoutes *AllowedRoutes `json:"allowedRoutes,omitempty"`
+}
+
+type Gateway struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		GatewayClassName string     `json:"gatewayClassName"`
+		Listeners        []Listener `json:"listeners"`
+	} `json:"spec"`
+	Status GatewayStatus `json:"status"`
+}
+
+type GatewayAddress struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type ListenerStatus struct {
+	Name           string      `json:"name"`
+	SupportedKinds []RouteKind `json:"supportedKinds"`
+	AttachedRoutes int32       `json:"attachedRoutes"`
+	Conditions     []Condition `json:"conditions"`
+}
+
+type GatewayStatus struct {
+	Addresses  []GatewayAddress `json:"addresses,omitempty"`
+	Conditions []Condition      `json:"conditions,omitempty"`
+	Listeners  []ListenerStatus `json:"listeners,omitempty"`
+}
+
+type ParentRef struct {
+	Group       string `json:"group,omitempty"`
+	Kind        string `json:"kind,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name"`
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+type BackendRef struct {
+	Group     string `json:"group,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Port      int32  `json:"port,omitempty"`
+	Weight    *int32 `json:"weight,omitempty"`
+}
+
+type PathMatch struct {
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+type HTTPRouteMatch struct {
+	Path *PathMatch `json:"path,omitempty"`
+}
+
+type HTTPHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HeaderModifier struct {
+	Set    []HTTPHeader `json:"set,omitempty"`
+	Add    []HTTPHeader `json:"add,omitempty"`
+	Remove []string     `json:"remove,omitempty"`
+}
+
+type PathModifier struct {
+	Type               string `json:"type"`
+	ReplaceFullPath    string `json:"replaceFullPath,omitempty"`
+	ReplacePrefixMatch string `json:"replacePrefixMatch,omitempty"`
+}
+
+type URLRewriteFilter struct {
+	Hostname string        `json:"hostname,omitempty"`
+	Path     *PathModifier `json:"path,omitempty"`
+}
+
+type RedirectFilter struct {
+	Scheme     string        `json:"scheme,omitempty"`
+	Hostname   string        `json:"hostname,omitempty"`
+	Path       *PathModifier `json:"path,omitempty"`
+	Port       int32         `json:"port,omitempty"`
+	StatusCode int           `json:"statusCode,omitempty"`
+}
+
+type HTTPRouteFilter struct {
+	Type                  string            `json:"type"`
+	RequestHeaderModifier *HeaderModifier   `json:"requestHeaderModifier,omitempty"`
+	URLRewrite            *URLRewriteFilter `json:"urlRewrite,omitempty"`
+	RequestRedirect       *RedirectFilter   `json:"requestRedirect,omitempty"`
+}
+
+type HTTPRouteRule struct {
+	Matches     []HTTPRouteMatch  `json:"matches,omitempty"`
+	Filters     []HTTPRouteFilter `json:"filters,omitempty"`
+	BackendRefs []BackendRef      `json:"backendRefs,omitempty"`
+}
+
+type HTTPRoute struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		ParentRefs []ParentRef     `json:"parentRefs,omitempty"`
+		Hostnames  []string        `json:"hostnames,omitempty"`
+		Rules      []HTTPRouteRule `json:"rules,omitempty"`
+	} `json:"spec"`
+	Status RouteStatus `json:"status"`
+}
+
+type RouteParentStatus struct {
+	ParentRef      ParentRef   `json:"parentRef"`
+	ControllerName string      `json:"controllerName"`
+	Conditions     []Condition `json:"conditions"`
+}
+
+type RouteStatus struct {
+	Parents []RouteParentStatus `json:"parents"`
+}
+
+type gatewayClassList struct {
+	Items []GatewayClass `json:"items"`
+}
+
+type gatewayList struct {
+	Items []Gateway `json:"items"`
+}
+
+type httpRouteList struct {
+	Items []HTTPRoute `json:"items"`
+}
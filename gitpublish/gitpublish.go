@@ -0,0 +1,297 @@
+package gitpublish
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+	"github.com/TykTechnologies/tyk-k8s/logger"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+)
+
+// The managed APIs are written in the layout tyk-sync reads: one definition per file in
+// the apiDefs directory and a .tyk.json index listing them
+const (
+	defsDir   = "apiDefs"
+	indexFile = ".tyk.json"
+)
+
+var log = logger.GetLogger("gitpublish")
+var ctrl *Controller
+
+// gitBinary and listAPIs are replaced in tests
+var gitBinary = "git"
+var listAPIs = tyk.ListManaged
+
+// Config for the Git publisher
+type Config struct {
+	// Enabled pushes the managed APIs to the repository on an interval
+	Enabled     bool `yaml:"enabled"`
+	SyncSeconds int  `yaml:"syncSeconds"`
+	// Repository is the remote the definitions are pushed to, credentials are given in
+	// the URL or by the SSH key and known hosts of the git client
+	Repository string `yaml:"repository"`
+	// Branch defaults to master and is created when it doesn't exist
+	Branch string `yaml:"branch"`
+	// Path is the directory of the repository the definitions are written to, so
+	// clusters sharing a repository don't overwrite each other
+	Path string `yaml:"path"`
+	// Checkout is the local clone, defaults to a directory in the temp dir
+	Checkout    string `yaml:"checkout"`
+	AuthorName  string `yaml:"authorName"`
+	AuthorEmail string `yaml:"authorEmail"`
+}
+
+type apiInfo struct {
+	File string `json:"file"`
+}
+
+// index is the .tyk.json tyk-sync reads, the files are relative to it
+type index struct {
+	Type     string        `json:"type"`
+	Files    []apiInfo     `json:"files"`
+	Policies []interface{} `json:"policies"`
+}
+
+// Controller publishes the managed APIs, the whole set is written on every run so APIs
+// removed from Tyk are removed from the repository too
+type Controller struct {
+	cfg    *Config
+	stopCh chan struct{}
+}
+
+func NewController() *Controller {
+	if ctrl == nil {
+		ctrl = &Controller{}
+	}
+
+	return ctrl
+}
+
+func GetController() *Controller {
+	return NewController()
+}
+
+func (c *Controller) Config(cfg *Config) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	if cfg.Branch == "" {
+		cfg.Branch = "master"
+	}
+
+	if cfg.Checkout == "" {
+		cfg.Checkout = filepath.Join(os.TempDir(), "tyk-k8s-gitpublish")
+	}
+
+	if cfg.AuthorName == "" {
+		cfg.AuthorName = "tyk-k8s"
+	}
+
+	if cfg.AuthorEmail == "" {
+		cfg.AuthorEmail = "tyk-k8s@localhost"
+	}
+
+	c.cfg = cfg
+}
+
+func (c *Controller) Start() error {
+	if c.cfg == nil || !c.cfg.Enabled {
+		return nil
+	}
+
+	if c.cfg.Repository == "" {
+		return fmt.Errorf("a repository is needed to publish definitions")
+	}
+
+	if _, err := exec.LookPath(gitBinary); err != nil {
+		return fmt.Errorf("git client not found: %v", err)
+	}
+
+	interval := time.Minute
+	if c.cfg.SyncSeconds > 0 {
+		interval = time.Duration(c.cfg.SyncSeconds) * time.Second
+	}
+
+	log.Infof("publishing definitions to %s on %s", c.cfg.Repository, c.cfg.Branch)
+	c.stopCh = make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			if err := c.Publish(); err != nil {
+				log.Error("failed to publish definitions: ", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (c *Controller) Stop() error {
+	if c.stopCh == nil {
+		return nil
+	}
+
+	close(c.stopCh)
+	c.stopCh = nil
+	return nil
+}
+
+func (c *Controller) git(args ...string) (string, error) {
+	cmd := exec.Command(gitBinary, args...)
+	cmd.Dir = c.cfg.Checkout
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+
+	return string(out), nil
+}
+
+// checkout brings the local clone to the remote branch, changes left by a failed push
+// are discarded and written again
+func (c *Controller) checkout() error {
+	if _, err := os.Stat(filepath.Join(c.cfg.Checkout, ".git")); os.IsNotExist(err) {
+		if err := os.MkdirAll(c.cfg.Checkout, 0700); err != nil {
+			return err
+		}
+
+		if _, err := c.git("init", "-q"); err != nil {
+			return err
+		}
+
+		if _, err := c.git("remote", "add", "origin", c.cfg.Repository); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.git("fetch", "-q", "origin"); err != nil {
+		return err
+	}
+
+	// new branches start from the local state, empty or the last unpushed commit
+	ref := "refs/remotes/origin/" + c.cfg.Branch
+	if _, err := c.git("rev-parse", "-q", "--verify", ref); err != nil {
+		_, err := c.git("checkout", "-q", "-B", c.cfg.Branch)
+		return err
+	}
+
+	if _, err := c.git("checkout", "-q", "-B", c.cfg.Branch, ref); err != nil {
+		return err
+	}
+
+	_, err := c.git("clean", "-q", "-fd")
+	return err
+}
+
+// definitionFile is the published definition, the Dashboard database ID is left out as
+// tyk-sync matches APIs by their API ID
+func definitionFile(api objects.DBApiDefinition) ([]byte, error) {
+	def := api.APIDefinition
+	def.Id = ""
+	raw, err := json.MarshalIndent(def, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(raw, '\n'), nil
+}
+
+// write replaces the definitions and the index in the publish path with the APIs
+func (c *Controller) write(apis []objects.DBApiDefinition) error {
+	root := filepath.Join(c.cfg.Checkout, c.cfg.Path)
+	dir := filepath.Join(root, defsDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	apis = append([]objects.DBApiDefinition{}, apis...)
+	sort.Slice(apis, func(i, j int) bool { return apis[i].Slug < apis[j].Slug })
+	idx := index{Type: "apidef", Files: make([]apiInfo, 0, len(apis)), Policies: []interface{}{}}
+	for _, a := range apis {
+		raw, err := definitionFile(a)
+		if err != nil {
+			return err
+		}
+
+		name := a.Slug + ".json"
+		if err := ioutil.WriteFile(filepath.Join(dir, name), raw, 0600); err != nil {
+			return err
+		}
+		idx.Files = append(idx.Files, apiInfo{File: defsDir + "/" + name})
+	}
+
+	raw, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(root, indexFile), append(raw, '\n'), 0600)
+}
+
+// Publish writes the managed APIs to the repository and pushes them when they changed
+func (c *Controller) Publish() error {
+	apis, err := listAPIs()
+	if err != nil {
+		return err
+	}
+
+	if err := c.checkout(); err != nil {
+		return err
+	}
+
+	if err := c.write(apis); err != nil {
+		return err
+	}
+
+	path := c.cfg.Path
+	if path == "" {
+		path = "."
+	}
+
+	if _, err := c.git("add", "-A", "--", path); err != nil {
+		return err
+	}
+
+	status, err := c.git("status", "--porcelain", "--", path)
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(status) == "" {
+		return nil
+	}
+
+	msg := fmt.Sprintf("Publish %d APIs", len(apis))
+	_, err = c.git("-c", "user.name="+c.cfg.AuthorName, "-c", "user.email="+c.cfg.AuthorEmail,
+		"commit", "-q", "-m", msg)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.git("push", "-q", "origin", "HEAD:refs/heads/"+c.cfg.Branch); err != nil {
+		return err
+	}
+
+	log.Infof("published %d APIs to %s", len(apis), c.cfg.Repository)
+	return nil
+}
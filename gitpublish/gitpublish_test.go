@@ -0,0 +1,110 @@
+package gitpublish
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+	"github.com/TykTechnologies/tyk/apidef"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func managedAPI(slug string) objects.DBApiDefinition {
+	return objects.DBApiDefinition{APIDefinition: apidef.APIDefinition{
+		Id:    bson.NewObjectId(),
+		APIID: slug + "-id",
+		Slug:  slug,
+		Name:  slug,
+	}}
+}
+
+func TestPublish(t *testing.T) {
+	if _, err := exec.LookPath(gitBinary); err != nil {
+		t.Skip("git client not found")
+	}
+
+	tmp, err := ioutil.TempDir("", "gitpublish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	remote := filepath.Join(tmp, "remote.git")
+	if out, err := exec.Command(gitBinary, "init", "-q", "--bare", remote).CombinedOutput(); err != nil {
+		t.Fatal(string(out))
+	}
+
+	apis := []objects.DBApiDefinition{managedAPI("web"), managedAPI("cart")}
+	oldList := listAPIs
+	defer func() { listAPIs = oldList }()
+	listAPIs = func() ([]objects.DBApiDefinition, error) { return apis, nil }
+
+	c := &Controller{}
+	c.Config(&Config{Repository: remote, Branch: "cluster-a", Path: "clusters/a", Checkout: filepath.Join(tmp, "checkout")})
+	if err := c.Publish(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second clone sees what tyk-sync would read
+	clone := filepath.Join(tmp, "clone")
+	if out, err := exec.Command(gitBinary, "clone", "-q", "-b", "cluster-a", remote, clone).CombinedOutput(); err != nil {
+		t.Fatal(string(out))
+	}
+
+	raw, err := ioutil.ReadFile(filepath.Join(clone, "clusters/a", indexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx := index{}
+	if err := json.Unmarshal(raw, &idx); err != nil {
+		t.Fatal(err)
+	}
+	if idx.Type != "apidef" || len(idx.Files) != 2 || idx.Files[0].File != "apiDefs/cart.json" {
+		t.Fatalf("unexpected index %s", raw)
+	}
+
+	raw, err = ioutil.ReadFile(filepath.Join(clone, "clusters/a", idx.Files[1].File))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	def := apidef.APIDefinition{}
+	if err := json.Unmarshal(raw, &def); err != nil {
+		t.Fatal(err)
+	}
+	if def.APIID != "web-id" || def.Id != "" {
+		t.Fatalf("unexpected definition %s", raw)
+	}
+
+	// unchanged APIs don't add commits, removed ones are deleted
+	if err := c.Publish(); err != nil {
+		t.Fatal(err)
+	}
+
+	apis = apis[:1]
+	if err := c.Publish(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(gitBinary, "--git-dir", remote, "log", "--format=%s", "cluster-a").CombinedOutput()
+	if err != nil {
+		t.Fatal(string(out))
+	}
+	if strings.TrimSpace(string(out)) != "Publish 1 APIs\nPublish 2 APIs" {
+		t.Fatalf("unexpected history %q", out)
+	}
+
+	out, err = exec.Command(gitBinary, "--git-dir", remote, "ls-tree", "-r", "--name-only", "cluster-a").CombinedOutput()
+	if err != nil {
+		t.Fatal(string(out))
+	}
+	if strings.TrimSpace(string(out)) != "clusters/a/.tyk.json\nclusters/a/apiDefs/web.json" {
+		t.Fatalf("unexpected files %q", out)
+	}
+}
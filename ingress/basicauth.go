@@ -0,0 +1,198 @@
+package ingress
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// An ingress protects its APIs with basic auth with "tyk.io/basic-auth-secret": "<name>",
+// every key of the secret in the ingress namespace is a username and its value the
+// password. The provisioned usernames are recorded on the secret so users removed from it
+// are deleted in Tyk too
+const (
+	BasicAuthSecretAnnotation = "tyk.io/basic-auth-secret"
+	basicAuthUsersAnnotation  = "tyk.io/basic-auth-users"
+)
+
+// basicAuthRefresh is how often secrets are checked for new, rotated and removed users
+var basicAuthRefresh = time.Minute
+
+func basicAuthSecret(ing *v1beta1.Ingress) string {
+	return strings.TrimSpace(ing.Annotations[BasicAuthSecretAnnotation])
+}
+
+// basicAuthUsers reads the users of the secret, sorted so the sync order is stable
+func basicAuthUsers(sec *v1.Secret) []tyk.BasicAuthUser {
+	users := make([]tyk.BasicAuthUser, 0, len(sec.Data))
+	for name, pw := range sec.Data {
+		users = append(users, tyk.BasicAuthUser{Username: name, Password: string(pw)})
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+// removedBasicUsers returns the users provisioned before that are no longer in the secret
+func removedBasicUsers(sec *v1.Secret, users []tyk.BasicAuthUser) []string {
+	current := map[string]bool{}
+	for _, u := range users {
+		current[u.Username] = true
+	}
+
+	removed := make([]string, 0)
+	for _, name := range strings.Split(sec.Annotations[basicAuthUsersAnnotation], ",") {
+		if name = strings.TrimSpace(name); name != "" && !current[name] {
+			removed = append(removed, name)
+		}
+	}
+
+	return removed
+}
+
+// basicAuthIngresses returns the managed ingresses using the secret, they share its users
+func (c *ControlServer) basicAuthIngresses(ns, name string) []*v1beta1.Ingress {
+	ings := make([]*v1beta1.Ingress, 0)
+	if c.store == nil {
+		return ings
+	}
+
+	for _, obj := range c.store.List() {
+		ing, ok := obj.(*v1beta1.Ingress)
+		if ok && ing.Namespace == ns && basicAuthSecret(ing) == name && c.checkIngressManaged(ing) {
+			ings = append(ings, ing)
+		}
+	}
+
+	return ings
+}
+
+// ingressAPIs returns the managed APIs created for the ingresses
+func ingressAPIs(ings []*v1beta1.Ingress) ([]objects.DBApiDefinition, error) {
+	sources := map[string]bool{}
+	for _, ing := range ings {
+		sources[fmt.Sprintf("Ingress/%s/%s", ing.Namespace, ing.Name)] = true
+	}
+
+	all, err := tyk.ListManaged()
+	if err != nil {
+		return nil, err
+	}
+
+	apis := make([]objects.DBApiDefinition, 0)
+	for _, a := range all {
+		if src, _ := a.ConfigData[tyk.SourceKey].(string); sources[src] {
+			apis = append(apis, a)
+		}
+	}
+
+	return apis, nil
+}
+
+// provisionBasicAuth syncs the users of the ingress secret after its APIs are synced,
+// failures are reported on the ingress and don't affect the APIs
+func (c *ControlServer) provisionBasicAuth(ing *v1beta1.Ingress) {
+	name := basicAuthSecret(ing)
+	if name == "" {
+		return
+	}
+
+	if err := c.syncBasicAuthSecret(ing.Namespace, name); err != nil {
+		c.recordIngressEvent(ing, v1.EventTypeWarning, "BasicAuthFailed", err.Error())
+	}
+}
+
+func (c *ControlServer) syncBasicAuthSecret(ns, name string) error {
+	if c.client == nil {
+		return nil
+	}
+
+	sec, err := c.client.CoreV1().Secrets(ns).Get(name, v12.GetOptions{})
+	if errors.IsNotFound(err) {
+		return fmt.Errorf("basic auth secret %s/%s not found", ns, name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	apis, err := ingressAPIs(c.basicAuthIngresses(ns, name))
+	if err != nil {
+		return err
+	}
+
+	// users are provisioned once the APIs exist, access rights need their IDs
+	if len(apis) == 0 {
+		return nil
+	}
+
+	users := basicAuthUsers(sec)
+	removed := removedBasicUsers(sec, users)
+	err = tyk.SyncBasicAuthUsers(fmt.Sprintf("Secret/%s/%s", ns, name), users, apis, removed)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(users))
+	for _, u := range users {
+		names = append(names, u.Username)
+	}
+
+	provisioned := strings.Join(names, ",")
+	if sec.Annotations[basicAuthUsersAnnotation] == provisioned {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{basicAuthUsersAnnotation: provisioned},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.client.CoreV1().Secrets(ns).Patch(name, types.MergePatchType, patch)
+	return err
+}
+
+// startBasicAuth rechecks the basic auth secrets on an interval, secret changes don't
+// touch the ingresses so rotated and removed users are picked up here
+func (c *ControlServer) startBasicAuth() {
+	ticker := time.NewTicker(basicAuthRefresh)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-c.stopCh:
+				return
+			}
+
+			seen := map[string]bool{}
+			for _, obj := range c.store.List() {
+				ing, ok := obj.(*v1beta1.Ingress)
+				if !ok || basicAuthSecret(ing) == "" || !c.ownsIngress(ing) {
+					continue
+				}
+
+				key := ing.Namespace + "/" + basicAuthSecret(ing)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				c.provisionBasicAuth(ing)
+			}
+		}
+	}()
+}
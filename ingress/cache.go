@@ -0,0 +1,78 @@
+package ingress
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// Only some paths of an API are cached with e.g. "tyk.io/cache-paths": "/products=5m,
+// /catalogue/.*=5m", the paths are regular expressions and the TTL is optional. The
+// vendored definition has one cache timeout per API and caches every safe method of a
+// path, so paths can't have different TTLs or methods
+const CachePathsAnnotation = "tyk.io/cache-paths"
+
+// cachePaths reads the cache annotation from the effective annotations, nil when the
+// ingress leaves caching to the template
+func (c *ControlServer) cachePaths(ing *v1beta1.Ingress, ann map[string]string) (*tyk.CachePaths, error) {
+	v, ok := ann[CachePathsAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	cp := &tyk.CachePaths{}
+	var ttl time.Duration
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		pth, d := entry, time.Duration(0)
+		if i := strings.LastIndex(entry, "="); i >= 0 {
+			pth = strings.TrimSpace(entry[:i])
+			var err error
+			d, err = time.ParseDuration(strings.TrimSpace(entry[i+1:]))
+			if err != nil || d < time.Second {
+				return nil, fmt.Errorf("invalid cache TTL in %s: %q", CachePathsAnnotation, entry)
+			}
+		}
+
+		if strings.Contains(pth, " ") {
+			msg := fmt.Sprintf("%s: %q, cached paths can't name methods, every safe method is cached", CachePathsAnnotation, entry)
+			c.recordIngressEvent(ing, v1.EventTypeWarning, "UnsupportedAnnotation", msg)
+			return nil, fmt.Errorf("unsupported annotation %s", msg)
+		}
+
+		if !strings.HasPrefix(pth, "/") {
+			return nil, fmt.Errorf("cache path %q in %s needs a leading slash", pth, CachePathsAnnotation)
+		}
+
+		if _, err := regexp.Compile(pth); err != nil {
+			return nil, fmt.Errorf("invalid cache path %q in %s: %v", pth, CachePathsAnnotation, err)
+		}
+
+		if d != 0 {
+			if ttl != 0 && d != ttl {
+				msg := fmt.Sprintf("%s: the Tyk API definition has one cache timeout per API, got %s and %s", CachePathsAnnotation, ttl, d)
+				c.recordIngressEvent(ing, v1.EventTypeWarning, "UnsupportedAnnotation", msg)
+				return nil, fmt.Errorf("unsupported annotation %s", msg)
+			}
+			ttl = d
+		}
+
+		cp.Paths = append(cp.Paths, pth)
+	}
+
+	if len(cp.Paths) == 0 {
+		return nil, fmt.Errorf("%s lists no paths", CachePathsAnnotation)
+	}
+
+	cp.TimeoutSeconds = int64(ttl / time.Second)
+	return cp, nil
+}
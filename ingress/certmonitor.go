@@ -0,0 +1,150 @@
+package ingress
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// certMonitorRefresh is how often uploaded certificates are compared with their secrets
+var certMonitorRefresh = 5 * time.Minute
+
+const defaultCertificateExpiryDays = 14
+
+// certAlerts remembers the last alert per certificate so events are only recorded when
+// the state of a certificate changes
+var certAlertsMu = sync.Mutex{}
+var certAlerts = map[string]string{}
+
+func secretSource(ns, name string) string {
+	return fmt.Sprintf("Secret/%s/%s", ns, name)
+}
+
+func (c *ControlServer) certificateExpiryWindow() time.Duration {
+	days := defaultCertificateExpiryDays
+	if c.cfg != nil && c.cfg.CertificateExpiryDays > 0 {
+		days = c.cfg.CertificateExpiryDays
+	}
+
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// certificateIngresses returns the managed ingresses using the secret for TLS or client
+// certificates, alerts are recorded on them
+func (c *ControlServer) certificateIngresses(ns, name string) []*v1beta1.Ingress {
+	ings := make([]*v1beta1.Ingress, 0)
+	if c.store == nil {
+		return ings
+	}
+
+	for _, obj := range c.store.List() {
+		ing, ok := obj.(*v1beta1.Ingress)
+		if !ok || ing.Namespace != ns || !c.ownsIngress(ing) {
+			continue
+		}
+
+		uses := false
+		for _, t := range ing.Spec.TLS {
+			uses = uses || t.SecretName == name
+		}
+
+		for _, s := range strings.Split(ing.Annotations[ClientMTLSSecretsAnnotation], ",") {
+			uses = uses || strings.TrimSpace(s) == name
+		}
+
+		if uses {
+			ings = append(ings, ing)
+		}
+	}
+
+	return ings
+}
+
+// certificateAlert returns the reason and message to alert on for a tracked certificate,
+// the reason is empty when the certificate is current and not about to expire
+func certificateAlert(t tyk.TrackedCertificate, now time.Time, window time.Duration) (string, string) {
+	switch {
+	case t.Stale:
+		return "CertificateRenewalPending", fmt.Sprintf("%s %s changed but Tyk still has certificate %s (%s), expiring %s",
+			t.Source, t.Field, t.ID, t.Subject, t.NotAfter.Format(time.RFC3339))
+	case !now.Before(t.NotAfter):
+		return "CertificateExpired", fmt.Sprintf("certificate %s (%s) from %s expired %s",
+			t.ID, t.Subject, t.Source, t.NotAfter.Format(time.RFC3339))
+	case t.NotAfter.Sub(now) < window:
+		return "CertificateExpiring", fmt.Sprintf("certificate %s (%s) from %s expires %s",
+			t.ID, t.Subject, t.Source, t.NotAfter.Format(time.RFC3339))
+	}
+
+	return "", ""
+}
+
+// checkCertificates marks certificates whose secret was renewed without the new
+// certificate reaching Tyk, and warns about them and certificates close to expiry
+func (c *ControlServer) checkCertificates() {
+	if c.client == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, t := range tyk.TrackedCertificates() {
+		parts := strings.SplitN(t.Source, "/", 3)
+		if len(parts) != 3 || parts[0] != "Secret" {
+			continue
+		}
+		ns, name := parts[1], parts[2]
+
+		sec, err := c.client.CoreV1().Secrets(ns).Get(name, v12.GetOptions{})
+		if errors.IsNotFound(err) {
+			tyk.UntrackCertificate(t.Source, t.Field)
+			continue
+		}
+
+		if err != nil {
+			log.Errorf("failed to check certificate of %s: %v", t.Source, err)
+			continue
+		}
+
+		fp, err := tyk.CertificateFingerprint(sec.Data[t.Field])
+		t.Stale = err != nil || fp != t.Fingerprint
+		tyk.MarkCertificateStale(t.Source, t.Field, t.Stale)
+
+		reason, msg := certificateAlert(t, now, c.certificateExpiryWindow())
+		key := t.Source + "#" + t.Field
+		certAlertsMu.Lock()
+		changed := certAlerts[key] != reason
+		certAlerts[key] = reason
+		certAlertsMu.Unlock()
+
+		if !changed || reason == "" {
+			continue
+		}
+
+		for _, ing := range c.certificateIngresses(ns, name) {
+			c.recordIngressEvent(ing, v1.EventTypeWarning, reason, msg)
+		}
+	}
+}
+
+// startCertMonitor checks the uploaded certificates on an interval, secret changes don't
+// touch the ingresses so a renewal only reaches Tyk with the next sync of the ingress
+func (c *ControlServer) startCertMonitor() {
+	ticker := time.NewTicker(certMonitorRefresh)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.checkCertificates()
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+}
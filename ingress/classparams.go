@@ -0,0 +1,265 @@
+package ingress
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/conditions"
+	"github.com/TykTechnologies/tyk-k8s/processor"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	classParamsGroup   = "tyk.io"
+	classParamsVersion = "v1alpha1"
+	classParamsKind    = "TykIngressClassParams"
+	classParamsPlural  = "tykingressclassparams"
+
+	classParamsRefresh = time.Minute
+)
+
+// ClassParams are the per-class defaults of a TykIngressClassParams resource referenced
+// by the parameters of our IngressClass, they apply below rules and ingress annotations
+type ClassParams struct {
+	TemplateName string            `json:"templateName"`
+	Tags         []string          `json:"tags"`
+	OrgID        string            `json:"orgId"`
+	AuthMode     string            `json:"authMode"`
+	Annotations  map[string]string `json:"annotations"`
+}
+
+// ingressClassObj is the part of a networking.k8s.io IngressClass we need, the vendored
+// client predates the resource so it is read as raw JSON
+type ingressClassObj struct {
+	Spec struct {
+		Controller string `json:"controller"`
+		Parameters *struct {
+			APIGroup  *string `json:"apiGroup"`
+			Kind      string  `json:"kind"`
+			Name      string  `json:"name"`
+			Scope     *string `json:"scope"`
+			Namespace *string `json:"namespace"`
+		} `json:"parameters"`
+	} `json:"spec"`
+}
+
+type classParamsObj struct {
+	Metadata struct {
+		Generation int64 `json:"generation"`
+	} `json:"metadata"`
+	Spec   ClassParams `json:"spec"`
+	Status struct {
+		Conditions []conditions.Condition `json:"conditions,omitempty"`
+	} `json:"status"`
+}
+
+// authModes maps the authMode of the class onto the API definition flags it enables
+var authModes = map[string]map[string]bool{
+	"keyless": {"use_keyless": true},
+	"token":   {"use_keyless": false, "use_standard_auth": true},
+	"jwt":     {"use_keyless": false, "enable_jwt": true},
+	"basic":   {"use_keyless": false, "use_basic_auth": true},
+	"oauth":   {"use_keyless": false, "use_oauth2": true},
+}
+
+func (p *ClassParams) validate() error {
+	if p.AuthMode == "" {
+		return nil
+	}
+
+	if _, ok := authModes[strings.ToLower(p.AuthMode)]; !ok {
+		return fmt.Errorf("unknown authMode %q in class parameters", p.AuthMode)
+	}
+
+	return nil
+}
+
+// defaults returns the class parameters as default annotations
+func (p *ClassParams) defaults() map[string]string {
+	ann := map[string]string{}
+	if p == nil {
+		return ann
+	}
+
+	for k, v := range p.Annotations {
+		ann[k] = v
+	}
+
+	if p.TemplateName != "" {
+		ann[tyk.TemplateNameKey] = p.TemplateName
+	}
+
+	if p.OrgID != "" {
+		ann[string(processor.ValueSetStringKey)+"org_id"] = p.OrgID
+	}
+
+	for field, on := range authModes[strings.ToLower(p.AuthMode)] {
+		ann[string(processor.ValueSetBoolKey)+field] = fmt.Sprint(on)
+	}
+
+	return ann
+}
+
+func (c *ControlServer) currentClassParams() *ClassParams {
+	c.paramsMu.RLock()
+	defer c.paramsMu.RUnlock()
+	return c.classParams
+}
+
+// fetchClassParams reads the IngressClass for our class and the parameters it points at,
+// classes without parameters, or clusters without IngressClass, have no defaults
+func (c *ControlServer) fetchClassParams() (*ClassParams, error) {
+	rc := c.client.CoreV1().RESTClient()
+	raw, err := rc.Get().AbsPath("/apis/networking.k8s.io/v1/ingressclasses", c.className()).DoRaw()
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ic := &ingressClassObj{}
+	if err := json.Unmarshal(raw, ic); err != nil {
+		return nil, err
+	}
+
+	ref := ic.Spec.Parameters
+	if ref == nil {
+		return nil, nil
+	}
+
+	if ref.APIGroup == nil || *ref.APIGroup != classParamsGroup || ref.Kind != classParamsKind {
+		log.Warning("ignoring unsupported parameters of ingress class ", c.className(), ": ", ref.Kind)
+		return nil, nil
+	}
+
+	path := []string{"/apis", classParamsGroup, classParamsVersion}
+	if ref.Scope != nil && *ref.Scope == "Namespace" && ref.Namespace != nil {
+		path = append(path, "namespaces", *ref.Namespace)
+	}
+	path = append(path, classParamsPlural, ref.Name)
+
+	raw, err = rc.Get().AbsPath(path...).DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load class parameters %s: %v", ref.Name, err)
+	}
+
+	obj := &classParamsObj{}
+	if err := json.Unmarshal(raw, obj); err != nil {
+		return nil, err
+	}
+
+	err = obj.Spec.validate()
+	c.writeClassParamsStatus(path, obj, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &obj.Spec, nil
+}
+
+// writeClassParamsStatus reports whether the parameters were accepted, valid parameters
+// apply as soon as they are loaded
+func (c *ControlServer) writeClassParamsStatus(path []string, obj *classParamsObj, err error) {
+	conds := conditions.Reconciled(obj.Status.Conditions, obj.Metadata.Generation, "InvalidParameters", err, true)
+	if conditions.SameJSON(conds, obj.Status.Conditions) {
+		return
+	}
+
+	body, mErr := json.Marshal(map[string]interface{}{"status": map[string]interface{}{"conditions": conds}})
+	if mErr != nil {
+		log.Error(mErr)
+		return
+	}
+
+	_, pErr := c.client.CoreV1().RESTClient().Patch(types.MergePatchType).
+		AbsPath(append(path, "status")...).Body(body).DoRaw()
+	if pErr != nil {
+		log.Error("failed to update class parameters status: ", pErr)
+	}
+}
+
+// loadClassParams refreshes the class parameters, ingresses are re-synced when they
+// change so the new defaults reach the existing APIs
+func (c *ControlServer) loadClassParams() error {
+	p, err := c.fetchClassParams()
+	if err != nil {
+		return err
+	}
+
+	c.paramsMu.Lock()
+	changed := !reflect.DeepEqual(c.classParams, p)
+	c.classParams = p
+	c.paramsMu.Unlock()
+
+	if changed && c.store != nil {
+		log.Info("ingress class parameters changed, re-syncing ingresses")
+		if err := c.resyncAll(); err != nil {
+			log.Error(err)
+		}
+	}
+
+	return nil
+}
+
+// resyncAll syncs every ingress this replica owns, the error joins the failed syncs
+func (c *ControlServer) resyncAll() error {
+	ings := make([]*v1beta1.Ingress, 0)
+	for _, ing := range c.managedIngresses() {
+		if c.shard.owns(ing.Namespace, ing.Name) {
+			ings = append(ings, ing)
+		}
+	}
+
+	if c.mergeHostsEnabled() {
+		err := c.syncHosts(ingressHosts(ings...))
+		if dbErr := c.syncDefaultBackends(ings...); dbErr != nil {
+			if err == nil {
+				return dbErr
+			}
+			err = fmt.Errorf("%v; %v", err, dbErr)
+		}
+		return err
+	}
+
+	errs := make([]string, 0)
+	for _, ing := range ings {
+		c.beginSync(ing)
+		err := c.updateIngress(ing)
+		if err != nil {
+			c.handleSyncError(ing, err)
+			errs = append(errs, fmt.Sprintf("%s/%s: %v", ing.Namespace, ing.Name, err))
+		} else {
+			c.reportSyncSuccess(ing)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("full sync failed: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func (c *ControlServer) watchClassParams() {
+	ticker := time.NewTicker(classParamsRefresh)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.loadClassParams(); err != nil {
+					log.Error("failed to refresh ingress class parameters: ", err)
+				}
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+}
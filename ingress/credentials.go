@@ -0,0 +1,32 @@
+package ingress
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// namespaceCredentials reads the Dashboard secret of a namespace from the configured
+// <name>/<key> secret reference, namespaces without the secret use the global one
+func (c *ControlServer) namespaceCredentials(ns string) (string, error) {
+	name, key, err := parseKeyRef(c.cfg.NamespaceCredentials)
+	if err != nil {
+		return "", err
+	}
+
+	sec, err := c.client.CoreV1().Secrets(ns).Get(name, v12.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	d, ok := sec.Data[key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in secret %s/%s", key, ns, name)
+	}
+
+	return string(d), nil
+}
@@ -0,0 +1,24 @@
+package ingress
+
+// Stats returns the queue depth and cache sizes of the controller, for the runtime stats
+// endpoint
+func (c *ControlServer) Stats() map[string]int {
+	stats := map[string]int{}
+	if c.store != nil {
+		stats["ingresses"] = len(c.store.ListKeys())
+	}
+
+	if c.queue != nil {
+		stats["queue_depth"] = len(c.queue.pending())
+	}
+
+	hmacMu.Lock()
+	stats["hmac_hashes"] = len(hmacHashes)
+	hmacMu.Unlock()
+
+	certAlertsMu.Lock()
+	stats["certificate_alerts"] = len(certAlerts)
+	certAlertsMu.Unlock()
+
+	return stats
+}
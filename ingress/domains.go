@@ -0,0 +1,121 @@
+package ingress
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// ruleAPI is an API generated for ingress rules, rules of several hosts routing the same
+// path to the same backend share the API unless the slug template tells the hosts apart
+type ruleAPI struct {
+	slug  string
+	path  v1beta1.HTTPIngressPath
+	hosts []string
+	// shadowed are exact hosts of other ingresses its wildcard hosts leave out
+	shadowed []string
+}
+
+// ruleAPIs groups the rule paths the ingress owns by the API they generate, in the order
+// they first appear. Different paths or backends rendering the same slug would overwrite
+// each other's API, the ingress is refused instead
+func (c *ControlServer) ruleAPIs(ing *v1beta1.Ingress) ([]*ruleAPI, error) {
+	others := c.managedIngresses()
+	apis := make([]*ruleAPI, 0)
+	bySlug := map[string]*ruleAPI{}
+	for _, r0 := range ing.Spec.Rules {
+		if r0.HTTP == nil {
+			continue
+		}
+
+		for _, p := range r0.HTTP.Paths {
+			if !c.checkPathOwner(ing, r0.Host, p.Path) {
+				continue
+			}
+
+			slug, err := c.ingressSlug(ing, r0.Host, p)
+			if err != nil {
+				return nil, err
+			}
+
+			if a, ok := bySlug[slug]; ok {
+				if a.path.Path != p.Path || a.path.Backend != p.Backend {
+					return nil, fmt.Errorf("paths %s and %s both generate the API %s, the slug template needs to tell them apart",
+						a.path.Path, p.Path, slug)
+				}
+				a.hosts = appendHost(a.hosts, r0.Host)
+				continue
+			}
+
+			a := &ruleAPI{slug: slug, path: p, hosts: []string{r0.Host}}
+			bySlug[slug] = a
+			apis = append(apis, a)
+		}
+	}
+
+	for _, a := range apis {
+		a.shadowed = shadowedHosts(ing, a.hosts, a.path.Path, others)
+	}
+
+	return apis, nil
+}
+
+func appendHost(hosts []string, host string) []string {
+	for _, h := range hosts {
+		if h == host {
+			return hosts
+		}
+	}
+
+	return append(hosts, host)
+}
+
+// hostsToDomain renders the Tyk domain matching any of the hosts, several hosts become one
+// pattern as the definition only has one domain. A rule without a host matches every host,
+// wildcards don't match the shadowed hosts
+func hostsToDomain(hosts, shadowed []string) string {
+	if len(hosts) == 1 {
+		h := hosts[0]
+		if labels := shadowedLabels(h, shadowed); len(labels) > 0 {
+			return "{subdomain:" + labelPattern(labels) + "}" + h[1:]
+		}
+		return hostToDomain(h)
+	}
+
+	alts := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if h == "" {
+			return ""
+		}
+
+		if strings.HasPrefix(h, wildcardPrefix) {
+			alts = append(alts, labelPattern(shadowedLabels(h, shadowed))+quoteHost(h[1:]))
+			continue
+		}
+
+		alts = append(alts, quoteHost(h))
+	}
+
+	return "{host:(?:" + strings.Join(alts, "|") + ")}"
+}
+
+// quoteHost escapes the dots of a DNS name, with a character class rather than a
+// backslash as the domain is rendered into the JSON templates unescaped
+func quoteHost(h string) string {
+	return strings.Replace(h, ".", "[.]", -1)
+}
+
+// hostsCertificates returns the certificates of the hosts, Tyk picks one by SNI
+func hostsCertificates(certs map[string]string, hosts []string) []string {
+	var ids []string
+	seen := map[string]bool{}
+	for _, h := range hosts {
+		if id, ok := hostCertificate(certs, h); ok && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
@@ -0,0 +1,114 @@
+package ingress
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/notify"
+	"github.com/TykTechnologies/tyk-k8s/reporting"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const eventSource = "tyk-k8s"
+
+// recordIngressEvent surfaces controller decisions on the ingress itself so they show
+// up in `kubectl describe`, without a client the event is only logged
+func (c *ControlServer) recordIngressEvent(ing *v1beta1.Ingress, eventType, reason, message string) {
+	if c.dryRun {
+		return
+	}
+
+	if eventType == v1.EventTypeWarning {
+		c.syncLog(ing).Warningf("%s/%s: %s: %s", ing.Namespace, ing.Name, reason, message)
+	} else {
+		c.syncLog(ing).Infof("%s/%s: %s: %s", ing.Namespace, ing.Name, reason, message)
+	}
+
+	var annotations map[string]string
+	if id := c.syncID(ing); id != "" {
+		message = fmt.Sprintf("%s (sync %s)", message, id)
+		annotations = map[string]string{SyncIDAnnotation: id}
+	}
+
+	if c.client == nil {
+		return
+	}
+
+	now := v12.NewTime(time.Now())
+	ev := &v1.Event{
+		ObjectMeta: v12.ObjectMeta{
+			GenerateName: ing.Name + ".",
+			Namespace:    ing.Namespace,
+			Annotations:  annotations,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:            "Ingress",
+			APIVersion:      "extensions/v1beta1",
+			Namespace:       ing.Namespace,
+			Name:            ing.Name,
+			UID:             ing.UID,
+			ResourceVersion: ing.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: eventSource},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	_, err := c.client.CoreV1().Events(ing.Namespace).Create(ev)
+	if err != nil {
+		log.Error("failed to record event: ", err)
+	}
+}
+
+// handleSyncError reports a failed sync of an ingress, quota errors become an event and
+// unavailable Dashboards queue the sync
+func (c *ControlServer) handleSyncError(ing *v1beta1.Ingress, err error) {
+	if tyk.IsQuotaExceeded(err) {
+		c.recordIngressEvent(ing, v1.EventTypeWarning, "QuotaExceeded", err.Error())
+		c.reportSyncFailure(ing, err)
+		return
+	}
+
+	if tyk.IsTemplateError(err) {
+		c.recordIngressEvent(ing, v1.EventTypeWarning, "TemplateFailed", err.Error())
+		c.reportSyncFailure(ing, err)
+		return
+	}
+
+	// queued syncs are retried by the queue and not reported
+	if !c.queueIfUnavailable(syncOp(ing), err) {
+		c.syncLog(ing).Error(err)
+		c.reportSyncFailure(ing, err)
+	}
+}
+
+// reportTags is the ingress context sent with error reports
+func (c *ControlServer) reportTags(ing *v1beta1.Ingress) map[string]string {
+	tags := map[string]string{"namespace": ing.Namespace, "ingress": ing.Name}
+	if id := c.syncID(ing); id != "" {
+		tags["sync_id"] = id
+	}
+
+	return tags
+}
+
+// reportSyncFailure counts the failure, repeated failures are sent to the error reporting
+// sink and new ones to the notification webhooks
+func (c *ControlServer) reportSyncFailure(ing *v1beta1.Ingress, err error) {
+	key := "Ingress/" + ing.Namespace + "/" + ing.Name
+	reporting.SyncFailed(key, err, c.reportTags(ing))
+	notify.SyncFailure(key, err, c.syncID(ing))
+}
+
+func (c *ControlServer) reportSyncSuccess(ing *v1beta1.Ingress) {
+	key := "Ingress/" + ing.Namespace + "/" + ing.Name
+	reporting.SyncSucceeded(key)
+	notify.SyncSucceeded(key)
+}
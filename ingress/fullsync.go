@@ -0,0 +1,192 @@
+package ingress
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// With fullSyncSeconds set every owned ingress is synced on that interval, changes made to
+// the APIs outside the controller are reverted and the time of the last sync without
+// failures is exported. Once none succeeded for staleSyncSeconds a warning event is
+// recorded on the controller pod, again every window until a full sync succeeds
+
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// fullSyncCheck is how often the window is checked when no full sync is due
+var fullSyncCheck = 10 * time.Second
+
+func (c *ControlServer) fullSyncInterval() time.Duration {
+	if c.cfg == nil || c.cfg.FullSyncSeconds <= 0 {
+		return 0
+	}
+
+	return time.Duration(c.cfg.FullSyncSeconds) * time.Second
+}
+
+func (c *ControlServer) staleSyncWindow() time.Duration {
+	if c.cfg == nil || c.cfg.StaleSyncSeconds <= 0 {
+		return 0
+	}
+
+	return time.Duration(c.cfg.StaleSyncSeconds) * time.Second
+}
+
+// fullSync syncs the owned ingresses and records the time when all of them succeeded
+func (c *ControlServer) fullSync() error {
+	if err := c.resyncAll(); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	c.syncMu.Lock()
+	c.lastFullSync = time.Now()
+	c.staleAlerted = time.Time{}
+	c.syncMu.Unlock()
+	return nil
+}
+
+// checkStaleSync records a warning when no full sync succeeded within the window, counted
+// from the start of the controller before the first one
+func (c *ControlServer) checkStaleSync(now time.Time) bool {
+	window := c.staleSyncWindow()
+	if window == 0 {
+		return false
+	}
+
+	c.syncMu.Lock()
+	since := c.lastFullSync
+	if since.IsZero() {
+		since = c.startedAt
+	}
+
+	alert := now.Sub(since) >= window && now.Sub(c.staleAlerted) >= window
+	if alert {
+		c.staleAlerted = now
+	}
+	c.syncMu.Unlock()
+
+	if !alert {
+		return false
+	}
+
+	msg := fmt.Sprintf("no successful full sync since %s", since.UTC().Format(time.RFC3339))
+	c.recordControllerEvent(v1.EventTypeWarning, "SyncStale", msg)
+	return true
+}
+
+func (c *ControlServer) startFullSync() {
+	interval := c.fullSyncInterval()
+	if interval == 0 {
+		return
+	}
+
+	c.syncMu.Lock()
+	c.startedAt = time.Now()
+	c.syncMu.Unlock()
+
+	check := fullSyncCheck
+	if interval < check {
+		check = interval
+	}
+
+	ticker := time.NewTicker(check)
+	go func() {
+		defer ticker.Stop()
+		next := time.Now().Add(interval)
+		for {
+			select {
+			case now := <-ticker.C:
+				if !now.Before(next) {
+					c.fullSync()
+					next = now.Add(interval)
+				}
+				c.checkStaleSync(now)
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// controllerPod returns the namespace and name of the pod the controller runs in, empty
+// when they can't be found
+func controllerPod() (string, string) {
+	ns := os.Getenv("POD_NAMESPACE")
+	if ns == "" {
+		raw, err := ioutil.ReadFile(namespaceFile)
+		if err == nil {
+			ns = strings.TrimSpace(string(raw))
+		}
+	}
+
+	name := os.Getenv("POD_NAME")
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+
+	if ns == "" || name == "" {
+		return "", ""
+	}
+
+	return ns, name
+}
+
+// recordControllerEvent records an event on the controller pod, it is only logged when
+// the pod is unknown
+func (c *ControlServer) recordControllerEvent(eventType, reason, message string) {
+	log.Warningf("%s: %s", reason, message)
+
+	ns, name := controllerPod()
+	if c.client == nil || ns == "" {
+		return
+	}
+
+	now := v12.NewTime(time.Now())
+	ev := &v1.Event{
+		ObjectMeta: v12.ObjectMeta{
+			GenerateName: name + ".",
+			Namespace:    ns,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Namespace:  ns,
+			Name:       name,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: eventSource},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	if _, err := c.client.CoreV1().Events(ns).Create(ev); err != nil {
+		log.Error("failed to record event: ", err)
+	}
+}
+
+// WriteSyncMetrics writes the time of the last successful full sync in the Prometheus
+// text format, 0 before the first
+func (c *ControlServer) WriteSyncMetrics(w io.Writer) error {
+	c.syncMu.Lock()
+	last := int64(0)
+	if !c.lastFullSync.IsZero() {
+		last = c.lastFullSync.Unix()
+	}
+	c.syncMu.Unlock()
+
+	_, err := fmt.Fprintf(w, "%s\n%s\ntyk_k8s_last_successful_full_sync_timestamp_seconds %d\n",
+		"# HELP tyk_k8s_last_successful_full_sync_timestamp_seconds Time of the last full sync without failures.",
+		"# TYPE tyk_k8s_last_successful_full_sync_timestamp_seconds gauge",
+		last)
+	return err
+}
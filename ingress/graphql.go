@@ -0,0 +1,16 @@
+package ingress
+
+// The GraphQL playground and introspection are meant to be set per ingress with
+// "tyk.io/graphql-playground-path": "/playground" and "tyk.io/graphql-introspection":
+// "false", and the schema loaded from a ConfigMap with "tyk.io/graphql-schema":
+// "<configmap>/<key>". The vendored Tyk API definition has no GraphQL section, APIs can't
+// be GraphQL typed, so these are refused rather than silently leaving introspection on or
+// publishing an API without its schema
+const (
+	GraphQLPlaygroundPathAnnotation = "tyk.io/graphql-playground-path"
+	GraphQLIntrospectionAnnotation  = "tyk.io/graphql-introspection"
+	GraphQLSchemaAnnotation         = "tyk.io/graphql-schema"
+)
+
+// There is no Universal Data Graph resource either, composing Services into one GraphQL
+// API needs the data sources and field mappings of the graphql section as well
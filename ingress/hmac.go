@@ -0,0 +1,278 @@
+package ingress
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HMAC request signing is enabled with "tyk.io/hmac-auth": "true", with
+// "tyk.io/hmac-secret": "<name>" the controller also creates a key for the APIs of the
+// ingress and writes its ID and signing secret to that secret in the ingress namespace
+const (
+	HMACAuthAnnotation       = "tyk.io/hmac-auth"
+	HMACAlgorithmsAnnotation = "tyk.io/hmac-algorithms"
+	HMACClockSkewAnnotation  = "tyk.io/hmac-clock-skew"
+	HMACSecretAnnotation     = "tyk.io/hmac-secret"
+
+	hmacKeyIDField  = "key_id"
+	hmacSecretField = "secret"
+)
+
+var hmacAlgorithms = map[string]bool{
+	"hmac-sha1":   true,
+	"hmac-sha256": true,
+	"hmac-sha384": true,
+	"hmac-sha512": true,
+}
+
+// hmacHashes remembers the key sessions already written per credentials secret
+var hmacMu = sync.Mutex{}
+var hmacHashes = map[string]string{}
+
+// hmacAuth reads the HMAC annotations from the effective annotations, nil when the
+// ingress doesn't sign requests
+func hmacAuth(ann map[string]string) (*tyk.HMACAuth, error) {
+	on := false
+	if v, ok := ann[HMACAuthAnnotation]; ok {
+		var err error
+		on, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", HMACAuthAnnotation, err)
+		}
+	}
+
+	if !on {
+		for _, k := range []string{HMACAlgorithmsAnnotation, HMACClockSkewAnnotation, HMACSecretAnnotation} {
+			if _, ok := ann[k]; ok {
+				return nil, fmt.Errorf("%s needs %s", k, HMACAuthAnnotation)
+			}
+		}
+		return nil, nil
+	}
+
+	h := &tyk.HMACAuth{}
+	for _, a := range strings.Split(ann[HMACAlgorithmsAnnotation], ",") {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == "" {
+			continue
+		}
+
+		if !hmacAlgorithms[a] {
+			return nil, fmt.Errorf("unsupported HMAC algorithm %s", a)
+		}
+		h.Algorithms = append(h.Algorithms, a)
+	}
+
+	if skew, ok := ann[HMACClockSkewAnnotation]; ok {
+		s, err := parseSkew(skew)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", HMACClockSkewAnnotation, err)
+		}
+		h.ClockSkewMillis = float64(s * uint64(time.Second/time.Millisecond))
+	}
+
+	return h, nil
+}
+
+// hmacSecret returns the credentials secret an ingress asks for
+func hmacSecret(ing *v1beta1.Ingress) string {
+	return strings.TrimSpace(ing.Annotations[HMACSecretAnnotation])
+}
+
+// provisionHMAC keeps the key in the credentials secret of the ingress in line with its
+// APIs after they are synced, failures are reported on the ingress and don't affect the
+// APIs
+func (c *ControlServer) provisionHMAC(ing *v1beta1.Ingress) {
+	if hmacSecret(ing) == "" || c.client == nil {
+		return
+	}
+
+	if err := c.syncHMACSecret(ing); err != nil {
+		c.recordIngressEvent(ing, v1.EventTypeWarning, "HMACCredentialsFailed", err.Error())
+	}
+}
+
+// hmacCredentials returns the credentials secret, nil when it doesn't exist. Secrets the
+// ingress doesn't own are refused so unrelated credentials aren't overwritten
+func (c *ControlServer) hmacCredentials(ing *v1beta1.Ingress) (*v1.Secret, error) {
+	sec, err := c.client.CoreV1().Secrets(ing.Namespace).Get(hmacSecret(ing), v12.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range sec.OwnerReferences {
+		if o.UID == ing.UID {
+			return sec, nil
+		}
+	}
+
+	return nil, fmt.Errorf("secret %s/%s exists and is not owned by the ingress", ing.Namespace, sec.Name)
+}
+
+func (c *ControlServer) syncHMACSecret(ing *v1beta1.Ingress) error {
+	apis, err := ingressAPIs([]*v1beta1.Ingress{ing})
+	if err != nil {
+		return err
+	}
+
+	// the key is created once the APIs exist, access rights need their IDs
+	if len(apis) == 0 {
+		return nil
+	}
+
+	sec, err := c.hmacCredentials(ing)
+	if err != nil {
+		return err
+	}
+
+	spec := &tyk.KeySpec{
+		Alias:    ing.Namespace + "/" + ing.Name,
+		APIs:     apis,
+		MetaData: map[string]string{tyk.SourceKey: fmt.Sprintf("Ingress/%s/%s", ing.Namespace, ing.Name)},
+		HMAC:     true,
+	}
+
+	hmacMu.Lock()
+	defer hmacMu.Unlock()
+
+	cacheKey := ing.Namespace + "/" + hmacSecret(ing)
+	keyID, secret := "", ""
+	if sec != nil {
+		keyID, secret = string(sec.Data[hmacKeyIDField]), string(sec.Data[hmacSecretField])
+	}
+
+	if keyID != "" && secret != "" {
+		spec.HMACSecret = secret
+		hash, err := hmacHash(spec)
+		if err != nil {
+			return err
+		}
+
+		if hmacHashes[cacheKey] == hash {
+			return nil
+		}
+
+		if err := tyk.UpdateKey(keyID, false, spec); err != nil {
+			return err
+		}
+
+		hmacHashes[cacheKey] = hash
+		return nil
+	}
+
+	k, err := tyk.CreateKey(spec)
+	if err != nil {
+		return err
+	}
+
+	secret, err = tyk.KeyHMACSecret(k.Key)
+	if err == nil {
+		err = c.writeHMACSecret(ing, sec, k.Key, secret)
+	}
+
+	if err != nil {
+		// without the secret the key is unusable, revoke it and try again next time
+		if err := tyk.DeleteKey(k.Key, false); err != nil {
+			log.Errorf("failed to revoke unsaved hmac key of %s/%s: %v", ing.Namespace, ing.Name, err)
+		}
+		return err
+	}
+
+	spec.HMACSecret = secret
+	if hash, err := hmacHash(spec); err == nil {
+		hmacHashes[cacheKey] = hash
+	}
+	return nil
+}
+
+// hmacHash identifies what the key grants, the APIs are reduced to their IDs so unrelated
+// definition changes don't rewrite the key
+func hmacHash(spec *tyk.KeySpec) (string, error) {
+	ids := make([]string, 0, len(spec.APIs))
+	for _, a := range spec.APIs {
+		ids = append(ids, a.APIID)
+	}
+	sort.Strings(ids)
+
+	raw, err := json.Marshal([]interface{}{spec.Alias, ids, spec.MetaData, spec.HMACSecret})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(raw)), nil
+}
+
+func (c *ControlServer) writeHMACSecret(ing *v1beta1.Ingress, sec *v1.Secret, keyID, secret string) error {
+	data := map[string][]byte{
+		hmacKeyIDField:  []byte(keyID),
+		hmacSecretField: []byte(secret),
+	}
+
+	if sec != nil {
+		sec = sec.DeepCopy()
+		sec.Data = data
+		_, err := c.client.CoreV1().Secrets(sec.Namespace).Update(sec)
+		return err
+	}
+
+	// the secret goes with the ingress, its key is revoked when the ingress is deleted
+	yes := true
+	sec = &v1.Secret{
+		ObjectMeta: v12.ObjectMeta{
+			Name:      hmacSecret(ing),
+			Namespace: ing.Namespace,
+			OwnerReferences: []v12.OwnerReference{{
+				APIVersion: "extensions/v1beta1",
+				Kind:       "Ingress",
+				Name:       ing.Name,
+				UID:        ing.UID,
+				Controller: &yes,
+			}},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: data,
+	}
+
+	_, err := c.client.CoreV1().Secrets(sec.Namespace).Create(sec)
+	return err
+}
+
+// revokeHMAC revokes the key of a deleted ingress, the secret itself is removed by the
+// garbage collector
+func (c *ControlServer) revokeHMAC(ing *v1beta1.Ingress) {
+	if hmacSecret(ing) == "" || c.client == nil {
+		return
+	}
+
+	sec, err := c.hmacCredentials(ing)
+	if err != nil || sec == nil {
+		log.Warningf("hmac key of %s/%s not revoked, its secret can't be read: %v", ing.Namespace, ing.Name, err)
+		return
+	}
+
+	if keyID := string(sec.Data[hmacKeyIDField]); keyID != "" {
+		if err := tyk.DeleteKey(keyID, false); err != nil {
+			log.Error(err)
+		}
+	}
+
+	hmacMu.Lock()
+	delete(hmacHashes, ing.Namespace+"/"+hmacSecret(ing))
+	hmacMu.Unlock()
+}
@@ -0,0 +1,558 @@
+package ingress
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+const wildcardPrefix = "*."
+
+// hostToDomain converts an ingress host into a Tyk domain, wildcard hosts match exactly
+// one DNS label like they do in Kubernetes
+func hostToDomain(host string) string {
+	if strings.HasPrefix(host, wildcardPrefix) {
+		return "{subdomain:[^.]+}" + host[1:]
+	}
+
+	return host
+}
+
+// wildcardCovers checks whether an exact host is matched by a wildcard host
+func wildcardCovers(wildcard, host string) bool {
+	if !strings.HasPrefix(wildcard, wildcardPrefix) || strings.HasPrefix(host, wildcardPrefix) {
+		return false
+	}
+
+	suffix := wildcard[1:]
+	if !strings.HasSuffix(host, suffix) {
+		return false
+	}
+
+	label := strings.TrimSuffix(host, suffix)
+	return label != "" && !strings.Contains(label, ".")
+}
+
+// hostPaths lists the host/path pairs an ingress claims
+func hostPaths(ing *v1beta1.Ingress) map[string][]string {
+	hp := map[string][]string{}
+	for _, r0 := range ing.Spec.Rules {
+		if r0.HTTP == nil {
+			continue
+		}
+
+		for _, p := range r0.HTTP.Paths {
+			hp[r0.Host] = append(hp[r0.Host], normalisePath(p.Path))
+		}
+	}
+
+	return hp
+}
+
+// shadowedHosts lists the exact hosts other ingresses claim the path on that the wildcard
+// hosts would cover too, the exact host wins so they are left out of the wildcard domain
+func shadowedHosts(ing *v1beta1.Ingress, hosts []string, path string, others []*v1beta1.Ingress) []string {
+	pth := normalisePath(path)
+	shadowed := make([]string, 0)
+	for _, o := range others {
+		if sameIngress(o, ing) {
+			continue
+		}
+
+		for h, ps := range hostPaths(o) {
+			covered := false
+			for _, w := range hosts {
+				covered = covered || wildcardCovers(w, h)
+			}
+
+			for _, p := range ps {
+				if covered && p == pth {
+					shadowed = appendHost(shadowed, h)
+				}
+			}
+		}
+	}
+
+	sort.Strings(shadowed)
+	return shadowed
+}
+
+// labelPattern matches a DNS label other than the excluded ones. RE2 has no lookaheads,
+// so the labels are left out by branching on their characters
+func labelPattern(except []string) string {
+	if len(except) == 0 {
+		return "[^.]+"
+	}
+
+	return labelsExcept(except, false)
+}
+
+// labelsExcept matches the strings without dots that are not in the set, the empty string
+// only when emptyOK
+func labelsExcept(set []string, emptyOK bool) string {
+	next := map[byte][]string{}
+	for _, s := range set {
+		if s == "" {
+			emptyOK = false
+			continue
+		}
+		next[s[0]] = append(next[s[0]], s[1:])
+	}
+
+	firsts := make([]string, 0, len(next))
+	for b := range next {
+		firsts = append(firsts, string(b))
+	}
+	sort.Strings(firsts)
+
+	// '-' goes last so it isn't read as a range
+	class := strings.Join(firsts, "")
+	if strings.Contains(class, "-") {
+		class = strings.Replace(class, "-", "", 1) + "-"
+	}
+
+	alts := []string{"[^." + class + "][^.]*"}
+	for _, f := range firsts {
+		alts = append(alts, "["+f+"]"+labelsExcept(next[f[0]], true))
+	}
+
+	pattern := "(?:" + strings.Join(alts, "|") + ")"
+	if emptyOK {
+		pattern += "?"
+	}
+
+	return pattern
+}
+
+// shadowedLabels returns the labels of the hosts the wildcard covers
+func shadowedLabels(wildcard string, hosts []string) []string {
+	labels := make([]string, 0)
+	for _, h := range hosts {
+		if wildcardCovers(wildcard, h) {
+			labels = append(labels, strings.TrimSuffix(h, wildcard[1:]))
+		}
+	}
+
+	return labels
+}
+
+// coveringIngresses returns the ingresses with a wildcard host covering an exact host of
+// the changed ingresses, their domains depend on which exact hosts are claimed
+func coveringIngresses(changed []*v1beta1.Ingress, others []*v1beta1.Ingress) []*v1beta1.Ingress {
+	found := make([]*v1beta1.Ingress, 0)
+	for _, o := range others {
+		if coversHosts(o, changed) {
+			found = append(found, o)
+		}
+	}
+
+	return found
+}
+
+func coversHosts(o *v1beta1.Ingress, changed []*v1beta1.Ingress) bool {
+	covers := false
+	for _, ing := range changed {
+		if sameIngress(o, ing) {
+			return false
+		}
+
+		for w := range hostPaths(o) {
+			for h := range hostPaths(ing) {
+				covers = covers || wildcardCovers(w, h)
+			}
+		}
+	}
+
+	return covers
+}
+
+// syncWildcardOverlaps re-syncs the ingresses whose wildcard hosts cover exact hosts of
+// the changed ingresses, so their domains leave out the exact hosts that claim the same
+// path and the exact host wins whatever order the gateway loads the APIs in. Ingresses of
+// other shards are re-synced by their own replica
+func (c *ControlServer) syncWildcardOverlaps(changed ...*v1beta1.Ingress) {
+	for _, o := range coveringIngresses(changed, c.managedIngresses()) {
+		if !c.ownsIngress(o) {
+			continue
+		}
+
+		log.Info("wildcard hosts overlap a changed ingress, re-syncing ", o.Namespace, "/", o.Name)
+		if err := c.updateIngress(o); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// ingressOlder orders ingresses by precedence, the oldest ingress wins a contested
+// host and path with the namespace and name as a tiebreak, like other controllers
+func ingressOlder(a, b *v1beta1.Ingress) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+
+	return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+}
+
+func sameIngress(a, b *v1beta1.Ingress) bool {
+	return a.Namespace == b.Namespace && a.Name == b.Name
+}
+
+func normalisePath(p string) string {
+	return "/" + strings.Trim(p, "/")
+}
+
+func (c *ControlServer) managedIngresses() []*v1beta1.Ingress {
+	found := make([]*v1beta1.Ingress, 0)
+	if c.store == nil {
+		return found
+	}
+
+	for _, obj := range c.store.List() {
+		ing, ok := obj.(*v1beta1.Ingress)
+		if ok && c.checkIngressManaged(ing) {
+			found = append(found, ing)
+		}
+	}
+
+	return found
+}
+
+// pathOwner returns the ingress that takes precedence for a host and path, longer paths
+// do not conflict as the gateway always matches the longest listen path first
+func pathOwner(ing *v1beta1.Ingress, host, path string, others []*v1beta1.Ingress) *v1beta1.Ingress {
+	owner := ing
+	pth := normalisePath(path)
+	for _, o := range others {
+		if sameIngress(o, ing) {
+			continue
+		}
+
+		for _, p := range hostPaths(o)[host] {
+			if p == pth && ingressOlder(o, owner) {
+				owner = o
+			}
+		}
+	}
+
+	return owner
+}
+
+// checkPathOwner reports whether the ingress may generate an API for the host and path,
+// losing ingresses are told why with an event instead of racing for the Dashboard entry
+func (c *ControlServer) checkPathOwner(ing *v1beta1.Ingress, host, path string) bool {
+	others := c.managedIngresses()
+	owner := pathOwner(ing, host, path, others)
+	if sameIngress(owner, ing) {
+		c.evictLosers(ing, host, path, others)
+		return true
+	}
+
+	c.recordIngressEvent(ing, v1.EventTypeWarning, "RouteConflict",
+		fmt.Sprintf("%s%s is already claimed by %s/%s, skipping", host, normalisePath(path), owner.Namespace, owner.Name))
+	return false
+}
+
+// evictLosers removes APIs that younger ingresses created for the route before the owner
+// was seen, e.g. when the informer delivers them first on start up. The APIs of other
+// shards are left to their replica, which skips the route once it sees the owner
+func (c *ControlServer) evictLosers(owner *v1beta1.Ingress, host, path string, others []*v1beta1.Ingress) {
+	pth := normalisePath(path)
+	for _, o := range others {
+		if sameIngress(o, owner) || c.mergeHostsEnabled() || !c.ownsIngress(o) {
+			continue
+		}
+
+		for _, r0 := range o.Spec.Rules {
+			if r0.Host != host || r0.HTTP == nil {
+				continue
+			}
+
+			for _, p := range r0.HTTP.Paths {
+				if normalisePath(p.Path) != pth {
+					continue
+				}
+
+				sid, err := c.ingressSlug(o, r0.Host, p)
+				if err != nil {
+					log.Error(err)
+					continue
+				}
+
+				if _, err := tyk.GetBySlug(sid); err != nil {
+					continue
+				}
+
+				err = tyk.DeleteBySlug(sid)
+				if err != nil {
+					log.Error(err)
+					continue
+				}
+
+				opLog.Delete("add-" + sid)
+				c.recordIngressEvent(o, v1.EventTypeWarning, "RouteConflict",
+					fmt.Sprintf("%s%s was taken over by %s/%s", host, pth, owner.Namespace, owner.Name))
+			}
+		}
+	}
+}
+
+// reconcileConflicts re-syncs ingresses that share a host and path with a removed
+// ingress, so the next ingress in line takes over the route. Only ingresses of this shard
+// are re-synced, the other replicas see the removal too
+func (c *ControlServer) reconcileConflicts(removed *v1beta1.Ingress) {
+	claimed := hostPaths(removed)
+	for _, o := range c.managedIngresses() {
+		if sameIngress(o, removed) || !c.ownsIngress(o) {
+			continue
+		}
+
+		shared := false
+		for h, ps := range hostPaths(o) {
+			for _, p := range ps {
+				for _, rp := range claimed[h] {
+					if p == rp {
+						shared = true
+					}
+				}
+			}
+		}
+
+		if !shared {
+			continue
+		}
+
+		log.Info("route owner removed, re-syncing ", o.Namespace, "/", o.Name)
+		err := c.updateIngress(o)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// In merge mode every managed ingress rule for a hostname is folded into a single API
+// for that host, paths are routed to their backends using URL rewrites
+
+func (c *ControlServer) mergeHostsEnabled() bool {
+	return c.cfg != nil && c.cfg.MergeHosts
+}
+
+func (c *ControlServer) generateHostID(host string) string {
+	hasher := sha1.New()
+	hasher.Write([]byte("host:" + host))
+	return base64.URLEncoding.EncodeToString(hasher.Sum(nil))
+}
+
+func ingressHosts(ings ...*v1beta1.Ingress) []string {
+	seen := map[string]struct{}{}
+	hosts := make([]string, 0)
+	for _, ing := range ings {
+		if ing == nil {
+			continue
+		}
+
+		for _, r0 := range ing.Spec.Rules {
+			if r0.HTTP == nil {
+				continue
+			}
+
+			if _, ok := seen[r0.Host]; ok {
+				continue
+			}
+
+			seen[r0.Host] = struct{}{}
+			hosts = append(hosts, r0.Host)
+		}
+	}
+
+	return hosts
+}
+
+// managedIngressesForHost returns the managed ingresses from the informer cache that have
+// a rule for the host, ordered by precedence so the merged output is stable
+func (c *ControlServer) managedIngressesForHost(host string) []*v1beta1.Ingress {
+	found := make([]*v1beta1.Ingress, 0)
+	for _, ing := range c.managedIngresses() {
+		for _, h := range ingressHosts(ing) {
+			if h == host {
+				found = append(found, ing)
+				break
+			}
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return ingressOlder(found[i], found[j])
+	})
+
+	return found
+}
+
+// mergeHost builds the API options for a single host-wide API, the first ingress in the
+// list provides the template and annotations
+func (c *ControlServer) mergeHost(host string, ings []*v1beta1.Ingress) (*tyk.APIDefOptions, error) {
+	if len(ings) == 0 {
+		return nil, fmt.Errorf("no ingresses found for host %s", host)
+	}
+
+	ann, err := c.effectiveAnnotations(ings[0])
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &tyk.APIDefOptions{
+		Name:        host,
+		Slug:        c.generateHostID(host),
+		ListenPath:  "/",
+		Hostname:    hostToDomain(host),
+		Tags:        c.ingressTags(ings[0], "ingress"),
+		Annotations: ann,
+		Source:      sourceMeta(ings[0]),
+	}
+
+	if err := c.setSecurity(ings[0], opts); err != nil {
+		return nil, err
+	}
+
+	if err := c.setProxy(ings[0], opts); err != nil {
+		return nil, err
+	}
+	opts.TemplateName = c.selectTemplate(ings[0], opts)
+	opts.SyncID = c.syncID(ings[0])
+
+	// the oldest ingress with a certificate for the host provides it
+	for _, ing := range ings {
+		certs, err := c.ingressCertificates(ing)
+		if err != nil {
+			return nil, err
+		}
+
+		if id, ok := hostCertificate(certs, host); ok {
+			opts.CertificateID = []string{id}
+			break
+		}
+	}
+
+	routes := map[string]string{}
+	for _, ing := range ings {
+		for _, r0 := range ing.Spec.Rules {
+			if r0.Host != host || r0.HTTP == nil {
+				continue
+			}
+
+			for _, p := range r0.HTTP.Paths {
+				pth := normalisePath(p.Path)
+				if _, exists := routes[pth]; exists {
+					c.recordIngressEvent(ing, v1.EventTypeWarning, "RouteConflict",
+						fmt.Sprintf("%s%s is already claimed by an older ingress, skipping", host, pth))
+					continue
+				}
+
+				tgt, err := c.getTarget(ing, p)
+				if err != nil {
+					return nil, err
+				}
+				routes[pth] = tgt
+			}
+		}
+	}
+
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("no paths found for host %s", host)
+	}
+
+	paths := make([]string, 0, len(routes))
+	for pth := range routes {
+		paths = append(paths, pth)
+	}
+
+	// longest paths first so more specific rewrites match before their parents
+	sort.Slice(paths, func(i, j int) bool {
+		if len(paths[i]) == len(paths[j]) {
+			return paths[i] < paths[j]
+		}
+		return len(paths[i]) > len(paths[j])
+	})
+
+	for _, pth := range paths {
+		if pth == "/" {
+			continue
+		}
+		opts.PathRoutes = append(opts.PathRoutes, tyk.PathRoute{Path: pth, Target: routes[pth]})
+	}
+
+	opts.Target = routes["/"]
+	if opts.Target == "" {
+		// no root path, unmatched requests go to the shortest path's backend
+		opts.Target = routes[paths[len(paths)-1]]
+	}
+
+	return opts, nil
+}
+
+// syncHosts re-renders the merged API for each host, hosts without any remaining
+// ingresses have their API removed
+func (c *ControlServer) syncHosts(hosts []string) error {
+	errs := make([]string, 0)
+	for _, host := range hosts {
+		ings := c.managedIngressesForHost(host)
+		if len(ings) == 0 {
+			sid := c.generateHostID(host)
+			err := tyk.DeleteBySlug(sid)
+			if err != nil {
+				log.Error(err)
+				errs = append(errs, err.Error())
+			} else {
+				log.Info("no ingresses left for host ", host, ", deleted: ", sid)
+			}
+			continue
+		}
+
+		opts, err := c.mergeHost(host, ings)
+		if err != nil {
+			log.Error(err)
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		err = tyk.UpdateAPIs(map[string]*tyk.APIDefOptions{opts.Slug: opts})
+		if err != nil {
+			log.Error(err)
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("host sync failed: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// syncDefaultBackends keeps the catch-all APIs of spec.backend in merge mode, they have
+// no host to be merged into so they are synced per ingress next to the host APIs
+func (c *ControlServer) syncDefaultBackends(ings ...*v1beta1.Ingress) error {
+	svcs := map[string]*tyk.APIDefOptions{}
+	for _, ing := range ings {
+		opts, err := c.defaultBackendOptions(ing)
+		if err != nil {
+			c.skipIngress(ing, tyk.SkipInvalid, err)
+			continue
+		}
+
+		if opts != nil {
+			svcs[opts.Slug] = opts
+		}
+	}
+
+	if len(svcs) == 0 {
+		return nil
+	}
+
+	return tyk.UpdateAPIs(svcs)
+}
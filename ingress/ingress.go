@@ -7,12 +7,15 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/TykTechnologies/tyk-k8s/injector"
 	"github.com/TykTechnologies/tyk-k8s/logger"
+	"github.com/TykTechnologies/tyk-k8s/reporting"
 	"github.com/TykTechnologies/tyk-k8s/tyk"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
@@ -24,7 +27,69 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-type Config struct{}
+type Config struct {
+	// EndpointLoadBalancing renders pod IPs into the API target list instead of using the service address
+	EndpointLoadBalancing bool `yaml:"endpointLoadBalancing"`
+	// EndpointCheckPath health checks every pod of the target list on the path, Tyk stops
+	// sending requests to pods failing it
+	EndpointCheckPath string `yaml:"endpointCheckPath"`
+	// MergeHosts generates one API per hostname instead of one per ingress path
+	MergeHosts bool `yaml:"mergeHosts"`
+	// SlugTemplate and NameTemplate override the generated API slug and name, e.g.
+	// "{{.Namespace}}-{{.IngressName}}-{{.Host}}{{.Path}}". Ingresses whose paths render
+	// the same slug are refused
+	SlugTemplate string `yaml:"slugTemplate"`
+	NameTemplate string `yaml:"nameTemplate"`
+	// Rules add processor annotations to matching ingresses
+	Rules []Rule `yaml:"rules"`
+	// TemplateConfigMap loads the templates from a <namespace>/<name> config map instead
+	// of the templates directory, keys are the template names
+	TemplateConfigMap string `yaml:"templateConfigMap"`
+	// QueueFile persists changes made while the Dashboard is unreachable so they can be
+	// replayed, queueing is disabled when it is empty
+	QueueFile         string `yaml:"queueFile"`
+	QueueRetrySeconds int    `yaml:"queueRetrySeconds"`
+	// StrictAnnotations fails the sync of ingresses with unrecognised Tyk annotations
+	StrictAnnotations bool `yaml:"strictAnnotations"`
+	// IngressClass is the kubernetes.io/ingress.class value this controller claims,
+	// defaults to "tyk" so other ingress controllers can run in the same cluster
+	IngressClass string `yaml:"ingressClass"`
+	// DefaultClass also claims ingresses that do not set a class, only one controller in
+	// the cluster should be the default
+	DefaultClass bool `yaml:"defaultClass"`
+	// UseClassParams loads per-class defaults from the TykIngressClassParams referenced by
+	// the parameters of our IngressClass
+	UseClassParams bool `yaml:"useClassParams"`
+	// NginxCompat translates rewrite-target, proxy-body-size and whitelist-source-range
+	// nginx-ingress annotations, hosts merged into one API don't get them. Rewrites follow
+	// nginx-ingress 0.22 and later, paths are regexes and targets may use their groups
+	NginxCompat bool `yaml:"nginxCompat"`
+	// InventoryConfigMap publishes every managed API to a <namespace>/<name> config map,
+	// with its source, last sync time and definition hash
+	InventoryConfigMap string `yaml:"inventoryConfigMap"`
+	// NamespaceCredentials is a <name>/<key> secret reference looked up in the namespace
+	// of every API, when the secret exists its value is used as the Dashboard secret
+	NamespaceCredentials string `yaml:"namespaceCredentials"`
+	// AllowedOrgs are the organisations ingresses may pick with the tyk.io/org-id
+	// annotation instead of the configured org, the annotation is rejected when empty
+	AllowedOrgs []string `yaml:"allowedOrgs"`
+	// CertificateExpiryDays is how close to expiry uploaded certificates get before a
+	// warning is recorded on their ingresses, defaults to 14
+	CertificateExpiryDays int `yaml:"certificateExpiryDays"`
+	// TemplateValues lets templates read labelled ConfigMaps and Secrets, lookups are
+	// disabled when it is not set
+	TemplateValues *TemplateValues `yaml:"templateValues"`
+	// Shards splits the ingresses across that many replicas, each syncing the ones that
+	// hash to its ordinal. The ordinal is read from the StatefulSet pod name unless
+	// ShardOrdinal is set. The other controllers aren't sharded and only run on ordinal 0
+	Shards       int  `yaml:"shards"`
+	ShardOrdinal *int `yaml:"shardOrdinal"`
+	// FullSyncSeconds syncs every owned ingress on that interval, 0 only syncs changes
+	FullSyncSeconds int `yaml:"fullSyncSeconds"`
+	// StaleSyncSeconds records a warning event on the controller pod when no full sync
+	// succeeded for that long, 0 disables the warning
+	StaleSyncSeconds int `yaml:"staleSyncSeconds"`
+}
 
 var ctrl *ControlServer
 var log = logger.GetLogger("ingress")
@@ -33,6 +98,16 @@ var opLog = sync.Map{}
 const (
 	IngressAnnotation      = "kubernetes.io/ingress.class"
 	IngressAnnotationValue = "tyk"
+	LoopTargetAnnotation   = "loop-target.service.tyk.io"
+
+	PathTypeAnnotation       = "path-type.service.tyk.io"
+	ExternalSchemeAnnotation = "external-scheme.service.tyk.io"
+	ExternalPortAnnotation   = "external-port.service.tyk.io"
+	GatewayTagsAnnotation    = "tyk.io/gateway-tags"
+	OrgAnnotation            = "tyk.io/org-id"
+
+	loopScheme = "tyk://"
+	loopSelf   = "self"
 )
 
 type ControlServer struct {
@@ -41,7 +116,27 @@ type ControlServer struct {
 	store             cache.Store
 	ingressController cache.Controller
 	podController     cache.Controller
+	epController      cache.Controller
 	stopCh            chan struct{}
+	slugTpl           *template.Template
+	nameTpl           *template.Template
+	queue             *offlineQueue
+	tplStopCh         chan struct{}
+	classParams       *ClassParams
+	paramsMu          sync.RWMutex
+	// dryRun controllers only build options, see planner
+	dryRun bool
+	// syncIDs holds the ID of the last reconcile per ingress, keyed by namespace/name
+	syncIDs sync.Map
+	// shard limits the synced ingresses, nil syncs all of them
+	shard *shard
+	// syncMu guards the times of the full syncs, see fullsync.go
+	syncMu       sync.Mutex
+	startedAt    time.Time
+	lastFullSync time.Time
+	staleAlerted time.Time
+	// nginxWarnings are the untranslated nginx annotations last reported per ingress
+	nginxWarnings sync.Map
 }
 
 func NewController() *ControlServer {
@@ -60,6 +155,22 @@ func Controller() *ControlServer {
 	return ctrl
 }
 
+func (c *ControlServer) Config(cfg *Config) {
+	if cfg == nil {
+		log.Info("using default ingress config")
+		cfg = &Config{}
+	}
+
+	c.cfg = cfg
+	var err error
+	if c.slugTpl, err = parseNamingTemplate("slug", cfg.SlugTemplate); err != nil {
+		log.Error(err)
+	}
+	if c.nameTpl, err = parseNamingTemplate("name", cfg.NameTemplate); err != nil {
+		log.Error(err)
+	}
+}
+
 func (c *ControlServer) getClient() (*kubernetes.Clientset, error) {
 	cfgF := os.Getenv("TYK_K8S_KUBECONF")
 	var config *rest.Config
@@ -80,14 +191,73 @@ func (c *ControlServer) getClient() (*kubernetes.Clientset, error) {
 }
 
 func (c *ControlServer) Start() error {
-	var err error
+	err := c.configureShard()
+	if err != nil {
+		return err
+	}
+
+	if err := c.checkRules(); err != nil {
+		return err
+	}
+
+	if err := c.checkNamingTemplates(); err != nil {
+		return err
+	}
+
 	c.client, err = c.getClient()
 	if err != nil {
 		return err
 	}
 
+	if c.cfg != nil && c.cfg.NamespaceCredentials != "" {
+		if _, _, err := parseKeyRef(c.cfg.NamespaceCredentials); err != nil {
+			return fmt.Errorf("invalid namespaceCredentials: %v", err)
+		}
+		tyk.SetCredentialsFunc(c.namespaceCredentials)
+	}
+
+	if c.cfg != nil && c.cfg.TemplateValues != nil {
+		tyk.SetValueFunc(c.templateValue)
+	}
+
+	if c.cfg != nil && c.cfg.TemplateConfigMap != "" {
+		err = c.watchTemplates()
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.cfg != nil && c.cfg.UseClassParams {
+		err = c.loadClassParams()
+		if err != nil {
+			return err
+		}
+	}
+
 	c.watchIngresses()
 	c.watchPods()
+	c.startBasicAuth()
+	c.startCertMonitor()
+	if c.endpointLBEnabled() {
+		c.watchEndpoints()
+	}
+
+	if c.cfg != nil && c.cfg.UseClassParams {
+		c.watchClassParams()
+	}
+	c.startFullSync()
+
+	if c.cfg != nil && c.cfg.InventoryConfigMap != "" {
+		err = c.startInventory()
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.cfg != nil && c.cfg.QueueFile != "" {
+		return c.startQueue()
+	}
+
 	return nil
 }
 
@@ -96,6 +266,11 @@ func (c *ControlServer) Stop() error {
 		return fmt.Errorf("not started")
 	}
 
+	if c.tplStopCh != nil {
+		close(c.tplStopCh)
+		c.tplStopCh = nil
+	}
+
 	select {
 	case c.stopCh <- struct{}{}:
 		return nil
@@ -119,9 +294,118 @@ func (c *ControlServer) generateIngressID(ingressName, ns string, p v1beta1.HTTP
 	return sha
 }
 
+// getTarget works out the upstream for an ingress path, if the ingress declares a loop
+// target then the API will proxy to another API in the gateway instead of the service
+func (c *ControlServer) getTarget(ing *v1beta1.Ingress, p v1beta1.HTTPIngressPath) (string, error) {
+	loop, ok := ing.Annotations[LoopTargetAnnotation]
+	if !ok || loop == "" {
+		return c.getServiceTarget(ing, p)
+	}
+
+	if strings.HasPrefix(loop, loopScheme) {
+		return loop, nil
+	}
+
+	if loop == loopSelf {
+		return loopScheme + loopSelf, nil
+	}
+
+	return c.resolveLoopTarget(ing.Namespace, loop)
+}
+
+func (c *ControlServer) getServiceTarget(ing *v1beta1.Ingress, p v1beta1.HTTPIngressPath) (string, error) {
+	svcN := p.Backend.ServiceName
+	svcP := p.Backend.ServicePort.IntVal
+	clusterTarget := fmt.Sprintf("http://%s.%s:%d", svcN, ing.Namespace, svcP)
+
+	if c.client == nil {
+		return clusterTarget, nil
+	}
+
+	svc, err := c.client.CoreV1().Services(ing.Namespace).Get(svcN, v12.GetOptions{})
+	if err != nil {
+		log.Warning("could not fetch service ", svcN, ", using cluster address: ", err)
+		return clusterTarget, nil
+	}
+
+	if svc.Spec.Type == v1.ServiceTypeExternalName {
+		return externalNameTarget(svc, ing, svcP)
+	}
+
+	return clusterTarget, nil
+}
+
+// externalNameTarget builds a target for ExternalName services, these have no cluster-local
+// address so the external DNS name must be used instead
+func externalNameTarget(svc *v1.Service, ing *v1beta1.Ingress, port int32) (string, error) {
+	if svc.Spec.ExternalName == "" {
+		return "", fmt.Errorf("service %s is of type ExternalName but has no external name set", svc.Name)
+	}
+
+	scheme := "http"
+	if s, ok := ing.Annotations[ExternalSchemeAnnotation]; ok && s != "" {
+		scheme = strings.ToLower(s)
+	}
+
+	if pStr, ok := ing.Annotations[ExternalPortAnnotation]; ok && pStr != "" {
+		pt, err := strconv.Atoi(pStr)
+		if err != nil {
+			return "", fmt.Errorf("invalid external port %v: %v", pStr, err)
+		}
+		port = int32(pt)
+	}
+
+	if port == 0 {
+		return fmt.Sprintf("%s://%s", scheme, svc.Spec.ExternalName), nil
+	}
+
+	return fmt.Sprintf("%s://%s:%d", scheme, svc.Spec.ExternalName, port), nil
+}
+
+// resolveLoopTarget finds the API generated for another managed ingress in the same namespace
+func (c *ControlServer) resolveLoopTarget(ns, ingressName string) (string, error) {
+	if c.client == nil {
+		return "", errors.New("no kubernetes client available to resolve loop target")
+	}
+
+	tgtIng, err := c.client.ExtensionsV1beta1().Ingresses(ns).Get(ingressName, v12.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	if !c.checkIngressManaged(tgtIng) {
+		return "", fmt.Errorf("loop target %s is not managed by this controller", ingressName)
+	}
+
+	for _, r0 := range tgtIng.Spec.Rules {
+		if r0.HTTP == nil {
+			continue
+		}
+
+		for _, p := range r0.HTTP.Paths {
+			sid, err := c.ingressSlug(tgtIng, r0.Host, p)
+			if err != nil {
+				return "", err
+			}
+
+			def, err := tyk.GetBySlug(sid)
+			if err != nil {
+				return "", err
+			}
+
+			return loopScheme + def.APIID, nil
+		}
+	}
+
+	return "", fmt.Errorf("loop target %s has no paths", ingressName)
+}
+
 func (c *ControlServer) handleTLS(ing *v1beta1.Ingress) (map[string]string, error) {
 	log.Info("checking for TLS entries")
 	certMap := map[string]string{}
+	if len(ing.Spec.TLS) > 0 && c.client == nil {
+		return nil, errors.New("TLS certificates need a kubernetes client")
+	}
 	for _, iTLS := range ing.Spec.TLS {
 		log.Info("found TLS entry: ", iTLS.String())
 		sec, err := c.client.CoreV1().Secrets(ing.Namespace).Get(iTLS.SecretName, v12.GetOptions{})
@@ -140,7 +424,7 @@ func (c *ControlServer) handleTLS(ing *v1beta1.Ingress) (map[string]string, erro
 		}
 
 		log.Info("creating certificate")
-		id, err := tyk.CreateCertificate(crt, key)
+		id, err := c.uploadCertificate(ing.Namespace, iTLS.SecretName, "tls.crt", crt, key)
 		if err != nil {
 			return nil, err
 		}
@@ -156,62 +440,204 @@ func (c *ControlServer) handleTLS(ing *v1beta1.Ingress) (map[string]string, erro
 
 }
 
-func checkAndGetTemplate(ing *v1beta1.Ingress) string {
-	for k, v := range ing.Annotations {
-		if k == tyk.TemplateNameKey {
-			log.Infof("template annotation found with value: %v", v)
-			return v
+// getPathType reads the path semantics for the ingress, v1beta1 ingresses have no
+// pathType field so it is set for all paths with an annotation
+func getPathType(ing *v1beta1.Ingress) string {
+	pt, ok := ing.Annotations[PathTypeAnnotation]
+	if !ok || pt == "" {
+		return tyk.PathTypePrefix
+	}
+
+	return pt
+}
+
+func (c *ControlServer) generateDefaultBackendID(ingressName, ns string) string {
+	hasher := sha1.New()
+	hasher.Write([]byte(fmt.Sprintf("default:%s.%s", ingressName, ns)))
+	return base64.URLEncoding.EncodeToString(hasher.Sum(nil))
+}
+
+// defaultBackendOptions creates a catch-all API for spec.backend, it has no host and
+// listens on the root so every other API takes precedence over it
+func (c *ControlServer) defaultBackendOptions(ing *v1beta1.Ingress) (*tyk.APIDefOptions, error) {
+	if ing.Spec.Backend == nil {
+		return nil, nil
+	}
+
+	p := v1beta1.HTTPIngressPath{Path: "/", Backend: *ing.Spec.Backend}
+	tgt, err := c.getTarget(ing, p)
+	if err != nil {
+		return nil, err
+	}
+
+	ann, err := c.effectiveAnnotations(ing)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &tyk.APIDefOptions{
+		Name:            c.getAPIName(ing.Name, ing.Spec.Backend.ServiceName),
+		Slug:            c.generateDefaultBackendID(ing.Name, ing.Namespace),
+		ListenPath:      "/",
+		Target:          tgt,
+		TargetList:      c.getTargetList(ing, p),
+		TargetCheckPath: c.endpointCheckPath(),
+		Tags:            c.ingressTags(ing, "ingress", "default-backend"),
+		Annotations:     ann,
+		Source:          sourceMeta(ing),
+		Filters:         c.nginxFilters(ing),
+	}
+
+	if err := c.setSecurity(ing, opts); err != nil {
+		return nil, err
+	}
+
+	if err := c.setProxy(ing, opts); err != nil {
+		return nil, err
+	}
+
+	opts.TemplateName = c.selectTemplate(ing, opts)
+	opts.SyncID = c.syncID(ing)
+	return opts, nil
+}
+
+// ingressTags adds the ingress class tags and the gateway tags from the ingress annotation
+// to the base tags, the configured cluster and segment tags are added when the API is rendered
+func (c *ControlServer) ingressTags(ing *v1beta1.Ingress, base ...string) []string {
+	tags := append([]string{}, base...)
+	extra := strings.Split(ing.Annotations[GatewayTagsAnnotation], ",")
+	if p := c.currentClassParams(); p != nil {
+		extra = append(append([]string{}, p.Tags...), extra...)
+	}
+
+	for _, t := range extra {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+
+		dup := false
+		for _, e := range tags {
+			if e == t {
+				dup = true
+				break
+			}
+		}
+
+		if !dup {
+			tags = append(tags, t)
 		}
 	}
 
+	return tags
+}
+
+func sourceMeta(ing *v1beta1.Ingress) *tyk.SourceMeta {
+	return &tyk.SourceMeta{
+		Kind:        "Ingress",
+		Namespace:   ing.Namespace,
+		Name:        ing.Name,
+		Labels:      ing.Labels,
+		Annotations: ing.Annotations,
+	}
+}
+
+func checkAndGetTemplate(ann map[string]string) string {
+	if v, ok := ann[tyk.TemplateNameKey]; ok {
+		log.Infof("template annotation found with value: %v", v)
+		return v
+	}
+
 	return tyk.DefaultTemplate
 }
 
 func (c *ControlServer) doAdd(ing *v1beta1.Ingress) error {
-	tags := []string{"ingress"}
-	hName := ""
+	tags := c.ingressTags(ing, "ingress")
+	filters := c.nginxFilters(ing)
 
-	certs, err := c.handleTLS(ing)
+	certs, err := c.ingressCertificates(ing)
 	if err != nil {
 		return err
 	}
 
-	for _, r0 := range ing.Spec.Rules {
-		hName = r0.Host
-		certID, addCert := certs[hName]
-		log.Info("checking if cert for host exists: ", r0.Host, ", (", addCert, ")")
+	apis, err := c.ruleAPIs(ing)
+	if err != nil {
+		return err
+	}
 
-		for _, p := range r0.HTTP.Paths {
-			opts := &tyk.APIDefOptions{}
-			opts.ListenPath = p.Path
-			svcN := p.Backend.ServiceName
-			svcP := p.Backend.ServicePort.IntVal
-			opts.Name = c.getAPIName(ing.Name, svcN)
-			opts.Target = fmt.Sprintf("http://%s.%s:%d", svcN, ing.Namespace, svcP)
-			opts.Slug = c.generateIngressID(ing.Name, ing.Namespace, p)
-			opts.TemplateName = checkAndGetTemplate(ing)
-			opts.Hostname = hName
-			opts.Tags = tags
-			opts.Annotations = ing.Annotations
-
-			if addCert {
-				log.Info("injecting certificate ID")
-				opts.CertificateID = []string{certID}
-			}
+	for _, a := range apis {
+		p := a.path
+		opts := &tyk.APIDefOptions{}
+		opts.ListenPath = p.Path
+		opts.Name = c.apiName(ing, a.hosts[0], p)
+		opts.Target, err = c.getTarget(ing, p)
+		if err != nil {
+			c.skipIngress(ing, tyk.SkipInvalid, err)
+			continue
+		}
+		opts.TargetList = c.getTargetList(ing, p)
+		opts.TargetCheckPath = c.endpointCheckPath()
+		opts.Slug = a.slug
+		opts.PathType = getPathType(ing)
+		opts.Hostname = hostsToDomain(a.hosts, a.shadowed)
+		opts.Tags = tags
+		opts.Source = sourceMeta(ing)
+		opts.Filters, opts.ListenPath, err = nginxRewrite(filters, p.Path)
+		if err != nil {
+			c.skipIngress(ing, tyk.SkipInvalid, err)
+			continue
+		}
+		opts.Annotations, err = c.effectiveAnnotations(ing)
+		if err != nil {
+			c.skipIngress(ing, tyk.SkipValidation, err)
+			continue
+		}
+		err = c.setSecurity(ing, opts)
+		if err != nil {
+			c.skipIngress(ing, tyk.SkipInvalid, err)
+			continue
+		}
+		err = c.setProxy(ing, opts)
+		if err != nil {
+			c.skipIngress(ing, tyk.SkipInvalid, err)
+			continue
+		}
+		opts.TemplateName = c.selectTemplate(ing, opts)
+		opts.SyncID = c.syncID(ing)
+		opts.CertificateID = hostsCertificates(certs, a.hosts)
+
+		_, ok := opLog.Load("add-" + opts.Slug)
+		if ok {
+			c.syncLog(ing).Info("ingress already processed")
+			continue
+		}
 
-			_, ok := opLog.Load("add" + opts.Slug)
-			if ok {
-				log.Info("ingress already processed")
-				continue
-			}
+		_, err := tyk.CreateService(opts)
+		if err != nil {
+			c.handleSyncError(ing, err)
+		} else {
+			// remember we processed this
+			opLog.Store("add-"+opts.Slug, struct{}{})
+			c.reportSyncSuccess(ing)
+		}
+	}
 
-			_, err := tyk.CreateService(opts)
-			if err != nil {
-				log.Error(err)
-			} else {
-				// remember we processed this
-				opLog.Store("add-"+opts.Slug, struct{}{})
-			}
+	dbOpts, err := c.defaultBackendOptions(ing)
+	if err != nil {
+		return err
+	}
+
+	if dbOpts != nil {
+		if _, ok := opLog.Load("add-" + dbOpts.Slug); ok {
+			c.syncLog(ing).Info("default backend already processed")
+			return nil
+		}
+
+		_, err := tyk.CreateService(dbOpts)
+		if err != nil {
+			c.handleSyncError(ing, err)
+		} else {
+			opLog.Store("add-"+dbOpts.Slug, struct{}{})
 		}
 	}
 
@@ -226,13 +652,37 @@ func (c *ControlServer) handleIngressAdd(obj interface{}) {
 	}
 
 	if !c.checkIngressManaged(ing) {
+		tyk.RecordSkipped("Ingress", ing.Namespace, tyk.SkipFiltered)
+		return
+	}
+
+	if !c.ownsIngress(ing) {
+		return
+	}
+	c.beginSync(ing)
+	defer reporting.Recover(c.reportTags(ing))
+
+	if c.mergeHostsEnabled() {
+		c.syncHosts(ingressHosts(ing))
+		if err := c.syncDefaultBackends(ing); err != nil {
+			c.handleSyncError(ing, err)
+		}
+		c.publishPortalDocs(ing)
+		c.provisionBasicAuth(ing)
+		c.provisionHMAC(ing)
+		c.provisionPolicy(ing)
 		return
 	}
 
 	err := c.doAdd(ing)
 	if err != nil {
-		log.Error(err)
+		c.syncLog(ing).Error(err)
 	}
+	c.syncWildcardOverlaps(ing)
+	c.publishPortalDocs(ing)
+	c.provisionBasicAuth(ing)
+	c.provisionHMAC(ing)
+	c.provisionPolicy(ing)
 }
 
 func (c *ControlServer) handleIngressUpdate(oldObj interface{}, newObj interface{}) {
@@ -242,94 +692,258 @@ func (c *ControlServer) handleIngressUpdate(oldObj interface{}, newObj interface
 		return
 	}
 
-	if !c.checkIngressManaged(oldIng) {
-		return
-	}
-
 	newIng, ok := newObj.(*v1beta1.Ingress)
 	if !ok {
 		log.Errorf("type not allowed: %v", reflect.TypeOf(newIng))
 		return
 	}
 
-	if !c.checkIngressManaged(newIng) {
+	wasManaged, isManaged := c.ownsIngress(oldIng), c.ownsIngress(newIng)
+	switch {
+	case !wasManaged && !isManaged:
+		return
+	case !wasManaged:
+		// the class was changed to ours, treat it as a new ingress
+		log.Info("ingress class claimed, adding ", newIng.Namespace, "/", newIng.Name)
+		c.handleIngressAdd(newIng)
+		return
+	case !isManaged:
+		// the class was changed to another controller, release the APIs
+		log.Info("ingress class released, removing ", oldIng.Namespace, "/", oldIng.Name)
+		c.handleIngressDelete(oldIng)
 		return
 	}
 
 	if !c.ingressChanged(oldIng, newIng) {
 		return
 	}
+	c.beginSync(newIng)
+	defer reporting.Recover(c.reportTags(newIng))
 
-	tags := []string{"ingress"}
-	hName := ""
-	createOrUpdateList := map[string]*tyk.APIDefOptions{}
+	if oldIng.Spec.Backend != nil && newIng.Spec.Backend == nil {
+		c.deleteDefaultBackend(oldIng)
+	}
 
-	for _, r0 := range newIng.Spec.Rules {
-		hName = r0.Host
+	if c.mergeHostsEnabled() {
+		c.syncHosts(ingressHosts(oldIng, newIng))
+		if err := c.syncDefaultBackends(newIng); err != nil {
+			c.handleSyncError(newIng, err)
+		}
+		c.publishPortalDocs(newIng)
+		c.provisionBasicAuth(newIng)
+		c.provisionHMAC(newIng)
+		c.provisionPolicy(newIng)
+		return
+	}
 
-		for _, p := range r0.HTTP.Paths {
-			opts := &tyk.APIDefOptions{}
-			opts.ListenPath = p.Path
-			svcN := p.Backend.ServiceName
-			svcP := p.Backend.ServicePort.IntVal
-			opts.Name = c.getAPIName(newIng.Name, svcN)
-			opts.Target = fmt.Sprintf("http://%s.%s:%d", svcN, newIng.Namespace, svcP)
-			opts.Slug = c.generateIngressID(newIng.Name, newIng.Namespace, p)
-			opts.TemplateName = checkAndGetTemplate(newIng)
-			opts.Hostname = hName
-			opts.Tags = tags
+	removed, err := c.removedSlugs(oldIng, newIng)
+	if err != nil {
+		c.handleSyncError(newIng, err)
+		return
+	}
 
-			createOrUpdateList[opts.Slug] = opts
+	for _, sid := range removed {
+		err := tyk.DeleteBySlug(sid)
+		if err != nil {
+			if !c.queueIfUnavailable(deleteOp(newIng, sid), err) {
+				log.Error(err)
+			}
+		} else {
+			opLog.Delete("add-" + sid)
+			log.Info("path removed from ingress, deleted: ", sid)
 		}
 	}
 
-	err := tyk.UpdateAPIs(createOrUpdateList)
+	err = c.updateIngress(newIng)
+	if err != nil {
+		c.handleSyncError(newIng, err)
+	} else {
+		c.reportSyncSuccess(newIng)
+	}
+	c.syncWildcardOverlaps(oldIng, newIng)
+	c.publishPortalDocs(newIng)
+	c.provisionBasicAuth(newIng)
+	c.provisionHMAC(newIng)
+	c.provisionPolicy(newIng)
+}
+
+// updateIngress creates or updates the APIs of the ingress rules
+func (c *ControlServer) updateIngress(ing *v1beta1.Ingress) error {
+	list, err := c.getUpdateList(ing)
+	if err != nil {
+		return err
+	}
+
+	return tyk.UpdateAPIs(list)
+}
+
+func (c *ControlServer) getUpdateList(ing *v1beta1.Ingress) (map[string]*tyk.APIDefOptions, error) {
+	tags := c.ingressTags(ing, "ingress")
+	filters := c.nginxFilters(ing)
+	createOrUpdateList := map[string]*tyk.APIDefOptions{}
+
+	// without the certificates the updates would unbind them, leave the APIs as they are
+	certs, err := c.ingressCertificates(ing)
 	if err != nil {
 		log.Error(err)
+		return createOrUpdateList, nil
+	}
+
+	apis, err := c.ruleAPIs(ing)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range apis {
+		p := a.path
+		opts := &tyk.APIDefOptions{}
+		opts.ListenPath = p.Path
+		opts.Name = c.apiName(ing, a.hosts[0], p)
+		tgt, err := c.getTarget(ing, p)
+		if err != nil {
+			c.skipIngress(ing, tyk.SkipInvalid, err)
+			continue
+		}
+		opts.Target = tgt
+		opts.TargetList = c.getTargetList(ing, p)
+		opts.TargetCheckPath = c.endpointCheckPath()
+		opts.Slug = a.slug
+		opts.PathType = getPathType(ing)
+		opts.Hostname = hostsToDomain(a.hosts, a.shadowed)
+		opts.Tags = tags
+		opts.Source = sourceMeta(ing)
+		opts.Filters, opts.ListenPath, err = nginxRewrite(filters, p.Path)
+		if err != nil {
+			c.skipIngress(ing, tyk.SkipInvalid, err)
+			continue
+		}
+		opts.Annotations, err = c.effectiveAnnotations(ing)
+		if err != nil {
+			c.skipIngress(ing, tyk.SkipValidation, err)
+			continue
+		}
+		err = c.setSecurity(ing, opts)
+		if err != nil {
+			c.skipIngress(ing, tyk.SkipInvalid, err)
+			continue
+		}
+		err = c.setProxy(ing, opts)
+		if err != nil {
+			c.skipIngress(ing, tyk.SkipInvalid, err)
+			continue
+		}
+		opts.TemplateName = c.selectTemplate(ing, opts)
+		opts.SyncID = c.syncID(ing)
+		opts.CertificateID = hostsCertificates(certs, a.hosts)
+
+		createOrUpdateList[opts.Slug] = opts
 	}
 
-	return
+	dbOpts, err := c.defaultBackendOptions(ing)
+	if err != nil {
+		log.Error(err)
+	} else if dbOpts != nil {
+		createOrUpdateList[dbOpts.Slug] = dbOpts
+	}
 
+	return createOrUpdateList, nil
 }
 
 func (c *ControlServer) ingressChanged(old *v1beta1.Ingress, new *v1beta1.Ingress) bool {
-	if len(new.Spec.Rules) > 0 {
-		r0 := new.Spec.Rules[0]
-		hName := r0.Host
+	// Each path of each rule maps to its own API, so any change to hosts, paths or backends
+	// means the set of APIs needs to be reconciled
+	if !reflect.DeepEqual(old.Spec, new.Spec) {
+		return true
+	}
 
-		// If hostname changed, re-create
-		if hName != old.Spec.Rules[0].Host {
-			return true
-		}
+	// annotations drive the processors and the class, status updates and resyncs of the
+	// informer change neither
+	return ingressClass(old) != ingressClass(new) || !reflect.DeepEqual(old.Annotations, new.Annotations)
+}
 
-		// New or deleted paths
-		if len(r0.HTTP.Paths) != len(old.Spec.Rules[0].HTTP.Paths) {
-			return true
+// removedSlugs returns the slugs of paths that no longer exist in the updated ingress, their
+// APIs need to be removed as the slugs are path-specific and would otherwise be orphaned
+func (c *ControlServer) removedSlugs(old *v1beta1.Ingress, new *v1beta1.Ingress) ([]string, error) {
+	current := map[string]struct{}{}
+	for _, r0 := range new.Spec.Rules {
+		if r0.HTTP == nil {
+			continue
 		}
 
-		// TODO: Handle if a path is changed
+		for _, p := range r0.HTTP.Paths {
+			sid, err := c.ingressSlug(new, r0.Host, p)
+			if err != nil {
+				return nil, err
+			}
+			current[sid] = struct{}{}
+		}
 	}
 
-	return false
+	removed := make([]string, 0)
+	for _, r0 := range old.Spec.Rules {
+		if r0.HTTP == nil {
+			continue
+		}
+
+		for _, p := range r0.HTTP.Paths {
+			sid, err := c.ingressSlug(old, r0.Host, p)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := current[sid]; !ok {
+				removed = append(removed, sid)
+			}
+		}
+	}
 
+	return removed, nil
 }
 
 func (c *ControlServer) doDelete(oldIng *v1beta1.Ingress) error {
 	for _, r0 := range oldIng.Spec.Rules {
+		if r0.HTTP == nil {
+			continue
+		}
+
 		for _, p := range r0.HTTP.Paths {
-			sid := c.generateIngressID(oldIng.Name, oldIng.Namespace, p)
-			err := tyk.DeleteBySlug(sid)
+			sid, err := c.ingressSlug(oldIng, r0.Host, p)
 			if err != nil {
-				log.Error(err)
+				return err
+			}
+
+			err = tyk.DeleteBySlug(sid)
+			if err != nil {
+				if !c.queueIfUnavailable(deleteOp(oldIng, sid), err) {
+					log.Error(err)
+				}
 			} else {
+				opLog.Delete("add-" + sid)
 				log.Info("deleted: ", sid)
 			}
 		}
 	}
 
+	if oldIng.Spec.Backend != nil {
+		c.deleteDefaultBackend(oldIng)
+	}
+
 	return nil
 }
 
+func (c *ControlServer) deleteDefaultBackend(ing *v1beta1.Ingress) {
+	sid := c.generateDefaultBackendID(ing.Name, ing.Namespace)
+	err := tyk.DeleteBySlug(sid)
+	if err != nil {
+		if !c.queueIfUnavailable(deleteOp(ing, sid), err) {
+			log.Error(err)
+		}
+		return
+	}
+
+	opLog.Delete("add-" + sid)
+	log.Info("deleted default backend: ", sid)
+}
+
 func (c *ControlServer) handleIngressDelete(obj interface{}) {
 	ing, ok := obj.(*v1beta1.Ingress)
 	if !ok {
@@ -337,26 +951,62 @@ func (c *ControlServer) handleIngressDelete(obj interface{}) {
 		return
 	}
 
-	if !c.checkIngressManaged(ing) {
+	if !c.ownsIngress(ing) {
+		return
+	}
+	c.beginSync(ing)
+	defer c.endSync(ing)
+	defer reporting.Recover(c.reportTags(ing))
+
+	c.nginxWarnings.Delete(ing.Namespace + "/" + ing.Name)
+	c.revokeHMAC(ing)
+	c.removePolicy(ing)
+
+	if c.mergeHostsEnabled() {
+		c.syncHosts(ingressHosts(ing))
+		if ing.Spec.Backend != nil {
+			c.deleteDefaultBackend(ing)
+		}
 		return
 	}
 
 	err := c.doDelete(ing)
 	if err != nil {
-		log.Error(err)
+		c.syncLog(ing).Error(err)
 	}
+
+	c.reconcileConflicts(ing)
+	c.syncWildcardOverlaps(ing)
 }
 
+// ingressClass returns the class an ingress asks for, empty when it has none
+func ingressClass(ing *v1beta1.Ingress) string {
+	return strings.TrimSpace(ing.Annotations[IngressAnnotation])
+}
+
+func (c *ControlServer) className() string {
+	if c.cfg == nil || c.cfg.IngressClass == "" {
+		return IngressAnnotationValue
+	}
+
+	return c.cfg.IngressClass
+}
+
+// checkIngressManaged only claims ingresses of our class, ingresses without a class are
+// left to the default controller so several controllers don't fight over them
 func (c *ControlServer) checkIngressManaged(ing *v1beta1.Ingress) bool {
-	for k, v := range ing.Annotations {
-		if k == IngressAnnotation {
-			if strings.ToLower(v) == IngressAnnotationValue {
-				return true
-			}
-		}
+	class := ingressClass(ing)
+	if class == "" {
+		return c.cfg != nil && c.cfg.DefaultClass
 	}
 
-	return false
+	return strings.EqualFold(class, c.className())
+}
+
+// skipIngress reports a path of the ingress no API is synced for
+func (c *ControlServer) skipIngress(ing *v1beta1.Ingress, reason string, err error) {
+	c.syncLog(ing).Error(err)
+	tyk.RecordSkipped("Ingress", ing.Namespace, reason)
 }
 
 func (c *ControlServer) watchIngresses() {
@@ -382,7 +1032,7 @@ func (c *ControlServer) watchPods() {
 	log.Info("Watching for pod deletion")
 	watchList := cache.NewListWatchFromClient(c.client.CoreV1().RESTClient(), "pods", v1.NamespaceAll,
 		fields.Everything())
-	c.store, c.podController = cache.NewInformer(
+	_, c.podController = cache.NewInformer(
 		watchList,
 		&v1.Pod{},
 		time.Second*10,
@@ -395,6 +1045,149 @@ func (c *ControlServer) watchPods() {
 	go c.podController.Run(c.stopCh)
 }
 
+func (c *ControlServer) endpointLBEnabled() bool {
+	return c.cfg != nil && c.cfg.EndpointLoadBalancing
+}
+
+func (c *ControlServer) endpointCheckPath() string {
+	if !c.endpointLBEnabled() {
+		return ""
+	}
+
+	return c.cfg.EndpointCheckPath
+}
+
+func (c *ControlServer) watchEndpoints() {
+	log.Info("Watching for endpoint changes")
+	watchList := cache.NewListWatchFromClient(c.client.CoreV1().RESTClient(), "endpoints", v1.NamespaceAll,
+		fields.Everything())
+	_, c.epController = cache.NewInformer(
+		watchList,
+		&v1.Endpoints{},
+		time.Second*10,
+		cache.ResourceEventHandlerFuncs{
+			UpdateFunc: c.handleEndpointsUpdate,
+		},
+	)
+
+	go c.epController.Run(c.stopCh)
+}
+
+func (c *ControlServer) handleEndpointsUpdate(oldObj interface{}, newObj interface{}) {
+	oldEp, ok := oldObj.(*v1.Endpoints)
+	if !ok {
+		log.Errorf("type not allowed for endpoints watcher: %v", reflect.TypeOf(oldObj))
+		return
+	}
+
+	newEp, ok := newObj.(*v1.Endpoints)
+	if !ok {
+		log.Errorf("type not allowed for endpoints watcher: %v", reflect.TypeOf(newObj))
+		return
+	}
+
+	// resyncs fire updates too, only act on real changes
+	if reflect.DeepEqual(oldEp.Subsets, newEp.Subsets) {
+		return
+	}
+
+	for _, ing := range c.managedIngresses() {
+		if ing.Namespace != newEp.Namespace || !c.ownsIngress(ing) || !ingressUsesService(ing, newEp.Name) {
+			continue
+		}
+
+		log.Info("endpoints changed for ", newEp.Name, ", updating ingress ", ing.Name)
+		if c.mergeHostsEnabled() {
+			c.syncHosts(ingressHosts(ing))
+			continue
+		}
+
+		if err := c.updateIngress(ing); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+func ingressUsesService(ing *v1beta1.Ingress, svcName string) bool {
+	for _, r0 := range ing.Spec.Rules {
+		if r0.HTTP == nil {
+			continue
+		}
+
+		for _, p := range r0.HTTP.Paths {
+			if p.Backend.ServiceName == svcName {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// getTargetList returns the pod addresses backing an ingress path when endpoint load
+// balancing is enabled, an empty list means the single service target is used
+func (c *ControlServer) getTargetList(ing *v1beta1.Ingress, p v1beta1.HTTPIngressPath) []string {
+	if !c.endpointLBEnabled() || c.client == nil {
+		return nil
+	}
+
+	// loop targets never go to the pods directly
+	if _, isLoop := ing.Annotations[LoopTargetAnnotation]; isLoop {
+		return nil
+	}
+
+	svcN := p.Backend.ServiceName
+	svc, err := c.client.CoreV1().Services(ing.Namespace).Get(svcN, v12.GetOptions{})
+	if err != nil {
+		log.Error("failed to fetch service for endpoint balancing: ", err)
+		return nil
+	}
+
+	if svc.Spec.Type == v1.ServiceTypeExternalName {
+		return nil
+	}
+
+	ep, err := c.client.CoreV1().Endpoints(ing.Namespace).Get(svcN, v12.GetOptions{})
+	if err != nil {
+		log.Error("failed to fetch endpoints for balancing: ", err)
+		return nil
+	}
+
+	return endpointTargets(svc, ep, p.Backend.ServicePort.IntVal)
+}
+
+// endpointTargets maps a service port to the ready pod addresses listening for it
+func endpointTargets(svc *v1.Service, ep *v1.Endpoints, port int32) []string {
+	portName := ""
+	for _, sp := range svc.Spec.Ports {
+		if sp.Port == port {
+			portName = sp.Name
+			break
+		}
+	}
+
+	targets := make([]string, 0)
+	for _, ss := range ep.Subsets {
+		var epPort int32
+		for _, pt := range ss.Ports {
+			if pt.Name == portName || len(ss.Ports) == 1 {
+				epPort = pt.Port
+				break
+			}
+		}
+
+		if epPort == 0 {
+			continue
+		}
+
+		for _, addr := range ss.Addresses {
+			targets = append(targets, fmt.Sprintf("http://%s:%d", addr.IP, epPort))
+		}
+	}
+
+	return targets
+}
+
 func (c *ControlServer) handlePodDeleteForMesh(pd *v1.Pod) {
 	log.Info("pod is injector-managed")
 
@@ -1,16 +1,30 @@
 package ingress
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/TykTechnologies/tyk-git/clients/objects"
 	"github.com/TykTechnologies/tyk-k8s/tyk"
 	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var lastResponse = ""
@@ -40,7 +54,7 @@ func serverSetup() {
 
 		js, _ := json.Marshal(d)
 
-		fmt.Fprintf(w, string(js))
+		fmt.Fprint(w, string(js))
 	})
 
 	running = true
@@ -48,6 +62,17 @@ func serverSetup() {
 	running = false
 }
 
+func waitForServer(addr string) {
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestControlServer_getAPIName(t *testing.T) {
 	x := NewController()
 	n := x.getAPIName("foo", "bar")
@@ -58,6 +83,7 @@ func TestControlServer_getAPIName(t *testing.T) {
 
 func TestControlServer_doAdd(t *testing.T) {
 	go serverSetup()
+	waitForServer("localhost:9696")
 	x := NewController()
 	ing := &v1beta1.Ingress{
 		ObjectMeta: v1.ObjectMeta{
@@ -119,6 +145,7 @@ func TestControlServer_doAdd(t *testing.T) {
 
 func TestControlServer_doAddWithCustomTemplate(t *testing.T) {
 	go serverSetup()
+	waitForServer("localhost:9696")
 	x := NewController()
 	ing := &v1beta1.Ingress{
 		ObjectMeta: v1.ObjectMeta{
@@ -184,3 +211,1503 @@ func TestControlServer_doAddWithCustomTemplate(t *testing.T) {
 
 	lastResponse = ""
 }
+
+func TestControlServer_getTarget(t *testing.T) {
+	x := NewController()
+	p := v1beta1.HTTPIngressPath{
+		Path: "/",
+		Backend: v1beta1.IngressBackend{
+			ServiceName: "foo-service",
+			ServicePort: intstr.IntOrString{IntVal: 80, StrVal: "80"},
+		},
+	}
+
+	scenarios := []struct {
+		Annotations map[string]string
+		Expected    string
+	}{
+		{map[string]string{}, "http://foo-service.bar-namespace:80"},
+		{map[string]string{LoopTargetAnnotation: "self"}, "tyk://self"},
+		{map[string]string{LoopTargetAnnotation: "tyk://my-api-id/foo"}, "tyk://my-api-id/foo"},
+	}
+
+	for _, sc := range scenarios {
+		ing := &v1beta1.Ingress{
+			ObjectMeta: v1.ObjectMeta{
+				Name:        "foo-name",
+				Namespace:   "bar-namespace",
+				Annotations: sc.Annotations,
+			},
+		}
+
+		tgt, err := x.getTarget(ing, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tgt != sc.Expected {
+			t.Fatalf("expected target %v, got %v", sc.Expected, tgt)
+		}
+	}
+}
+
+func TestExternalNameTarget(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: v1.ObjectMeta{Name: "ext-service"},
+		Spec: corev1.ServiceSpec{
+			Type:         corev1.ServiceTypeExternalName,
+			ExternalName: "api.example.com",
+		},
+	}
+
+	scenarios := []struct {
+		Annotations map[string]string
+		Port        int32
+		Expected    string
+	}{
+		{map[string]string{}, 80, "http://api.example.com:80"},
+		{map[string]string{}, 0, "http://api.example.com"},
+		{map[string]string{ExternalSchemeAnnotation: "https", ExternalPortAnnotation: "443"}, 80, "https://api.example.com:443"},
+	}
+
+	for _, sc := range scenarios {
+		ing := &v1beta1.Ingress{ObjectMeta: v1.ObjectMeta{Annotations: sc.Annotations}}
+		tgt, err := externalNameTarget(svc, ing, sc.Port)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tgt != sc.Expected {
+			t.Fatalf("expected target %v, got %v", sc.Expected, tgt)
+		}
+	}
+
+	bad := &v1beta1.Ingress{ObjectMeta: v1.ObjectMeta{Annotations: map[string]string{ExternalPortAnnotation: "abc"}}}
+	if _, err := externalNameTarget(svc, bad, 80); err == nil {
+		t.Fatal("expected error for invalid port")
+	}
+}
+
+func TestEndpointTargets(t *testing.T) {
+	svc := &corev1.Service{
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80},
+				{Name: "metrics", Port: 9090},
+			},
+		},
+	}
+
+	ep := &corev1.Endpoints{
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+				Ports: []corev1.EndpointPort{
+					{Name: "metrics", Port: 9091},
+					{Name: "http", Port: 8080},
+				},
+			},
+		},
+	}
+
+	tgts := endpointTargets(svc, ep, 80)
+	if len(tgts) != 2 {
+		t.Fatalf("expected 2 targets, got %v", tgts)
+	}
+
+	if tgts[0] != "http://10.0.0.1:8080" || tgts[1] != "http://10.0.0.2:8080" {
+		t.Fatalf("unexpected targets: %v", tgts)
+	}
+}
+
+func TestControlServer_removedSlugs(t *testing.T) {
+	x := NewController()
+	mkIng := func(paths ...string) *v1beta1.Ingress {
+		ps := make([]v1beta1.HTTPIngressPath, 0)
+		for _, p := range paths {
+			ps = append(ps, v1beta1.HTTPIngressPath{
+				Path:    p,
+				Backend: v1beta1.IngressBackend{ServiceName: "foo-service"},
+			})
+		}
+
+		return &v1beta1.Ingress{
+			ObjectMeta: v1.ObjectMeta{Name: "foo-name", Namespace: "bar-namespace"},
+			Spec: v1beta1.IngressSpec{
+				Rules: []v1beta1.IngressRule{
+					{Host: "foo.com", IngressRuleValue: v1beta1.IngressRuleValue{HTTP: &v1beta1.HTTPIngressRuleValue{Paths: ps}}},
+					{Host: "bar.com"},
+				},
+			},
+		}
+	}
+
+	old := mkIng("/a", "/b", "/c")
+	updated := mkIng("/a", "/d", "/c")
+
+	if !x.ingressChanged(old, updated) {
+		t.Fatal("changed path should be detected")
+	}
+
+	if x.ingressChanged(old, mkIng("/a", "/b", "/c")) {
+		t.Fatal("identical rules should not be detected as changed")
+	}
+
+	annotated := mkIng("/a", "/b", "/c")
+	annotated.Annotations = map[string]string{"bool.service.tyk.io/use_keyless": "false"}
+	if !x.ingressChanged(old, annotated) {
+		t.Fatal("changed annotations should be detected")
+	}
+
+	removed, err := x.removedSlugs(old, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expSlug := x.generateIngressID("foo-name", "bar-namespace", v1beta1.HTTPIngressPath{
+		Path:    "/b",
+		Backend: v1beta1.IngressBackend{ServiceName: "foo-service"},
+	})
+	if len(removed) != 1 || removed[0] != expSlug {
+		t.Fatalf("expected /b to be removed, got %v", removed)
+	}
+}
+
+func TestControlServer_mergeHost(t *testing.T) {
+	x := NewController()
+	mkIng := func(name string, paths map[string]string) *v1beta1.Ingress {
+		ps := make([]v1beta1.HTTPIngressPath, 0)
+		for p, svc := range paths {
+			ps = append(ps, v1beta1.HTTPIngressPath{
+				Path:    p,
+				Backend: v1beta1.IngressBackend{ServiceName: svc, ServicePort: intstr.IntOrString{IntVal: 80}},
+			})
+		}
+
+		return &v1beta1.Ingress{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "bar-namespace"},
+			Spec: v1beta1.IngressSpec{
+				Rules: []v1beta1.IngressRule{
+					{Host: "foo.com", IngressRuleValue: v1beta1.IngressRuleValue{HTTP: &v1beta1.HTTPIngressRuleValue{Paths: ps}}},
+				},
+			},
+		}
+	}
+
+	ings := []*v1beta1.Ingress{
+		mkIng("a", map[string]string{"/": "root-svc", "/api": "api-svc"}),
+		mkIng("b", map[string]string{"/api/v2/": "v2-svc", "/api": "ignored-svc"}),
+	}
+
+	opts, err := x.mergeHost("foo.com", ings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.Target != "http://root-svc.bar-namespace:80" {
+		t.Fatal("root path should be the default target, got ", opts.Target)
+	}
+
+	if opts.Hostname != "foo.com" || opts.ListenPath != "/" {
+		t.Fatalf("unexpected host or listen path: %v %v", opts.Hostname, opts.ListenPath)
+	}
+
+	if len(opts.PathRoutes) != 2 {
+		t.Fatalf("expected 2 path routes, got %v", opts.PathRoutes)
+	}
+
+	if opts.PathRoutes[0].Path != "/api/v2" || opts.PathRoutes[0].Target != "http://v2-svc.bar-namespace:80" {
+		t.Fatalf("longest path should be routed first, got %v", opts.PathRoutes[0])
+	}
+
+	if opts.PathRoutes[1].Target != "http://api-svc.bar-namespace:80" {
+		t.Fatalf("first ingress should own a duplicate path, got %v", opts.PathRoutes[1])
+	}
+}
+
+func TestWildcardHosts(t *testing.T) {
+	if d := hostToDomain("*.example.com"); d != "{subdomain:[^.]+}.example.com" {
+		t.Fatal("unexpected wildcard domain: ", d)
+	}
+
+	if d := hostToDomain("foo.example.com"); d != "foo.example.com" {
+		t.Fatal("exact host should not change, got: ", d)
+	}
+
+	scenarios := []struct {
+		Wildcard string
+		Host     string
+		Covers   bool
+	}{
+		{"*.example.com", "foo.example.com", true},
+		{"*.example.com", "foo.bar.example.com", false},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "*.example.com", false},
+		{"foo.example.com", "foo.example.com", false},
+	}
+
+	for _, sc := range scenarios {
+		if wildcardCovers(sc.Wildcard, sc.Host) != sc.Covers {
+			t.Fatalf("expected %v covers %v to be %v", sc.Wildcard, sc.Host, sc.Covers)
+		}
+	}
+
+	mkIng := func(name, host, path string) *v1beta1.Ingress {
+		return &v1beta1.Ingress{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "bar-namespace"},
+			Spec: v1beta1.IngressSpec{
+				Rules: []v1beta1.IngressRule{
+					{Host: host, IngressRuleValue: v1beta1.IngressRuleValue{HTTP: &v1beta1.HTTPIngressRuleValue{
+						Paths: []v1beta1.HTTPIngressPath{{Path: path}},
+					}}},
+				},
+			},
+		}
+	}
+
+	wc := mkIng("wild", "*.example.com", "/")
+	others := []*v1beta1.Ingress{
+		wc,
+		mkIng("exact", "foo.example.com", "/"),
+		mkIng("exact-other-path", "foo.example.com", "/api"),
+		mkIng("unrelated", "foo.other.com", "/"),
+	}
+
+	shadowed := shadowedHosts(wc, []string{"*.example.com"}, "/", others)
+	if strings.Join(shadowed, ",") != "foo.example.com" {
+		t.Fatalf("only the exact host claiming the same path should be shadowed, got %v", shadowed)
+	}
+
+	// the exact host wins, the wildcard API doesn't match it whatever the load order
+	d := hostsToDomain([]string{"*.example.com"}, append(shadowed, "fo-o.example.com"))
+	if !strings.HasPrefix(d, "{subdomain:") || !strings.HasSuffix(d, "}.example.com") {
+		t.Fatal("unexpected wildcard domain: ", d)
+	}
+
+	label := regexp.MustCompile("^" + strings.TrimSuffix(strings.TrimPrefix(d, "{subdomain:"), "}.example.com") + "$")
+	for l, match := range map[string]bool{"foo": false, "fo-o": false, "bar": true, "fo": true, "f": true, "fooo": true, "fo-": true, "": false} {
+		if label.MatchString(l) != match {
+			t.Fatalf("expected %s matching %s to be %v", d, l, match)
+		}
+	}
+
+	x := &ControlServer{store: cache.NewStore(cache.MetaNamespaceKeyFunc)}
+	x.Config(&Config{DefaultClass: true})
+	for _, ing := range others {
+		x.store.Add(ing)
+	}
+
+	apis, err := x.ruleAPIs(wc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apis) != 1 || strings.Join(apis[0].shadowed, ",") != "foo.example.com" {
+		t.Fatalf("the wildcard API should leave the exact host out, got %+v", apis)
+	}
+
+	if cov := coveringIngresses([]*v1beta1.Ingress{others[1]}, others); len(cov) != 1 || cov[0] != wc {
+		t.Fatal("the wildcard ingress should be re-synced when the exact host changes, got ", cov)
+	}
+}
+
+func TestConflictsOfOtherShards(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `{"apis": [], "pages": 1}`)
+			return
+		}
+		fmt.Fprint(w, `{"status": "ok", "meta": "new"}`)
+	}))
+	defer srv.Close()
+
+	if err := tyk.Init(&tyk.TykConf{URL: srv.URL, Secret: "secret", Org: "org", LookupCacheSeconds: -1}); err != nil {
+		t.Fatal(err)
+	}
+
+	mkIng := func(name, host string) *v1beta1.Ingress {
+		return &v1beta1.Ingress{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "bar-namespace"},
+			Spec: v1beta1.IngressSpec{Rules: []v1beta1.IngressRule{
+				{Host: host, IngressRuleValue: v1beta1.IngressRuleValue{HTTP: &v1beta1.HTTPIngressRuleValue{
+					Paths: []v1beta1.HTTPIngressPath{{Path: "/", Backend: v1beta1.IngressBackend{ServiceName: name, ServicePort: intstr.FromInt(80)}}},
+				}}},
+			}},
+		}
+	}
+
+	wild, exact := mkIng("wild", "*.example.com"), mkIng("exact", "foo.example.com")
+	x := &ControlServer{store: cache.NewStore(cache.MetaNamespaceKeyFunc), shard: &shard{count: 2}}
+	x.Config(&Config{DefaultClass: true})
+	x.store.Add(wild)
+	x.store.Add(exact)
+
+	// this replica runs the shard the wildcard ingress is not on
+	if x.shard.owns(wild.Namespace, wild.Name) {
+		x.shard.ordinal = 1
+	}
+
+	x.syncWildcardOverlaps(exact)
+	x.reconcileConflicts(mkIng("removed", "*.example.com"))
+	x.evictLosers(exact, "*.example.com", "/", []*v1beta1.Ingress{wild})
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Fatalf("ingresses of other shards should be left to their replica, got %d requests", n)
+	}
+
+	x.shard.ordinal = 1 - x.shard.ordinal
+	x.syncWildcardOverlaps(exact)
+	if atomic.LoadInt32(&requests) == 0 {
+		t.Fatal("the wildcard ingress of this shard should be re-synced")
+	}
+}
+
+func TestControlServer_defaultBackendOptions(t *testing.T) {
+	x := NewController()
+	ing := &v1beta1.Ingress{
+		ObjectMeta: v1.ObjectMeta{Name: "foo-name", Namespace: "bar-namespace"},
+	}
+
+	opts, err := x.defaultBackendOptions(ing)
+	if err != nil || opts != nil {
+		t.Fatal("no options expected without a default backend")
+	}
+
+	ing.Spec.Backend = &v1beta1.IngressBackend{
+		ServiceName: "fallback",
+		ServicePort: intstr.IntOrString{IntVal: 8080},
+	}
+
+	opts, err = x.defaultBackendOptions(ing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.ListenPath != "/" || opts.Hostname != "" {
+		t.Fatalf("default backend should be a catch-all, got %v on %v", opts.ListenPath, opts.Hostname)
+	}
+
+	if opts.Target != "http://fallback.bar-namespace:8080" {
+		t.Fatal("unexpected default backend target: ", opts.Target)
+	}
+
+	if opts.Slug == x.generateIngressID(ing.Name, ing.Namespace, v1beta1.HTTPIngressPath{Path: "/", Backend: *ing.Spec.Backend}) {
+		t.Fatal("default backend slug should not collide with a rule path")
+	}
+}
+
+func TestPathOwner(t *testing.T) {
+	mkIng := func(name string, created time.Time, path string) *v1beta1.Ingress {
+		return &v1beta1.Ingress{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "bar-namespace", CreationTimestamp: v1.NewTime(created)},
+			Spec: v1beta1.IngressSpec{
+				Rules: []v1beta1.IngressRule{
+					{Host: "foo.com", IngressRuleValue: v1beta1.IngressRuleValue{HTTP: &v1beta1.HTTPIngressRuleValue{
+						Paths: []v1beta1.HTTPIngressPath{{Path: path}},
+					}}},
+				},
+			},
+		}
+	}
+
+	now := time.Now()
+	oldest := mkIng("z-oldest", now.Add(-time.Hour), "/api")
+	tied := mkIng("a-tied", now, "/api/")
+	newest := mkIng("b-tied", now, "/api")
+	longer := mkIng("longer", now.Add(-2*time.Hour), "/api/v2")
+	all := []*v1beta1.Ingress{oldest, tied, newest, longer}
+
+	if o := pathOwner(newest, "foo.com", "/api", all); !sameIngress(o, oldest) {
+		t.Fatal("oldest ingress should own the path, got ", o.Name)
+	}
+
+	if o := pathOwner(newest, "foo.com", "/api", []*v1beta1.Ingress{tied, newest}); !sameIngress(o, tied) {
+		t.Fatal("name should break creation time ties, got ", o.Name)
+	}
+
+	if o := pathOwner(oldest, "bar.com", "/api", all); !sameIngress(o, oldest) {
+		t.Fatal("other hosts should not conflict, got ", o.Name)
+	}
+
+	if o := pathOwner(oldest, "foo.com", "/api", []*v1beta1.Ingress{oldest, longer}); !sameIngress(o, oldest) {
+		t.Fatal("longer paths should not conflict, got ", o.Name)
+	}
+}
+
+func TestControlServer_namingTemplates(t *testing.T) {
+	x := NewController()
+	defer x.Config(nil)
+
+	ing := &v1beta1.Ingress{ObjectMeta: v1.ObjectMeta{Name: "foo-name", Namespace: "bar-namespace"}}
+	p := v1beta1.HTTPIngressPath{
+		Path:    "/api",
+		Backend: v1beta1.IngressBackend{ServiceName: "foo-service", ServicePort: intstr.IntOrString{IntVal: 80}},
+	}
+
+	x.Config(&Config{})
+	if s, _ := x.ingressSlug(ing, "foo.com", p); s != x.generateIngressID(ing.Name, ing.Namespace, p) {
+		t.Fatal("default slug should be the ingress hash")
+	}
+
+	if x.apiName(ing, "foo.com", p) != "foo-name:foo-service" {
+		t.Fatal("default name should be ingress:service")
+	}
+
+	x.Config(&Config{
+		SlugTemplate: "{{.Namespace}}-{{.IngressName}}-{{.Host}}/{{.Path}}",
+		NameTemplate: "{{.Host}} ({{.ServiceName}}:{{.ServicePort}})",
+	})
+
+	if s, err := x.ingressSlug(ing, "foo.com", p); err != nil || s != "bar-namespace-foo-name-foo.com/api" {
+		t.Fatal("unexpected templated slug: ", s, err)
+	}
+
+	if n := x.apiName(ing, "foo.com", p); n != "foo.com (foo-service:80)" {
+		t.Fatal("unexpected templated name: ", n)
+	}
+
+	x.Config(&Config{SlugTemplate: "{{.Nope}}"})
+	if err := x.checkNamingTemplates(); err == nil {
+		t.Fatal("templates with unknown fields should be refused on start up")
+	}
+
+	x.Config(&Config{SlugTemplate: "{{if .Host}}{{.Host}}{{end}}"})
+	if _, err := x.ingressSlug(ing, "", p); err == nil {
+		t.Fatal("an empty slug should fail the sync instead of falling back to the hash")
+	}
+
+	x.Config(&Config{
+		SlugTemplate: "{{.Namespace}}-{{.IngressName}}",
+		NameTemplate: "{{.Host}}",
+	})
+	x.Config(nil)
+	if s, _ := x.ingressSlug(ing, "foo.com", p); s != x.generateIngressID(ing.Name, ing.Namespace, p) {
+		t.Fatal("resetting the config should bring back the default slug")
+	}
+
+	if x.apiName(ing, "foo.com", p) != "foo-name:foo-service" {
+		t.Fatal("resetting the config should bring back the default name")
+	}
+}
+
+func TestSlugTemplateCollisions(t *testing.T) {
+	x := NewController()
+	x.Config(&Config{SlugTemplate: "{{.Namespace}}-{{.IngressName}}"})
+	defer x.Config(nil)
+
+	ing := &v1beta1.Ingress{
+		ObjectMeta: v1.ObjectMeta{Name: "foo-name", Namespace: "bar-namespace"},
+		Spec: v1beta1.IngressSpec{Rules: []v1beta1.IngressRule{{
+			Host: "foo.com",
+			IngressRuleValue: v1beta1.IngressRuleValue{HTTP: &v1beta1.HTTPIngressRuleValue{Paths: []v1beta1.HTTPIngressPath{
+				{Path: "/a", Backend: v1beta1.IngressBackend{ServiceName: "a", ServicePort: intstr.FromInt(80)}},
+				{Path: "/b", Backend: v1beta1.IngressBackend{ServiceName: "b", ServicePort: intstr.FromInt(80)}},
+			}}},
+		}}},
+	}
+
+	if _, err := x.ruleAPIs(ing); err == nil {
+		t.Fatal("paths rendering the same slug should refuse the ingress")
+	}
+
+	x.Config(&Config{SlugTemplate: "{{.Namespace}}-{{.IngressName}}{{.Path}}"})
+	if apis, err := x.ruleAPIs(ing); err != nil || len(apis) != 2 {
+		t.Fatal("slugs with the path should generate an API per path, got ", apis, err)
+	}
+}
+
+func TestEffectiveAnnotations(t *testing.T) {
+	x := NewController()
+	x.Config(&Config{Rules: []Rule{
+		{Namespace: "prod-*", Annotations: map[string]string{
+			"tyk.io/set.use_keyless": "false",
+			"tyk.io/set.name":        "from-rule",
+		}},
+		{Labels: map[string]string{"team": "a"}, Annotations: map[string]string{
+			"tyk.io/append.tags": "team-a",
+		}},
+	}})
+	defer x.Config(nil)
+
+	ing := &v1beta1.Ingress{}
+	ing.Namespace = "prod-eu"
+	ing.Annotations = map[string]string{"tyk.io/set.name": "from-ingress"}
+
+	ann, err := x.effectiveAnnotations(ing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ann["tyk.io/set.use_keyless"] != "false" {
+		t.Fatal("namespace rule not applied, got ", ann)
+	}
+
+	if ann["tyk.io/set.name"] != "from-ingress" {
+		t.Fatal("ingress annotations should take precedence, got ", ann)
+	}
+
+	if _, ok := ann["tyk.io/append.tags"]; ok {
+		t.Fatal("label rule should not match")
+	}
+
+	ing.Namespace = "dev"
+	ing.Labels = map[string]string{"team": "a"}
+	ann, err = x.effectiveAnnotations(ing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ann["tyk.io/set.use_keyless"]; ok {
+		t.Fatal("namespace rule should not match")
+	}
+
+	if ann["tyk.io/append.tags"] != "team-a" {
+		t.Fatal("label rule not applied, got ", ann)
+	}
+
+	if err := (&ControlServer{cfg: &Config{Rules: []Rule{{Namespace: "prod-["}}}}).checkRules(); err == nil {
+		t.Fatal("invalid namespace patterns should be refused")
+	}
+}
+
+func TestAnnotationRefs(t *testing.T) {
+	x := &ControlServer{}
+	ing := &v1beta1.Ingress{}
+	ing.Namespace = "default"
+	ing.Name = "ing"
+
+	v, err := x.resolveAnnotationValue(ing.Namespace, "plain")
+	if err != nil || v != "plain" {
+		t.Fatal("plain values should be passed through, got ", v, err)
+	}
+
+	name, key, err := parseKeyRef("jwt/signing-key")
+	if err != nil || name != "jwt" || key != "signing-key" {
+		t.Fatal("unexpected key ref: ", name, key, err)
+	}
+
+	for _, ref := range []string{"jwt", "/key", "jwt/"} {
+		if _, _, err := parseKeyRef(ref); err == nil {
+			t.Fatalf("%q should be rejected", ref)
+		}
+	}
+
+	_, err = x.resolveAnnotationRefs(ing, map[string]string{
+		"tyk.io/set.config_data.secret": "secretKeyRef:jwt/signing-key",
+	})
+	if err == nil {
+		t.Fatal("resolving a reference without a client should fail")
+	}
+}
+
+func TestStrictAnnotations(t *testing.T) {
+	x := NewController()
+	x.Config(&Config{StrictAnnotations: true})
+	defer x.Config(nil)
+
+	ing := &v1beta1.Ingress{}
+	ing.Namespace = "default"
+	ing.Name = "ing"
+	ing.Annotations = map[string]string{
+		IngressAnnotation:                 IngressAnnotationValue,
+		"template.service.tyk.io":         "default",
+		"tyk.io/set.use_keyless":          "false",
+		"injector.tyk.io/inject":          "true",
+		"nginx.ingress.kubernetes.io/foo": "bar",
+	}
+
+	_, err := x.effectiveAnnotations(ing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ing.Annotations["tyk.io/templte"] = "default"
+	ing.Annotations["strng.service.tyk.io/name"] = "x"
+	_, err = x.effectiveAnnotations(ing)
+	if err == nil || !strings.Contains(err.Error(), "strng.service.tyk.io/name, tyk.io/templte") {
+		t.Fatal("expected unknown annotations to fail, got ", err)
+	}
+}
+
+func TestOfflineQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tyk-k8s-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	qf := filepath.Join(dir, "queue.json")
+	q, err := loadQueue(qf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ing := &v1beta1.Ingress{}
+	ing.Namespace = "default"
+	ing.Name = "ing"
+
+	q.add(syncOp(ing))
+	q.add(syncOp(ing))
+	q.add(deleteOp(ing, "a"))
+	q.add(deleteOp(ing, "b", "a"))
+
+	reloaded, err := loadQueue(qf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := reloaded.pending()
+	if len(ops) != 2 {
+		t.Fatalf("expected one sync and one delete, got %+v", ops)
+	}
+
+	if ops[1].Op != queueOpDelete || strings.Join(ops[1].Slugs, ",") != "a,b" {
+		t.Fatalf("deletes should be merged, got %+v", ops[1])
+	}
+
+	// changes queued while replaying are kept
+	reloaded.add(deleteOp(ing, "c"))
+	reloaded.done(ops)
+
+	ops = reloaded.pending()
+	if len(ops) != 1 || strings.Join(ops[0].Slugs, ",") != "a,b,c" {
+		t.Fatalf("expected the updated delete to remain, got %+v", ops)
+	}
+
+	x := &ControlServer{queue: reloaded}
+	if x.queueIfUnavailable(syncOp(ing), errors.New("API Returned error: bad request")) {
+		t.Fatal("rejected requests should not be queued")
+	}
+
+	if !x.queueIfUnavailable(syncOp(ing), errors.New("dial tcp 127.0.0.1:3000: connect: connection refused")) {
+		t.Fatal("unreachable dashboard should be queued")
+	}
+}
+
+func TestIngressTags(t *testing.T) {
+	x := NewController()
+	ing := &v1beta1.Ingress{}
+	if tags := x.ingressTags(ing, "ingress"); strings.Join(tags, ",") != "ingress" {
+		t.Fatal("unexpected tags without annotation: ", tags)
+	}
+
+	ing.Annotations = map[string]string{GatewayTagsAnnotation: "edge, eu-west,,ingress"}
+	tags := x.ingressTags(ing, "ingress", "default-backend")
+	if strings.Join(tags, ",") != "ingress,default-backend,edge,eu-west" {
+		t.Fatal("annotation tags should be merged, got ", tags)
+	}
+
+	x.Config(&Config{StrictAnnotations: true})
+	defer x.Config(nil)
+	if _, err := x.effectiveAnnotations(ing); err != nil {
+		t.Fatal("gateway tags annotation should be recognised: ", err)
+	}
+}
+
+func TestIngressClass(t *testing.T) {
+	mk := func(class string) *v1beta1.Ingress {
+		ing := &v1beta1.Ingress{}
+		if class != "" {
+			ing.Annotations = map[string]string{IngressAnnotation: class}
+		}
+		return ing
+	}
+
+	x := NewController()
+	x.Config(nil)
+	if !x.checkIngressManaged(mk("Tyk")) {
+		t.Fatal("the default class should be claimed")
+	}
+	if x.checkIngressManaged(mk("nginx")) || x.checkIngressManaged(mk("")) {
+		t.Fatal("other and unclassed ingresses should be left alone")
+	}
+
+	x.Config(&Config{IngressClass: "tyk-internal", DefaultClass: true})
+	defer x.Config(nil)
+	if x.checkIngressManaged(mk("tyk")) {
+		t.Fatal("only the configured class should be claimed")
+	}
+	if !x.checkIngressManaged(mk("tyk-internal")) || !x.checkIngressManaged(mk("")) {
+		t.Fatal("configured and unclassed ingresses should be claimed as the default class")
+	}
+}
+
+func TestClassParams(t *testing.T) {
+	x := NewController()
+	x.Config(nil)
+	x.classParams = &ClassParams{
+		TemplateName: "internal",
+		Tags:         []string{"internal", "edge"},
+		OrgID:        "org-1",
+		AuthMode:     "JWT",
+	}
+	defer func() { x.classParams = nil }()
+
+	ing := &v1beta1.Ingress{}
+	ing.Annotations = map[string]string{GatewayTagsAnnotation: "edge,eu-west"}
+	tags := x.ingressTags(ing, "ingress")
+	if strings.Join(tags, ",") != "ingress,internal,edge,eu-west" {
+		t.Fatal("class tags should be merged, got ", tags)
+	}
+
+	ann, err := x.effectiveAnnotations(ing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkAndGetTemplate(ann) != "internal" {
+		t.Fatal("class template should be the default, got ", checkAndGetTemplate(ann))
+	}
+	if ann["string.service.tyk.io/org_id"] != "org-1" || ann["bool.service.tyk.io/enable_jwt"] != "true" ||
+		ann["bool.service.tyk.io/use_keyless"] != "false" {
+		t.Fatal("class org and auth mode should become default annotations, got ", ann)
+	}
+
+	ing.Annotations[tyk.TemplateNameKey] = "mine"
+	ann, _ = x.effectiveAnnotations(ing)
+	if checkAndGetTemplate(ann) != "mine" {
+		t.Fatal("ingress annotations should override the class defaults")
+	}
+
+	if err := (&ClassParams{AuthMode: "magic"}).validate(); err == nil {
+		t.Fatal("unknown auth modes should be rejected")
+	}
+}
+
+func TestNginxCompat(t *testing.T) {
+	x := NewController()
+	x.Config(&Config{NginxCompat: true})
+	defer x.Config(nil)
+
+	ing := &v1beta1.Ingress{}
+	ing.Annotations = map[string]string{
+		nginxRewriteTarget: "/v2",
+		nginxBodySize:      "8m",
+		nginxWhitelist:     "10.0.0.0/8, 192.168.1.1",
+		nginxSSLRedirect:   "true",
+	}
+
+	f := x.nginxFilters(ing)
+	if f == nil || f.RewritePath != "/v2" || f.RewritePrefix {
+		t.Fatal("rewrite-target should replace the whole path, got ", f)
+	}
+
+	rf, lp, err := nginxRewrite(f, "/foo")
+	if err != nil || lp != "/foo" || rf.RewritePattern != "(?i)^/foo" || f.RewritePattern != "" {
+		t.Fatal("plain paths should match as a prefix, got ", lp, rf, err)
+	}
+	if f.SizeLimit != 8<<20 {
+		t.Fatal("proxy-body-size should be in bytes, got ", f.SizeLimit)
+	}
+	if strings.Join(f.AllowedIPs, ",") != "10.0.0.0/8,192.168.1.1" {
+		t.Fatal("whitelist-source-range should become allowed IPs, got ", f.AllowedIPs)
+	}
+
+	ing.Annotations = map[string]string{nginxBodySize: "lots"}
+	if f := x.nginxFilters(ing); f != nil {
+		t.Fatal("unsupported values should not produce filters, got ", f)
+	}
+
+	ing.Annotations = map[string]string{nginxRewriteTarget: "/$2"}
+	rf, lp, err = nginxRewrite(x.nginxFilters(ing), "/something(/|$)(.*)")
+	if err != nil || lp != "/something" {
+		t.Fatal("the API should listen on the literal part of the path, got ", lp, err)
+	}
+
+	re := regexp.MustCompile(rf.RewritePattern)
+	if re.ReplaceAllString("/Something/users/1", rf.RewritePath) != "/users/1" || re.ReplaceAllString("/something", rf.RewritePath) != "/" {
+		t.Fatal("capture groups should be rewritten like nginx, got ", rf.RewritePattern)
+	}
+	if re.MatchString("/somethingelse") {
+		t.Fatal("sibling paths should not be rewritten")
+	}
+
+	if _, _, err := nginxRewrite(rf, "/broken(("); err == nil {
+		t.Fatal("invalid path regexes should be refused")
+	}
+
+	if n, err := parseNginxSize("0"); err != nil || n != 0 {
+		t.Fatal("0 should mean unlimited, got ", n, err)
+	}
+
+	x.Config(nil)
+	ing.Annotations = map[string]string{nginxBodySize: "1k"}
+	if x.nginxFilters(ing) != nil {
+		t.Fatal("annotations should be ignored when the layer is off")
+	}
+}
+
+func TestOrgAnnotation(t *testing.T) {
+	x := NewController()
+	x.Config(&Config{AllowedOrgs: []string{"bu-retail", "bu-payments"}})
+	defer x.Config(nil)
+
+	ing := &v1beta1.Ingress{}
+	ing.Annotations = map[string]string{OrgAnnotation: "bu-payments"}
+	ann, err := x.effectiveAnnotations(ing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ann["string.service.tyk.io/org_id"] != "bu-payments" {
+		t.Fatal("allowed orgs should set the org id, got ", ann)
+	}
+
+	ing.Annotations[OrgAnnotation] = "bu-other"
+	if _, err := x.effectiveAnnotations(ing); err == nil {
+		t.Fatal("orgs outside the allow list should be rejected")
+	}
+
+	// the org can't be written around the allow list
+	for k, v := range map[string]string{
+		"string.service.tyk.io/org_id": "bu-other",
+		"string.service.tyk.io/org-id": "bu-other",
+		"tyk.io/set.org_id":            `"bu-other"`,
+		"tyk.io/json-patch":            `[{"op": "replace", "path": "/org_id", "value": "bu-other"}]`,
+	} {
+		ing.Annotations = map[string]string{k: v}
+		if _, err := x.effectiveAnnotations(ing); err == nil || !strings.Contains(err.Error(), "can't set org_id") {
+			t.Errorf("%s should be rejected, got %v", k, err)
+		}
+	}
+	ing.Annotations = map[string]string{}
+
+	x.Config(&Config{StrictAnnotations: true})
+	ing.Annotations[OrgAnnotation] = "bu-retail"
+	_, err = x.effectiveAnnotations(ing)
+	if err == nil || strings.Contains(err.Error(), "unrecognised") {
+		t.Fatal("an empty allow list should reject the annotation as not allowed, got ", err)
+	}
+}
+
+func TestPortalDocs(t *testing.T) {
+	ing := &v1beta1.Ingress{}
+	ing.Name, ing.Namespace = "petstore", "shop"
+
+	d, err := portalDocs(ing, map[string]string{})
+	if d != nil || err != nil {
+		t.Fatal("ingresses without docs should not publish anything")
+	}
+
+	ann := map[string]string{PortalDocsAnnotation: "openapi: 3.0.0\ninfo:\n  title: Petstore\n"}
+	if _, err := portalDocs(ing, ann); err == nil {
+		t.Fatal("docs without a policy should be rejected")
+	}
+
+	ann[PortalPolicyAnnotation] = "pol-1"
+	ann[PortalDescriptionAnnotation] = "Pets"
+	d, err = portalDocs(ing, ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(d.Document) != `{"info":{"title":"Petstore"},"openapi":"3.0.0"}` {
+		t.Fatal("YAML documents should be converted to JSON, got ", string(d.Document))
+	}
+
+	if d.Source != "Ingress/shop/petstore" || d.Entry.Name != "shop/petstore" || d.Entry.APIID != "pol-1" || d.Entry.ShortDescription != "Pets" {
+		t.Fatalf("unexpected catalogue entry: %+v", d.Entry)
+	}
+}
+
+func TestJWTAnnotations(t *testing.T) {
+	x := NewController()
+	x.Config(&Config{})
+	defer x.Config(nil)
+
+	ing := &v1beta1.Ingress{}
+	j, err := x.jwtAuth(ing, map[string]string{})
+	if err != nil || j != nil {
+		t.Fatal("ingresses without a JWKS URI should keep their auth, got ", j, err)
+	}
+
+	j, err = x.jwtAuth(ing, map[string]string{
+		JWTJWKSURIAnnotation:       "https://idp/jwks.json",
+		JWTIdentityClaimAnnotation: "email",
+		JWTPolicyClaimAnnotation:   "pol",
+		JWTClockSkewAnnotation:     "2m",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if j.JWKSURI != "https://idp/jwks.json" || j.IdentityClaim != "email" || j.PolicyClaim != "pol" || j.ClockSkewSeconds != 120 {
+		t.Fatalf("unexpected jwt auth: %+v", j)
+	}
+
+	if s, err := parseSkew("30"); err != nil || s != 30 {
+		t.Fatal("plain numbers should be seconds, got ", s, err)
+	}
+
+	if _, err := x.jwtAuth(ing, map[string]string{JWTJWKSURIAnnotation: "https://idp", JWTClockSkewAnnotation: "-1s"}); err == nil {
+		t.Fatal("negative skews should be rejected")
+	}
+
+	if _, err := x.jwtAuth(ing, map[string]string{JWTJWKSURIAnnotation: "https://idp", JWTIssuerAnnotation: "https://idp"}); err == nil {
+		t.Fatal("issuer checks can't be configured and should fail the sync")
+	}
+}
+
+func TestOIDCAnnotation(t *testing.T) {
+	x := NewController()
+	x.Config(&Config{})
+	defer x.Config(nil)
+
+	ing := &v1beta1.Ingress{}
+	opts := &tyk.APIDefOptions{Annotations: map[string]string{
+		OIDCProvidersAnnotation:         `[{"issuer": "https://accounts.google.com", "clients": {"web": "pol-1", "cli": "pol-2"}}]`,
+		OIDCSegregateByClientAnnotation: "true",
+	}}
+	if err := x.setSecurity(ing, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	o := opts.OpenID
+	if o == nil || !o.SegregateByClient || len(o.Providers) != 1 || o.Providers[0].Issuer != "https://accounts.google.com" ||
+		o.Providers[0].ClientPolicies["cli"] != "pol-2" {
+		t.Fatalf("unexpected openid options: %+v", o)
+	}
+
+	for _, bad := range []string{`{}`, `[]`, `[{"clients": {"web": "pol-1"}}]`, `[{"issuer": "https://idp"}]`, `[{"issuer": "https://idp", "clients": {"web": ""}}]`} {
+		opts.Annotations[OIDCProvidersAnnotation] = bad
+		if err := x.setSecurity(ing, opts); err == nil {
+			t.Fatalf("%s should be rejected", bad)
+		}
+	}
+
+	opts.Annotations = map[string]string{
+		OIDCProvidersAnnotation: `[{"issuer": "https://idp", "clients": {"web": "pol-1"}}]`,
+		JWTJWKSURIAnnotation:    "https://idp/jwks.json",
+	}
+	if err := x.setSecurity(ing, opts); err == nil {
+		t.Fatal("JWT and OIDC should not be combined")
+	}
+}
+
+func TestBasicAuthAnnotation(t *testing.T) {
+	sec := &corev1.Secret{}
+	sec.Annotations = map[string]string{basicAuthUsersAnnotation: "alice,carol"}
+	sec.Data = map[string][]byte{"bob": []byte("pw2"), "alice": []byte("pw1")}
+
+	users := basicAuthUsers(sec)
+	if len(users) != 2 || users[0].Username != "alice" || users[0].Password != "pw1" || users[1].Username != "bob" {
+		t.Fatal("unexpected users: ", users)
+	}
+
+	if removed := removedBasicUsers(sec, users); len(removed) != 1 || removed[0] != "carol" {
+		t.Fatal("only users missing from the secret should be removed, got ", removed)
+	}
+
+	x := NewController()
+	x.Config(&Config{})
+	defer x.Config(nil)
+
+	ing := &v1beta1.Ingress{}
+	ing.Annotations = map[string]string{BasicAuthSecretAnnotation: "users"}
+	opts := &tyk.APIDefOptions{Annotations: ing.Annotations}
+	if err := x.setSecurity(ing, opts); err != nil || !opts.BasicAuth {
+		t.Fatal("the secret annotation should enable basic auth, got ", opts.BasicAuth, err)
+	}
+
+	opts.Annotations = map[string]string{JWTJWKSURIAnnotation: "https://idp/jwks.json"}
+	if err := x.setSecurity(ing, opts); err == nil {
+		t.Fatal("basic auth and JWT should not be combined")
+	}
+}
+
+func TestHMACAnnotations(t *testing.T) {
+	h, err := hmacAuth(map[string]string{
+		HMACAuthAnnotation:       "true",
+		HMACAlgorithmsAnnotation: "hmac-sha256, HMAC-SHA512",
+		HMACClockSkewAnnotation:  "5s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(h.Algorithms, ",") != "hmac-sha256,hmac-sha512" || h.ClockSkewMillis != 5000 {
+		t.Fatalf("unexpected hmac auth: %+v", h)
+	}
+
+	if h, err := hmacAuth(map[string]string{}); h != nil || err != nil {
+		t.Fatal("ingresses without the annotation should not sign requests, got ", h, err)
+	}
+
+	if _, err := hmacAuth(map[string]string{HMACAuthAnnotation: "true", HMACAlgorithmsAnnotation: "md5"}); err == nil {
+		t.Fatal("unknown algorithms should be rejected")
+	}
+
+	if _, err := hmacAuth(map[string]string{HMACSecretAnnotation: "creds"}); err == nil {
+		t.Fatal("credentials need HMAC auth to be enabled")
+	}
+
+	x := NewController()
+	x.Config(&Config{})
+	defer x.Config(nil)
+
+	ing := &v1beta1.Ingress{}
+	ing.Annotations = map[string]string{HMACAuthAnnotation: "true", BasicAuthSecretAnnotation: "users"}
+	if err := x.setSecurity(ing, &tyk.APIDefOptions{Annotations: ing.Annotations}); err == nil {
+		t.Fatal("HMAC and basic auth should not be combined")
+	}
+}
+
+func TestClientCertificatesAnnotation(t *testing.T) {
+	x := NewController()
+	x.Config(&Config{})
+	defer x.Config(nil)
+
+	ing := &v1beta1.Ingress{}
+	ids, err := x.clientCertificates(ing)
+	if ids != nil || err != nil {
+		t.Fatal("ingresses without the annotation should have no client certificates, got ", ids, err)
+	}
+
+	ing.Annotations = map[string]string{ClientMTLSSecretsAnnotation: "clients"}
+	if err := x.setSecurity(ing, &tyk.APIDefOptions{Annotations: ing.Annotations}); err == nil {
+		t.Fatal("certificates that can't be read should fail the sync")
+	}
+}
+
+func TestUpstreamCAAnnotation(t *testing.T) {
+	pins, err := upstreamPins(map[string]string{})
+	if pins != nil || err != nil {
+		t.Fatal("ingresses without the annotation should pin nothing, got ", pins, err)
+	}
+
+	if _, err := upstreamPins(map[string]string{UpstreamCAAnnotation: "not a bundle"}); err == nil {
+		t.Fatal("invalid bundles should fail the sync")
+	}
+}
+
+func TestCertificateAlert(t *testing.T) {
+	now := time.Now()
+	crt := tyk.TrackedCertificate{Source: "Secret/default/tls", Field: "tls.crt", ID: "abc", NotAfter: now.Add(30 * 24 * time.Hour)}
+	if reason, _ := certificateAlert(crt, now, 14*24*time.Hour); reason != "" {
+		t.Fatal("current certificates should not alert, got ", reason)
+	}
+
+	if reason, _ := certificateAlert(crt, now, 60*24*time.Hour); reason != "CertificateExpiring" {
+		t.Fatal("certificates in the expiry window should alert, got ", reason)
+	}
+
+	crt.Stale = true
+	if reason, _ := certificateAlert(crt, now, 14*24*time.Hour); reason != "CertificateRenewalPending" {
+		t.Fatal("renewed secrets should alert, got ", reason)
+	}
+
+	crt.Stale = false
+	crt.NotAfter = now.Add(-time.Hour)
+	if reason, _ := certificateAlert(crt, now, 14*24*time.Hour); reason != "CertificateExpired" {
+		t.Fatal("expired certificates should alert, got ", reason)
+	}
+}
+
+func TestHostCertificate(t *testing.T) {
+	certs := map[string]string{"*.foo.com": "wild", "api.foo.com": "exact"}
+	for host, want := range map[string]string{"api.foo.com": "exact", "web.foo.com": "wild", "a.b.foo.com": "", "bar.com": ""} {
+		id, _ := hostCertificate(certs, host)
+		if id != want {
+			t.Errorf("%s: expected certificate %q, got %q", host, want, id)
+		}
+	}
+
+	ing := &v1beta1.Ingress{
+		Spec: v1beta1.IngressSpec{
+			TLS: []v1beta1.IngressTLS{{Hosts: []string{"*.foo.com"}, SecretName: "foo-tls"}},
+			Rules: []v1beta1.IngressRule{
+				{Host: "web.foo.com", IngressRuleValue: v1beta1.IngressRuleValue{HTTP: &v1beta1.HTTPIngressRuleValue{}}},
+				{Host: "bar.com", IngressRuleValue: v1beta1.IngressRuleValue{HTTP: &v1beta1.HTTPIngressRuleValue{}}},
+			},
+		},
+	}
+
+	missing := uncoveredHosts(ing, map[string]string{"*.foo.com": "wild"})
+	if strings.Join(missing, ",") != "bar.com" {
+		t.Fatal("expected bar.com to have no certificate, got ", missing)
+	}
+
+	ing.Spec.TLS = nil
+	if missing := uncoveredHosts(ing, nil); len(missing) != 0 {
+		t.Fatal("ingresses without TLS need no certificates, got ", missing)
+	}
+}
+
+func TestRuleAPIsShareHosts(t *testing.T) {
+	x := NewController()
+	x.Config(&Config{})
+	defer x.Config(nil)
+
+	paths := &v1beta1.HTTPIngressRuleValue{Paths: []v1beta1.HTTPIngressPath{
+		{Path: "/", Backend: v1beta1.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)}},
+	}}
+	ing := &v1beta1.Ingress{
+		ObjectMeta: v1.ObjectMeta{Name: "multi", Namespace: "default"},
+		Spec: v1beta1.IngressSpec{Rules: []v1beta1.IngressRule{
+			{Host: "foo.com", IngressRuleValue: v1beta1.IngressRuleValue{HTTP: paths}},
+			{Host: "*.bar.com", IngressRuleValue: v1beta1.IngressRuleValue{HTTP: paths}},
+		}},
+	}
+
+	apis, err := x.ruleAPIs(ing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apis) != 1 || strings.Join(apis[0].hosts, ",") != "foo.com,*.bar.com" {
+		t.Fatalf("expected one API for both hosts, got %+v", apis)
+	}
+
+	if d := hostsToDomain(apis[0].hosts, nil); d != "{host:(?:foo[.]com|[^.]+[.]bar[.]com)}" {
+		t.Fatal("unexpected domain: ", d)
+	}
+
+	if d := hostsToDomain([]string{"foo.com", ""}, nil); d != "" {
+		t.Fatal("rules without a host should match every host, got ", d)
+	}
+
+	ids := hostsCertificates(map[string]string{"foo.com": "a", "*.bar.com": "b"}, apis[0].hosts)
+	if strings.Join(ids, ",") != "a,b" {
+		t.Fatal("expected the certificates of both hosts, got ", ids)
+	}
+}
+
+func TestStrippingAnnotations(t *testing.T) {
+	opts := &tyk.APIDefOptions{Annotations: map[string]string{StripListenPathAnnotation: "false"}}
+	if err := setStripping(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.StripListenPath == nil || *opts.StripListenPath || opts.StripVersionPath != nil {
+		t.Fatalf("unexpected stripping: %v %v", opts.StripListenPath, opts.StripVersionPath)
+	}
+
+	opts.Annotations[StripVersionPathAnnotation] = "maybe"
+	if err := setStripping(opts); err == nil {
+		t.Fatal("invalid booleans should fail the sync")
+	}
+}
+
+func TestTransportAnnotations(t *testing.T) {
+	tr, err := proxyTransport(map[string]string{})
+	if tr != nil || err != nil {
+		t.Fatal("ingresses without transport annotations should keep the defaults, got ", tr, err)
+	}
+
+	tr, err = proxyTransport(map[string]string{
+		UpstreamTLSMinVersionAnnotation: "1.2",
+		UpstreamTLSCiphersAnnotation:    "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+		UpstreamProxyURLAnnotation:      "http://egress.internal:3128",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tr.MinTLSVersion != 0x0303 || len(tr.CipherSuites) != 2 || tr.ProxyURL != "http://egress.internal:3128" {
+		t.Fatalf("unexpected transport: %+v", tr)
+	}
+
+	for k, v := range map[string]string{
+		UpstreamTLSMinVersionAnnotation: "1.4",
+		UpstreamTLSCiphersAnnotation:    "TLS_MADE_UP",
+		UpstreamProxyURLAnnotation:      "ftp://egress",
+	} {
+		if _, err := proxyTransport(map[string]string{k: v}); err == nil {
+			t.Errorf("%s: %s should be rejected", k, v)
+		}
+	}
+
+	x := NewController()
+	x.Config(&Config{})
+	defer x.Config(nil)
+
+	ing := &v1beta1.Ingress{}
+	opts := &tyk.APIDefOptions{Annotations: map[string]string{UpstreamConnectTimeoutAnnotation: "5s"}}
+	if err := x.setProxy(ing, opts); err == nil {
+		t.Fatal("connect timeouts can't be set and should fail the sync")
+	}
+}
+
+// expectUnsupported checks an annotation fails the sync with an error naming it
+func expectUnsupported(t *testing.T, key, value string) {
+	x := NewController()
+	x.Config(&Config{})
+	defer x.Config(nil)
+
+	opts := &tyk.APIDefOptions{Annotations: map[string]string{key: value}}
+	err := x.setProxy(&v1beta1.Ingress{}, opts)
+	if err == nil || !strings.Contains(err.Error(), key) {
+		t.Fatalf("%s should fail the sync, got %v", key, err)
+	}
+}
+
+func TestGraphQLPlaygroundRefused(t *testing.T) {
+	expectUnsupported(t, GraphQLPlaygroundPathAnnotation, "/playground")
+}
+
+func TestGraphQLIntrospectionRefused(t *testing.T) {
+	// an ingress asking for introspection to be off must not be published with it on
+	expectUnsupported(t, GraphQLIntrospectionAnnotation, "false")
+}
+
+func TestGraphQLSchemaRefused(t *testing.T) {
+	expectUnsupported(t, GraphQLSchemaAnnotation, "schemas/orders.graphql")
+}
+
+func TestUpstreamProtocolAnnotation(t *testing.T) {
+	p, err := upstreamProtocol(map[string]string{UpstreamProtocolAnnotation: "H2C"})
+	if err != nil || p != tyk.UpstreamH2C {
+		t.Fatal("expected h2c, got ", p, err)
+	}
+
+	if _, err := upstreamProtocol(map[string]string{UpstreamProtocolAnnotation: "grpc"}); err == nil {
+		t.Fatal("unknown protocols should fail the sync")
+	}
+}
+
+func TestPolicyAnnotations(t *testing.T) {
+	ing := &v1beta1.Ingress{ObjectMeta: v1.ObjectMeta{Name: "web", Namespace: "shop"}}
+	if s, err := policySpec(ing); s != nil || err != nil {
+		t.Fatal("ingresses without policy annotations should have no policy, got ", s, err)
+	}
+
+	ing.Annotations = map[string]string{RateLimitAnnotation: "100/1m", QuotaAnnotation: "10000/86400"}
+	s, err := policySpec(ing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Name != "shop/web" || s.Rate != 100 || s.Per != 60 || s.QuotaMax != 10000 || s.QuotaRenewalRate != 86400 || s.ACL {
+		t.Fatalf("unexpected policy: %+v", s)
+	}
+
+	ing.Annotations = map[string]string{PolicyACLAnnotation: "false"}
+	if s, err := policySpec(ing); s != nil || err != nil {
+		t.Fatal("a disabled ACL alone should not generate a policy, got ", s, err)
+	}
+
+	for _, v := range []string{"100", "x/1m", "100/soon", "100/-1s"} {
+		ing.Annotations = map[string]string{RateLimitAnnotation: v}
+		if _, err := policySpec(ing); err == nil {
+			t.Errorf("%s should be rejected", v)
+		}
+	}
+}
+
+func TestCachePathsAnnotation(t *testing.T) {
+	x := NewController()
+	x.Config(&Config{})
+	defer x.Config(nil)
+
+	ing := &v1beta1.Ingress{}
+	cp, err := x.cachePaths(ing, map[string]string{CachePathsAnnotation: "/products=5m, /catalogue/.*=5m, /health"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(cp.Paths, ",") != "/products,/catalogue/.*,/health" || cp.TimeoutSeconds != 300 {
+		t.Fatalf("unexpected cache paths: %+v", cp)
+	}
+
+	for _, v := range []string{"/a=1m,/b=2m", "GET /a", "products", "/a=soon", "/a(", ""} {
+		if _, err := x.cachePaths(ing, map[string]string{CachePathsAnnotation: v}); err == nil {
+			t.Errorf("%q should be rejected", v)
+		}
+	}
+}
+
+func TestTemplateConfigMapTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	cl, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := templateSyncTimeout
+	templateSyncTimeout = 100 * time.Millisecond
+	defer func() { templateSyncTimeout = old }()
+
+	x := &ControlServer{client: cl}
+	x.Config(&Config{TemplateConfigMap: "tyk/templates"})
+	if err := x.watchTemplates(); err == nil || x.tplStopCh != nil {
+		t.Fatal("a config map that can't be listed should fail the start, got ", err)
+	}
+
+	if !tyk.HasTemplate(tyk.DefaultTemplate) {
+		t.Fatal("the default template should stay loaded")
+	}
+}
+
+func TestConventionTemplates(t *testing.T) {
+	opts := &tyk.APIDefOptions{Annotations: map[string]string{}}
+	if n := conventionTemplates(opts); len(n) != 0 {
+		t.Fatal("keyless APIs have no convention templates, got ", n)
+	}
+
+	opts.JWT = &tyk.JWTAuth{}
+	opts.Annotations[APITypeAnnotation] = "GraphQL"
+	if n := strings.Join(conventionTemplates(opts), ","); n != "graphql-jwt,jwt,graphql" {
+		t.Fatal("unexpected convention templates ", n)
+	}
+
+	x := NewController()
+	x.Config(&Config{})
+	defer x.Config(nil)
+
+	x.classParams = &ClassParams{TemplateName: "internal"}
+	defer func() { x.classParams = nil }()
+
+	ing := &v1beta1.Ingress{ObjectMeta: v1.ObjectMeta{Labels: map[string]string{TemplateLabel: "labelled"}}}
+	opts.Annotations[tyk.TemplateNameKey] = "internal"
+	if n := strings.Join(x.templateChain(ing, opts), ","); n != "labelled,internal,default" {
+		t.Fatal("the label should win over the class template, got ", n)
+	}
+
+	ing.Annotations = map[string]string{tyk.TemplateNameKey: "mine"}
+	opts.Annotations[tyk.TemplateNameKey] = "mine"
+	if n := strings.Join(x.templateChain(ing, opts), ","); n != "mine,internal,default" {
+		t.Fatal("the template annotation should win, got ", n)
+	}
+
+	if n := x.selectTemplate(ing, opts); n != tyk.DefaultTemplate {
+		t.Fatal("templates that aren't loaded should fall back to the default, got ", n)
+	}
+}
+
+func TestTemplateValuesAllowList(t *testing.T) {
+	v := &TemplateValues{ConfigMaps: true, Namespaces: []string{"team-*"}}
+	if err := v.allows(tyk.ConfigMapValues, "team-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.allows(tyk.SecretValues, "team-a"); err == nil {
+		t.Fatal("secrets should need to be enabled")
+	}
+
+	if err := v.allows(tyk.ConfigMapValues, "kube-system"); err == nil {
+		t.Fatal("namespaces outside the allow list should be refused")
+	}
+}
+
+func TestShards(t *testing.T) {
+	if ord, err := podOrdinal("tyk-k8s-2"); err != nil || ord != 2 {
+		t.Fatal("unexpected ordinal: ", ord, err)
+	}
+
+	if _, err := podOrdinal("tyk-k8s-6d4cf56db6-xq2z8"); err == nil {
+		t.Fatal("deployment pod names have no ordinal")
+	}
+
+	if !(&ControlServer{}).PrimaryShard() {
+		t.Fatal("unsharded replicas run every controller")
+	}
+
+	owners := map[int]int{}
+	for i := 0; i < 3; i++ {
+		ord := i
+		x := &ControlServer{cfg: &Config{Shards: 3, ShardOrdinal: &ord}}
+		if err := x.configureShard(); err != nil {
+			t.Fatal(err)
+		}
+
+		if x.PrimaryShard() != (i == 0) {
+			t.Fatalf("only the first shard should run the other controllers, shard %d", i)
+		}
+
+		for n := 0; n < 30; n++ {
+			ing := &v1beta1.Ingress{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: fmt.Sprint("web-", n),
+				Annotations: map[string]string{IngressAnnotation: "tyk"}}}
+			if x.ownsIngress(ing) {
+				owners[n]++
+			}
+		}
+	}
+
+	for n := 0; n < 30; n++ {
+		if owners[n] != 1 {
+			t.Fatalf("ingress %d should be owned by exactly one shard, got %d", n, owners[n])
+		}
+	}
+
+	ord := 3
+	if err := (&ControlServer{cfg: &Config{Shards: 3, ShardOrdinal: &ord}}).configureShard(); err == nil {
+		t.Fatal("ordinals outside of the shards should be refused")
+	}
+
+	ord = 0
+	if err := (&ControlServer{cfg: &Config{Shards: 3, ShardOrdinal: &ord, MergeHosts: true}}).configureShard(); err == nil {
+		t.Fatal("merged hosts can't be sharded")
+	}
+}
+
+func TestSyncIDs(t *testing.T) {
+	x := NewController()
+	ing := &v1beta1.Ingress{
+		ObjectMeta: v1.ObjectMeta{Name: "foo-name", Namespace: "bar-namespace"},
+		Spec: v1beta1.IngressSpec{Backend: &v1beta1.IngressBackend{
+			ServiceName: "fallback",
+			ServicePort: intstr.IntOrString{IntVal: 8080},
+		}},
+	}
+
+	if x.syncID(ing) != "" {
+		t.Fatal("no sync ID expected before the first reconcile")
+	}
+
+	id := x.beginSync(ing)
+	if len(id) != 16 || x.syncID(ing) != id {
+		t.Fatal("unexpected sync ID: ", id)
+	}
+
+	opts, err := x.defaultBackendOptions(ing)
+	if err != nil || opts.SyncID != id {
+		t.Fatal("options should carry the sync ID, got ", opts, err)
+	}
+
+	if next := x.beginSync(ing); next == id {
+		t.Fatal("every reconcile should get a new ID")
+	}
+
+	x.endSync(ing)
+	if x.syncID(ing) != "" {
+		t.Fatal("the ID should be dropped once the ingress is deleted")
+	}
+}
+
+func TestStaleSync(t *testing.T) {
+	x := &ControlServer{cfg: &Config{FullSyncSeconds: 30, StaleSyncSeconds: 60}}
+	start := time.Now()
+	x.startedAt = start
+
+	var buf bytes.Buffer
+	if err := x.WriteSyncMetrics(&buf); err != nil || !strings.Contains(buf.String(), "tyk_k8s_last_successful_full_sync_timestamp_seconds 0\n") {
+		t.Fatal("expected no full sync before the first, got ", buf.String(), err)
+	}
+
+	if x.checkStaleSync(start.Add(30 * time.Second)) {
+		t.Fatal("the window has not passed yet")
+	}
+
+	if !x.checkStaleSync(start.Add(61 * time.Second)) {
+		t.Fatal("expected a warning once the window passed without a full sync")
+	}
+
+	if x.checkStaleSync(start.Add(62 * time.Second)) {
+		t.Fatal("the warning should only repeat once per window")
+	}
+
+	if !x.checkStaleSync(start.Add(122 * time.Second)) {
+		t.Fatal("expected the warning to repeat after another window")
+	}
+
+	if err := x.fullSync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if x.checkStaleSync(time.Now().Add(30 * time.Second)) {
+		t.Fatal("a successful full sync should reset the window")
+	}
+
+	buf.Reset()
+	if err := x.WriteSyncMetrics(&buf); err != nil || strings.Contains(buf.String(), "seconds 0\n") {
+		t.Fatal("expected the time of the full sync, got ", buf.String(), err)
+	}
+}
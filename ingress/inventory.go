@@ -0,0 +1,102 @@
+package ingress
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	inventoryKey     = "inventory.json"
+	inventoryRefresh = time.Minute
+)
+
+func parseConfigMapRef(ref, field string) (string, string, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%s must be <namespace>/<name>, got %q", field, ref)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// startInventory publishes every managed API to the inventory config map, so the
+// controller's view can be inspected without Dashboard access. Sync times and hashes of
+// the previous run are restored from it first
+func (c *ControlServer) startInventory() error {
+	ns, name, err := parseConfigMapRef(c.cfg.InventoryConfigMap, "inventoryConfigMap")
+	if err != nil {
+		return err
+	}
+
+	cm, err := c.client.CoreV1().ConfigMaps(ns).Get(name, v12.GetOptions{})
+	if err == nil {
+		entries := make([]tyk.InventoryEntry, 0)
+		if err := json.Unmarshal([]byte(cm.Data[inventoryKey]), &entries); err == nil {
+			tyk.SeedInventory(entries)
+		}
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+
+	log.Info("Publishing the managed API inventory to config map ", c.cfg.InventoryConfigMap)
+	ticker := time.NewTicker(inventoryRefresh)
+	go func() {
+		defer ticker.Stop()
+		for {
+			if err := c.writeInventory(ns, name); err != nil {
+				log.Error("failed to write api inventory: ", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (c *ControlServer) writeInventory(ns, name string) error {
+	entries, err := tyk.Inventory()
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	cms := c.client.CoreV1().ConfigMaps(ns)
+	cm, err := cms.Get(name, v12.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = cms.Create(&v1.ConfigMap{
+			ObjectMeta: v12.ObjectMeta{Name: name, Namespace: ns},
+			Data:       map[string]string{inventoryKey: string(raw)},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if cm.Data[inventoryKey] == string(raw) {
+		return nil
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[inventoryKey] = string(raw)
+	_, err = cms.Update(cm)
+	return err
+}
@@ -0,0 +1,88 @@
+package ingress
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// JWT auth is set up without a custom template with, e.g.
+// "tyk.io/jwt-jwks-uri": "https://idp.example.com/.well-known/jwks.json" and
+// "tyk.io/jwt-policy-claim": "pol", the other annotations only apply with a JWKS URI
+const (
+	JWTJWKSURIAnnotation       = "tyk.io/jwt-jwks-uri"
+	JWTSigningMethodAnnotation = "tyk.io/jwt-signing-method"
+	JWTIdentityClaimAnnotation = "tyk.io/jwt-identity-claim"
+	JWTPolicyClaimAnnotation   = "tyk.io/jwt-policy-claim"
+	JWTClockSkewAnnotation     = "tyk.io/jwt-clock-skew"
+	JWTIssuerAnnotation        = "tyk.io/jwt-issuer"
+	JWTDefaultPolicyAnnotation = "tyk.io/jwt-default-policy"
+)
+
+// unsupportedJWT are JWT settings the vendored Tyk API definition has no field for, they
+// fail the sync rather than being dropped so tokens are never accepted more widely than
+// the ingress asks for
+var unsupportedJWT = map[string]string{
+	JWTIssuerAnnotation:        "the Tyk API definition has no issuer check",
+	JWTDefaultPolicyAnnotation: "the Tyk API definition has no default policies, use " + JWTPolicyClaimAnnotation,
+}
+
+// jwtAuth reads the JWT annotations from the effective annotations, nil when the ingress
+// doesn't use JWT auth
+func (c *ControlServer) jwtAuth(ing *v1beta1.Ingress, ann map[string]string) (*tyk.JWTAuth, error) {
+	for k, why := range unsupportedJWT {
+		if _, ok := ann[k]; ok {
+			msg := fmt.Sprintf("%s: %s", k, why)
+			c.recordIngressEvent(ing, v1.EventTypeWarning, "UnsupportedAnnotation", msg)
+			return nil, fmt.Errorf("unsupported annotation %s", msg)
+		}
+	}
+
+	uri, ok := ann[JWTJWKSURIAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	if uri == "" {
+		return nil, fmt.Errorf("%s is empty", JWTJWKSURIAnnotation)
+	}
+
+	j := &tyk.JWTAuth{
+		JWKSURI:       uri,
+		SigningMethod: ann[JWTSigningMethodAnnotation],
+		IdentityClaim: ann[JWTIdentityClaimAnnotation],
+		PolicyClaim:   ann[JWTPolicyClaimAnnotation],
+	}
+
+	if skew, ok := ann[JWTClockSkewAnnotation]; ok {
+		s, err := parseSkew(skew)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", JWTClockSkewAnnotation, err)
+		}
+		j.ClockSkewSeconds = s
+	}
+
+	return j, nil
+}
+
+// parseSkew accepts seconds or a duration such as 30s or 2m
+func parseSkew(v string) (uint64, error) {
+	if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+		return n, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("negative skew %s", v)
+	}
+
+	return uint64(d / time.Second), nil
+}
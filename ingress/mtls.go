@@ -0,0 +1,64 @@
+package ingress
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/api/extensions/v1beta1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Client certificate auth is enabled with "tyk.io/client-mtls-secrets": "<name>,<name>",
+// the certificates of the secrets in the ingress namespace are uploaded to Tyk and only
+// clients presenting one of them are let through
+const ClientMTLSSecretsAnnotation = "tyk.io/client-mtls-secrets"
+
+// clientCertificates uploads the client certificates of the ingress and returns their
+// IDs, a CA bundle in ca.crt is used over tls.crt
+func (c *ControlServer) clientCertificates(ing *v1beta1.Ingress) ([]string, error) {
+	v, ok := ing.Annotations[ClientMTLSSecretsAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	if c.client == nil {
+		return nil, errors.New("client certificates need a kubernetes client")
+	}
+
+	ids := make([]string, 0)
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		sec, err := c.client.CoreV1().Secrets(ing.Namespace).Get(name, v12.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client certificate secret %s: %v", name, err)
+		}
+
+		field := "ca.crt"
+		crt, ok := sec.Data[field]
+		if !ok {
+			field = "tls.crt"
+			crt, ok = sec.Data[field]
+		}
+		if !ok {
+			return nil, fmt.Errorf("secret %s has no ca.crt or tls.crt", name)
+		}
+
+		// only the public certificate is uploaded, Tyk matches clients against it
+		id, err := c.uploadCertificate(ing.Namespace, name, field, crt, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload client certificate %s: %v", name, err)
+		}
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%s lists no secrets", ClientMTLSSecretsAnnotation)
+	}
+
+	return ids, nil
+}
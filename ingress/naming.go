@@ -0,0 +1,111 @@
+package ingress
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"text/template"
+
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// namingVars are available to the slug and name templates
+type namingVars struct {
+	Namespace   string
+	IngressName string
+	Host        string
+	Path        string
+	ServiceName string
+	ServicePort int32
+}
+
+func newNamingVars(ing *v1beta1.Ingress, host string, p v1beta1.HTTPIngressPath) *namingVars {
+	return &namingVars{
+		Namespace:   ing.Namespace,
+		IngressName: ing.Name,
+		Host:        host,
+		Path:        p.Path,
+		ServiceName: p.Backend.ServiceName,
+		ServicePort: p.Backend.ServicePort.IntVal,
+	}
+}
+
+var repeatedSlashes = regexp.MustCompile("//+")
+
+func parseNamingTemplate(name, tpl string) (*template.Template, error) {
+	if tpl == "" {
+		return nil, nil
+	}
+
+	t, err := template.New(name).Option("missingkey=error").Parse(tpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %v", name, err)
+	}
+
+	// fields are only looked up on execution, render once so typos fail on start up too
+	if _, err := renderNamingTemplate(t, &namingVars{}); err != nil {
+		return nil, fmt.Errorf("invalid %s template: %v", name, err)
+	}
+
+	return t, nil
+}
+
+// checkNamingTemplates refuses to start with templates that can't be rendered, falling
+// back to the default slugs would recreate every API under a different identity
+func (c *ControlServer) checkNamingTemplates() error {
+	if c.cfg == nil {
+		return nil
+	}
+
+	if _, err := parseNamingTemplate("slug", c.cfg.SlugTemplate); err != nil {
+		return err
+	}
+
+	_, err := parseNamingTemplate("name", c.cfg.NameTemplate)
+	return err
+}
+
+func renderNamingTemplate(tpl *template.Template, vars *namingVars) (string, error) {
+	var out bytes.Buffer
+	err := tpl.Execute(&out, vars)
+	if err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+// ingressSlug is the identity of the API generated for an ingress path, it is a hash of
+// the ingress and path when no slug template is configured. Templated slugs keep single
+// slashes so paths stay readable
+func (c *ControlServer) ingressSlug(ing *v1beta1.Ingress, host string, p v1beta1.HTTPIngressPath) (string, error) {
+	if c.slugTpl == nil {
+		return c.generateIngressID(ing.Name, ing.Namespace, p), nil
+	}
+
+	slug, err := renderNamingTemplate(c.slugTpl, newNamingVars(ing, host, p))
+	if err != nil {
+		return "", fmt.Errorf("failed to render slug template: %v", err)
+	}
+
+	slug = repeatedSlashes.ReplaceAllString(slug, "/")
+	if slug == "" {
+		return "", errors.New("slug template rendered an empty slug")
+	}
+
+	return slug, nil
+}
+
+// apiName is the display name of the API generated for an ingress path
+func (c *ControlServer) apiName(ing *v1beta1.Ingress, host string, p v1beta1.HTTPIngressPath) string {
+	if c.nameTpl != nil {
+		name, err := renderNamingTemplate(c.nameTpl, newNamingVars(ing, host, p))
+		if err == nil && name != "" {
+			return name
+		}
+		log.Error("failed to render name template, using default: ", err)
+	}
+
+	return c.getAPIName(ing.Name, p.Backend.ServiceName)
+}
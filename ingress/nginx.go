@@ -0,0 +1,168 @@
+package ingress
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// The nginx compatibility layer translates the most common nginx-ingress annotations so
+// existing manifests keep working while they are migrated, anything it can't carry over
+// is reported with an event rather than silently ignored
+
+const (
+	nginxPrefix = "nginx.ingress.kubernetes.io/"
+
+	nginxRewriteTarget    = nginxPrefix + "rewrite-target"
+	nginxSSLRedirect      = nginxPrefix + "ssl-redirect"
+	nginxForceSSLRedirect = nginxPrefix + "force-ssl-redirect"
+	nginxBodySize         = nginxPrefix + "proxy-body-size"
+	nginxWhitelist        = nginxPrefix + "whitelist-source-range"
+)
+
+func (c *ControlServer) nginxCompatEnabled() bool {
+	return c.cfg != nil && c.cfg.NginxCompat
+}
+
+// parseNginxSize reads nginx sizes like 8m or 512k, 0 means unlimited
+func parseNginxSize(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult, s = 1<<10, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "m"):
+		mult, s = 1<<20, strings.TrimSuffix(s, "m")
+	case strings.HasSuffix(s, "g"):
+		mult, s = 1<<30, strings.TrimSuffix(s, "g")
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return n * mult, nil
+}
+
+// nginxFilters translates the nginx annotations of an ingress into request filters, it
+// returns nil when the layer is off or the ingress has none
+func (c *ControlServer) nginxFilters(ing *v1beta1.Ingress) *tyk.RequestFilters {
+	if !c.nginxCompatEnabled() {
+		return nil
+	}
+
+	var f *tyk.RequestFilters
+	filters := func() *tyk.RequestFilters {
+		if f == nil {
+			f = &tyk.RequestFilters{}
+		}
+		return f
+	}
+
+	warnings := make([]string, 0)
+	unsupported := func(k, why string) {
+		warnings = append(warnings, fmt.Sprintf("%s: %s", k, why))
+	}
+	defer func() {
+		c.reportNginxWarnings(ing, warnings)
+	}()
+
+	for k, v := range ing.Annotations {
+		if !strings.HasPrefix(k, nginxPrefix) {
+			continue
+		}
+
+		switch k {
+		case nginxRewriteTarget:
+			// the path regex is applied per path, see nginxRewrite
+			filters().RewritePath = v
+
+		case nginxBodySize:
+			n, err := parseNginxSize(v)
+			if err != nil {
+				unsupported(k, err.Error())
+				continue
+			}
+			filters().SizeLimit = n
+
+		case nginxWhitelist:
+			for _, r := range strings.Split(v, ",") {
+				if r = strings.TrimSpace(r); r != "" {
+					filters().AllowedIPs = append(filters().AllowedIPs, r)
+				}
+			}
+
+		case nginxSSLRedirect, nginxForceSSLRedirect:
+			// the gateway listener decides the scheme, APIs can't redirect plain requests
+			if strings.ToLower(v) == "true" {
+				unsupported(k, "TLS redirects are configured on the Tyk gateway listener")
+			}
+
+		default:
+			unsupported(k, "no Tyk equivalent")
+		}
+	}
+
+	return f
+}
+
+// reportNginxWarnings records an event per annotation that can't be translated, only when
+// they changed since the last sync of the ingress so every resync doesn't add events
+func (c *ControlServer) reportNginxWarnings(ing *v1beta1.Ingress, warnings []string) {
+	key := ing.Namespace + "/" + ing.Name
+	sort.Strings(warnings)
+	joined := strings.Join(warnings, "\n")
+
+	prev, ok := c.nginxWarnings.Load(key)
+	if ok && prev.(string) == joined || !ok && joined == "" {
+		return
+	}
+
+	if joined == "" {
+		c.nginxWarnings.Delete(key)
+		return
+	}
+	c.nginxWarnings.Store(key, joined)
+
+	for _, w := range warnings {
+		c.recordIngressEvent(ing, v1.EventTypeWarning, "UnsupportedAnnotation", w)
+	}
+}
+
+// nginxRegexChars start the regex part of an nginx path
+const nginxRegexChars = `.^$*+?()[]{}|\`
+
+// nginxRewrite applies the rewrite-target of the ingress to one of its paths with the
+// semantics of nginx-ingress 0.22 and later. The path is a regex matched from the start of
+// the request path, ignoring case, and the whole request path is replaced by the target,
+// which may use the capture groups of the path as $1. The API listens on the literal part
+// of the path before the regex and doesn't strip it, so the rewrite sees the whole path
+func nginxRewrite(f *tyk.RequestFilters, pth string) (*tyk.RequestFilters, string, error) {
+	if f == nil || f.RewritePath == "" {
+		return f, pth, nil
+	}
+
+	pattern := "(?i)^" + pth
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, "", fmt.Errorf("invalid path regex %s: %v", pth, err)
+	}
+
+	listenPath := pth
+	if i := strings.IndexAny(pth, nginxRegexChars); i >= 0 {
+		listenPath = pth[:i]
+	}
+	if listenPath == "" {
+		listenPath = "/"
+	}
+
+	rf := *f
+	rf.RewritePattern = pattern
+	return &rf, listenPath, nil
+}
@@ -0,0 +1,60 @@
+package ingress
+
+import (
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NewOfflineController returns a controller that syncs the ingresses passed to Apply and
+// Remove instead of watching a cluster, for integration tests and embedders. It has no
+// Kubernetes client so events are only logged and secrets can't be read
+func NewOfflineController(cfg *Config) *ControlServer {
+	c := &ControlServer{store: cache.NewStore(cache.MetaNamespaceKeyFunc)}
+	c.Config(cfg)
+
+	// the processed APIs are remembered per package, an earlier controller could have
+	// synced the same slugs
+	opLog.Range(func(k, _ interface{}) bool {
+		opLog.Delete(k)
+		return true
+	})
+
+	return c
+}
+
+// Apply syncs the ingress as the informer would, as an update when it was applied before
+func (c *ControlServer) Apply(ing *v1beta1.Ingress) error {
+	old, exists, err := c.store.Get(ing)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		if err := c.store.Add(ing); err != nil {
+			return err
+		}
+		c.handleIngressAdd(ing)
+		return nil
+	}
+
+	if err := c.store.Update(ing); err != nil {
+		return err
+	}
+	c.handleIngressUpdate(old, ing)
+	return nil
+}
+
+// Remove syncs the deletion of the ingress
+func (c *ControlServer) Remove(ing *v1beta1.Ingress) error {
+	if err := c.store.Delete(ing); err != nil {
+		return err
+	}
+
+	c.handleIngressDelete(ing)
+	return nil
+}
+
+// Resync syncs every ingress applied so far, as a full sync does
+func (c *ControlServer) Resync() error {
+	return c.resyncAll()
+}
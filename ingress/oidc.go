@@ -0,0 +1,71 @@
+package ingress
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+)
+
+// OpenID Connect is set up from the manifest with a JSON list of providers, e.g.
+// "tyk.io/oidc-providers": '[{"issuer": "https://accounts.google.com",
+// "clients": {"<client ID>": "<policy ID>"}}]'
+const (
+	OIDCProvidersAnnotation         = "tyk.io/oidc-providers"
+	OIDCSegregateByClientAnnotation = "tyk.io/oidc-segregate-by-client"
+)
+
+type oidcProvider struct {
+	Issuer  string            `json:"issuer"`
+	Clients map[string]string `json:"clients"`
+}
+
+// openIDAuth reads the OIDC annotations from the effective annotations, nil when the
+// ingress doesn't use OpenID Connect
+func openIDAuth(ann map[string]string) (*tyk.OpenIDAuth, error) {
+	raw, ok := ann[OIDCProvidersAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	providers := make([]oidcProvider, 0)
+	if err := json.Unmarshal([]byte(raw), &providers); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", OIDCProvidersAnnotation, err)
+	}
+
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("%s lists no providers", OIDCProvidersAnnotation)
+	}
+
+	o := &tyk.OpenIDAuth{}
+	for i, p := range providers {
+		if p.Issuer == "" {
+			return nil, fmt.Errorf("%s: provider %d has no issuer", OIDCProvidersAnnotation, i)
+		}
+
+		// tokens of clients without a policy would have no access, so they are refused
+		// here rather than at request time
+		if len(p.Clients) == 0 {
+			return nil, fmt.Errorf("%s: provider %s has no clients", OIDCProvidersAnnotation, p.Issuer)
+		}
+
+		for client, pol := range p.Clients {
+			if client == "" || pol == "" {
+				return nil, fmt.Errorf("%s: provider %s needs a client ID and policy for every client", OIDCProvidersAnnotation, p.Issuer)
+			}
+		}
+
+		o.Providers = append(o.Providers, tyk.OpenIDProvider{Issuer: p.Issuer, ClientPolicies: p.Clients})
+	}
+
+	if v, ok := ann[OIDCSegregateByClientAnnotation]; ok {
+		seg, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", OIDCSegregateByClientAnnotation, err)
+		}
+		o.SegregateByClient = seg
+	}
+
+	return o, nil
+}
@@ -0,0 +1,159 @@
+package ingress
+
+import (
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/extensions/v1beta1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// planner is a copy of the controller that builds options without side effects, events
+// are dropped and certificates are not uploaded
+func (c *ControlServer) planner() *ControlServer {
+	return &ControlServer{
+		cfg:         c.cfg,
+		client:      c.client,
+		store:       c.store,
+		slugTpl:     c.slugTpl,
+		nameTpl:     c.nameTpl,
+		classParams: c.currentClassParams(),
+		dryRun:      true,
+	}
+}
+
+// uploadCertificate uploads a certificate of a secret to Tyk and monitors it, plans only
+// work out the ID Tyk gives it
+func (c *ControlServer) uploadCertificate(ns, secret, field string, crt, key []byte) (string, error) {
+	if c.dryRun {
+		return tyk.CertificateID(crt)
+	}
+
+	id, err := tyk.CreateCertificate(crt, key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tyk.TrackCertificate(secretSource(ns, secret), field, id, crt); err != nil {
+		log.Warningf("certificate %s is not monitored: %v", id, err)
+	}
+
+	return id, nil
+}
+
+// Plan works out what a full sync of the managed ingresses would change in Tyk without
+// changing it. APIs of ingresses that no longer generate them are planned for deletion,
+// the running controller leaves those in place until their ingress is deleted or updated
+func (c *ControlServer) Plan() (*tyk.Plan, error) {
+	if err := c.checkNamingTemplates(); err != nil {
+		return nil, err
+	}
+
+	if c.client == nil {
+		var err error
+		c.client, err = c.getClient()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p := c.planner()
+	if p.store == nil {
+		ings, err := p.client.ExtensionsV1beta1().Ingresses("").List(v12.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		p.store = cache.NewStore(cache.MetaNamespaceKeyFunc)
+		for i := range ings.Items {
+			if err := p.store.Add(&ings.Items[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if p.classParams == nil && p.cfg != nil && p.cfg.UseClassParams {
+		params, err := p.fetchClassParams()
+		if err != nil {
+			return nil, err
+		}
+		p.classParams = params
+	}
+
+	svcs := map[string]*tyk.APIDefOptions{}
+	expected := map[string]bool{}
+	ings := p.managedIngresses()
+	if p.mergeHostsEnabled() {
+		for _, host := range ingressHosts(ings...) {
+			expected[p.generateHostID(host)] = true
+			opts, err := p.mergeHost(host, p.managedIngressesForHost(host))
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			svcs[opts.Slug] = opts
+		}
+
+		for _, ing := range ings {
+			if ing.Spec.Backend == nil {
+				continue
+			}
+
+			expected[p.generateDefaultBackendID(ing.Name, ing.Namespace)] = true
+			opts, err := p.defaultBackendOptions(ing)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			svcs[opts.Slug] = opts
+		}
+	} else {
+		for _, ing := range ings {
+			// without the slugs the plan can't tell which APIs are still wanted
+			slugs, err := p.ingressSlugs(ing)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range slugs {
+				expected[s] = true
+			}
+
+			list, err := p.getUpdateList(ing)
+			if err != nil {
+				return nil, err
+			}
+			for s, opts := range list {
+				svcs[s] = opts
+			}
+		}
+	}
+
+	// APIs whose options failed are reported by the sync itself, they are not deleted
+	keep := map[string]bool{}
+	for s := range expected {
+		if _, ok := svcs[s]; !ok {
+			keep[s] = true
+		}
+	}
+
+	return tyk.PlanAPIs(svcs, "Ingress", keep)
+}
+
+// ingressSlugs returns the slugs the ingress generates, whether or not their options can
+// be built
+func (c *ControlServer) ingressSlugs(ing *v1beta1.Ingress) ([]string, error) {
+	apis, err := c.ruleAPIs(ing)
+	if err != nil {
+		return nil, err
+	}
+
+	slugs := make([]string, 0)
+	for _, a := range apis {
+		slugs = append(slugs, a.slug)
+	}
+
+	if ing.Spec.Backend != nil {
+		slugs = append(slugs, c.generateDefaultBackendID(ing.Name, ing.Namespace))
+	}
+
+	return slugs, nil
+}
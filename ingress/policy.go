@@ -0,0 +1,145 @@
+package ingress
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// A partitioned policy is generated for the APIs of an ingress with e.g.
+// "tyk.io/rate-limit": "100/1m", "tyk.io/quota": "10000/24h" and "tyk.io/policy-acl": "true",
+// only the partitions of the annotations set are enabled. Keys reference the policy by the
+// ID reported in the PolicySynced event
+const (
+	RateLimitAnnotation = "tyk.io/rate-limit"
+	QuotaAnnotation     = "tyk.io/quota"
+	PolicyACLAnnotation = "tyk.io/policy-acl"
+)
+
+// parseLimit reads "<count>/<period>", the period is a duration or seconds
+func parseLimit(v string) (float64, float64, error) {
+	parts := strings.SplitN(strings.TrimSpace(v), "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected <count>/<period>, got %q", v)
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || n <= 0 {
+		return 0, 0, fmt.Errorf("invalid count %q", parts[0])
+	}
+
+	period := strings.TrimSpace(parts[1])
+	secs, err := strconv.ParseFloat(period, 64)
+	if err != nil {
+		d, derr := time.ParseDuration(period)
+		if derr != nil {
+			return 0, 0, fmt.Errorf("invalid period %q", period)
+		}
+		secs = d.Seconds()
+	}
+
+	if secs <= 0 {
+		return 0, 0, fmt.Errorf("invalid period %q", period)
+	}
+
+	return n, secs, nil
+}
+
+// policySpec reads the policy annotations of the ingress, nil when it sets none
+func policySpec(ing *v1beta1.Ingress) (*tyk.PolicySpec, error) {
+	s := &tyk.PolicySpec{Name: ing.Namespace + "/" + ing.Name}
+	set := false
+
+	if v, ok := ing.Annotations[RateLimitAnnotation]; ok {
+		rate, per, err := parseLimit(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", RateLimitAnnotation, err)
+		}
+		s.Rate, s.Per, set = rate, per, true
+	}
+
+	if v, ok := ing.Annotations[QuotaAnnotation]; ok {
+		max, renew, err := parseLimit(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", QuotaAnnotation, err)
+		}
+		s.QuotaMax, s.QuotaRenewalRate, set = int64(max), int64(renew), true
+	}
+
+	if v, ok := ing.Annotations[PolicyACLAnnotation]; ok {
+		acl, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", PolicyACLAnnotation, err)
+		}
+		s.ACL, set = acl, set || acl
+	}
+
+	if !set {
+		return nil, nil
+	}
+
+	return s, nil
+}
+
+func policySource(ing *v1beta1.Ingress) string {
+	return fmt.Sprintf("Ingress/%s/%s", ing.Namespace, ing.Name)
+}
+
+// provisionPolicy keeps the policy of the ingress in line with its APIs after they are
+// synced, a policy written before the annotations were removed is deleted
+func (c *ControlServer) provisionPolicy(ing *v1beta1.Ingress) {
+	src := policySource(ing)
+	spec, err := policySpec(ing)
+	if err == nil && spec == nil {
+		if tyk.PolicyManaged(src) {
+			err = tyk.DeletePolicy(src)
+		}
+	} else if err == nil {
+		err = c.syncPolicy(ing, src, spec)
+	}
+
+	if err != nil {
+		c.recordIngressEvent(ing, v1.EventTypeWarning, "PolicyFailed", err.Error())
+	}
+}
+
+func (c *ControlServer) syncPolicy(ing *v1beta1.Ingress, src string, spec *tyk.PolicySpec) error {
+	apis, err := ingressAPIs([]*v1beta1.Ingress{ing})
+	if err != nil {
+		return err
+	}
+
+	// the policy is written once the APIs exist, access rights need their IDs
+	if len(apis) == 0 {
+		return nil
+	}
+	spec.APIs = apis
+
+	written := tyk.PolicyManaged(src)
+	id, err := tyk.SyncPolicy(src, spec)
+	if err != nil {
+		return err
+	}
+
+	if !written {
+		c.recordIngressEvent(ing, v1.EventTypeNormal, "PolicySynced", "policy "+id)
+	}
+
+	return nil
+}
+
+// removePolicy deletes the policy of a deleted ingress
+func (c *ControlServer) removePolicy(ing *v1beta1.Ingress) {
+	if spec, _ := policySpec(ing); spec == nil && !tyk.PolicyManaged(policySource(ing)) {
+		return
+	}
+
+	if err := tyk.DeletePolicy(policySource(ing)); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,71 @@
+package ingress
+
+import (
+	"fmt"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"github.com/ghodss/yaml"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// An ingress publishes its OpenAPI document to the developer portal with, e.g.
+// "tyk.io/portal-docs": "configMapKeyRef:petstore-docs/openapi.yaml" and
+// "tyk.io/portal-policy": "<policy ID>", the catalogue entry is keyed by the policy
+// developers request access to and belongs to the ingress that published it first
+const (
+	PortalDocsAnnotation        = "tyk.io/portal-docs"
+	PortalPolicyAnnotation      = "tyk.io/portal-policy"
+	PortalDescriptionAnnotation = "tyk.io/portal-description"
+)
+
+// portalDocs builds the portal documentation from the effective annotations, nil when
+// the ingress doesn't publish any
+func portalDocs(ing *v1beta1.Ingress, ann map[string]string) (*tyk.PortalDocs, error) {
+	doc, ok := ann[PortalDocsAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	policy := ann[PortalPolicyAnnotation]
+	if policy == "" {
+		return nil, fmt.Errorf("%s needs %s", PortalDocsAnnotation, PortalPolicyAnnotation)
+	}
+
+	// YAML documents are converted, the portal renders JSON
+	raw, err := yaml.YAMLToJSON([]byte(doc))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %v", err)
+	}
+
+	return &tyk.PortalDocs{
+		Source: fmt.Sprintf("Ingress/%s/%s", ing.Namespace, ing.Name),
+		Entry: tyk.CatalogueEntry{
+			Name:             fmt.Sprintf("%s/%s", ing.Namespace, ing.Name),
+			ShortDescription: ann[PortalDescriptionAnnotation],
+			Show:             true,
+			APIID:            policy,
+		},
+		Document: raw,
+	}, nil
+}
+
+// publishPortalDocs publishes the documentation of an ingress after its APIs are synced,
+// failures are reported on the ingress and don't affect the APIs
+func (c *ControlServer) publishPortalDocs(ing *v1beta1.Ingress) {
+	// docs may come from class or namespace defaults, so the effective annotations decide
+	ann, err := c.effectiveAnnotations(ing)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	d, err := portalDocs(ing, ann)
+	if err == nil && d != nil {
+		err = tyk.PublishDocs(d)
+	}
+
+	if err != nil {
+		c.recordIngressEvent(ing, v1.EventTypeWarning, "PortalDocsFailed", err.Error())
+	}
+}
@@ -0,0 +1,174 @@
+package ingress
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// The templates strip the listen path and the version from the upstream request, upstreams
+// expecting the original path turn that off with "tyk.io/strip-listen-path": "false".
+// The upstream connections are tuned with e.g. "tyk.io/upstream-tls-min-version": "1.2",
+// "tyk.io/upstream-tls-ciphers": "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" and
+// "tyk.io/upstream-proxy-url": "http://egress.internal:3128". Upstreams that only speak
+// HTTP/2, like gRPC services, set "tyk.io/upstream-protocol": "h2c" or "http2". Clients
+// using the Tyk batch endpoint of an API need "tyk.io/batch-requests": "true"
+const (
+	StripListenPathAnnotation        = "tyk.io/strip-listen-path"
+	StripVersionPathAnnotation       = "tyk.io/strip-version-path"
+	UpstreamTLSMinVersionAnnotation  = "tyk.io/upstream-tls-min-version"
+	UpstreamTLSCiphersAnnotation     = "tyk.io/upstream-tls-ciphers"
+	UpstreamProxyURLAnnotation       = "tyk.io/upstream-proxy-url"
+	UpstreamConnectTimeoutAnnotation = "tyk.io/upstream-connect-timeout"
+	UpstreamProtocolAnnotation       = "tyk.io/upstream-protocol"
+	BatchRequestsAnnotation          = "tyk.io/batch-requests"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// unsupportedAnnotations are settings the vendored Tyk API definition has no field for
+var unsupportedAnnotations = map[string]string{
+	UpstreamConnectTimeoutAnnotation: "the Tyk API definition has no connect timeout, the gateway proxy_default_timeout applies",
+	GraphQLPlaygroundPathAnnotation:  "the Tyk API definition has no GraphQL support",
+	GraphQLIntrospectionAnnotation:   "the Tyk API definition has no GraphQL support",
+	GraphQLSchemaAnnotation:          "the Tyk API definition has no GraphQL support",
+}
+
+func boolAnnotation(ann map[string]string, key string) (*bool, error) {
+	v, ok := ann[key]
+	if !ok {
+		return nil, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", key, err)
+	}
+
+	return &b, nil
+}
+
+// setProxy applies the stripping, transport and cache annotations to the options
+func (c *ControlServer) setProxy(ing *v1beta1.Ingress, opts *tyk.APIDefOptions) error {
+	if err := setStripping(opts); err != nil {
+		return err
+	}
+
+	for k, why := range unsupportedAnnotations {
+		if _, ok := opts.Annotations[k]; ok {
+			msg := fmt.Sprintf("%s: %s", k, why)
+			c.recordIngressEvent(ing, v1.EventTypeWarning, "UnsupportedAnnotation", msg)
+			return fmt.Errorf("unsupported annotation %s", msg)
+		}
+	}
+
+	var err error
+	opts.Transport, err = proxyTransport(opts.Annotations)
+	if err != nil {
+		return err
+	}
+
+	opts.UpstreamProtocol, err = upstreamProtocol(opts.Annotations)
+	if err != nil {
+		return err
+	}
+
+	opts.BatchRequests, err = boolAnnotation(opts.Annotations, BatchRequestsAnnotation)
+	if err != nil {
+		return err
+	}
+
+	opts.CachePaths, err = c.cachePaths(ing, opts.Annotations)
+	return err
+}
+
+func upstreamProtocol(ann map[string]string) (string, error) {
+	v, ok := ann[UpstreamProtocolAnnotation]
+	if !ok {
+		return "", nil
+	}
+
+	switch p := strings.ToLower(strings.TrimSpace(v)); p {
+	case tyk.UpstreamHTTP, tyk.UpstreamHTTP2, tyk.UpstreamH2C:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid %s %q, expected h2c, http2 or http", UpstreamProtocolAnnotation, v)
+	}
+}
+
+// setStripping reads the stripping overrides from the effective annotations
+func setStripping(opts *tyk.APIDefOptions) error {
+	var err error
+	opts.StripListenPath, err = boolAnnotation(opts.Annotations, StripListenPathAnnotation)
+	if err != nil {
+		return err
+	}
+
+	opts.StripVersionPath, err = boolAnnotation(opts.Annotations, StripVersionPathAnnotation)
+	return err
+}
+
+// proxyTransport reads the transport annotations, nil when the ingress sets none
+func proxyTransport(ann map[string]string) (*tyk.ProxyTransport, error) {
+	var t *tyk.ProxyTransport
+	transport := func() *tyk.ProxyTransport {
+		if t == nil {
+			t = &tyk.ProxyTransport{}
+		}
+		return t
+	}
+
+	if v, ok := ann[UpstreamTLSMinVersionAnnotation]; ok {
+		ver, ok := tlsVersions[strings.TrimSpace(v)]
+		if !ok {
+			return nil, fmt.Errorf("invalid %s %q, expected one of 1.0, 1.1, 1.2 or 1.3", UpstreamTLSMinVersionAnnotation, v)
+		}
+		transport().MinTLSVersion = ver
+	}
+
+	if v, ok := ann[UpstreamTLSCiphersAnnotation]; ok {
+		known := map[string]bool{}
+		for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			known[s.Name] = true
+		}
+
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+
+			if !known[name] {
+				return nil, fmt.Errorf("unknown cipher suite %s in %s", name, UpstreamTLSCiphersAnnotation)
+			}
+			transport().CipherSuites = append(transport().CipherSuites, name)
+		}
+	}
+
+	if v, ok := ann[UpstreamProxyURLAnnotation]; ok {
+		u, err := url.Parse(strings.TrimSpace(v))
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid %s %q", UpstreamProxyURLAnnotation, v)
+		}
+
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported %s scheme %q", UpstreamProxyURLAnnotation, u.Scheme)
+		}
+		transport().ProxyURL = u.String()
+	}
+
+	return t, nil
+}
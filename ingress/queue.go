@@ -0,0 +1,323 @@
+package ingress
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// Changes that fail because the Dashboard is unreachable are written to the queue file
+// and replayed once it is back. Only ingress references and slugs are stored, the
+// definitions are rendered again on replay so no annotation values end up on disk
+
+const (
+	queueOpSync   = "sync"
+	queueOpDelete = "delete"
+
+	defaultQueueRetry = 30 * time.Second
+)
+
+type pendingOp struct {
+	Op        string   `json:"op"`
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Slugs     []string `json:"slugs,omitempty"`
+	// Seq changes whenever the entry changes so a replay only removes what it applied
+	Seq int64 `json:"seq"`
+}
+
+type offlineQueue struct {
+	mu   sync.Mutex
+	path string
+	ops  []pendingOp
+	seq  int64
+}
+
+func loadQueue(path string) (*offlineQueue, error) {
+	q := &offlineQueue{path: path, ops: make([]pendingOp, 0)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return q, nil
+	}
+
+	err = json.Unmarshal(data, &q.ops)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range q.ops {
+		if o.Seq > q.seq {
+			q.seq = o.Seq
+		}
+	}
+
+	return q, nil
+}
+
+// save writes the queue to a temporary file first so a crash never leaves it truncated
+func (q *offlineQueue) save() error {
+	data, err := json.Marshal(q.ops)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(q.path), "."+filepath.Base(q.path)+".tmp")
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, q.path)
+}
+
+// add queues an operation, syncs replace earlier syncs of the same ingress and deletes
+// are merged into a single entry per ingress
+func (q *offlineQueue) add(op pendingOp) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	merged := false
+	for i, o := range q.ops {
+		if o.Op != op.Op || o.Namespace != op.Namespace || o.Name != op.Name {
+			continue
+		}
+
+		o.Slugs = appendUnique(o.Slugs, op.Slugs...)
+		o.Seq = q.seq
+		q.ops[i] = o
+		merged = true
+		break
+	}
+
+	if !merged {
+		op.Seq = q.seq
+		q.ops = append(q.ops, op)
+	}
+
+	err := q.save()
+	if err != nil {
+		log.Error("failed to persist offline queue: ", err)
+	}
+}
+
+func (q *offlineQueue) pending() []pendingOp {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ops := make([]pendingOp, len(q.ops))
+	copy(ops, q.ops)
+	return ops
+}
+
+// done removes replayed operations, operations queued during the replay are kept
+func (q *offlineQueue) done(replayed []pendingOp) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	remaining := make([]pendingOp, 0, len(q.ops))
+	for _, o := range q.ops {
+		found := false
+		for _, r := range replayed {
+			if o.Op == r.Op && o.Namespace == r.Namespace && o.Name == r.Name && o.Seq == r.Seq {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			remaining = append(remaining, o)
+		}
+	}
+
+	q.ops = remaining
+	err := q.save()
+	if err != nil {
+		log.Error("failed to persist offline queue: ", err)
+	}
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, it := range items {
+		exists := false
+		for _, l := range list {
+			if l == it {
+				exists = true
+				break
+			}
+		}
+
+		if !exists {
+			list = append(list, it)
+		}
+	}
+
+	return list
+}
+
+// queueIfUnavailable queues the operation when the error means the Dashboard could not
+// be reached and reports whether it did
+func (c *ControlServer) queueIfUnavailable(op pendingOp, err error) bool {
+	if c.queue == nil || !tyk.IsUnavailable(err) {
+		return false
+	}
+
+	log.Warningf("tyk API unavailable, queueing %s of %s/%s: %v", op.Op, op.Namespace, op.Name, err)
+	c.queue.add(op)
+	return true
+}
+
+func syncOp(ing *v1beta1.Ingress) pendingOp {
+	return pendingOp{Op: queueOpSync, Namespace: ing.Namespace, Name: ing.Name}
+}
+
+func deleteOp(ing *v1beta1.Ingress, slugs ...string) pendingOp {
+	return pendingOp{Op: queueOpDelete, Namespace: ing.Namespace, Name: ing.Name, Slugs: slugs}
+}
+
+// replayQueue applies queued deletes and then queued syncs, syncs re-render the ingress
+// from the informer cache so an ingress removed while offline is skipped. Replay stops
+// at the first operation that fails because the Dashboard is still unavailable
+func (c *ControlServer) replayQueue() {
+	ops := c.queue.pending()
+	if len(ops) == 0 {
+		return
+	}
+
+	replayed := make([]pendingOp, 0, len(ops))
+	for _, phase := range []string{queueOpDelete, queueOpSync} {
+		for _, op := range ops {
+			if op.Op != phase {
+				continue
+			}
+
+			err := c.replayOp(op)
+			if tyk.IsUnavailable(err) {
+				log.Warning("tyk API still unavailable, ", len(ops)-len(replayed), " queued changes remaining")
+				c.queue.done(replayed)
+				return
+			}
+
+			if err != nil {
+				log.Errorf("failed to replay %s of %s/%s: %v", op.Op, op.Namespace, op.Name, err)
+			}
+
+			replayed = append(replayed, op)
+		}
+	}
+
+	log.Info("replayed ", len(replayed), " queued changes")
+	c.queue.done(replayed)
+}
+
+func (c *ControlServer) replayOp(op pendingOp) error {
+	switch op.Op {
+	case queueOpDelete:
+		for _, sid := range op.Slugs {
+			if _, err := tyk.GetBySlug(sid); err != nil {
+				if tyk.IsUnavailable(err) {
+					return err
+				}
+				// already gone
+				continue
+			}
+
+			err := tyk.DeleteBySlug(sid)
+			if err != nil {
+				return err
+			}
+
+			opLog.Delete("add-" + sid)
+		}
+
+		return nil
+	case queueOpSync:
+		if c.store == nil {
+			return nil
+		}
+
+		obj, exists, err := c.store.GetByKey(op.Namespace + "/" + op.Name)
+		if err != nil || !exists {
+			return err
+		}
+
+		ing, ok := obj.(*v1beta1.Ingress)
+		if !ok || !c.ownsIngress(ing) {
+			return nil
+		}
+		c.beginSync(ing)
+
+		list, err := c.getUpdateList(ing)
+		if err != nil {
+			return err
+		}
+
+		err = tyk.UpdateAPIs(list)
+		if err != nil {
+			return err
+		}
+
+		for sid := range list {
+			opLog.Store("add-"+sid, struct{}{})
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+func (c *ControlServer) startQueue() error {
+	q, err := loadQueue(c.cfg.QueueFile)
+	if err != nil {
+		return err
+	}
+
+	c.queue = q
+	interval := defaultQueueRetry
+	if c.cfg.QueueRetrySeconds > 0 {
+		interval = time.Duration(c.cfg.QueueRetrySeconds) * time.Second
+	}
+
+	log.Info("offline queue enabled, ", len(q.pending()), " changes pending")
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				c.replayQueue()
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// FlushQueue writes the pending changes to the queue file, a shutdown calls it once the
+// API writes have drained so the changes queued while draining are kept
+func (c *ControlServer) FlushQueue() error {
+	if c.queue == nil {
+		return nil
+	}
+
+	c.queue.mu.Lock()
+	defer c.queue.mu.Unlock()
+	return c.queue.save()
+}
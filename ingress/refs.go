@@ -0,0 +1,95 @@
+package ingress
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Annotation values can reference a key in a Secret or ConfigMap in the ingress namespace
+// so sensitive values never appear in the ingress itself, e.g.
+// "tyk.io/set.config_data.jwt_secret": "secretKeyRef:jwt-secret/signing-key"
+const (
+	secretKeyRefPrefix    = "secretKeyRef:"
+	configMapKeyRefPrefix = "configMapKeyRef:"
+)
+
+func parseKeyRef(ref string) (string, string, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid key reference %q, expected <name>/<key>", ref)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// resolveAnnotationValue returns the referenced value for secretKeyRef and configMapKeyRef
+// values and the value itself for everything else
+func (c *ControlServer) resolveAnnotationValue(ns, val string) (string, error) {
+	var isSecret bool
+	var ref string
+	switch {
+	case strings.HasPrefix(val, secretKeyRefPrefix):
+		isSecret = true
+		ref = val[len(secretKeyRefPrefix):]
+	case strings.HasPrefix(val, configMapKeyRefPrefix):
+		ref = val[len(configMapKeyRefPrefix):]
+	default:
+		return val, nil
+	}
+
+	name, key, err := parseKeyRef(ref)
+	if err != nil {
+		return "", err
+	}
+
+	if c.client == nil {
+		return "", fmt.Errorf("cannot resolve %s/%s without a cluster client", name, key)
+	}
+
+	if isSecret {
+		sec, err := c.client.CoreV1().Secrets(ns).Get(name, v12.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get secret %s/%s: %v", ns, name, err)
+		}
+
+		d, ok := sec.Data[key]
+		if !ok {
+			return "", fmt.Errorf("key %s not found in secret %s/%s", key, ns, name)
+		}
+
+		return string(d), nil
+	}
+
+	cm, err := c.client.CoreV1().ConfigMaps(ns).Get(name, v12.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get config map %s/%s: %v", ns, name, err)
+	}
+
+	d, ok := cm.Data[key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in config map %s/%s", key, ns, name)
+	}
+
+	return d, nil
+}
+
+// resolveAnnotationRefs replaces key references with their values, the error names the
+// annotation but never includes resolved values
+func (c *ControlServer) resolveAnnotationRefs(ing *v1beta1.Ingress, ann map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(ann))
+	for k, v := range ann {
+		rv, err := c.resolveAnnotationValue(ing.Namespace, v)
+		if err != nil {
+			c.recordIngressEvent(ing, v1.EventTypeWarning, "AnnotationRefFailed", fmt.Sprintf("%s: %v", k, err))
+			return nil, fmt.Errorf("failed to resolve annotation %s: %v", k, err)
+		}
+
+		resolved[k] = rv
+	}
+
+	return resolved, nil
+}
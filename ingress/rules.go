@@ -0,0 +1,221 @@
+package ingress
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/TykTechnologies/tyk-k8s/processor"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// controllerAnnotations are read by the controller rather than the processor
+var controllerAnnotations = []string{
+	tyk.TemplateNameKey,
+	LoopTargetAnnotation,
+	PathTypeAnnotation,
+	ExternalSchemeAnnotation,
+	ExternalPortAnnotation,
+	GatewayTagsAnnotation,
+	OrgAnnotation,
+	PortalDocsAnnotation,
+	PortalPolicyAnnotation,
+	PortalDescriptionAnnotation,
+	JWTJWKSURIAnnotation,
+	JWTSigningMethodAnnotation,
+	JWTIdentityClaimAnnotation,
+	JWTPolicyClaimAnnotation,
+	JWTClockSkewAnnotation,
+	JWTIssuerAnnotation,
+	JWTDefaultPolicyAnnotation,
+	OIDCProvidersAnnotation,
+	OIDCSegregateByClientAnnotation,
+	BasicAuthSecretAnnotation,
+	HMACAuthAnnotation,
+	HMACAlgorithmsAnnotation,
+	HMACClockSkewAnnotation,
+	HMACSecretAnnotation,
+	ClientMTLSSecretsAnnotation,
+	UpstreamCAAnnotation,
+	StripListenPathAnnotation,
+	StripVersionPathAnnotation,
+	UpstreamTLSMinVersionAnnotation,
+	UpstreamTLSCiphersAnnotation,
+	UpstreamProxyURLAnnotation,
+	UpstreamConnectTimeoutAnnotation,
+	UpstreamProtocolAnnotation,
+	RateLimitAnnotation,
+	QuotaAnnotation,
+	PolicyACLAnnotation,
+	CachePathsAnnotation,
+	BatchRequestsAnnotation,
+	GraphQLPlaygroundPathAnnotation,
+	GraphQLIntrospectionAnnotation,
+	GraphQLSchemaAnnotation,
+	tyk.OwnerTeamKey,
+	APITypeAnnotation,
+}
+
+func isTykAnnotation(k string) bool {
+	return strings.HasPrefix(k, "tyk.io/") || strings.Contains(k, "service.tyk.io")
+}
+
+// unknownAnnotations lists Tyk annotations that nothing handles, usually typos
+func unknownAnnotations(ann map[string]string) []string {
+	unknown := make([]string, 0)
+	for k := range ann {
+		if !isTykAnnotation(k) || processor.Recognised(k) {
+			continue
+		}
+
+		known := false
+		for _, ca := range controllerAnnotations {
+			if k == ca {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			unknown = append(unknown, k)
+		}
+	}
+
+	sort.Strings(unknown)
+	return unknown
+}
+
+// Rule applies processor annotations to every managed ingress it matches, e.g. to turn
+// on authentication for all ingresses in "prod-*" namespaces. Annotations set on the
+// ingress itself take precedence over rule annotations
+type Rule struct {
+	// Namespace is a glob matched against the ingress namespace, empty matches all
+	Namespace string `yaml:"namespace"`
+	// Labels must all be present on the ingress with the same value
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+func (r Rule) matches(ing *v1beta1.Ingress) bool {
+	if r.Namespace != "" {
+		ok, err := path.Match(r.Namespace, ing.Namespace)
+		if err != nil {
+			log.Errorf("invalid namespace pattern in rule %q: %v", r.Namespace, err)
+			return false
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	for k, v := range r.Labels {
+		if lv, ok := ing.Labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkRules refuses invalid namespace patterns at start up, the rule would never match
+func (c *ControlServer) checkRules() error {
+	if c.cfg == nil {
+		return nil
+	}
+
+	for i, r := range c.cfg.Rules {
+		if _, err := path.Match(r.Namespace, ""); err != nil {
+			return fmt.Errorf("invalid namespace pattern in rule %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// effectiveAnnotations returns the ingress annotations with the matching rules applied,
+// later rules override earlier ones, and Secret and ConfigMap references resolved
+func (c *ControlServer) effectiveAnnotations(ing *v1beta1.Ingress) (map[string]string, error) {
+	ann := c.currentClassParams().defaults()
+	if c.cfg != nil {
+		for _, r := range c.cfg.Rules {
+			if !r.matches(ing) {
+				continue
+			}
+
+			for k, v := range r.Annotations {
+				ann[k] = v
+			}
+		}
+	}
+
+	for k, v := range ing.Annotations {
+		// the org is only picked with the org annotation, which checks the allowed orgs
+		if processor.Writes(k, v, "org_id") {
+			msg := fmt.Sprintf("%s can't set org_id, use %s", k, OrgAnnotation)
+			c.recordIngressEvent(ing, v1.EventTypeWarning, "OrgNotAllowed", msg)
+			return nil, errors.New(msg)
+		}
+		ann[k] = v
+	}
+
+	if c.cfg != nil && c.cfg.StrictAnnotations {
+		unknown := unknownAnnotations(ann)
+		if len(unknown) > 0 {
+			msg := "unrecognised annotations: " + strings.Join(unknown, ", ")
+			c.recordIngressEvent(ing, v1.EventTypeWarning, "UnknownAnnotation", msg)
+			return nil, errors.New(msg)
+		}
+	}
+
+	if err := c.applyOrg(ing, ann); err != nil {
+		return nil, err
+	}
+
+	return c.resolveAnnotationRefs(ing, ann)
+}
+
+// applyOrg turns the org annotation into the org_id of the definition once it is found
+// in the allow list, existing APIs keep the org they were created in
+func (c *ControlServer) applyOrg(ing *v1beta1.Ingress, ann map[string]string) error {
+	org, ok := ann[OrgAnnotation]
+	if !ok {
+		return nil
+	}
+
+	allowed := false
+	for _, o := range c.allowedOrgs() {
+		if o == org {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		msg := fmt.Sprintf("organisation %q is not in the allowed orgs", org)
+		c.recordIngressEvent(ing, v1.EventTypeWarning, "OrgNotAllowed", msg)
+		return errors.New(msg)
+	}
+
+	ann[string(processor.ValueSetStringKey)+"org_id"] = org
+	return nil
+}
+
+// allowedOrgs are the orgs an API of an ingress may be created in besides the org of the
+// template, the org of the class parameters is set by the cluster admin
+func (c *ControlServer) allowedOrgs() []string {
+	orgs := make([]string, 0)
+	if c.cfg != nil {
+		orgs = append(orgs, c.cfg.AllowedOrgs...)
+	}
+
+	if p := c.currentClassParams(); p != nil && p.OrgID != "" {
+		orgs = append(orgs, p.OrgID)
+	}
+
+	return orgs
+}
@@ -0,0 +1,52 @@
+package ingress
+
+import (
+	"errors"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// setSecurity applies the auth and upstream TLS annotations to the options, an API uses
+// one of JWT, OpenID Connect, basic auth or HMAC signatures. Client certificates can be
+// added to any of them. The rendered org is checked against the allowed orgs
+func (c *ControlServer) setSecurity(ing *v1beta1.Ingress, opts *tyk.APIDefOptions) error {
+	opts.AllowedOrgs = c.allowedOrgs()
+
+	var err error
+	opts.JWT, err = c.jwtAuth(ing, opts.Annotations)
+	if err != nil {
+		return err
+	}
+
+	opts.OpenID, err = openIDAuth(opts.Annotations)
+	if err != nil {
+		return err
+	}
+
+	opts.HMAC, err = hmacAuth(opts.Annotations)
+	if err != nil {
+		return err
+	}
+
+	opts.BasicAuth = basicAuthSecret(ing) != ""
+
+	methods := 0
+	for _, set := range []bool{opts.JWT != nil, opts.OpenID != nil, opts.BasicAuth, opts.HMAC != nil} {
+		if set {
+			methods++
+		}
+	}
+
+	if methods > 1 {
+		return errors.New("only one of the JWT, OpenID Connect, basic auth and HMAC annotations can be used")
+	}
+
+	opts.ClientCertificates, err = c.clientCertificates(ing)
+	if err != nil {
+		return err
+	}
+
+	opts.UpstreamPins, err = upstreamPins(opts.Annotations)
+	return err
+}
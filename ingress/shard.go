@@ -0,0 +1,95 @@
+package ingress
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// shard is the part of the ingresses a replica syncs, replicas run as a StatefulSet and
+// ingresses are assigned by the hash of their namespace/name
+type shard struct {
+	count   uint32
+	ordinal uint32
+}
+
+func (s *shard) owns(ns, name string) bool {
+	if s == nil {
+		return true
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(ns + "/" + name))
+	return h.Sum32()%s.count == s.ordinal
+}
+
+// podOrdinal reads the ordinal from a StatefulSet pod name, e.g. 2 for tyk-k8s-2
+func podOrdinal(hostname string) (int, error) {
+	i := strings.LastIndex(hostname, "-")
+	if i < 0 {
+		return 0, fmt.Errorf("%s is not a StatefulSet pod name", hostname)
+	}
+
+	ord, err := strconv.Atoi(hostname[i+1:])
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a StatefulSet pod name", hostname)
+	}
+
+	return ord, nil
+}
+
+func (c *ControlServer) configureShard() error {
+	c.shard = nil
+	if c.cfg == nil || c.cfg.Shards <= 1 {
+		return nil
+	}
+
+	// ingresses of a host are merged into one API, they can't be synced by several replicas
+	if c.mergeHostsEnabled() {
+		return errors.New("shards can't be used with mergeHosts")
+	}
+
+	ord := 0
+	if c.cfg.ShardOrdinal != nil {
+		ord = *c.cfg.ShardOrdinal
+	} else {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+
+		ord, err = podOrdinal(hostname)
+		if err != nil {
+			return fmt.Errorf("set shardOrdinal: %v", err)
+		}
+	}
+
+	if ord < 0 || ord >= c.cfg.Shards {
+		return fmt.Errorf("shard ordinal %d is outside of the %d shards", ord, c.cfg.Shards)
+	}
+
+	c.shard = &shard{count: uint32(c.cfg.Shards), ordinal: uint32(ord)}
+	log.Infof("syncing shard %d of %d", ord, c.cfg.Shards)
+	return nil
+}
+
+// ownsIngress tells if this replica syncs the ingress, checkIngressManaged alone is used
+// where ingresses of other shards matter too, e.g. for shared basic auth secrets
+func (c *ControlServer) ownsIngress(ing *v1beta1.Ingress) bool {
+	return c.checkIngressManaged(ing) && c.shard.owns(ing.Namespace, ing.Name)
+}
+
+// PrimaryShard tells if this replica is the first shard. The other controllers sync and
+// garbage collect their whole set on every run, so they only run on the first shard
+func (c *ControlServer) PrimaryShard() bool {
+	if c.cfg == nil || c.cfg.Shards <= 1 {
+		return true
+	}
+
+	return c.shard != nil && c.shard.ordinal == 0
+}
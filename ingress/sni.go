@@ -0,0 +1,78 @@
+package ingress
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// APIs of different hosts can share a listen path, the gateway tells them apart by the
+// domain and picks the certificate by SNI from the certificates bound to the API of the
+// host. Gateways need custom domains enabled for the domain to be matched
+
+// hostCertificate returns the certificate of a rule host, a TLS entry for the exact host
+// wins over a wildcard covering it
+func hostCertificate(certs map[string]string, host string) (string, bool) {
+	if id, ok := certs[host]; ok {
+		return id, true
+	}
+
+	wildcards := make([]string, 0)
+	for h := range certs {
+		if wildcardCovers(h, host) {
+			wildcards = append(wildcards, h)
+		}
+	}
+
+	if len(wildcards) == 0 {
+		return "", false
+	}
+
+	// the covering wildcard is unique, sorted anyway so a broken spec is stable
+	sort.Strings(wildcards)
+	return certs[wildcards[0]], true
+}
+
+// uncoveredHosts returns the rule hosts of an ingress with TLS entries that none of its
+// certificates cover, Tyk would answer them with the default gateway certificate
+func uncoveredHosts(ing *v1beta1.Ingress, certs map[string]string) []string {
+	if len(ing.Spec.TLS) == 0 {
+		return nil
+	}
+
+	missing := make([]string, 0)
+	seen := map[string]bool{}
+	for _, r0 := range ing.Spec.Rules {
+		if r0.HTTP == nil || r0.Host == "" || seen[r0.Host] {
+			continue
+		}
+		seen[r0.Host] = true
+
+		if _, ok := hostCertificate(certs, r0.Host); !ok {
+			missing = append(missing, r0.Host)
+		}
+	}
+
+	sort.Strings(missing)
+	return missing
+}
+
+// ingressCertificates uploads the TLS certificates of the ingress and warns about hosts
+// without one, the hosts are still synced so plain HTTP keeps working
+func (c *ControlServer) ingressCertificates(ing *v1beta1.Ingress) (map[string]string, error) {
+	certs, err := c.handleTLS(ing)
+	if err != nil {
+		c.recordIngressEvent(ing, v1.EventTypeWarning, "CertificateFailed", err.Error())
+		return nil, err
+	}
+
+	if missing := uncoveredHosts(ing, certs); len(missing) > 0 {
+		c.recordIngressEvent(ing, v1.EventTypeWarning, "MissingCertificate",
+			fmt.Sprintf("no TLS certificate covers %s", strings.Join(missing, ", ")))
+	}
+
+	return certs, nil
+}
@@ -0,0 +1,37 @@
+package ingress
+
+import (
+	"github.com/TykTechnologies/logrus"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// Every reconcile of an ingress gets a sync ID, it is logged, written into the APIs it
+// syncs and added to the events recorded on the ingress, also by the provisioners that
+// run after the sync
+const SyncIDAnnotation = "tyk.io/sync-id"
+
+func (c *ControlServer) beginSync(ing *v1beta1.Ingress) string {
+	id := tyk.NewSyncID()
+	c.syncIDs.Store(ing.Namespace+"/"+ing.Name, id)
+	return id
+}
+
+func (c *ControlServer) endSync(ing *v1beta1.Ingress) {
+	c.syncIDs.Delete(ing.Namespace + "/" + ing.Name)
+}
+
+// syncID returns the ID of the last reconcile of the ingress, empty before the first
+func (c *ControlServer) syncID(ing *v1beta1.Ingress) string {
+	id, _ := c.syncIDs.Load(ing.Namespace + "/" + ing.Name)
+	s, _ := id.(string)
+	return s
+}
+
+func (c *ControlServer) syncLog(ing *v1beta1.Ingress) *logrus.Entry {
+	if id := c.syncID(ing); id != "" {
+		return log.WithField("sync_id", id)
+	}
+
+	return log
+}
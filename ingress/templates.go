@@ -0,0 +1,205 @@
+package ingress
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The template is the first loaded one of: the template annotation of the ingress or a
+// rule, the "tyk.io/template" label of the ingress, the "tyk.io/template" annotation of
+// its namespace so a namespace can enforce a template, the conventions, the template of
+// the ingress class and the default template. Conventions are picked from the auth mode
+// and the "tyk.io/api-type" annotation, e.g. "graphql-jwt.json", then "jwt.json", then
+// "graphql.json", with the auth modes jwt, openid, basic-auth, hmac and mtls
+const (
+	TemplateLabel     = "tyk.io/template"
+	APITypeAnnotation = "tyk.io/api-type"
+)
+
+// authMode names the auth of the options for template conventions, empty for keyless and
+// the template's own auth
+func authMode(opts *tyk.APIDefOptions) string {
+	switch {
+	case opts.JWT != nil:
+		return "jwt"
+	case opts.OpenID != nil:
+		return "openid"
+	case opts.BasicAuth:
+		return "basic-auth"
+	case opts.HMAC != nil:
+		return "hmac"
+	case len(opts.ClientCertificates) > 0:
+		return "mtls"
+	}
+
+	return ""
+}
+
+// conventionTemplates lists the template names for the options, most specific first
+func conventionTemplates(opts *tyk.APIDefOptions) []string {
+	apiType := strings.ToLower(strings.TrimSpace(opts.Annotations[APITypeAnnotation]))
+	auth := authMode(opts)
+
+	names := make([]string, 0, 3)
+	if apiType != "" && auth != "" {
+		names = append(names, apiType+"-"+auth)
+	}
+
+	for _, n := range []string{auth, apiType} {
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+
+	return names
+}
+
+// namespaceTemplate returns the template annotation of the ingress namespace
+func (c *ControlServer) namespaceTemplate(ns string) string {
+	if c.client == nil {
+		return ""
+	}
+
+	n, err := c.client.CoreV1().Namespaces().Get(ns, v12.GetOptions{})
+	if err != nil {
+		log.Warning("could not fetch namespace ", ns, ": ", err)
+		return ""
+	}
+
+	return strings.TrimSpace(n.Annotations[TemplateLabel])
+}
+
+// requestedTemplate returns the template the ingress asks for, a template annotation that
+// only comes from the class defaults is the class template rather than a request
+func (c *ControlServer) requestedTemplate(ing *v1beta1.Ingress, opts *tyk.APIDefOptions, class string) string {
+	if v, ok := opts.Annotations[tyk.TemplateNameKey]; ok {
+		if _, own := ing.Annotations[tyk.TemplateNameKey]; own || v != class {
+			return checkAndGetTemplate(opts.Annotations)
+		}
+	}
+
+	return strings.TrimSpace(ing.Labels[TemplateLabel])
+}
+
+// templateChain lists the templates to try in order, the conventions are only listed when
+// they are loaded
+func (c *ControlServer) templateChain(ing *v1beta1.Ingress, opts *tyk.APIDefOptions) []string {
+	class := ""
+	if p := c.currentClassParams(); p != nil {
+		class = p.TemplateName
+	}
+
+	chain := make([]string, 0, 4)
+	add := func(n string) {
+		for _, e := range chain {
+			if e == n {
+				return
+			}
+		}
+		if n != "" {
+			chain = append(chain, n)
+		}
+	}
+
+	add(c.requestedTemplate(ing, opts, class))
+	add(c.namespaceTemplate(ing.Namespace))
+	for _, n := range conventionTemplates(opts) {
+		if name, ok := tyk.FindTemplate(n); ok {
+			add(name)
+			break
+		}
+	}
+	add(class)
+	add(tyk.DefaultTemplate)
+
+	return chain
+}
+
+// selectTemplate picks the template once the auth options are set, the ingress is told
+// when the template it, its namespace or its class asks for isn't loaded
+func (c *ControlServer) selectTemplate(ing *v1beta1.Ingress, opts *tyk.APIDefOptions) string {
+	chain := c.templateChain(ing, opts)
+	for _, n := range chain {
+		if !tyk.HasTemplate(n) {
+			continue
+		}
+
+		if n != chain[0] {
+			msg := fmt.Sprintf("template %s is not loaded, %s uses %s", chain[0], opts.Slug, n)
+			c.recordIngressEvent(ing, v1.EventTypeWarning, "TemplateFallback", msg)
+		}
+		return n
+	}
+
+	return tyk.DefaultTemplate
+}
+
+// templateSyncTimeout bounds the wait for the template config map, e.g. when RBAC denies
+// listing it
+var templateSyncTimeout = 30 * time.Second
+
+// watchTemplates loads the API templates from the configured ConfigMap and reloads them
+// when it changes, it waits for the first load so ingresses are never rendered with
+// templates that are not there yet. When the config map can't be read in time the file or
+// default templates stay loaded and the error is returned
+func (c *ControlServer) watchTemplates() error {
+	ns, name, err := parseConfigMapRef(c.cfg.TemplateConfigMap, "templateConfigMap")
+	if err != nil {
+		return err
+	}
+
+	log.Info("Watching for template changes in config map ", c.cfg.TemplateConfigMap)
+	watchList := cache.NewListWatchFromClient(c.client.CoreV1().RESTClient(), "configmaps", ns,
+		fields.OneTermEqualSelector("metadata.name", name))
+	_, ctrl := cache.NewInformer(
+		watchList,
+		&v1.ConfigMap{},
+		time.Minute,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: c.handleTemplatesUpdate,
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				c.handleTemplatesUpdate(newObj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				log.Warning("template config map removed, keeping the last loaded templates")
+			},
+		},
+	)
+
+	c.tplStopCh = make(chan struct{})
+	go ctrl.Run(c.tplStopCh)
+
+	wait := make(chan struct{})
+	timer := time.AfterFunc(templateSyncTimeout, func() { close(wait) })
+	defer timer.Stop()
+
+	if !cache.WaitForCacheSync(wait, ctrl.HasSynced) {
+		close(c.tplStopCh)
+		c.tplStopCh = nil
+		return fmt.Errorf("failed to sync template config map %s within %v", c.cfg.TemplateConfigMap, templateSyncTimeout)
+	}
+
+	return nil
+}
+
+func (c *ControlServer) handleTemplatesUpdate(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok {
+		log.Errorf("type not allowed for template watcher: %v", reflect.TypeOf(obj))
+		return
+	}
+
+	err := tyk.LoadTemplates(cm.Data)
+	if err != nil {
+		log.Error("failed to load templates, keeping the previous ones: ", err)
+	}
+}
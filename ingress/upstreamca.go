@@ -0,0 +1,33 @@
+package ingress
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+)
+
+// The upstream of an ingress is verified against a CA bundle with
+// "tyk.io/upstream-ca": "configMapKeyRef:<name>/<key>" (or secretKeyRef), the public keys
+// of the bundle are pinned so the upstream has to present a chain containing one of them
+const UpstreamCAAnnotation = "tyk.io/upstream-ca"
+
+// upstreamPins returns the pinned fingerprints of the CA bundle in the effective
+// annotations, references are already resolved to the PEM bundle
+func upstreamPins(ann map[string]string) ([]string, error) {
+	bundle, ok := ann[UpstreamCAAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	if strings.TrimSpace(bundle) == "" {
+		return nil, fmt.Errorf("%s is empty", UpstreamCAAnnotation)
+	}
+
+	pins, err := tyk.PublicKeyFingerprints([]byte(bundle))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", UpstreamCAAnnotation, err)
+	}
+
+	return pins, nil
+}
@@ -0,0 +1,82 @@
+package ingress
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TemplateValuesLabel opts a ConfigMap or Secret in to being read by templates, objects
+// without "tyk.io/template-values": "true" are never read so templates can't reach
+// unrelated credentials
+const TemplateValuesLabel = "tyk.io/template-values"
+
+// TemplateValues enables the configMapValue and secretValue template functions, values
+// are read from the namespace of the source being rendered
+type TemplateValues struct {
+	ConfigMaps bool `yaml:"configMaps"`
+	Secrets    bool `yaml:"secrets"`
+	// Namespaces are globs of the namespaces values may be read in, empty allows all
+	Namespaces []string `yaml:"namespaces"`
+}
+
+func (v *TemplateValues) allows(kind, ns string) error {
+	if (kind == tyk.ConfigMapValues && !v.ConfigMaps) || (kind == tyk.SecretValues && !v.Secrets) {
+		return fmt.Errorf("templates may not read %s values", kind)
+	}
+
+	if len(v.Namespaces) == 0 {
+		return nil
+	}
+
+	for _, g := range v.Namespaces {
+		if ok, err := path.Match(g, ns); err == nil && ok {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("templates may not read values in namespace %s", ns)
+}
+
+// templateValue reads a key of a labelled ConfigMap or Secret for the template functions
+func (c *ControlServer) templateValue(kind, ns, name, key string) (string, error) {
+	if err := c.cfg.TemplateValues.allows(kind, ns); err != nil {
+		return "", err
+	}
+
+	var labels map[string]string
+	var value []byte
+	found := false
+	switch kind {
+	case tyk.ConfigMapValues:
+		cm, err := c.client.CoreV1().ConfigMaps(ns).Get(name, v12.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		labels = cm.Labels
+		if v, ok := cm.Data[key]; ok {
+			value, found = []byte(v), true
+		}
+	case tyk.SecretValues:
+		sec, err := c.client.CoreV1().Secrets(ns).Get(name, v12.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		labels = sec.Labels
+		value, found = sec.Data[key]
+	default:
+		return "", fmt.Errorf("unknown value kind %s", kind)
+	}
+
+	if labels[TemplateValuesLabel] != "true" {
+		return "", fmt.Errorf("%s %s/%s is not labelled %s", kind, ns, name, TemplateValuesLabel)
+	}
+
+	if !found {
+		return "", fmt.Errorf("key %s not found in %s %s/%s", key, kind, ns, name)
+	}
+
+	return string(value), nil
+}
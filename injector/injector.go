@@ -52,6 +52,20 @@ type Config struct {
 	Containers     []corev1.Container `yaml:"containers"`
 	InitContainers []corev1.Container `yaml:"initContainers"`
 	CreateRoutes   bool               `yaml:"createRoutes"`
+	// FailurePolicy decides what happens to a pod when its routes can't be created,
+	// "Ignore" (the default) admits it without the sidecar and "Fail" rejects it
+	FailurePolicy string `yaml:"failurePolicy"`
+}
+
+const (
+	FailurePolicyIgnore = "Ignore"
+	FailurePolicyFail   = "Fail"
+)
+
+// failOpen tells if pods are admitted unchanged when the Tyk API can't create their
+// routes, so a broken control plane doesn't stop pods from being scheduled
+func (c *Config) failOpen() bool {
+	return c == nil || !strings.EqualFold(c.FailurePolicy, FailurePolicyFail)
 }
 
 type namedThing struct {
@@ -358,6 +372,13 @@ func (whsvr *WebhookServer) processPodMutations(ar *v1beta1.AdmissionReview) *v1
 	if whsvr.SidecarConfig.CreateRoutes {
 		var err error
 		annotations, err = createServiceRoutes(&pod, annotations, ar.Request.Namespace)
+		if err != nil && whsvr.SidecarConfig.failOpen() {
+			log.Warningf("routes for %s/%s not created, admitting it without the sidecar: %v", ar.Request.Namespace, pod.Name, err)
+			return &v1beta1.AdmissionResponse{
+				Allowed: true,
+			}
+		}
+
 		if err != nil {
 			return &v1beta1.AdmissionResponse{
 				Result: &metav1.Status{
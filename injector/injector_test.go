@@ -8,10 +8,24 @@ import (
 	"github.com/TykTechnologies/tykctl/api/_test_util"
 	"github.com/ghodss/yaml"
 	"io/ioutil"
+	"net"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
+// waitForServer blocks until the mock accepts connections, it is started asynchronously
+func waitForServer(addr string) {
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestWebhookServer_Serve(t *testing.T) {
 	cfg := &Config{}
 	if err := yaml.Unmarshal([]byte(testCfg), cfg); err != nil {
@@ -29,6 +43,7 @@ func TestWebhookServer_Serve(t *testing.T) {
 	svr := _test_util.DashServerMock{}
 	svr.Start(":8989")
 	defer svr.Stop()
+	waitForServer("localhost:8989")
 
 	scenarios := []struct {
 		Payload      string
@@ -118,6 +133,40 @@ func TestWebhookServer_Serve(t *testing.T) {
 	}
 }
 
+func TestFailurePolicy(t *testing.T) {
+	// nothing listens on the port, route creation fails
+	tyk.Init(&tyk.TykConf{URL: "http://127.0.0.1:1", Secret: "foo", Org: "1"})
+
+	for _, sc := range []struct {
+		policy  string
+		allowed bool
+	}{
+		{"", true},
+		{FailurePolicyIgnore, true},
+		{FailurePolicyFail, false},
+	} {
+		whs := WebhookServer{SidecarConfig: &Config{CreateRoutes: true, FailurePolicy: sc.policy}}
+		req := httptest.NewRequest("POST", "http://localhost:9797/inject", bytes.NewReader([]byte(AdmissionReviewJson)))
+		req.Header.Add("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		whs.Serve(rec, req)
+
+		resp := struct {
+			Response struct {
+				Allowed bool   `json:"allowed"`
+				Patch   string `json:"patch"`
+			} `json:"response"`
+		}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Response.Allowed != sc.allowed || resp.Response.Patch != "" {
+			t.Fatalf("policy %q: expected allowed %v without a patch, got %s", sc.policy, sc.allowed, rec.Body.String())
+		}
+	}
+}
+
 var testCfg = `
 containers:
 - name: sidecar-nginx
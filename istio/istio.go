@@ -0,0 +1,427 @@
+package istio
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"github.com/ghodss/yaml"
+)
+
+// The conversion works on exported manifests rather than the cluster, so migrations can be
+// reviewed before anything is pushed to Tyk. Only the parts of the networking.istio.io
+// resources that have a Tyk equivalent are read
+
+const (
+	kindVirtualService  = "VirtualService"
+	kindDestinationRule = "DestinationRule"
+
+	// ImportTag is added to every converted API
+	ImportTag   = "istio-import"
+	defaultPort = 80
+)
+
+type meta struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+type StringMatch struct {
+	Exact  string `json:"exact,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	Regex  string `json:"regex,omitempty"`
+}
+
+type HTTPMatchRequest struct {
+	URI *StringMatch `json:"uri,omitempty"`
+}
+
+type Destination struct {
+	Host   string `json:"host"`
+	Subset string `json:"subset,omitempty"`
+	Port   *struct {
+		Number int32 `json:"number"`
+	} `json:"port,omitempty"`
+}
+
+type HeaderOperations struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Add    map[string]string `json:"add,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+type Headers struct {
+	Request *HeaderOperations `json:"request,omitempty"`
+}
+
+type HTTPRouteDestination struct {
+	Destination Destination `json:"destination"`
+	Weight      int32       `json:"weight,omitempty"`
+}
+
+type HTTPRedirect struct {
+	URI          string `json:"uri,omitempty"`
+	Authority    string `json:"authority,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	RedirectCode int    `json:"redirectCode,omitempty"`
+}
+
+type HTTPRewrite struct {
+	URI       string `json:"uri,omitempty"`
+	Authority string `json:"authority,omitempty"`
+}
+
+type HTTPRetry struct {
+	Attempts int `json:"attempts"`
+}
+
+type HTTPRoute struct {
+	Name     string                 `json:"name,omitempty"`
+	Match    []HTTPMatchRequest     `json:"match,omitempty"`
+	Route    []HTTPRouteDestination `json:"route,omitempty"`
+	Redirect *HTTPRedirect          `json:"redirect,omitempty"`
+	Rewrite  *HTTPRewrite           `json:"rewrite,omitempty"`
+	Timeout  string                 `json:"timeout,omitempty"`
+	Retries  *HTTPRetry             `json:"retries,omitempty"`
+	Headers  *Headers               `json:"headers,omitempty"`
+}
+
+type VirtualService struct {
+	Metadata meta `json:"metadata"`
+	Spec     struct {
+		Hosts []string    `json:"hosts"`
+		HTTP  []HTTPRoute `json:"http"`
+	} `json:"spec"`
+}
+
+type OutlierDetection struct {
+	ConsecutiveErrors    int64  `json:"consecutiveErrors,omitempty"`
+	Consecutive5xxErrors int64  `json:"consecutive5xxErrors,omitempty"`
+	BaseEjectionTime     string `json:"baseEjectionTime,omitempty"`
+}
+
+type TrafficPolicy struct {
+	OutlierDetection *OutlierDetection `json:"outlierDetection,omitempty"`
+	TLS              *struct {
+		Mode string `json:"mode"`
+	} `json:"tls,omitempty"`
+}
+
+type DestinationRule struct {
+	Metadata meta `json:"metadata"`
+	Spec     struct {
+		Host          string         `json:"host"`
+		TrafficPolicy *TrafficPolicy `json:"trafficPolicy,omitempty"`
+	} `json:"spec"`
+}
+
+// Resources are the Istio objects read from a set of manifests
+type Resources struct {
+	VirtualServices  []VirtualService
+	DestinationRules []DestinationRule
+}
+
+// Parse reads multi-document YAML or JSON manifests, objects of other kinds are skipped
+func Parse(data []byte) (*Resources, error) {
+	res := &Resources{}
+	for i, doc := range bytes.Split(data, []byte("\n---")) {
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		head := struct {
+			Kind string `json:"kind"`
+		}{}
+		if err := yaml.Unmarshal(doc, &head); err != nil {
+			return nil, fmt.Errorf("document %d: %v", i, err)
+		}
+
+		var err error
+		switch head.Kind {
+		case kindVirtualService:
+			vs := VirtualService{}
+			err = yaml.Unmarshal(doc, &vs)
+			res.VirtualServices = append(res.VirtualServices, vs)
+		case kindDestinationRule:
+			dr := DestinationRule{}
+			err = yaml.Unmarshal(doc, &dr)
+			res.DestinationRules = append(res.DestinationRules, dr)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", i, err)
+		}
+	}
+
+	return res, nil
+}
+
+func namespace(m meta) string {
+	if m.Namespace == "" {
+		return "default"
+	}
+	return m.Namespace
+}
+
+// serviceHost qualifies short Istio host names with the namespace of the resource
+func serviceHost(host, ns string) string {
+	if strings.Contains(host, ".") {
+		return host
+	}
+	return host + "." + ns
+}
+
+// Converter turns Istio resources into Tyk API options, everything that could not be
+// carried over is reported as a warning instead of failing the import
+type Converter struct {
+	res      *Resources
+	Warnings []string
+}
+
+func NewConverter(res *Resources) *Converter {
+	return &Converter{res: res}
+}
+
+func (c *Converter) warn(vs *VirtualService, format string, args ...interface{}) {
+	w := fmt.Sprintf("%s/%s: ", namespace(vs.Metadata), vs.Metadata.Name) + fmt.Sprintf(format, args...)
+	for _, e := range c.Warnings {
+		if e == w {
+			return
+		}
+	}
+	c.Warnings = append(c.Warnings, w)
+}
+
+func (c *Converter) destinationRule(host, ns string) *DestinationRule {
+	for i, dr := range c.res.DestinationRules {
+		if serviceHost(dr.Spec.Host, namespace(dr.Metadata)) == serviceHost(host, ns) {
+			return &c.res.DestinationRules[i]
+		}
+	}
+	return nil
+}
+
+func seconds(d string) (int, error) {
+	dur, err := time.ParseDuration(d)
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Ceil(dur.Seconds())), nil
+}
+
+func (c *Converter) target(vs *VirtualService, d Destination) string {
+	ns := namespace(vs.Metadata)
+	port := int32(defaultPort)
+	if d.Port != nil && d.Port.Number != 0 {
+		port = d.Port.Number
+	}
+
+	scheme := "http"
+	if dr := c.destinationRule(d.Host, ns); dr != nil && dr.Spec.TrafficPolicy != nil && dr.Spec.TrafficPolicy.TLS != nil {
+		switch dr.Spec.TrafficPolicy.TLS.Mode {
+		case "SIMPLE":
+			scheme = "https"
+		case "MUTUAL", "ISTIO_MUTUAL":
+			c.warn(vs, "mTLS to %s is not converted, the upstream certificate must be configured in Tyk", d.Host)
+		}
+	}
+
+	if d.Subset != "" {
+		c.warn(vs, "subset %s of %s is not converted, traffic goes to the whole service", d.Subset, d.Host)
+	}
+
+	return fmt.Sprintf("%s://%s:%d", scheme, serviceHost(d.Host, ns), port)
+}
+
+// circuitBreaker approximates outlier detection, ejecting after n consecutive errors is
+// a breaker that trips when all of the last n samples failed
+func (c *Converter) circuitBreaker(vs *VirtualService, host string) *tyk.CircuitBreaker {
+	dr := c.destinationRule(host, namespace(vs.Metadata))
+	if dr == nil || dr.Spec.TrafficPolicy == nil || dr.Spec.TrafficPolicy.OutlierDetection == nil {
+		return nil
+	}
+
+	od := dr.Spec.TrafficPolicy.OutlierDetection
+	samples := od.Consecutive5xxErrors
+	if samples == 0 {
+		samples = od.ConsecutiveErrors
+	}
+	if samples == 0 {
+		samples = 5
+	}
+
+	after := 30
+	if od.BaseEjectionTime != "" {
+		s, err := seconds(od.BaseEjectionTime)
+		if err != nil {
+			c.warn(vs, "invalid baseEjectionTime %s: %v", od.BaseEjectionTime, err)
+		} else {
+			after = s
+		}
+	}
+
+	return &tyk.CircuitBreaker{ThresholdPercent: 1, Samples: samples, ReturnToServiceAfter: after}
+}
+
+func (c *Converter) filters(vs *VirtualService, r HTTPRoute, host string) *tyk.RequestFilters {
+	f := &tyk.RequestFilters{SetHeaders: map[string]string{}}
+	if r.Headers != nil && r.Headers.Request != nil {
+		for k, v := range r.Headers.Request.Add {
+			f.SetHeaders[k] = v
+		}
+		for k, v := range r.Headers.Request.Set {
+			f.SetHeaders[k] = v
+		}
+		f.RemoveHeaders = r.Headers.Request.Remove
+	}
+
+	if r.Rewrite != nil {
+		if r.Rewrite.Authority != "" {
+			f.SetHeaders["Host"] = r.Rewrite.Authority
+		}
+		f.RewritePath, f.RewritePrefix = r.Rewrite.URI, true
+	}
+
+	if r.Redirect != nil {
+		authority := r.Redirect.Authority
+		if authority == "" {
+			authority = host
+		}
+		if authority == "" || strings.HasPrefix(authority, "*") {
+			c.warn(vs, "redirect needs an authority for wildcard hosts, skipped")
+		} else {
+			f.RedirectTo = fmt.Sprintf("%s://%s/%s", defaultScheme(r.Redirect.Scheme), authority,
+				strings.TrimLeft(r.Redirect.URI, "/"))
+			f.RedirectCode = r.Redirect.RedirectCode
+			if f.RedirectCode == 0 {
+				f.RedirectCode = 301
+			}
+		}
+	}
+
+	if r.Timeout != "" {
+		s, err := seconds(r.Timeout)
+		if err != nil {
+			c.warn(vs, "invalid timeout %s: %v", r.Timeout, err)
+		} else {
+			f.TimeoutSeconds = s
+		}
+	}
+
+	if r.Retries != nil && r.Retries.Attempts > 0 {
+		c.warn(vs, "retries are not supported by the gateway and were dropped")
+	}
+
+	if len(r.Route) > 0 {
+		f.CircuitBreaker = c.circuitBreaker(vs, r.Route[0].Destination.Host)
+	}
+
+	return f
+}
+
+func defaultScheme(s string) string {
+	if s == "" {
+		return "http"
+	}
+	return s
+}
+
+func hostToDomain(host string) string {
+	switch {
+	case host == "*":
+		return ""
+	case strings.HasPrefix(host, "*."):
+		return "{subdomain:.+}" + host[1:]
+	}
+	return host
+}
+
+func match(m HTTPMatchRequest) (string, string) {
+	switch {
+	case m.URI == nil:
+		return "/", tyk.PathTypePrefix
+	case m.URI.Exact != "":
+		return m.URI.Exact, tyk.PathTypeExact
+	case m.URI.Regex != "":
+		return "/" + strings.TrimPrefix(m.URI.Regex, "/"), tyk.PathTypeRegex
+	case m.URI.Prefix != "":
+		return m.URI.Prefix, tyk.PathTypePrefix
+	}
+	return "/", tyk.PathTypePrefix
+}
+
+func slug(vs *VirtualService, host string, route, m int) string {
+	hasher := sha1.New()
+	hasher.Write([]byte(fmt.Sprintf("istio:%s/%s:%s:%d:%d", namespace(vs.Metadata), vs.Metadata.Name, host, route, m)))
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
+// Convert builds one API per host and match of every HTTP route, the APIs are returned
+// ordered by slug so the output is stable
+func (c *Converter) Convert() []*tyk.APIDefOptions {
+	apis := make([]*tyk.APIDefOptions, 0)
+	for i := range c.res.VirtualServices {
+		vs := &c.res.VirtualServices[i]
+		for ri, r := range vs.Spec.HTTP {
+			if len(r.Route) == 0 && r.Redirect == nil {
+				c.warn(vs, "http route %d has no destination, skipped", ri)
+				continue
+			}
+
+			targets := make([]string, 0)
+			weights := make([]int32, 0)
+			for _, d := range r.Route {
+				w := d.Weight
+				if w == 0 && len(r.Route) == 1 {
+					w = 100
+				}
+				if w <= 0 {
+					continue
+				}
+				targets = append(targets, c.target(vs, d.Destination))
+				weights = append(weights, w)
+			}
+			targets = tyk.WeightedTargets(targets, weights)
+			if len(targets) == 0 {
+				targets = []string{"http://127.0.0.1"}
+			}
+
+			matches := r.Match
+			if len(matches) == 0 {
+				matches = []HTTPMatchRequest{{}}
+			}
+
+			for _, host := range vs.Spec.Hosts {
+				filters := c.filters(vs, r, host)
+				for mi, m := range matches {
+					lp, pt := match(m)
+					apis = append(apis, &tyk.APIDefOptions{
+						Name:         fmt.Sprintf("%s:%s", vs.Metadata.Name, namespace(vs.Metadata)),
+						Slug:         slug(vs, host, ri, mi),
+						ListenPath:   lp,
+						PathType:     pt,
+						Hostname:     hostToDomain(host),
+						Target:       targets[0],
+						TargetList:   targets,
+						TemplateName: tyk.DefaultTemplate,
+						Tags:         []string{ImportTag},
+						Filters:      filters,
+						Source: &tyk.SourceMeta{
+							Kind:      kindVirtualService,
+							Namespace: namespace(vs.Metadata),
+							Name:      vs.Metadata.Name,
+						},
+					})
+				}
+			}
+		}
+	}
+
+	sort.Slice(apis, func(i, j int) bool { return apis[i].Slug < apis[j].Slug })
+	return apis
+}
@@ -0,0 +1,111 @@
+package istio
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+)
+
+const manifests = `apiVersion: networking.istio.io/v1beta1
+kind: VirtualService
+metadata:
+  name: reviews
+  namespace: shop
+spec:
+  hosts:
+  - reviews.example.com
+  http:
+  - match:
+    - uri:
+        prefix: /v1
+    rewrite:
+      uri: /
+    timeout: 1500ms
+    retries:
+      attempts: 3
+    route:
+    - destination:
+        host: reviews
+        subset: v1
+      weight: 80
+    - destination:
+        host: reviews-canary
+        port:
+          number: 9080
+      weight: 20
+  - match:
+    - uri:
+        exact: /old
+    redirect:
+      uri: /new
+---
+apiVersion: networking.istio.io/v1beta1
+kind: DestinationRule
+metadata:
+  name: reviews
+  namespace: shop
+spec:
+  host: reviews.shop
+  trafficPolicy:
+    tls:
+      mode: SIMPLE
+    outlierDetection:
+      consecutive5xxErrors: 7
+      baseEjectionTime: 1m
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: ignored
+`
+
+func TestConvert(t *testing.T) {
+	res, err := Parse([]byte(manifests))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.VirtualServices) != 1 || len(res.DestinationRules) != 1 {
+		t.Fatal("unexpected resources: ", res)
+	}
+
+	conv := NewConverter(res)
+	apis := conv.Convert()
+	if len(apis) != 2 {
+		t.Fatal("expected an API per match, got ", len(apis))
+	}
+
+	var prefix, exact *tyk.APIDefOptions
+	for _, a := range apis {
+		if a.ListenPath == "/v1" {
+			prefix = a
+		} else {
+			exact = a
+		}
+	}
+
+	if prefix.Hostname != "reviews.example.com" || prefix.PathType != tyk.PathTypePrefix {
+		t.Fatal("unexpected api: ", prefix.Hostname, prefix.PathType)
+	}
+	if strings.Join(prefix.TargetList, ",") != "https://reviews.shop:80,https://reviews.shop:80,https://reviews.shop:80,https://reviews.shop:80,http://reviews-canary.shop:9080" {
+		t.Fatal("unexpected targets: ", prefix.TargetList)
+	}
+
+	f := prefix.Filters
+	if f.RewritePath != "/" || !f.RewritePrefix || f.TimeoutSeconds != 2 {
+		t.Fatal("unexpected filters: ", f)
+	}
+	if f.CircuitBreaker == nil || f.CircuitBreaker.Samples != 7 || f.CircuitBreaker.ReturnToServiceAfter != 60 {
+		t.Fatal("outlier detection should become a circuit breaker, got ", f.CircuitBreaker)
+	}
+
+	if exact.PathType != tyk.PathTypeExact || exact.Filters.RedirectTo != "http://reviews.example.com/new" ||
+		exact.Filters.RedirectCode != 301 {
+		t.Fatal("unexpected redirect: ", exact.PathType, exact.Filters.RedirectTo)
+	}
+
+	warnings := strings.Join(conv.Warnings, "\n")
+	if !strings.Contains(warnings, "retries") || !strings.Contains(warnings, "subset v1") {
+		t.Fatal("dropped features should be reported, got ", conv.Warnings)
+	}
+}
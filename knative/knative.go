@@ -0,0 +1,361 @@
+package knative
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/logger"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	routesPath = "/apis/serving.knative.dev/v1/routes"
+
+	// VisibilityLabel marks Knative routes that must stay inside the cluster
+	VisibilityLabel   = "networking.knative.dev/visibility"
+	visibilityPrivate = "cluster-local"
+
+	knativeTag = "knative"
+	revPort    = 80
+
+	// defaultResync is how often unchanged APIs are synced again
+	defaultResync = 5 * time.Minute
+)
+
+var log = logger.GetLogger("knative")
+var ctrl *Controller
+
+// Config for the Knative integration
+type Config struct {
+	// Enabled turns the integration on, Knative Serving must be installed
+	Enabled     bool `yaml:"enabled"`
+	SyncSeconds int  `yaml:"syncSeconds"`
+	// ResyncSeconds syncs the APIs even when the resources didn't change, so edits and
+	// deletes made in Tyk are reverted, defaults to 5 minutes
+	ResyncSeconds int `yaml:"resyncSeconds"`
+}
+
+// The vendored client has no Knative types, these are the parts of a serving.knative.dev
+// Route we read. Knative Services own a Route of the same name so watching Routes covers
+// both
+
+type TrafficTarget struct {
+	RevisionName string `json:"revisionName"`
+	Percent      *int32 `json:"percent,omitempty"`
+	Tag          string `json:"tag,omitempty"`
+	URL          string `json:"url,omitempty"`
+}
+
+type Condition struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+type Route struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Status struct {
+		URL        string          `json:"url"`
+		Traffic    []TrafficTarget `json:"traffic"`
+		Conditions []Condition     `json:"conditions"`
+	} `json:"status"`
+}
+
+type routeList struct {
+	Items []Route `json:"items"`
+}
+
+// Controller exposes Knative routes through Tyk, routes are listed on an interval
+type Controller struct {
+	cfg    *Config
+	client *kubernetes.Clientset
+	stopCh chan struct{}
+
+	mu         sync.Mutex
+	lastSync   string
+	lastSyncAt time.Time
+	// known are the APIs of the routes when they were last ready, by namespace/name
+	known map[string]map[string]*tyk.APIDefOptions
+}
+
+func NewController() *Controller {
+	if ctrl == nil {
+		ctrl = &Controller{}
+	}
+
+	return ctrl
+}
+
+func GetController() *Controller {
+	return NewController()
+}
+
+func (c *Controller) Config(cfg *Config) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	c.cfg = cfg
+}
+
+func (c *Controller) getClient() (*kubernetes.Clientset, error) {
+	cfgF := os.Getenv("TYK_K8S_KUBECONF")
+	var config *rest.Config
+	var err error
+
+	if cfgF != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", cfgF)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+func (c *Controller) Start() error {
+	if c.cfg == nil || !c.cfg.Enabled {
+		return nil
+	}
+
+	var err error
+	c.client, err = c.getClient()
+	if err != nil {
+		return err
+	}
+
+	interval := 15 * time.Second
+	if c.cfg.SyncSeconds > 0 {
+		interval = time.Duration(c.cfg.SyncSeconds) * time.Second
+	}
+
+	log.Info("Watching Knative routes")
+	c.stopCh = make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			if err := c.reconcile(); err != nil {
+				log.Error("knative reconcile failed: ", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (c *Controller) Stop() error {
+	if c.stopCh == nil {
+		return nil
+	}
+
+	close(c.stopCh)
+	c.stopCh = nil
+	return nil
+}
+
+func (c *Controller) reconcile() error {
+	raw, err := c.client.CoreV1().RESTClient().Get().AbsPath(routesPath).DoRaw()
+	if err != nil {
+		return fmt.Errorf("failed to list knative routes: %v", err)
+	}
+
+	rts := &routeList{}
+	if err := json.Unmarshal(raw, rts); err != nil {
+		return err
+	}
+
+	return c.sync(c.routesAPIs(rts.Items))
+}
+
+// routesAPIs builds the APIs of the listed routes. Routes that aren't ready, e.g. while a
+// new revision rolls out, keep the APIs they had when they were last ready, so only routes
+// that were deleted lose their APIs. The slugs of routes that weren't ready since the
+// controller started are returned to be kept as they are in Tyk
+func (c *Controller) routesAPIs(rts []Route) (map[string]*tyk.APIDefOptions, map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	known := map[string]map[string]*tyk.APIDefOptions{}
+	apis := map[string]*tyk.APIDefOptions{}
+	keep := map[string]bool{}
+	for i := range rts {
+		rt := &rts[i]
+		key := rt.Metadata.Namespace + "/" + rt.Metadata.Name
+
+		rtAPIs := routeAPIs(rt)
+		if !ready(rt) && rt.Metadata.Labels[VisibilityLabel] != visibilityPrivate {
+			rtAPIs = c.known[key]
+			if rtAPIs == nil {
+				for _, slug := range routeSlugs(rt) {
+					keep[slug] = true
+				}
+			}
+		}
+		if rtAPIs == nil {
+			continue
+		}
+
+		known[key] = rtAPIs
+		for slug, o := range rtAPIs {
+			apis[slug] = o
+		}
+	}
+
+	c.known = known
+	return apis, keep
+}
+
+// routeSlugs are the slugs the APIs of the route can have
+func routeSlugs(rt *Route) []string {
+	slugs := []string{routeSlug(rt, "")}
+	for _, t := range rt.Status.Traffic {
+		if t.Tag != "" {
+			slugs = append(slugs, routeSlug(rt, t.Tag))
+		}
+	}
+	return slugs
+}
+
+// sync only pushes the APIs when they changed since the last interval, the options are
+// fingerprinted first as UpdateAPIs fills in the existing definitions
+func (c *Controller) sync(apis map[string]*tyk.APIDefOptions, keep map[string]bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	raw, err := json.Marshal([]interface{}{apis, keep})
+	if err != nil {
+		return err
+	}
+	if string(raw) == c.lastSync && time.Since(c.lastSyncAt) < c.resyncInterval() {
+		return nil
+	}
+
+	if err := tyk.SyncByTagKeep(knativeTag, apis, keep); err != nil {
+		return err
+	}
+
+	c.lastSync = string(raw)
+	c.lastSyncAt = time.Now()
+	return nil
+}
+
+func (c *Controller) resyncInterval() time.Duration {
+	if c.cfg != nil && c.cfg.ResyncSeconds > 0 {
+		return time.Duration(c.cfg.ResyncSeconds) * time.Second
+	}
+	return defaultResync
+}
+
+func ready(rt *Route) bool {
+	for _, c := range rt.Status.Conditions {
+		if c.Type == "Ready" {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
+
+func hostOf(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return pu.Hostname()
+}
+
+func routeSlug(rt *Route, tag string) string {
+	hasher := sha1.New()
+	hasher.Write([]byte(fmt.Sprintf("knative:%s/%s:%s", rt.Metadata.Namespace, rt.Metadata.Name, tag)))
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
+// revisionTarget is the Service Knative creates for every revision, it goes through the
+// activator when the revision is scaled to zero
+func revisionTarget(rt *Route, revision string) string {
+	return fmt.Sprintf("http://%s.%s:%d", revision, rt.Metadata.Namespace, revPort)
+}
+
+func (rt *Route) options(slug, name, host string, targets []string) *tyk.APIDefOptions {
+	tpl := rt.Metadata.Annotations[tyk.TemplateNameKey]
+	if tpl == "" {
+		tpl = tyk.DefaultTemplate
+	}
+
+	return &tyk.APIDefOptions{
+		Name:         name,
+		Slug:         slug,
+		ListenPath:   "/",
+		Hostname:     host,
+		Target:       targets[0],
+		TargetList:   targets,
+		TemplateName: tpl,
+		Tags:         []string{knativeTag},
+		Annotations:  rt.Metadata.Annotations,
+		Source: &tyk.SourceMeta{
+			Kind:        "Route",
+			Namespace:   rt.Metadata.Namespace,
+			Name:        rt.Metadata.Name,
+			Labels:      rt.Metadata.Labels,
+			Annotations: rt.Metadata.Annotations,
+		},
+	}
+}
+
+// routeAPIs builds an API for the route URL that splits traffic over the revisions by
+// percent, and one API per tag that pins the tag URL to its revision
+func routeAPIs(rt *Route) map[string]*tyk.APIDefOptions {
+	apis := map[string]*tyk.APIDefOptions{}
+	if !ready(rt) || rt.Metadata.Labels[VisibilityLabel] == visibilityPrivate {
+		return apis
+	}
+
+	name := fmt.Sprintf("%s:%s", rt.Metadata.Name, rt.Metadata.Namespace)
+	targets := make([]string, 0)
+	weights := make([]int32, 0)
+	for _, t := range rt.Status.Traffic {
+		if t.RevisionName == "" {
+			continue
+		}
+
+		if t.Tag != "" {
+			if host := hostOf(t.URL); host != "" {
+				apis[routeSlug(rt, t.Tag)] = rt.options(routeSlug(rt, t.Tag), name+":"+t.Tag, host,
+					[]string{revisionTarget(rt, t.RevisionName)})
+			}
+		}
+
+		if t.Percent != nil && *t.Percent > 0 {
+			targets = append(targets, revisionTarget(rt, t.RevisionName))
+			weights = append(weights, *t.Percent)
+		}
+	}
+
+	host := hostOf(rt.Status.URL)
+	if host != "" && len(targets) > 0 {
+		apis[routeSlug(rt, "")] = rt.options(routeSlug(rt, ""), name, host, tyk.WeightedTargets(targets, weights))
+	}
+
+	return apis
+}
@@ -0,0 +1,86 @@
+package knative
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const fixtureRoutes = `{"items": [
+	{"metadata": {"name": "hello", "namespace": "apps", "annotations": {"bool.service.tyk.io/use_keyless": "false"}},
+	 "status": {"url": "http://hello.apps.example.com",
+		"conditions": [{"type": "Ready", "status": "True"}],
+		"traffic": [
+			{"revisionName": "hello-00002", "percent": 75, "tag": "current", "url": "http://current-hello.apps.example.com"},
+			{"revisionName": "hello-00001", "percent": 25},
+			{"revisionName": "hello-00003", "percent": 0, "tag": "next", "url": "http://next-hello.apps.example.com"}
+		]}},
+	{"metadata": {"name": "internal", "namespace": "apps", "labels": {"networking.knative.dev/visibility": "cluster-local"}},
+	 "status": {"url": "http://internal.apps.svc.cluster.local", "conditions": [{"type": "Ready", "status": "True"}],
+		"traffic": [{"revisionName": "internal-00001", "percent": 100}]}},
+	{"metadata": {"name": "starting", "namespace": "apps"},
+	 "status": {"url": "http://starting.apps.example.com", "conditions": [{"type": "Ready", "status": "Unknown"}],
+		"traffic": [{"revisionName": "starting-00001", "percent": 100}]}}
+]}`
+
+func TestRouteAPIs(t *testing.T) {
+	rts := &routeList{}
+	if err := json.Unmarshal([]byte(fixtureRoutes), rts); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(routeAPIs(&rts.Items[1])) != 0 {
+		t.Fatal("cluster-local routes should not be exposed")
+	}
+	if len(routeAPIs(&rts.Items[2])) != 0 {
+		t.Fatal("routes should only be exposed when ready")
+	}
+
+	hello := &rts.Items[0]
+	apis := routeAPIs(hello)
+	if len(apis) != 3 {
+		t.Fatal("expected the route and two tag APIs, got ", len(apis))
+	}
+
+	main := apis[routeSlug(hello, "")]
+	if main.Hostname != "hello.apps.example.com" || main.Annotations["bool.service.tyk.io/use_keyless"] != "false" {
+		t.Fatal("unexpected route api: ", main.Hostname, main.Annotations)
+	}
+	if strings.Join(main.TargetList, ",") != "http://hello-00002.apps:80,http://hello-00002.apps:80,http://hello-00002.apps:80,http://hello-00001.apps:80" {
+		t.Fatal("traffic should be split by percent, got ", main.TargetList)
+	}
+
+	next := apis[routeSlug(hello, "next")]
+	if next.Hostname != "next-hello.apps.example.com" || next.Target != "http://hello-00003.apps:80" {
+		t.Fatal("tags should pin their revision even without traffic, got ", next.Hostname, next.Target)
+	}
+}
+
+func TestNotReadyRoutes(t *testing.T) {
+	rts := &routeList{}
+	if err := json.Unmarshal([]byte(fixtureRoutes), rts); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Controller{}
+	apis, keep := c.routesAPIs(rts.Items)
+	if len(apis) != 3 || !keep[routeSlug(&rts.Items[2], "")] {
+		t.Fatal("expected the APIs of the ready route and to keep the starting one, got ", len(apis), keep)
+	}
+
+	hello := rts.Items[0]
+	hello.Status.Conditions = []Condition{{Type: "Ready", Status: "Unknown"}}
+	apis, _ = c.routesAPIs([]Route{hello, rts.Items[2]})
+	if len(apis) != 3 || apis[routeSlug(&hello, "")] == nil {
+		t.Fatal("routes that aren't ready should keep their last APIs, got ", len(apis))
+	}
+
+	if apis, _ := c.routesAPIs([]Route{rts.Items[2]}); len(apis) != 0 {
+		t.Fatal("deleted routes should lose their APIs")
+	}
+
+	apis, keep = c.routesAPIs([]Route{hello})
+	if len(apis) != 0 || !keep[routeSlug(&hello, "")] {
+		t.Fatal("deleted routes should be forgotten, got ", len(apis), keep)
+	}
+}
@@ -1,8 +1,133 @@
 package logger
 
-import "github.com/TykTechnologies/logrus"
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/logrus"
+)
+
+// Config sets up the log output, logs go to stderr when File is empty
+type Config struct {
+	// File is rotated once it grows over MaxSizeMB or is older than MaxAgeHours, the
+	// MaxBackups newest rotated files are kept
+	File        string `yaml:"file"`
+	MaxSizeMB   int    `yaml:"maxSizeMB"`
+	MaxAgeHours int    `yaml:"maxAgeHours"`
+	MaxBackups  int    `yaml:"maxBackups"`
+	// SampleSeconds and SampleBurst log the same warning or info message at most
+	// SampleBurst times per SampleSeconds, errors are never sampled
+	SampleSeconds int `yaml:"sampleSeconds"`
+	SampleBurst   int `yaml:"sampleBurst"`
+	// Levels overrides the log level per module, e.g. {"ingress": "debug"}
+	Levels map[string]string `yaml:"levels"`
+}
+
+const defaultSampleBurst = 10
+
+// every module logs through its own logger so its level can be changed alone, they share
+// the output, formatter and hooks of the standard logger
+var modulesMu = sync.Mutex{}
+var modules = map[string]*logrus.Logger{}
+
+func moduleLogger(modName string) *logrus.Logger {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+
+	if l, ok := modules[modName]; ok {
+		return l
+	}
+
+	std := logrus.StandardLogger()
+	l := &logrus.Logger{Out: std.Out, Formatter: std.Formatter, Hooks: std.Hooks, Level: std.Level}
+	modules[modName] = l
+	return l
+}
 
 func GetLogger(modName string) *logrus.Entry {
-	log := logrus.WithField("app", "tk8s").WithField("mod", modName)
+	log := logrus.NewEntry(moduleLogger(modName)).WithField("app", "tk8s").WithField("mod", modName)
 	return log
 }
+
+// SetLevel changes the log level of a module at runtime
+func SetLevel(modName, level string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+
+	l, ok := modules[modName]
+	if !ok {
+		return fmt.Errorf("unknown log module %s", modName)
+	}
+
+	l.Level = lvl
+	return nil
+}
+
+// Levels returns the log level of every module
+func Levels() map[string]string {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+
+	levels := make(map[string]string, len(modules))
+	for name, l := range modules {
+		levels[name] = l.Level.String()
+	}
+
+	return levels
+}
+
+// syncModules copies the output and formatter of the standard logger to the modules,
+// they are created before the configuration is read
+func syncModules() {
+	std := logrus.StandardLogger()
+
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+
+	for _, l := range modules {
+		l.Out = std.Out
+		l.Formatter = std.Formatter
+	}
+}
+
+// Configure applies the rotation and sampling settings to every logger
+func Configure(cfg *Config) error {
+	if cfg == nil {
+		return nil
+	}
+
+	if cfg.File != "" {
+		w, err := newRotatingFile(cfg.File, int64(cfg.MaxSizeMB)<<20, time.Duration(cfg.MaxAgeHours)*time.Hour, cfg.MaxBackups)
+		if err != nil {
+			return err
+		}
+		logrus.SetOutput(w)
+	}
+
+	if cfg.SampleSeconds > 0 {
+		burst := cfg.SampleBurst
+		if burst <= 0 {
+			burst = defaultSampleBurst
+		}
+
+		logrus.SetFormatter(&samplingFormatter{
+			Formatter: logrus.StandardLogger().Formatter,
+			sampler:   newSampler(time.Duration(cfg.SampleSeconds)*time.Second, burst),
+		})
+	}
+
+	syncModules()
+	for name, level := range cfg.Levels {
+		if err := SetLevel(name, level); err != nil {
+			return fmt.Errorf("invalid level of %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package logger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TykTechnologies/logrus"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tk8s-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(dir, "tk8s.log")
+	r, err := newRotatingFile(path, 10, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.nowFunc = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		now = now.Add(time.Second)
+		if _, err := r.Write([]byte("12345678\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatal("only the newest backups should be kept, got ", backups)
+	}
+
+	now = now.Add(2 * time.Hour)
+	r.Write([]byte("1\n"))
+	r.Write([]byte("2\n"))
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != "1\n2\n" {
+		t.Fatalf("old files should be rotated, got %q", data)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := logrus.New()
+	l.Out = out
+	l.Formatter = &samplingFormatter{
+		Formatter: &logrus.TextFormatter{DisableColors: true},
+		sampler:   newSampler(time.Hour, 2),
+	}
+
+	for i := 0; i < 5; i++ {
+		l.Warning("using default template")
+		l.Error("sync failed")
+	}
+
+	if n := strings.Count(out.String(), "using default template"); n != 2 {
+		t.Fatal("repeated warnings should be sampled, got ", n)
+	}
+
+	if n := strings.Count(out.String(), "sync failed"); n != 5 {
+		t.Fatal("errors should never be sampled, got ", n)
+	}
+
+	s := newSampler(time.Minute, 1)
+	start := time.Now()
+	s.allow("a", start)
+	s.allow("a", start)
+	s.allow("a", start)
+	if ok, dropped := s.allow("a", start.Add(time.Minute)); !ok || dropped != 2 {
+		t.Fatal("the next window should report the dropped messages, got ", ok, dropped)
+	}
+}
+
+func TestModuleLevels(t *testing.T) {
+	out := &bytes.Buffer{}
+	std := logrus.StandardLogger()
+	oldOut := std.Out
+	defer func() {
+		std.Out = oldOut
+		syncModules()
+	}()
+	std.Out = out
+
+	a, b := GetLogger("test-a"), GetLogger("test-b")
+	if err := Configure(&Config{Levels: map[string]string{"test-a": "debug"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Debug("a debug")
+	b.Debug("b debug")
+	if !strings.Contains(out.String(), "a debug") || strings.Contains(out.String(), "b debug") {
+		t.Fatalf("only test-a should log debug messages, got %q", out.String())
+	}
+
+	if err := SetLevel("test-a", "error"); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Warning("a warning")
+	if strings.Contains(out.String(), "a warning") || Levels()["test-a"] != "error" {
+		t.Fatal("the level should be changed at runtime, got ", Levels())
+	}
+
+	if SetLevel("missing", "debug") == nil || SetLevel("test-a", "loud") == nil {
+		t.Fatal("unknown modules and levels should be refused")
+	}
+}
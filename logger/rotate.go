@@ -0,0 +1,106 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatingFile is a log file that is moved aside when it gets too big or too old, a zero
+// size or age disables that limit and zero backups keeps every rotated file
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	backups int
+
+	f       *os.File
+	size    int64
+	opened  time.Time
+	nowFunc func() time.Time
+}
+
+func newRotatingFile(path string, maxSize int64, maxAge time.Duration, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, backups: backups, nowFunc: time.Now}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.f, r.size, r.opened = f, info.Size(), r.nowFunc()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tooBig := r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize
+	tooOld := r.maxAge > 0 && r.nowFunc().Sub(r.opened) >= r.maxAge
+	if tooBig || tooOld {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rotate log file: %v\n", err)
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+
+	backup := fmt.Sprintf("%s.%s", r.path, r.nowFunc().UTC().Format("20060102T150405.000"))
+	if err := os.Rename(r.path, backup); err != nil {
+		return err
+	}
+
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	return r.prune()
+}
+
+// prune removes the oldest rotated files, the timestamp suffix sorts by age
+func (r *rotatingFile) prune() error {
+	if r.backups <= 0 {
+		return nil
+	}
+
+	old, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(old)
+	for len(old) > r.backups {
+		if err := os.Remove(old[0]); err != nil {
+			return err
+		}
+		old = old[1:]
+	}
+
+	return nil
+}
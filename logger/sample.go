@@ -0,0 +1,90 @@
+package logger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/logrus"
+)
+
+// sampler counts messages per window, messages over the burst are dropped and the number
+// dropped is reported with the next one that is logged
+type sampler struct {
+	mu     sync.Mutex
+	window time.Duration
+	burst  int
+	start  time.Time
+	seen   map[string]*sample
+}
+
+type sample struct {
+	count   int
+	dropped int
+}
+
+func newSampler(window time.Duration, burst int) *sampler {
+	return &sampler{window: window, burst: burst, seen: map[string]*sample{}}
+}
+
+func (s *sampler) allow(key string, now time.Time) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// counts start over every window, only the pending drop counts are carried over
+	if now.Sub(s.start) >= s.window {
+		old := s.seen
+		s.seen = map[string]*sample{}
+		s.start = now
+		for k, v := range old {
+			if v.dropped > 0 {
+				s.seen[k] = &sample{dropped: v.dropped}
+			}
+		}
+	}
+
+	sm, ok := s.seen[key]
+	if !ok {
+		sm = &sample{}
+		s.seen[key] = sm
+	}
+
+	sm.count++
+	if sm.count > s.burst {
+		sm.dropped++
+		return false, 0
+	}
+
+	dropped := sm.dropped
+	sm.dropped = 0
+	return true, dropped
+}
+
+// samplingFormatter drops repeated messages by formatting them to nothing, hooks still
+// see every entry
+type samplingFormatter struct {
+	logrus.Formatter
+	sampler *sampler
+}
+
+func (f *samplingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if entry.Level <= logrus.ErrorLevel {
+		return f.Formatter.Format(entry)
+	}
+
+	ok, dropped := f.sampler.allow(entry.Level.String()+" "+entry.Message, entry.Time)
+	if !ok {
+		return nil, nil
+	}
+
+	if dropped > 0 {
+		// the data is shared with the logger the entry came from, copy before adding to it
+		e := *entry
+		e.Data = logrus.Fields{"suppressed": dropped}
+		for k, v := range entry.Data {
+			e.Data[k] = v
+		}
+		entry = &e
+	}
+
+	return f.Formatter.Format(entry)
+}
@@ -0,0 +1,237 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/logger"
+)
+
+// API changes and sync failures are posted to the configured webhooks, as the event JSON
+// or as a Slack message. Notifications are disabled without webhooks
+
+var log = logger.GetLogger("notify")
+
+const (
+	APICreated = "api.created"
+	APIUpdated = "api.updated"
+	APIDeleted = "api.deleted"
+	SyncFailed = "sync.failed"
+
+	FormatGeneric = "generic"
+	FormatSlack   = "slack"
+
+	defaultTimeout = 10 * time.Second
+)
+
+var eventTypes = map[string]bool{APICreated: true, APIUpdated: true, APIDeleted: true, SyncFailed: true}
+
+type Config struct {
+	Webhooks []Webhook `yaml:"webhooks"`
+}
+
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Format is generic, the event JSON, or slack for incoming webhooks, defaults to generic
+	Format string `yaml:"format"`
+	// Events limits the event types sent, all are sent when empty
+	Events []string `yaml:"events"`
+	// Headers are added to the requests, e.g. to authenticate with the receiver
+	Headers        map[string]string `yaml:"headers"`
+	TimeoutSeconds int               `yaml:"timeoutSeconds"`
+}
+
+// Event is a change made by the controller, Source is the object the API is generated
+// from, e.g. Ingress/default/web
+type Event struct {
+	Type   string    `json:"type"`
+	API    string    `json:"api,omitempty"`
+	APIID  string    `json:"api_id,omitempty"`
+	Source string    `json:"source,omitempty"`
+	SyncID string    `json:"sync_id,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+type hook struct {
+	url     string
+	format  string
+	events  map[string]bool
+	headers map[string]string
+	client  *http.Client
+}
+
+var mu = sync.Mutex{}
+var hooks []*hook
+
+// failed is the last failure notified per object, a failure is only sent again when it
+// changes so retries don't flood the channel
+var failed = map[string]string{}
+
+// pending lets tests wait for the notifications sent in the background
+var pending sync.WaitGroup
+
+// Init validates the webhooks, a nil config disables notifications
+func Init(cfg *Config) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	hooks = nil
+	failed = map[string]string{}
+	if cfg == nil {
+		return nil
+	}
+
+	for i, w := range cfg.Webhooks {
+		u, err := url.Parse(w.URL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("webhook %d: invalid url %s", i, w.URL)
+		}
+
+		h := &hook{url: w.URL, format: w.Format, events: map[string]bool{}, headers: w.Headers, client: &http.Client{Timeout: defaultTimeout}}
+		if h.format == "" {
+			h.format = FormatGeneric
+		}
+		if h.format != FormatGeneric && h.format != FormatSlack {
+			return fmt.Errorf("webhook %d: unknown format %s", i, w.Format)
+		}
+
+		for _, e := range w.Events {
+			if !eventTypes[e] {
+				return fmt.Errorf("webhook %d: unknown event %s", i, e)
+			}
+			h.events[e] = true
+		}
+
+		if w.TimeoutSeconds > 0 {
+			h.client.Timeout = time.Duration(w.TimeoutSeconds) * time.Second
+		}
+
+		hooks = append(hooks, h)
+	}
+
+	return nil
+}
+
+// slackText is the message of an event in Slack markup
+func slackText(ev Event) string {
+	api := ev.API
+	if api == "" {
+		api = ev.Source
+	}
+
+	var text string
+	switch ev.Type {
+	case APICreated:
+		text = fmt.Sprintf(":white_check_mark: API `%s` created", api)
+	case APIUpdated:
+		text = fmt.Sprintf(":arrows_counterclockwise: API `%s` updated", api)
+	case APIDeleted:
+		text = fmt.Sprintf(":wastebasket: API `%s` deleted", api)
+	case SyncFailed:
+		text = fmt.Sprintf(":x: sync of `%s` failed: %s", ev.Source, ev.Error)
+	}
+
+	details := make([]string, 0, 2)
+	if ev.Source != "" && ev.Type != SyncFailed {
+		details = append(details, "source "+ev.Source)
+	}
+	if ev.SyncID != "" {
+		details = append(details, "sync "+ev.SyncID)
+	}
+	if len(details) > 0 {
+		text += " (" + strings.Join(details, ", ") + ")"
+	}
+
+	return text
+}
+
+func (h *hook) payload(ev Event) ([]byte, error) {
+	if h.format == FormatSlack {
+		return json.Marshal(map[string]string{"text": slackText(ev)})
+	}
+
+	return json.Marshal(ev)
+}
+
+// post sends the event in the background so notifications never hold up a sync
+func (h *hook) post(ev Event) {
+	if len(h.events) > 0 && !h.events[ev.Type] {
+		return
+	}
+
+	body, err := h.payload(ev)
+	if err != nil {
+		log.Error("failed to encode notification: ", err)
+		return
+	}
+
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+
+		req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+		if err != nil {
+			log.Error("failed to send notification: ", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range h.headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := h.client.Do(req)
+		if err != nil {
+			log.Error("failed to send notification: ", err)
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			log.Errorf("failed to send notification: status %d", resp.StatusCode)
+		}
+	}()
+}
+
+// Send posts the event to the webhooks
+func Send(ev Event) {
+	mu.Lock()
+	hs := hooks
+	mu.Unlock()
+
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+
+	for _, h := range hs {
+		h.post(ev)
+	}
+}
+
+// SyncFailure notifies a failed sync of an object unless the same failure was the last
+// one notified for it
+func SyncFailure(key string, err error, syncID string) {
+	mu.Lock()
+	if len(hooks) == 0 || failed[key] == err.Error() {
+		mu.Unlock()
+		return
+	}
+	failed[key] = err.Error()
+	mu.Unlock()
+
+	Send(Event{Type: SyncFailed, Source: key, SyncID: syncID, Error: err.Error()})
+}
+
+// SyncSucceeded forgets the last failure of an object, so it is notified again when it
+// comes back
+func SyncSucceeded(key string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(failed, key)
+}
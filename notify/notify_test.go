@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testReceiver(t *testing.T) (*httptest.Server, func() map[string][]string) {
+	mu := sync.Mutex{}
+	bodies := map[string][]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/generic" && r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("missing header on %s", r.URL.Path)
+		}
+
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		bodies[r.URL.Path] = append(bodies[r.URL.Path], string(b))
+		mu.Unlock()
+	}))
+
+	return srv, func() map[string][]string {
+		pending.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		return bodies
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	srv, received := testReceiver(t)
+	defer srv.Close()
+	defer Init(nil)
+
+	err := Init(&Config{Webhooks: []Webhook{
+		{URL: srv.URL + "/generic", Events: []string{APIDeleted, SyncFailed}, Headers: map[string]string{"Authorization": "Bearer secret"}},
+		{URL: srv.URL + "/slack", Format: FormatSlack},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Send(Event{Type: APICreated, API: "web", Source: "Ingress/default/web", SyncID: "s1"})
+	Send(Event{Type: APIDeleted, API: "web", APIID: "a1", Source: "Ingress/default/web"})
+
+	// the same failure is sent once until the object syncs again
+	SyncFailure("Ingress/default/web", errors.New("template failed"), "s2")
+	SyncFailure("Ingress/default/web", errors.New("template failed"), "s3")
+	SyncSucceeded("Ingress/default/web")
+	SyncFailure("Ingress/default/web", errors.New("template failed"), "s4")
+
+	bodies := received()
+	if len(bodies["/generic"]) != 3 || len(bodies["/slack"]) != 4 {
+		t.Fatalf("unexpected notifications %v", bodies)
+	}
+
+	// notifications are sent concurrently, their order isn't kept
+	found := false
+	for _, b := range bodies["/generic"] {
+		ev := Event{}
+		if err := json.Unmarshal([]byte(b), &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type == APIDeleted {
+			found = ev.APIID == "a1" && !ev.Time.IsZero()
+		}
+	}
+	if !found {
+		t.Fatalf("expected the deletion, got %v", bodies["/generic"])
+	}
+
+	texts := make([]string, 0)
+	for _, b := range bodies["/slack"] {
+		msg := map[string]string{}
+		if err := json.Unmarshal([]byte(b), &msg); err != nil {
+			t.Fatal(err)
+		}
+		texts = append(texts, msg["text"])
+	}
+
+	all := strings.Join(texts, "\n")
+	for _, expected := range []string{
+		":white_check_mark: API `web` created (source Ingress/default/web, sync s1)",
+		":x: sync of `Ingress/default/web` failed: template failed (sync s4)",
+	} {
+		if !strings.Contains(all, expected) {
+			t.Fatalf("expected %q in %q", expected, all)
+		}
+	}
+}
+
+func TestInit(t *testing.T) {
+	defer Init(nil)
+
+	for _, w := range []Webhook{{URL: "/relative"}, {URL: "https://example.com", Format: "teams"}, {URL: "https://example.com", Events: []string{"api.renamed"}}} {
+		if err := Init(&Config{Webhooks: []Webhook{w}}); err == nil {
+			t.Errorf("expected %+v to be refused", w)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package oauthclient
+
+import (
+	"fmt"
+
+	"github.com/TykTechnologies/tyk-k8s/conditions"
+	"github.com/TykTechnologies/tyk-k8s/crd"
+	"github.com/TykTechnologies/tyk-k8s/logger"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	resource = "oauthclients"
+
+	kind = "OAuthClient"
+
+	// finalizer keeps deleted OAuthClients around until their client is removed
+	finalizer = "tyk.io/remove-oauth-client"
+
+	secretClientID     = "client_id"
+	secretClientSecret = "client_secret"
+)
+
+var log = logger.GetLogger("oauthclient")
+var ctrl *Controller
+
+// Config for the OAuthClient controller
+type Config struct {
+	// Enabled registers OAuth2 clients for OAuthClient resources and stores their
+	// credentials in secrets
+	Enabled     bool `yaml:"enabled"`
+	SyncSeconds int  `yaml:"syncSeconds"`
+	// AllowedPolicies are policy IDs clients may be bound to per namespace, on top of the
+	// policies generated for the ingresses of the namespace
+	AllowedPolicies map[string][]string `yaml:"allowedPolicies"`
+}
+
+type Spec struct {
+	// API is the slug of a managed API of the namespace that uses OAuth2
+	API          string   `json:"api"`
+	RedirectURIs []string `json:"redirectURIs"`
+	// GrantTypes the client needs, they have to be allowed by the API
+	GrantTypes  []string          `json:"grantTypes"`
+	PolicyID    string            `json:"policyId"`
+	Description string            `json:"description"`
+	MetaData    map[string]string `json:"metadata"`
+	// SecretName is the secret the credentials are written to, defaults to the name of
+	// the resource
+	SecretName string `json:"secretName"`
+}
+
+type Status struct {
+	APIID      string                 `json:"apiId,omitempty"`
+	ClientID   string                 `json:"clientId,omitempty"`
+	Conditions []conditions.Condition `json:"conditions,omitempty"`
+}
+
+type OAuthClient struct {
+	Metadata crd.Metadata `json:"metadata"`
+	Spec     Spec         `json:"spec"`
+	Status   Status       `json:"status"`
+}
+
+type oauthClientList struct {
+	Items []OAuthClient `json:"items"`
+}
+
+// Controller reconciles the OAuthClients listed by its poller
+type Controller struct {
+	cfg    *Config
+	poller *crd.Poller
+}
+
+func NewController() *Controller {
+	if ctrl == nil {
+		ctrl = &Controller{poller: crd.NewPoller(kind, resource)}
+	}
+
+	return ctrl
+}
+
+func GetController() *Controller {
+	return NewController()
+}
+
+func (c *Controller) Config(cfg *Config) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	c.cfg = cfg
+}
+
+func (c *Controller) Start() error {
+	if c.cfg == nil || !c.cfg.Enabled {
+		return nil
+	}
+
+	return c.poller.Start(c.cfg.SyncSeconds, c.reconcile)
+}
+
+func (c *Controller) Stop() error {
+	return c.poller.Stop()
+}
+
+func (c *Controller) reconcile() error {
+	ocs := &oauthClientList{}
+	if err := c.poller.List(ocs); err != nil {
+		return err
+	}
+
+	for i := range ocs.Items {
+		oc := &ocs.Items[i]
+		if oc.Metadata.DeletionTimestamp != "" {
+			c.remove(oc)
+			continue
+		}
+
+		st := c.sync(oc)
+		if conditions.SameJSON(st, oc.Status) {
+			continue
+		}
+
+		if err := c.poller.PatchStatus(&oc.Metadata, st); err != nil {
+			log.Error("failed to update oauth client status: ", err)
+		}
+	}
+
+	return nil
+}
+
+// remove deletes the client of a deleted OAuthClient and then lets it go, the secret is
+// removed by the garbage collector through its owner reference
+func (c *Controller) remove(oc *OAuthClient) {
+	if !oc.Metadata.HasFinalizer(finalizer) {
+		return
+	}
+
+	if oc.Status.ClientID != "" {
+		if err := tyk.DeleteOAuthClient(oc.Status.APIID, oc.Status.ClientID); err != nil {
+			log.Errorf("failed to remove oauth client of %s: %v", oc.source(), err)
+			return
+		}
+	}
+
+	if err := c.poller.RemoveFinalizer(&oc.Metadata, finalizer); err != nil {
+		log.Errorf("failed to remove finalizer of %s: %v", oc.source(), err)
+	}
+}
+
+// secret returns the credentials secret, nil when it doesn't exist. Secrets the
+// OAuthClient doesn't own are refused so unrelated credentials aren't overwritten
+func (c *Controller) secret(oc *OAuthClient) (*v1.Secret, error) {
+	sec, err := c.poller.Client().CoreV1().Secrets(oc.Metadata.Namespace).Get(oc.secretName(), v12.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range sec.OwnerReferences {
+		if string(o.UID) == oc.Metadata.UID {
+			return sec, nil
+		}
+	}
+
+	return nil, fmt.Errorf("secret %s/%s exists and is not owned by the OAuthClient", oc.Metadata.Namespace, sec.Name)
+}
+
+func (c *Controller) sync(oc *OAuthClient) Status {
+	st := oc.Status
+	result := func(reason string, err error) Status {
+		st.Conditions = conditions.Reconciled(st.Conditions, oc.Metadata.Generation, reason, err, true)
+		return st
+	}
+
+	spec, err := oc.clientSpec()
+	if err != nil {
+		return result("InvalidSpec", err)
+	}
+
+	ns := oc.Metadata.Namespace
+	if spec.PolicyID != "" {
+		if err := tyk.CheckPolicies(ns, []string{spec.PolicyID}, c.cfg.AllowedPolicies[ns]); err != nil {
+			return result("PolicyNotAllowed", err)
+		}
+	}
+
+	def, err := tyk.OAuthAPI(oc.Spec.API, oc.Metadata.Namespace)
+	if err != nil {
+		return result("InvalidAPI", err)
+	}
+
+	if err := tyk.CheckGrantTypes(&def.APIDefinition, oc.Spec.GrantTypes); err != nil {
+		return result("GrantNotAllowed", err)
+	}
+
+	sec, err := c.secret(oc)
+	if err != nil {
+		return result("SecretConflict", err)
+	}
+
+	if err := c.poller.AddFinalizer(&oc.Metadata, finalizer); err != nil {
+		return result("SyncFailed", err)
+	}
+
+	// clients belong to one API and their secret can't be read back, moving to another
+	// API or losing the secret registers a new client
+	if st.ClientID != "" && (st.APIID != def.APIID || !hasCredentials(sec)) {
+		if err := tyk.DeleteOAuthClient(st.APIID, st.ClientID); err != nil {
+			return result("SyncFailed", err)
+		}
+		st.APIID, st.ClientID = "", ""
+	}
+
+	if st.ClientID == "" {
+		cl, err := tyk.CreateOAuthClient(def.APIID, spec)
+		if err != nil {
+			return result("SyncFailed", err)
+		}
+
+		if err := c.writeSecret(oc, sec, cl); err != nil {
+			// without the secret the client is unusable, remove it and try again next time
+			if err := tyk.DeleteOAuthClient(def.APIID, cl.ClientID); err != nil {
+				log.Errorf("failed to remove unsaved oauth client of %s: %v", oc.source(), err)
+			}
+			return result("SyncFailed", err)
+		}
+
+		st.APIID, st.ClientID = def.APIID, cl.ClientID
+		return result("", nil)
+	}
+
+	// clients follow spec changes, the generation tells whether the spec moved on
+	ready := conditions.Get(st.Conditions, conditions.Ready)
+	if ready.Status == conditions.True && ready.ObservedGeneration == oc.Metadata.Generation {
+		return st
+	}
+
+	if err := tyk.UpdateOAuthClient(st.APIID, st.ClientID, spec); err != nil {
+		return result("SyncFailed", err)
+	}
+
+	return result("", nil)
+}
+
+func hasCredentials(sec *v1.Secret) bool {
+	return sec != nil && len(sec.Data[secretClientID]) > 0 && len(sec.Data[secretClientSecret]) > 0
+}
+
+func (c *Controller) writeSecret(oc *OAuthClient, sec *v1.Secret, cl *tyk.OAuthClient) error {
+	data := map[string][]byte{
+		secretClientID:     []byte(cl.ClientID),
+		secretClientSecret: []byte(cl.Secret),
+	}
+
+	if sec != nil {
+		sec = sec.DeepCopy()
+		sec.Data = data
+		_, err := c.poller.Client().CoreV1().Secrets(sec.Namespace).Update(sec)
+		return err
+	}
+
+	yes := true
+	sec = &v1.Secret{
+		ObjectMeta: v12.ObjectMeta{
+			Name:      oc.secretName(),
+			Namespace: oc.Metadata.Namespace,
+			OwnerReferences: []v12.OwnerReference{{
+				APIVersion: crd.APIVersion,
+				Kind:       kind,
+				Name:       oc.Metadata.Name,
+				UID:        types.UID(oc.Metadata.UID),
+				Controller: &yes,
+			}},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: data,
+	}
+
+	_, err := c.poller.Client().CoreV1().Secrets(sec.Namespace).Create(sec)
+	return err
+}
+
+func (oc *OAuthClient) source() string {
+	return fmt.Sprintf("%s/%s/%s", kind, oc.Metadata.Namespace, oc.Metadata.Name)
+}
+
+func (oc *OAuthClient) secretName() string {
+	if oc.Spec.SecretName != "" {
+		return oc.Spec.SecretName
+	}
+
+	return oc.Metadata.Name
+}
+
+// clientSpec converts the resource into the client registration, the resource is
+// recorded in the client metadata so clients can be traced back to it
+func (oc *OAuthClient) clientSpec() (*tyk.OAuthClientSpec, error) {
+	s := oc.Spec
+	if s.API == "" {
+		return nil, fmt.Errorf("spec.api is required")
+	}
+
+	if _, ok := s.MetaData[tyk.SourceKey]; ok {
+		return nil, fmt.Errorf("spec.metadata can't set %s", tyk.SourceKey)
+	}
+
+	// redirect based grants need somewhere to send the user back to
+	for _, g := range s.GrantTypes {
+		if (g == "authorization_code" || g == "implicit") && len(s.RedirectURIs) == 0 {
+			return nil, fmt.Errorf("spec.redirectURIs is required for the %s grant", g)
+		}
+	}
+
+	cs := &tyk.OAuthClientSpec{
+		RedirectURIs: s.RedirectURIs,
+		PolicyID:     s.PolicyID,
+		Description:  s.Description,
+		MetaData:     map[string]string{tyk.SourceKey: oc.source()},
+	}
+
+	if cs.Description == "" {
+		cs.Description = oc.Metadata.Namespace + "/" + oc.Metadata.Name
+	}
+
+	for k, v := range s.MetaData {
+		cs.MetaData[k] = v
+	}
+
+	return cs, nil
+}
@@ -0,0 +1,91 @@
+package oauthclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TykTechnologies/tyk-k8s/conditions"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+)
+
+const webClient = `{
+  "metadata": {"name": "web", "namespace": "shop", "uid": "u1", "generation": 1},
+  "spec": {
+    "api": "shop-oauth",
+    "redirectURIs": ["https://shop.example.com/callback"],
+    "grantTypes": ["authorization_code", "refresh_token"],
+    "policyId": "pol-1",
+    "metadata": {"team": "shop"}
+  }
+}`
+
+func fixture(t *testing.T) *OAuthClient {
+	oc := &OAuthClient{}
+	if err := json.Unmarshal([]byte(webClient), oc); err != nil {
+		t.Fatal(err)
+	}
+	return oc
+}
+
+func TestClientSpec(t *testing.T) {
+	oc := fixture(t)
+	cs, err := oc.clientSpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cs.Description != "shop/web" || cs.PolicyID != "pol-1" || len(cs.RedirectURIs) != 1 ||
+		cs.MetaData["team"] != "shop" || cs.MetaData[tyk.SourceKey] != "OAuthClient/shop/web" {
+		t.Fatalf("unexpected client spec: %+v", cs)
+	}
+
+	if oc.secretName() != "web" {
+		t.Fatal("the secret should default to the resource name, got ", oc.secretName())
+	}
+
+	oc.Spec.RedirectURIs = nil
+	if _, err := oc.clientSpec(); err == nil {
+		t.Fatal("redirect grants need a redirect URI")
+	}
+
+	oc.Spec.GrantTypes = []string{"client_credentials"}
+	if _, err := oc.clientSpec(); err != nil {
+		t.Fatal("client credentials don't need a redirect URI: ", err)
+	}
+
+	oc = fixture(t)
+	oc.Spec.MetaData[tyk.SourceKey] = "OAuthClient/other/client"
+	if _, err := oc.clientSpec(); err == nil {
+		t.Fatal("metadata should not be able to claim other clients")
+	}
+
+	oc = fixture(t)
+	oc.Spec.API = ""
+	if _, err := oc.clientSpec(); err == nil {
+		t.Fatal("an API is required")
+	}
+}
+
+func TestPoliciesOfOtherNamespaces(t *testing.T) {
+	theirs := tyk.PolicyID("Ingress/admin/web")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/portal/policies" {
+			t.Error("only the policies should be read, got ", r.Method, r.URL)
+		}
+		fmt.Fprintf(w, `{"Data": [{"_id": "m1", "id": "%s", "tags": ["%s:Ingress/admin/web"]}]}`, theirs, tyk.SourceKey)
+	}))
+	defer srv.Close()
+	tyk.Init(&tyk.TykConf{URL: srv.URL, Secret: "s", Org: "org"})
+
+	c := &Controller{cfg: &Config{}}
+	oc := fixture(t)
+	oc.Spec.PolicyID = theirs
+
+	st := c.sync(oc)
+	if e := conditions.Get(st.Conditions, conditions.Error); e.Status != conditions.True || e.Reason != "PolicyNotAllowed" {
+		t.Fatal("policies of other namespaces should be refused, got ", st.Conditions)
+	}
+}
@@ -0,0 +1,401 @@
+package operator
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/conditions"
+	"github.com/TykTechnologies/tyk-k8s/logger"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"github.com/TykTechnologies/tyk/apidef"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	apiGroup   = "tyk.tyk.io"
+	apiVersion = "v1alpha1"
+	resource   = "apidefinitions"
+
+	operatorTag = "operator"
+
+	// defaultResync is how often unchanged APIs are synced again
+	defaultResync = 5 * time.Minute
+)
+
+// operatorOnly are spec fields of the Tyk Operator schema that refer to other resources,
+// they have no meaning in a Tyk API definition and are dropped
+var operatorOnly = []string{"contextRef", "certificate_secret_names", "upstream_certificate_refs", "pinned_public_keys_refs"}
+
+var log = logger.GetLogger("operator")
+var ctrl *Controller
+
+// Config for the Tyk Operator compatibility controller
+type Config struct {
+	// Enabled reconciles tyk.tyk.io ApiDefinitions, the Tyk Operator itself must not run
+	// in the same cluster
+	Enabled     bool `yaml:"enabled"`
+	SyncSeconds int  `yaml:"syncSeconds"`
+	// ResyncSeconds syncs the APIs even when the resources didn't change, so edits and
+	// deletes made in Tyk are reverted, defaults to 5 minutes
+	ResyncSeconds int `yaml:"resyncSeconds"`
+}
+
+// ApiDefinition is the Tyk Operator resource, the spec is a Tyk API definition so it is
+// kept raw and decoded straight into one
+type ApiDefinition struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Generation  int64             `json:"generation"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec   json.RawMessage `json:"spec"`
+	Status Status          `json:"status"`
+}
+
+// Status mirrors the field the Tyk Operator reports so tooling written for it keeps
+// working, next to the standard conditions
+type Status struct {
+	ApiID      string                 `json:"api_id,omitempty"`
+	Conditions []conditions.Condition `json:"conditions,omitempty"`
+}
+
+type apiDefinitionList struct {
+	Items []ApiDefinition `json:"items"`
+}
+
+// Controller reconciles ApiDefinitions, they are listed on an interval as the vendored
+// client has no informers for them
+type Controller struct {
+	cfg    *Config
+	client *kubernetes.Clientset
+	stopCh chan struct{}
+
+	mu         sync.Mutex
+	lastSync   string
+	lastSyncAt time.Time
+	// good keeps the last valid options of every resource, a broken edit keeps serving
+	// the previous definition instead of deleting the API
+	good map[string]*tyk.APIDefOptions
+}
+
+func NewController() *Controller {
+	if ctrl == nil {
+		ctrl = &Controller{good: map[string]*tyk.APIDefOptions{}}
+	}
+
+	return ctrl
+}
+
+func GetController() *Controller {
+	return NewController()
+}
+
+func (c *Controller) Config(cfg *Config) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	c.cfg = cfg
+}
+
+func (c *Controller) getClient() (*kubernetes.Clientset, error) {
+	cfgF := os.Getenv("TYK_K8S_KUBECONF")
+	var config *rest.Config
+	var err error
+
+	if cfgF != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", cfgF)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+func (c *Controller) Start() error {
+	if c.cfg == nil || !c.cfg.Enabled {
+		return nil
+	}
+
+	var err error
+	c.client, err = c.getClient()
+	if err != nil {
+		return err
+	}
+
+	interval := 15 * time.Second
+	if c.cfg.SyncSeconds > 0 {
+		interval = time.Duration(c.cfg.SyncSeconds) * time.Second
+	}
+
+	log.Info("Watching Tyk Operator ApiDefinitions")
+	c.stopCh = make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			if err := c.reconcile(); err != nil {
+				log.Error("operator reconcile failed: ", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (c *Controller) Stop() error {
+	if c.stopCh == nil {
+		return nil
+	}
+
+	close(c.stopCh)
+	c.stopCh = nil
+	return nil
+}
+
+func (c *Controller) rest() rest.Interface {
+	return c.client.CoreV1().RESTClient()
+}
+
+func (c *Controller) patchStatus(ns, name string, status interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.rest().Patch(types.MergePatchType).
+		AbsPath("/apis", apiGroup, apiVersion, "namespaces", ns, resource, name, "status").
+		Body(body).DoRaw()
+	return err
+}
+
+func (c *Controller) reconcile() error {
+	raw, err := c.rest().Get().AbsPath("/apis", apiGroup, apiVersion, resource).DoRaw()
+	if err != nil {
+		return fmt.Errorf("failed to list api definitions: %v", err)
+	}
+
+	ads := &apiDefinitionList{}
+	if err := json.Unmarshal(raw, ads); err != nil {
+		return err
+	}
+
+	apis, errs := c.wanted(ads.Items)
+	syncErr := c.sync(apis)
+	c.writeStatus(ads.Items, errs, syncErr)
+	return syncErr
+}
+
+// wanted converts the resources, resources that fail keep their last good options and
+// their errors are returned by key
+func (c *Controller) wanted(items []ApiDefinition) (map[string]*tyk.APIDefOptions, map[string]error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	apis := map[string]*tyk.APIDefOptions{}
+	errs := map[string]error{}
+	seen := map[string]struct{}{}
+	for i := range items {
+		ad := &items[i]
+		k := ad.key()
+		seen[k] = struct{}{}
+
+		o, err := ad.options()
+		if err != nil {
+			log.Errorf("%s: %v", k, err)
+			errs[k] = err
+			o = c.good[k]
+		} else {
+			c.good[k] = o
+		}
+
+		if o != nil {
+			apis[o.Slug] = o
+		}
+	}
+
+	for k := range c.good {
+		if _, ok := seen[k]; !ok {
+			delete(c.good, k)
+		}
+	}
+
+	return apis, errs
+}
+
+// sync only pushes the APIs when they changed since the last interval, the options are
+// fingerprinted first as UpdateAPIs fills in the existing definitions
+func (c *Controller) sync(apis map[string]*tyk.APIDefOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	raw, err := json.Marshal(apis)
+	if err != nil {
+		return err
+	}
+	if string(raw) == c.lastSync && time.Since(c.lastSyncAt) < c.resyncInterval() {
+		return nil
+	}
+
+	if err := tyk.SyncByTag(operatorTag, apis); err != nil {
+		return err
+	}
+
+	c.lastSync = string(raw)
+	c.lastSyncAt = time.Now()
+	return nil
+}
+
+func (c *Controller) resyncInterval() time.Duration {
+	if c.cfg != nil && c.cfg.ResyncSeconds > 0 {
+		return time.Duration(c.cfg.ResyncSeconds) * time.Second
+	}
+	return defaultResync
+}
+
+// writeStatus reports the API ID Tyk assigned and the outcome of the reconcile, resources
+// are only patched when their status changed
+func (c *Controller) writeStatus(items []ApiDefinition, errs map[string]error, syncErr error) {
+	for i := range items {
+		ad := &items[i]
+		apiID := ""
+		if def, err := tyk.GetBySlug(ad.slug()); err == nil {
+			apiID = def.APIID
+		}
+
+		st := ad.status(apiID, errs[ad.key()], syncErr)
+		if conditions.SameJSON(st, ad.Status) {
+			continue
+		}
+
+		err := c.patchStatus(ad.Metadata.Namespace, ad.Metadata.Name, st)
+		if err != nil {
+			log.Error("failed to update api definition status: ", err)
+		}
+	}
+}
+
+// status for the outcome of a reconcile, apiID is empty when Tyk doesn't serve the API
+func (ad *ApiDefinition) status(apiID string, specErr, syncErr error) Status {
+	st := Status{ApiID: ad.Status.ApiID}
+	if apiID != "" {
+		st.ApiID = apiID
+	}
+
+	reason, err := "", error(nil)
+	switch {
+	case specErr != nil:
+		reason, err = "InvalidSpec", specErr
+	case syncErr != nil:
+		reason, err = "SyncFailed", syncErr
+	}
+
+	st.Conditions = conditions.Reconciled(ad.Status.Conditions, ad.Metadata.Generation, reason, err, apiID != "")
+	return st
+}
+
+func (ad *ApiDefinition) key() string {
+	return ad.Metadata.Namespace + "/" + ad.Metadata.Name
+}
+
+func (ad *ApiDefinition) slug() string {
+	hasher := sha1.New()
+	hasher.Write([]byte("operator:" + ad.key()))
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
+// definition decodes the spec into a Tyk API definition, Operator-only fields are dropped
+// and a definition without versions gets the unversioned Default version the Operator
+// would have defaulted
+func (ad *ApiDefinition) definition() (json.RawMessage, []string, error) {
+	spec := map[string]interface{}{}
+	if err := json.Unmarshal(ad.Spec, &spec); err != nil {
+		return nil, nil, fmt.Errorf("invalid spec: %v", err)
+	}
+
+	dropped := make([]string, 0)
+	for _, f := range operatorOnly {
+		if _, ok := spec[f]; ok {
+			delete(spec, f)
+			dropped = append(dropped, f)
+		}
+	}
+
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	def := &apidef.APIDefinition{}
+	if err := json.Unmarshal(raw, def); err != nil {
+		return nil, nil, fmt.Errorf("invalid spec: %v", err)
+	}
+
+	if def.Name == "" {
+		return nil, nil, fmt.Errorf("spec.name is required")
+	}
+	if def.Proxy.ListenPath == "" || def.Proxy.TargetURL == "" {
+		return nil, nil, fmt.Errorf("spec.proxy needs a listen_path and a target_url")
+	}
+
+	if len(def.VersionData.Versions) == 0 {
+		def.VersionData.NotVersioned = true
+		def.VersionData.DefaultVersion = "Default"
+		def.VersionData.Versions = map[string]apidef.VersionInfo{
+			"Default": {Name: "Default", UseExtendedPaths: true},
+		}
+	}
+
+	raw, err = json.Marshal(def)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Strings(dropped)
+	return raw, dropped, nil
+}
+
+func (ad *ApiDefinition) options() (*tyk.APIDefOptions, error) {
+	def, dropped, err := ad.definition()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dropped) > 0 {
+		log.Warningf("%s: ignoring %v, they are only understood by the Tyk Operator", ad.key(), dropped)
+	}
+
+	return &tyk.APIDefOptions{
+		Name:       ad.Metadata.Name,
+		Slug:       ad.slug(),
+		Tags:       []string{operatorTag},
+		Definition: def,
+		Source: &tyk.SourceMeta{
+			Kind:        "ApiDefinition",
+			Namespace:   ad.Metadata.Namespace,
+			Name:        ad.Metadata.Name,
+			Labels:      ad.Metadata.Labels,
+			Annotations: ad.Metadata.Annotations,
+		},
+	}, nil
+}
@@ -0,0 +1,127 @@
+package operator
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/TykTechnologies/tyk-k8s/conditions"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const httpbin = `{
+  "metadata": {"name": "httpbin", "namespace": "default"},
+  "spec": {
+    "name": "httpbin",
+    "use_keyless": true,
+    "active": true,
+    "protocol": "http",
+    "contextRef": {"name": "community-edition", "namespace": "tyk"},
+    "proxy": {"listen_path": "/httpbin", "target_url": "http://httpbin.default.svc:8000", "strip_listen_path": true}
+  }
+}`
+
+func fixture(t *testing.T, raw string) *ApiDefinition {
+	ad := &ApiDefinition{}
+	if err := json.Unmarshal([]byte(raw), ad); err != nil {
+		t.Fatal(err)
+	}
+	return ad
+}
+
+func TestOptions(t *testing.T) {
+	ad := fixture(t, httpbin)
+	o, err := ad.options()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if o.Slug != ad.slug() || o.Tags[0] != operatorTag || o.Source.Kind != "ApiDefinition" {
+		t.Fatalf("unexpected options: %+v", o)
+	}
+
+	def := &apidef.APIDefinition{}
+	if err := json.Unmarshal(o.Definition, def); err != nil {
+		t.Fatal(err)
+	}
+
+	if !def.UseKeylessAccess || !def.Proxy.StripListenPath || def.Proxy.TargetURL != "http://httpbin.default.svc:8000" {
+		t.Fatalf("spec should be used as the definition, got %+v", def)
+	}
+
+	if !def.VersionData.NotVersioned || def.VersionData.Versions["Default"].Name != "Default" {
+		t.Fatal("a Default version should be added, got ", def.VersionData)
+	}
+
+	spec := map[string]interface{}{}
+	json.Unmarshal(o.Definition, &spec)
+	if _, ok := spec["contextRef"]; ok {
+		t.Fatal("operator only fields should be dropped")
+	}
+}
+
+func TestInvalidSpecKeepsLastGood(t *testing.T) {
+	c := &Controller{good: map[string]*tyk.APIDefOptions{}}
+	ad := fixture(t, httpbin)
+
+	apis, _ := c.wanted([]ApiDefinition{*ad})
+	if len(apis) != 1 {
+		t.Fatal("expected one API, got ", len(apis))
+	}
+
+	ad.Spec = json.RawMessage(`{"name": "httpbin", "proxy": {}}`)
+	apis, errs := c.wanted([]ApiDefinition{*ad})
+	if apis[ad.slug()] == nil || apis[ad.slug()].Definition == nil {
+		t.Fatal("a broken edit should keep the last good definition")
+	}
+	if errs[ad.key()] == nil {
+		t.Fatal("the spec error should be reported")
+	}
+
+	apis, _ = c.wanted(nil)
+	if len(apis) != 0 || len(c.good) != 0 {
+		t.Fatal("removed resources should be forgotten")
+	}
+}
+
+func TestStatusConditions(t *testing.T) {
+	oldNow := conditions.Now
+	defer func() { conditions.Now = oldNow }()
+	conditions.Now = func() string { return "t1" }
+
+	ad := fixture(t, httpbin)
+	ad.Metadata.Generation = 2
+
+	st := ad.status("", nil, nil)
+	if conditions.Get(st.Conditions, conditions.Synced).Status != conditions.True || conditions.Get(st.Conditions, conditions.Ready).Status != conditions.False ||
+		conditions.Get(st.Conditions, conditions.Ready).Reason != "Pending" {
+		t.Fatalf("synced but not yet served should not be ready: %+v", st.Conditions)
+	}
+
+	ad.Status = st
+	conditions.Now = func() string { return "t2" }
+	st = ad.status("api-1", nil, nil)
+	if st.ApiID != "api-1" || conditions.Get(st.Conditions, conditions.Ready).Status != conditions.True || conditions.Get(st.Conditions, conditions.Error).Status != conditions.False {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if conditions.Get(st.Conditions, conditions.Ready).LastTransitionTime != "t2" || conditions.Get(st.Conditions, conditions.Synced).LastTransitionTime != "t1" {
+		t.Fatal("transition times should only move when the status changes")
+	}
+	if conditions.Get(st.Conditions, conditions.Ready).ObservedGeneration != 2 {
+		t.Fatal("the observed generation should be reported")
+	}
+
+	ad.Status = st
+	st = ad.status("", errors.New("spec.name is required"), errors.New("dashboard down"))
+	if st.ApiID != "api-1" {
+		t.Fatal("the last API ID should be kept")
+	}
+	e := conditions.Get(st.Conditions, conditions.Error)
+	if e.Status != conditions.True || e.Reason != "InvalidSpec" || e.Message != "spec.name is required" {
+		t.Fatalf("spec errors should win over sync errors: %+v", e)
+	}
+	if conditions.Get(st.Conditions, conditions.Ready).Status != conditions.False || conditions.Get(st.Conditions, conditions.Synced).Status != conditions.False {
+		t.Fatalf("failed generations should not be ready: %+v", st.Conditions)
+	}
+}
@@ -0,0 +1,282 @@
+package orglimit
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/TykTechnologies/tyk-k8s/conditions"
+	"github.com/TykTechnologies/tyk-k8s/crd"
+	"github.com/TykTechnologies/tyk-k8s/logger"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+)
+
+const (
+	resource = "orgratelimits"
+
+	kind = "OrgRateLimit"
+
+	// finalizer keeps deleted OrgRateLimits around until the org limits are removed
+	finalizer = "tyk.io/remove-org-limits"
+)
+
+var log = logger.GetLogger("orglimit")
+var ctrl *Controller
+
+// Config for the OrgRateLimit controller
+type Config struct {
+	// Enabled writes the org session of the organisations named by OrgRateLimit resources
+	Enabled     bool `yaml:"enabled"`
+	SyncSeconds int  `yaml:"syncSeconds"`
+	// AllowedOrgs are the organisations besides the configured org limits can be set for
+	AllowedOrgs []string `yaml:"allowedOrgs"`
+	// GatewayURL and GatewaySecret address the gateway API, org limits are gateway
+	// sessions so they are needed when the controller talks to a Dashboard
+	GatewayURL    string `yaml:"gatewayURL"`
+	GatewaySecret string `yaml:"gatewaySecret"`
+}
+
+type Spec struct {
+	// OrgID defaults to the organisation of the controller
+	OrgID string `json:"orgId"`
+	// Rate requests are allowed every Per seconds across the organisation
+	Rate float64 `json:"rate"`
+	Per  float64 `json:"per"`
+	// QuotaMax requests are allowed every QuotaRenewalRate seconds
+	QuotaMax         int64 `json:"quotaMax"`
+	QuotaRenewalRate int64 `json:"quotaRenewalRate"`
+}
+
+type Status struct {
+	OrgID      string                 `json:"orgId,omitempty"`
+	Conditions []conditions.Condition `json:"conditions,omitempty"`
+}
+
+type OrgRateLimit struct {
+	Metadata crd.Metadata `json:"metadata"`
+	Spec     Spec         `json:"spec"`
+	Status   Status       `json:"status"`
+}
+
+type orgRateLimitList struct {
+	Items []OrgRateLimit `json:"items"`
+}
+
+// Controller reconciles the OrgRateLimits listed by its poller
+type Controller struct {
+	cfg    *Config
+	poller *crd.Poller
+}
+
+func NewController() *Controller {
+	if ctrl == nil {
+		ctrl = &Controller{poller: crd.NewPoller(kind, resource)}
+	}
+
+	return ctrl
+}
+
+func GetController() *Controller {
+	return NewController()
+}
+
+func (c *Controller) Config(cfg *Config) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	c.cfg = cfg
+}
+
+func (c *Controller) Start() error {
+	if c.cfg == nil || !c.cfg.Enabled {
+		return nil
+	}
+
+	return c.poller.Start(c.cfg.SyncSeconds, c.reconcile)
+}
+
+func (c *Controller) Stop() error {
+	return c.poller.Stop()
+}
+
+func (c *Controller) gateway() *tyk.GatewayAPI {
+	if c.cfg == nil || c.cfg.GatewayURL == "" {
+		return nil
+	}
+
+	return &tyk.GatewayAPI{URL: c.cfg.GatewayURL, Secret: c.cfg.GatewaySecret}
+}
+
+func (c *Controller) reconcile() error {
+	list := &orgRateLimitList{}
+	if err := c.poller.List(list); err != nil {
+		return err
+	}
+
+	owners := owners(list.Items)
+	for i := range list.Items {
+		ol := &list.Items[i]
+		if ol.Metadata.DeletionTimestamp != "" {
+			c.remove(ol)
+			continue
+		}
+
+		st := c.sync(ol, owners[c.orgID(ol)])
+		if conditions.SameJSON(st, ol.Status) {
+			continue
+		}
+
+		if err := c.poller.PatchStatus(&ol.Metadata, st); err != nil {
+			log.Error("failed to update org rate limit status: ", err)
+		}
+	}
+
+	return nil
+}
+
+// owners picks the resource whose limits apply per organisation, the oldest wins so a
+// second resource for the same org can't take the limits over
+func owners(items []OrgRateLimit) map[string]string {
+	sorted := make([]OrgRateLimit, 0, len(items))
+	for _, ol := range items {
+		if ol.Metadata.DeletionTimestamp == "" {
+			sorted = append(sorted, ol)
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].Metadata, sorted[j].Metadata
+		if a.CreationTimestamp != b.CreationTimestamp {
+			return a.CreationTimestamp < b.CreationTimestamp
+		}
+		return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+	})
+
+	out := map[string]string{}
+	for _, ol := range sorted {
+		org := ol.Spec.OrgID
+		if org == "" {
+			org = tyk.OrgID()
+		}
+
+		if _, ok := out[org]; !ok {
+			out[org] = ol.source()
+		}
+	}
+
+	return out
+}
+
+func (c *Controller) orgID(ol *OrgRateLimit) string {
+	if ol.Spec.OrgID != "" {
+		return ol.Spec.OrgID
+	}
+
+	return tyk.OrgID()
+}
+
+func (c *Controller) orgAllowed(org string) bool {
+	if org == tyk.OrgID() {
+		return true
+	}
+
+	for _, o := range c.cfg.AllowedOrgs {
+		if o == org {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remove deletes the org limits of a deleted OrgRateLimit and then lets it go
+func (c *Controller) remove(ol *OrgRateLimit) {
+	if !ol.Metadata.HasFinalizer(finalizer) {
+		return
+	}
+
+	if org := ol.Status.OrgID; org != "" {
+		if err := tyk.DeleteOrgLimits(c.gateway(), org, ol.source()); err != nil {
+			log.Errorf("failed to remove org limits of %s: %v", ol.source(), err)
+			return
+		}
+	}
+
+	if err := c.poller.RemoveFinalizer(&ol.Metadata, finalizer); err != nil {
+		log.Errorf("failed to remove finalizer of %s: %v", ol.source(), err)
+	}
+}
+
+func (c *Controller) sync(ol *OrgRateLimit, owner string) Status {
+	st := ol.Status
+	result := func(reason string, err error) Status {
+		st.Conditions = conditions.Reconciled(st.Conditions, ol.Metadata.Generation, reason, err, true)
+		return st
+	}
+
+	limits, err := ol.limits()
+	if err != nil {
+		return result("InvalidSpec", err)
+	}
+
+	org := c.orgID(ol)
+	if org == "" {
+		return result("InvalidSpec", fmt.Errorf("spec.orgId is needed without a configured org"))
+	}
+
+	if !c.orgAllowed(org) {
+		return result("OrgNotAllowed", fmt.Errorf("org %s is not in the allowed orgs", org))
+	}
+
+	if owner != ol.source() {
+		return result("Conflict", fmt.Errorf("the limits of org %s are managed by %s", org, owner))
+	}
+
+	if err := c.poller.AddFinalizer(&ol.Metadata, finalizer); err != nil {
+		return result("SyncFailed", err)
+	}
+
+	// a changed org releases the limits of the old one
+	if st.OrgID != "" && st.OrgID != org {
+		if err := tyk.DeleteOrgLimits(c.gateway(), st.OrgID, ol.source()); err != nil {
+			return result("SyncFailed", err)
+		}
+	}
+
+	ready := conditions.Get(st.Conditions, conditions.Ready)
+	if st.OrgID == org && ready.Status == conditions.True && ready.ObservedGeneration == ol.Metadata.Generation {
+		return st
+	}
+
+	if err := tyk.SetOrgLimits(c.gateway(), org, ol.source(), limits); err != nil {
+		return result("SyncFailed", err)
+	}
+
+	st.OrgID = org
+	return result("", nil)
+}
+
+func (ol *OrgRateLimit) source() string {
+	return fmt.Sprintf("%s/%s/%s", kind, ol.Metadata.Namespace, ol.Metadata.Name)
+}
+
+func (ol *OrgRateLimit) limits() (*tyk.OrgLimits, error) {
+	s := ol.Spec
+	if s.Rate < 0 || s.Per < 0 || s.QuotaMax < 0 || s.QuotaRenewalRate < 0 {
+		return nil, fmt.Errorf("limits can't be negative")
+	}
+
+	if (s.Rate > 0) != (s.Per > 0) {
+		return nil, fmt.Errorf("spec.rate and spec.per are set together")
+	}
+
+	if (s.QuotaMax > 0) != (s.QuotaRenewalRate > 0) {
+		return nil, fmt.Errorf("spec.quotaMax and spec.quotaRenewalRate are set together")
+	}
+
+	if s.Per == 0 && s.QuotaMax == 0 {
+		return nil, fmt.Errorf("spec needs a rate limit or a quota")
+	}
+
+	return &tyk.OrgLimits{Rate: s.Rate, Per: s.Per, QuotaMax: s.QuotaMax, QuotaRenewalRate: s.QuotaRenewalRate}, nil
+}
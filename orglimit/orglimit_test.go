@@ -0,0 +1,73 @@
+package orglimit
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/TykTechnologies/tyk-k8s/conditions"
+)
+
+const tenantLimit = `{
+  "metadata": {"name": "tenant", "namespace": "platform", "uid": "u1", "generation": 1, "creationTimestamp": "2020-01-01T00:00:00Z"},
+  "spec": {"orgId": "tenant-org", "rate": 1000, "per": 1, "quotaMax": 1000000, "quotaRenewalRate": 2592000}
+}`
+
+func fixture(t *testing.T) *OrgRateLimit {
+	ol := &OrgRateLimit{}
+	if err := json.Unmarshal([]byte(tenantLimit), ol); err != nil {
+		t.Fatal(err)
+	}
+	return ol
+}
+
+func TestLimits(t *testing.T) {
+	l, err := fixture(t).limits()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.Rate != 1000 || l.Per != 1 || l.QuotaMax != 1000000 || l.QuotaRenewalRate != 2592000 {
+		t.Fatalf("unexpected limits: %+v", l)
+	}
+
+	for _, s := range []Spec{{}, {Rate: 10}, {QuotaMax: 10}, {Rate: -1, Per: 1}} {
+		ol := fixture(t)
+		ol.Spec = s
+		if _, err := ol.limits(); err == nil {
+			t.Errorf("%+v should be refused", s)
+		}
+	}
+}
+
+func TestOwners(t *testing.T) {
+	older := *fixture(t)
+	newer := *fixture(t)
+	newer.Metadata.Name = "copy"
+	newer.Metadata.CreationTimestamp = "2021-01-01T00:00:00Z"
+	deleted := *fixture(t)
+	deleted.Metadata.Name = "deleted"
+	deleted.Metadata.CreationTimestamp = "2019-01-01T00:00:00Z"
+	deleted.Metadata.DeletionTimestamp = "2022-01-01T00:00:00Z"
+
+	o := owners([]OrgRateLimit{newer, deleted, older})
+	if o["tenant-org"] != "OrgRateLimit/platform/tenant" {
+		t.Fatal("the oldest resource should own the org, got ", o)
+	}
+}
+
+func TestSyncRefusals(t *testing.T) {
+	c := &Controller{}
+	c.Config(&Config{})
+
+	ol := fixture(t)
+	st := c.sync(ol, ol.source())
+	if r := conditions.Get(st.Conditions, conditions.Ready); r.Status != conditions.False || r.Reason != "OrgNotAllowed" {
+		t.Fatalf("orgs that aren't allowed should be refused: %+v", r)
+	}
+
+	c.Config(&Config{AllowedOrgs: []string{"tenant-org"}})
+	st = c.sync(ol, "OrgRateLimit/platform/other")
+	if r := conditions.Get(st.Conditions, conditions.Ready); r.Status != conditions.False || r.Reason != "Conflict" {
+		t.Fatalf("orgs owned by another resource should be refused: %+v", r)
+	}
+}
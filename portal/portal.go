@@ -0,0 +1,244 @@
+package portal
+
+import (
+	"fmt"
+
+	"github.com/TykTechnologies/tyk-k8s/conditions"
+	"github.com/TykTechnologies/tyk-k8s/crd"
+	"github.com/TykTechnologies/tyk-k8s/logger"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"github.com/ghodss/yaml"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	resource = "portalcatalogues"
+
+	kind = "PortalCatalogue"
+)
+
+var log = logger.GetLogger("portal")
+var ctrl *Controller
+
+// Config for the PortalCatalogue controller
+type Config struct {
+	// Enabled reconciles PortalCatalogue resources against the Dashboard portal
+	Enabled     bool `yaml:"enabled"`
+	SyncSeconds int  `yaml:"syncSeconds"`
+}
+
+type KeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// Documentation is the OpenAPI document of an entry, inline or from a config map in the
+// namespace of the resource
+type Documentation struct {
+	Inline          string  `json:"inline,omitempty"`
+	ConfigMapKeyRef *KeyRef `json:"configMapKeyRef,omitempty"`
+}
+
+// Spec describes a catalogue entry, developers request access to the API through the
+// policy so the policy identifies the entry in the portal
+type Spec struct {
+	Name             string            `json:"name"`
+	ShortDescription string            `json:"shortDescription"`
+	LongDescription  string            `json:"longDescription"`
+	Show             *bool             `json:"show"`
+	PolicyID         string            `json:"policyId"`
+	AuthType         string            `json:"authType"`
+	Documentation    *Documentation    `json:"documentation"`
+	Fields           map[string]string `json:"fields"`
+}
+
+type Status struct {
+	Conditions []conditions.Condition `json:"conditions,omitempty"`
+}
+
+type PortalCatalogue struct {
+	Metadata crd.Metadata `json:"metadata"`
+	Spec     Spec         `json:"spec"`
+	Status   Status       `json:"status"`
+}
+
+type portalCatalogueList struct {
+	Items []PortalCatalogue `json:"items"`
+}
+
+// Controller reconciles the PortalCatalogues listed by its poller
+type Controller struct {
+	cfg    *Config
+	poller *crd.Poller
+}
+
+func NewController() *Controller {
+	if ctrl == nil {
+		ctrl = &Controller{poller: crd.NewPoller(kind, resource)}
+	}
+
+	return ctrl
+}
+
+func GetController() *Controller {
+	return NewController()
+}
+
+func (c *Controller) Config(cfg *Config) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	c.cfg = cfg
+}
+
+func (c *Controller) Start() error {
+	if c.cfg == nil || !c.cfg.Enabled {
+		return nil
+	}
+
+	return c.poller.Start(c.cfg.SyncSeconds, c.reconcile)
+}
+
+func (c *Controller) Stop() error {
+	return c.poller.Stop()
+}
+
+func (c *Controller) reconcile() error {
+	pcs := &portalCatalogueList{}
+	if err := c.poller.List(pcs); err != nil {
+		return err
+	}
+
+	items := make([]*tyk.CatalogueItem, 0, len(pcs.Items))
+	errs := map[string]error{}
+	for i := range pcs.Items {
+		pc := &pcs.Items[i]
+		it, err := pc.item(c.configMapValue)
+		if err != nil {
+			errs[pc.source()] = err
+			continue
+		}
+		items = append(items, it)
+	}
+
+	// entries of invalid resources are kept as they are rather than removed
+	for i := range pcs.Items {
+		if _, failed := errs[pcs.Items[i].source()]; failed {
+			items = append(items, &tyk.CatalogueItem{Source: pcs.Items[i].source(), Keep: true})
+		}
+	}
+
+	syncErrs, syncErr := tyk.SyncCatalogue(kind, items)
+	for src, err := range syncErrs {
+		errs[src] = err
+	}
+
+	for i := range pcs.Items {
+		pc := &pcs.Items[i]
+		st := pc.status(errs[pc.source()], syncErr)
+		if conditions.SameJSON(st, pc.Status) {
+			continue
+		}
+
+		if err := c.poller.PatchStatus(&pc.Metadata, st); err != nil {
+			log.Error("failed to update portal catalogue status: ", err)
+		}
+	}
+
+	return syncErr
+}
+
+func (c *Controller) configMapValue(ns string, ref *KeyRef) (string, error) {
+	cm, err := c.poller.Client().CoreV1().ConfigMaps(ns).Get(ref.Name, v12.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get config map %s/%s: %v", ns, ref.Name, err)
+	}
+
+	d, ok := cm.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in config map %s/%s", ref.Key, ns, ref.Name)
+	}
+
+	return d, nil
+}
+
+func (pc *PortalCatalogue) source() string {
+	return fmt.Sprintf("%s/%s/%s", kind, pc.Metadata.Namespace, pc.Metadata.Name)
+}
+
+func (pc *PortalCatalogue) status(err, syncErr error) Status {
+	reason := ""
+	switch {
+	case err != nil:
+		reason = "InvalidSpec"
+	case syncErr != nil:
+		reason, err = "SyncFailed", syncErr
+	}
+
+	return Status{Conditions: conditions.Reconciled(pc.Status.Conditions, pc.Metadata.Generation, reason, err, true)}
+}
+
+// item converts the resource into a catalogue item, lookup reads config map documents
+func (pc *PortalCatalogue) item(lookup func(ns string, ref *KeyRef) (string, error)) (*tyk.CatalogueItem, error) {
+	s := pc.Spec
+	if s.PolicyID == "" {
+		return nil, fmt.Errorf("spec.policyId is required")
+	}
+
+	if _, ok := s.Fields[tyk.SourceKey]; ok {
+		return nil, fmt.Errorf("spec.fields can't set %s", tyk.SourceKey)
+	}
+
+	name := s.Name
+	if name == "" {
+		name = pc.Metadata.Name
+	}
+
+	show := true
+	if s.Show != nil {
+		show = *s.Show
+	}
+
+	fields := map[string]string{}
+	for k, v := range s.Fields {
+		fields[k] = v
+	}
+
+	it := &tyk.CatalogueItem{
+		Source: pc.source(),
+		Entry: tyk.CatalogueEntry{
+			Name:             name,
+			ShortDescription: s.ShortDescription,
+			LongDescription:  s.LongDescription,
+			Show:             show,
+			APIID:            s.PolicyID,
+			AuthType:         s.AuthType,
+			Fields:           fields,
+		},
+	}
+
+	if s.Documentation == nil {
+		return it, nil
+	}
+
+	doc := s.Documentation.Inline
+	if ref := s.Documentation.ConfigMapKeyRef; ref != nil {
+		var err error
+		doc, err = lookup(pc.Metadata.Namespace, ref)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if doc != "" {
+		// YAML documents are converted, the portal renders JSON
+		raw, err := yaml.YAMLToJSON([]byte(doc))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OpenAPI document: %v", err)
+		}
+		it.Document = raw
+	}
+
+	return it, nil
+}
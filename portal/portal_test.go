@@ -0,0 +1,79 @@
+package portal
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/TykTechnologies/tyk-k8s/conditions"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+)
+
+const petstore = `{
+  "metadata": {"name": "petstore", "namespace": "shop", "generation": 3},
+  "spec": {
+    "shortDescription": "Pets",
+    "policyId": "pol-1",
+    "authType": "keyless",
+    "show": false,
+    "documentation": {"configMapKeyRef": {"name": "petstore-docs", "key": "openapi.yaml"}},
+    "fields": {"team": "shop"}
+  }
+}`
+
+func fixture(t *testing.T) *PortalCatalogue {
+	pc := &PortalCatalogue{}
+	if err := json.Unmarshal([]byte(petstore), pc); err != nil {
+		t.Fatal(err)
+	}
+	return pc
+}
+
+func TestItem(t *testing.T) {
+	pc := fixture(t)
+	lookup := func(ns string, ref *KeyRef) (string, error) {
+		if ns != "shop" || ref.Name != "petstore-docs" || ref.Key != "openapi.yaml" {
+			return "", errors.New("not found")
+		}
+		return "openapi: 3.0.0\n", nil
+	}
+
+	it, err := pc.item(lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if it.Source != "PortalCatalogue/shop/petstore" || it.Entry.Name != "petstore" || it.Entry.Show ||
+		it.Entry.APIID != "pol-1" || it.Entry.Fields["team"] != "shop" {
+		t.Fatalf("unexpected catalogue item: %+v", it)
+	}
+
+	if string(it.Document) != `{"openapi":"3.0.0"}` {
+		t.Fatal("the document should be read from the config map, got ", string(it.Document))
+	}
+
+	pc.Spec.Fields[tyk.SourceKey] = "mine"
+	if _, err := pc.item(lookup); err == nil {
+		t.Fatal("fields should not be able to claim other entries")
+	}
+
+	pc = fixture(t)
+	pc.Spec.PolicyID = ""
+	if _, err := pc.item(lookup); err == nil {
+		t.Fatal("a policy is required")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	pc := fixture(t)
+	st := pc.status(nil, nil)
+	if conditions.Get(st.Conditions, conditions.Ready).Status != conditions.True ||
+		conditions.Get(st.Conditions, conditions.Ready).ObservedGeneration != 3 {
+		t.Fatalf("synced entries should be ready: %+v", st.Conditions)
+	}
+
+	st = pc.status(nil, errors.New("dashboard down"))
+	if c := conditions.Get(st.Conditions, conditions.Error); c.Status != conditions.True || c.Reason != "SyncFailed" {
+		t.Fatalf("sync errors should be reported: %+v", c)
+	}
+}
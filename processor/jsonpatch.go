@@ -0,0 +1,320 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// patchOp is a single RFC 6902 operation
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// applyJSONPatch applies an RFC 6902 JSON Patch document to the definition, the patch is
+// applied as a whole so a failed operation or test leaves the definition untouched
+func applyJSONPatch(def, patch string) (string, error) {
+	ops := make([]patchOp, 0)
+	err := json.Unmarshal([]byte(patch), &ops)
+	if err != nil {
+		return def, fmt.Errorf("invalid json patch: %v", err)
+	}
+
+	doc, err := decodeJSON([]byte(def))
+	if err != nil {
+		return def, err
+	}
+
+	for i, op := range ops {
+		doc, err = applyPatchOp(doc, op)
+		if err != nil {
+			return def, fmt.Errorf("json patch operation %d (%s %s) failed: %v", i, op.Op, op.Path, err)
+		}
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return def, err
+	}
+
+	return string(out), nil
+}
+
+func applyPatchOp(doc interface{}, op patchOp) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return doc, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return doc, errors.New("value is required")
+		}
+
+		val, err := decodeJSON(op.Value)
+		if err != nil {
+			return doc, err
+		}
+
+		switch op.Op {
+		case "add":
+			return addAt(doc, path, val)
+		case "replace":
+			return replaceAt(doc, path, val)
+		}
+
+		cur, err := getAt(doc, path)
+		if err != nil {
+			return doc, err
+		}
+
+		if !jsonEqual(cur, val) {
+			return doc, errors.New("test failed")
+		}
+
+		return doc, nil
+	case "remove":
+		return removeAt(doc, path)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return doc, err
+		}
+
+		val, err := getAt(doc, from)
+		if err != nil {
+			return doc, err
+		}
+
+		if op.Op == "copy" {
+			return addAt(doc, path, deepCopy(val))
+		}
+
+		if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+			return doc, errors.New("cannot move a value into one of its children")
+		}
+
+		doc, err = removeAt(doc, from)
+		if err != nil {
+			return doc, err
+		}
+
+		return addAt(doc, path, val)
+	default:
+		return doc, fmt.Errorf("unsupported operation: %q", op.Op)
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its unescaped tokens
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("invalid json pointer: %q", p)
+	}
+
+	toks := strings.Split(p[1:], "/")
+	r := strings.NewReplacer("~1", "/", "~0", "~")
+	for i, t := range toks {
+		toks[i] = r.Replace(t)
+	}
+
+	return toks, nil
+}
+
+func arrayIndex(tok string, n int, allowEnd bool) (int, error) {
+	if allowEnd && tok == "-" {
+		return n, nil
+	}
+
+	idx, err := strconv.Atoi(tok)
+	if err != nil || idx < 0 || (len(tok) > 1 && tok[0] == '0') {
+		return 0, fmt.Errorf("invalid array index: %q", tok)
+	}
+
+	max := n - 1
+	if allowEnd {
+		max = n
+	}
+
+	if idx > max {
+		return 0, fmt.Errorf("array index out of range: %d", idx)
+	}
+
+	return idx, nil
+}
+
+func getAt(doc interface{}, path []string) (interface{}, error) {
+	cur := doc
+	for _, tok := range path {
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			v, ok := node[tok]
+			if !ok {
+				return nil, fmt.Errorf("%q not found", tok)
+			}
+			cur = v
+		case []interface{}:
+			idx, err := arrayIndex(tok, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			cur = node[idx]
+		default:
+			return nil, fmt.Errorf("cannot descend into %q", tok)
+		}
+	}
+
+	return cur, nil
+}
+
+// updateAt replaces the parent of the last token with the result of fn, containers are
+// rebuilt on the way back up as slices may be reallocated
+func updateAt(doc interface{}, path []string, fn func(parent interface{}, tok string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	child, err := getAt(doc, path[:1])
+	if err != nil {
+		return doc, err
+	}
+
+	child, err = updateAt(child, path[1:], fn)
+	if err != nil {
+		return doc, err
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		node[path[0]] = child
+	case []interface{}:
+		idx, _ := arrayIndex(path[0], len(node), false)
+		node[idx] = child
+	}
+
+	return doc, nil
+}
+
+func addAt(doc interface{}, path []string, val interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return val, nil
+	}
+
+	return updateAt(doc, path, func(parent interface{}, tok string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[tok] = val
+			return node, nil
+		case []interface{}:
+			idx, err := arrayIndex(tok, len(node), true)
+			if err != nil {
+				return parent, err
+			}
+
+			node = append(node, nil)
+			copy(node[idx+1:], node[idx:])
+			node[idx] = val
+			return node, nil
+		default:
+			return parent, fmt.Errorf("cannot add %q to a scalar", tok)
+		}
+	})
+}
+
+func removeAt(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return doc, errors.New("cannot remove the whole definition")
+	}
+
+	return updateAt(doc, path, func(parent interface{}, tok string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[tok]; !ok {
+				return parent, fmt.Errorf("%q not found", tok)
+			}
+			delete(node, tok)
+			return node, nil
+		case []interface{}:
+			idx, err := arrayIndex(tok, len(node), false)
+			if err != nil {
+				return parent, err
+			}
+			return append(node[:idx], node[idx+1:]...), nil
+		default:
+			return parent, fmt.Errorf("cannot remove %q from a scalar", tok)
+		}
+	})
+}
+
+func replaceAt(doc interface{}, path []string, val interface{}) (interface{}, error) {
+	if _, err := getAt(doc, path); err != nil {
+		return doc, err
+	}
+
+	if len(path) == 0 {
+		return val, nil
+	}
+
+	return updateAt(doc, path, func(parent interface{}, tok string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[tok] = val
+			return node, nil
+		case []interface{}:
+			idx, _ := arrayIndex(tok, len(node), false)
+			node[idx] = val
+			return node, nil
+		default:
+			return parent, fmt.Errorf("cannot replace %q in a scalar", tok)
+		}
+	})
+}
+
+// decodeJSON keeps numbers as json.Number so large IDs and timeouts round trip exactly
+func decodeJSON(b []byte) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	err := dec.Decode(&v)
+	return v, err
+}
+
+func deepCopy(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+
+	c, err := decodeJSON(b)
+	if err != nil {
+		return v
+	}
+
+	return c
+}
+
+func jsonEqual(a, b interface{}) bool {
+	var na, nb interface{}
+	ba, errA := json.Marshal(a)
+	bb, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+
+	if json.Unmarshal(ba, &na) != nil || json.Unmarshal(bb, &nb) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(na, nb)
+}
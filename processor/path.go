@@ -0,0 +1,34 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// pathSegment is a field name or array index, annotation key names only allow letters,
+// digits, '-', '_' and '.' so keys holding other characters need tyk.io/json-patch
+var pathSegment = regexp.MustCompile(`^(-1|[A-Za-z0-9_][A-Za-z0-9_-]*)$`)
+
+// fieldPath converts a field path written with dots, e.g. `proxy.target_list.0`, into an
+// sjson path. Numeric segments index arrays and `-1` appends to an array, so the path is
+// valid in an annotation key such as tyk.io/set.proxy.target_list.-1
+func fieldPath(p string) (string, error) {
+	if p == "" {
+		return "", errors.New("field path is empty")
+	}
+
+	segs := strings.Split(p, ".")
+	for _, seg := range segs {
+		if seg == "" {
+			return "", fmt.Errorf("empty segment in field path %q", p)
+		}
+
+		if !pathSegment.MatchString(seg) {
+			return "", fmt.Errorf("invalid segment %q in field path %q", seg, p)
+		}
+	}
+
+	return p, nil
+}
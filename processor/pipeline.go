@@ -0,0 +1,63 @@
+package processor
+
+import (
+	"strings"
+	"sync"
+)
+
+// Processor mutates a rendered API definition based on the ingress annotations,
+// integrators embedding the controller can Register their own to handle custom
+// annotations without changing Process
+type Processor interface {
+	// Handles reports whether the annotation key is consumed by the processor, this is
+	// used by strict mode to find unrecognised annotations
+	Handles(key string) bool
+	// Process returns the modified definition
+	Process(ann map[string]string, def string) (string, error)
+}
+
+// PrefixProcessor handles every annotation that starts with Prefix with Fn
+type PrefixProcessor struct {
+	Prefix string
+	Fn     func(key, val, def string) (string, error)
+}
+
+func (p *PrefixProcessor) Handles(key string) bool {
+	return strings.HasPrefix(key, p.Prefix)
+}
+
+func (p *PrefixProcessor) Process(ann map[string]string, def string) (string, error) {
+	var err error
+	for k, v := range ann {
+		if !p.Handles(k) {
+			continue
+		}
+
+		def, err = p.Fn(k, v, def)
+		if err != nil {
+			return def, err
+		}
+	}
+
+	return def, nil
+}
+
+var registered = make([]Processor, 0)
+var regMu = sync.RWMutex{}
+
+// Register adds a processor to the end of the pipeline, it runs after the built-in
+// processors and any processors registered before it
+func Register(p Processor) {
+	regMu.Lock()
+	defer regMu.Unlock()
+	registered = append(registered, p)
+}
+
+func pipeline() []Processor {
+	regMu.RLock()
+	defer regMu.RUnlock()
+
+	pl := make([]Processor, 0, len(builtins)+len(registered))
+	pl = append(pl, builtins...)
+	return append(pl, registered...)
+}
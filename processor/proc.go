@@ -3,7 +3,9 @@ package processor
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/TykTechnologies/tyk-k8s/logger"
+	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 	"strconv"
 	"strings"
@@ -17,8 +19,18 @@ const (
 	ValueSetNumKey    ValueType = "num.service.tyk.io/"
 	ObjectSetKey      ValueType = "object.service.tyk.io/"
 	ArraySetKey       ValueType = "array.service.tyk.io/"
+	EventHandlerKey   ValueType = "event.service.tyk.io/"
+	ConfigDataKey     ValueType = "tyk.io/config-data."
+	ValueSetKey       ValueType = "tyk.io/set."
+	DeleteKey         ValueType = "tyk.io/delete."
+	AppendKey         ValueType = "tyk.io/append."
+	MergeKey          ValueType = "tyk.io/merge."
+	JSONPatchKey      ValueType = "tyk.io/json-patch"
 )
 
+// WebHookHandlerName is the Tyk event handler used for annotation-declared events
+const WebHookHandlerName = "eh_web_hook_handler"
+
 var log = logger.GetLogger("processor")
 
 func set(key, val, def string, t ValueType) (string, error) {
@@ -46,7 +58,13 @@ func set(key, val, def string, t ValueType) (string, error) {
 		log.Info("setting num value: ", pth)
 		d, err := strconv.Atoi(val)
 		if err != nil {
-			return def, err
+			// rates and intervals can be fractional
+			f, fErr := strconv.ParseFloat(val, 64)
+			if fErr != nil {
+				return def, err
+			}
+
+			return sjson.Set(def, pth, f)
 		}
 
 		return sjson.Set(def, pth, d)
@@ -68,44 +86,177 @@ func set(key, val, def string, t ValueType) (string, error) {
 		}
 
 		return sjson.Set(def, pth, d)
+	case EventHandlerKey:
+		// event names are case-sensitive, so use the raw key
+		evName := key[len(string(t)):]
+		log.Info("setting event handler: ", evName)
+		meta := make(map[string]interface{}, 0)
+		err := json.Unmarshal([]byte(val), &meta)
+		if err != nil {
+			return def, err
+		}
+
+		handlers := []map[string]interface{}{
+			{
+				"handler_name": WebHookHandlerName,
+				"handler_meta": meta,
+			},
+		}
+
+		return sjson.Set(def, "event_handlers.events."+evName, handlers)
+	case ValueSetKey:
+		fPth, err := fieldPath(key[len(string(t)):])
+		if err != nil {
+			return def, err
+		}
+
+		log.Info("setting typed value: ", fPth)
+		return setTyped(def, fPth, val)
+	case DeleteKey:
+		fPth, err := fieldPath(key[len(string(t)):])
+		if err != nil {
+			return def, err
+		}
+
+		log.Info("deleting field: ", fPth)
+		return sjson.Delete(def, fPth)
+	case AppendKey:
+		fPth, err := fieldPath(key[len(string(t)):])
+		if err != nil {
+			return def, err
+		}
+
+		log.Info("appending to array: ", fPth)
+		return appendValues(def, fPth, val)
+	case MergeKey:
+		fPth, err := fieldPath(key[len(string(t)):])
+		if err != nil {
+			return def, err
+		}
+
+		log.Info("merging object: ", fPth)
+		return mergeObject(def, fPth, val)
+	case JSONPatchKey:
+		if key != string(t) {
+			return def, errors.New("unsupported annotation: " + key)
+		}
+
+		log.Info("applying json patch")
+		return applyJSONPatch(def, val)
+	case ConfigDataKey:
+		// config data keys are read by plugins, so they are used verbatim
+		cdKey := key[len(string(t)):]
+		if cdKey == "" {
+			return def, errors.New("config data key is empty")
+		}
+
+		log.Info("setting config data: ", cdKey)
+		return sjson.Set(def, "config_data."+escapePathKey(cdKey), val)
 	default:
 		return def, errors.New("unsupported type")
 	}
 }
 
-func Process(ann map[string]string, def string) (string, error) {
+// setTyped writes the value with its JSON type, so "true" becomes a bool and "[1,2]" an
+// array, anything that is not valid JSON is written as a string
+func setTyped(def, pth, val string) (string, error) {
+	trimmed := strings.TrimSpace(val)
+	if trimmed != "" && json.Valid([]byte(trimmed)) {
+		return sjson.SetRaw(def, pth, trimmed)
+	}
+
+	return sjson.Set(def, pth, val)
+}
+
+// appendValues adds to the array at the path instead of replacing it, a JSON array value
+// appends each of its entries, anything else is appended as a single typed value
+func appendValues(def, pth, val string) (string, error) {
+	existing := gjson.Get(def, pth)
+	if existing.Exists() && !existing.IsArray() {
+		return def, fmt.Errorf("cannot append to %s, it is not an array", pth)
+	}
+
+	items := []string{val}
+	trimmed := strings.TrimSpace(val)
+	if json.Valid([]byte(trimmed)) && gjson.Parse(trimmed).IsArray() {
+		items = items[:0]
+		for _, r := range gjson.Parse(trimmed).Array() {
+			items = append(items, r.Raw)
+		}
+
+		if len(items) == 0 && !existing.Exists() {
+			return sjson.SetRaw(def, pth, "[]")
+		}
+	}
+
 	var err error
-	for k, v := range ann {
-		if strings.HasPrefix(k, string(ValueSetStringKey)) {
-			def, err = set(k, v, def, ValueSetStringKey)
-			if err != nil {
-				return def, err
-			}
+	for _, it := range items {
+		def, err = setTyped(def, pth+".-1", it)
+		if err != nil {
+			return def, err
 		}
+	}
 
-		if strings.HasPrefix(k, string(ValueSetNumKey)) {
-			def, err = set(k, v, def, ValueSetNumKey)
-			if err != nil {
-				return def, err
+	return def, nil
+}
+
+// mergeObject sets the keys of a JSON object on the object at the path, keys that are
+// not in the value keep their template defaults
+func mergeObject(def, pth, val string) (string, error) {
+	existing := gjson.Get(def, pth)
+	if existing.Exists() && !existing.IsObject() {
+		return def, fmt.Errorf("cannot merge into %s, it is not an object", pth)
+	}
+
+	trimmed := strings.TrimSpace(val)
+	if !json.Valid([]byte(trimmed)) || !gjson.Parse(trimmed).IsObject() {
+		return def, fmt.Errorf("merge value for %s must be a JSON object", pth)
+	}
+
+	var err error
+	gjson.Parse(trimmed).ForEach(func(k, v gjson.Result) bool {
+		def, err = sjson.SetRaw(def, pth+"."+escapePathKey(k.String()), v.Raw)
+		return err == nil
+	})
+
+	return def, err
+}
+
+// escapePathKey escapes the characters that sjson would treat as path syntax
+func escapePathKey(k string) string {
+	r := strings.NewReplacer(".", `\.`, "*", `\*`, "?", `\?`)
+	return r.Replace(k)
+}
+
+// phase applies a group of built-in annotation types
+type phase []ValueType
+
+func (ph phase) Handles(key string) bool {
+	for _, t := range ph {
+		if t == JSONPatchKey {
+			if key == string(t) {
+				return true
 			}
+			continue
 		}
 
-		if strings.HasPrefix(k, string(ValueSetBoolKey)) {
-			def, err = set(k, v, def, ValueSetBoolKey)
-			if err != nil {
-				return def, err
-			}
+		if strings.HasPrefix(key, string(t)) {
+			return true
 		}
+	}
 
-		if strings.HasPrefix(k, string(ArraySetKey)) {
-			def, err = set(k, v, def, ArraySetKey)
-			if err != nil {
-				return def, err
+	return false
+}
+
+func (ph phase) Process(ann map[string]string, def string) (string, error) {
+	var err error
+	for k, v := range ann {
+		for _, t := range ph {
+			if !strings.HasPrefix(k, string(t)) {
+				continue
 			}
-		}
 
-		if strings.HasPrefix(k, string(ObjectSetKey)) {
-			def, err = set(k, v, def, ObjectSetKey)
+			def, err = set(k, v, def, t)
 			if err != nil {
 				return def, err
 			}
@@ -114,3 +265,98 @@ func Process(ann map[string]string, def string) (string, error) {
 
 	return def, nil
 }
+
+// the built-in phases are applied in order, so template fields can be deleted before they
+// are set and appends and merges compose with the values that were set, a JSON patch sees
+// the result of every other built-in annotation
+var builtins = []Processor{
+	phase{DeleteKey},
+	phase{
+		ValueSetStringKey,
+		ValueSetNumKey,
+		ValueSetBoolKey,
+		ArraySetKey,
+		ObjectSetKey,
+		EventHandlerKey,
+		ValueSetKey,
+		ConfigDataKey,
+	},
+	phase{AppendKey, MergeKey},
+	phase{JSONPatchKey},
+}
+
+// Recognised reports whether the annotation key is handled by any processor in the pipeline
+func Recognised(key string) bool {
+	for _, p := range pipeline() {
+		if p.Handles(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Process runs the API definition through every processor in the pipeline
+func Process(ann map[string]string, def string) (string, error) {
+	var err error
+	for _, p := range pipeline() {
+		def, err = p.Process(ann, def)
+		if err != nil {
+			return def, err
+		}
+	}
+
+	return def, nil
+}
+
+// Writes tells if the annotation changes the top level field of the definition, or may
+// change it as a JSON patch of the whole document does, so fields the controller guards
+// can be refused before processing
+func Writes(key, val, field string) bool {
+	if key == string(JSONPatchKey) {
+		ops := make([]patchOp, 0)
+		if err := json.Unmarshal([]byte(val), &ops); err != nil {
+			return false
+		}
+
+		for _, op := range ops {
+			toks, err := parsePointer(op.Path)
+			if err == nil && (len(toks) == 0 || toks[0] == field) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, t := range []ValueType{ValueSetStringKey, ValueSetBoolKey, ValueSetNumKey, ObjectSetKey, ArraySetKey} {
+		if strings.HasPrefix(key, string(t)) {
+			pth := strings.Replace(key[len(string(t)):], "-", "_", -1)
+			return topField(pth) == field
+		}
+	}
+
+	for _, t := range []ValueType{ValueSetKey, DeleteKey, AppendKey, MergeKey} {
+		if strings.HasPrefix(key, string(t)) {
+			pth, err := fieldPath(key[len(string(t)):])
+			return err == nil && topField(pth) == field
+		}
+	}
+
+	return false
+}
+
+// topField is the first segment of an sjson path without its escapes
+func topField(pth string) string {
+	for i := 0; i < len(pth); i++ {
+		if pth[i] == '\\' {
+			i++
+			continue
+		}
+		if pth[i] == '.' {
+			pth = pth[:i]
+			break
+		}
+	}
+
+	return strings.Replace(pth, "\\", "", -1)
+}
@@ -3,6 +3,10 @@ package processor
 import (
 	"encoding/json"
 	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
 	"testing"
 )
 
@@ -105,3 +109,400 @@ func TestProc(t *testing.T) {
 	}
 
 }
+
+func TestProcEventHandlers(t *testing.T) {
+	testAnnotations := map[string]string{
+		"event.service.tyk.io/QuotaExceeded":    `{"method":"POST","target_path":"http://alerts.svc/quota","template_path":"templates/default_webhook.json","event_timeout":10}`,
+		"event.service.tyk.io/BreakerTriggered": `{"method":"POST","target_path":"http://alerts.svc/breaker"}`,
+	}
+
+	def, err := Process(testAnnotations, js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	asDefObj := &apidef.APIDefinition{}
+	err = json.Unmarshal([]byte(def), asDefObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quota, ok := asDefObj.EventHandlers.Events["QuotaExceeded"]
+	if !ok || len(quota) != 1 {
+		t.Fatal("quota event handler not set")
+	}
+
+	if quota[0].Handler != WebHookHandlerName {
+		t.Fatalf("expected handler %v, got %v", WebHookHandlerName, quota[0].Handler)
+	}
+
+	if quota[0].HandlerMeta["target_path"] != "http://alerts.svc/quota" {
+		t.Fatal("webhook target not set, got ", quota[0].HandlerMeta["target_path"])
+	}
+
+	if _, ok := asDefObj.EventHandlers.Events["BreakerTriggered"]; !ok {
+		t.Fatal("breaker event handler not set")
+	}
+
+	_, err = Process(map[string]string{"event.service.tyk.io/QuotaExceeded": "not-json"}, js)
+	if err == nil {
+		t.Fatal("expected error for invalid handler meta")
+	}
+}
+
+func TestProcConfigData(t *testing.T) {
+	testAnnotations := map[string]string{
+		"tyk.io/config-data.plugin-mode":   "strict",
+		"tyk.io/config-data.upstream.host": "internal.svc",
+	}
+
+	def, err := Process(testAnnotations, js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	asDefObj := &apidef.APIDefinition{}
+	err = json.Unmarshal([]byte(def), asDefObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if asDefObj.ConfigData["plugin-mode"] != "strict" {
+		t.Fatal("config data not set, got ", asDefObj.ConfigData)
+	}
+
+	if asDefObj.ConfigData["upstream.host"] != "internal.svc" {
+		t.Fatal("dotted config data key should be kept intact, got ", asDefObj.ConfigData)
+	}
+}
+
+func TestProcTypedValues(t *testing.T) {
+	testAnnotations := map[string]string{
+		"tyk.io/set.disable_rate_limit":            "false",
+		"tyk.io/set.session_lifetime":              "3600",
+		"tyk.io/set.allowed_ips":                   `["10.0.0.1", "10.0.0.2"]`,
+		"tyk.io/set.global_rate_limit":             `{"rate": 10.5, "per": 1}`,
+		"tyk.io/set.name":                          "not json",
+		"num.service.tyk.io/global_rate_limit.per": "2.5",
+	}
+
+	def, err := Process(testAnnotations, js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	asDefObj := &apidef.APIDefinition{}
+	err = json.Unmarshal([]byte(def), asDefObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if asDefObj.DisableRateLimit {
+		t.Fatal("bool not set from typed value")
+	}
+
+	if asDefObj.SessionLifetime != 3600 {
+		t.Fatal("number not set from typed value")
+	}
+
+	if len(asDefObj.AllowedIPs) != 2 || asDefObj.AllowedIPs[1] != "10.0.0.2" {
+		t.Fatal("array not set from typed value, got ", asDefObj.AllowedIPs)
+	}
+
+	if asDefObj.GlobalRateLimit.Rate != 10.5 {
+		t.Fatal("object not set from typed value, got ", asDefObj.GlobalRateLimit)
+	}
+
+	if asDefObj.Name != "not json" {
+		t.Fatal("non-JSON value should be set as a string, got ", asDefObj.Name)
+	}
+}
+
+func TestFieldPath(t *testing.T) {
+	valid := map[string]string{
+		"name": "name",
+		"proxy.transport.ssl_insecure_skip_verify": "proxy.transport.ssl_insecure_skip_verify",
+		"proxy.target_list.0":                      "proxy.target_list.0",
+		"allowed_ips.-1":                           "allowed_ips.-1",
+		"config_data.my-key.c":                     "config_data.my-key.c",
+		"a.1.2":                                    "a.1.2",
+	}
+
+	for in, want := range valid {
+		got, err := fieldPath(in)
+		if err != nil {
+			t.Fatalf("%s: %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("%s: expected %s, got %s", in, want, got)
+		}
+
+		// the path is only useful if it can be written as an annotation key
+		if errs := validation.IsQualifiedName(string(ValueSetKey) + in); len(errs) != 0 {
+			t.Fatalf("%s is not a valid annotation key: %v", in, errs)
+		}
+	}
+
+	for _, in := range []string{"", ".name", "name.", "a..b", "a[0]", `a["b"]`, "a.-2", "a.*", "a/b"} {
+		if _, err := fieldPath(in); err == nil {
+			t.Fatalf("%q should be rejected", in)
+		}
+	}
+}
+
+func TestProcNestedValues(t *testing.T) {
+	testAnnotations := map[string]string{
+		"tyk.io/set.proxy.transport.ssl_insecure_skip_verify": "true",
+		"tyk.io/set.proxy.target_list.-1":                     `"http://a.ns:80"`,
+		"tyk.io/set.config_data.my-key":                       "v",
+	}
+
+	for k := range testAnnotations {
+		if errs := validation.IsQualifiedName(k); len(errs) != 0 {
+			t.Fatalf("%s is not a valid annotation key: %v", k, errs)
+		}
+	}
+
+	def, err := Process(testAnnotations, js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	asDefObj := &apidef.APIDefinition{}
+	err = json.Unmarshal([]byte(def), asDefObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !asDefObj.Proxy.Transport.SSLInsecureSkipVerify {
+		t.Fatal("nested field not set")
+	}
+
+	if len(asDefObj.Proxy.Targets) != 1 || asDefObj.Proxy.Targets[0] != "http://a.ns:80" {
+		t.Fatal("array entry not appended, got ", asDefObj.Proxy.Targets)
+	}
+
+	if asDefObj.ConfigData["my-key"] != "v" {
+		t.Fatal("config data key not set, got ", asDefObj.ConfigData)
+	}
+}
+
+func TestProcDelete(t *testing.T) {
+	testAnnotations := map[string]string{
+		"tyk.io/delete.cache_options":     "",
+		"tyk.io/delete.proxy.listen_path": "",
+		"tyk.io/delete.name":              "",
+		"tyk.io/set.name":                 `"replaced"`,
+		"tyk.io/delete.does_not_exist":    "",
+	}
+
+	def, err := Process(testAnnotations, js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gjson.Get(def, "cache_options").Exists() {
+		t.Fatal("cache_options should be removed")
+	}
+
+	if gjson.Get(def, "proxy.listen_path").Exists() || !gjson.Get(def, "proxy.target_url").Exists() {
+		t.Fatal("only the nested field should be removed")
+	}
+
+	if gjson.Get(def, "name").String() != "replaced" {
+		t.Fatal("set should apply after delete, got ", gjson.Get(def, "name"))
+	}
+}
+
+func TestProcAppendMerge(t *testing.T) {
+	testAnnotations := map[string]string{
+		"tyk.io/set.tags":                   `["template"]`,
+		"tyk.io/append.tags":                `["a", "b"]`,
+		"tyk.io/append.allowed_ips":         "10.0.0.1",
+		"tyk.io/merge.cache_options":        `{"cache_timeout": 30}`,
+		"tyk.io/append.response_processors": `{"name": "header_injector"}`,
+	}
+
+	def, err := Process(testAnnotations, js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	asDefObj := &apidef.APIDefinition{}
+	err = json.Unmarshal([]byte(def), asDefObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(asDefObj.Tags) != 3 || asDefObj.Tags[0] != "template" || asDefObj.Tags[2] != "b" {
+		t.Fatal("tags should be appended to, got ", asDefObj.Tags)
+	}
+
+	if len(asDefObj.AllowedIPs) != 1 || asDefObj.AllowedIPs[0] != "10.0.0.1" {
+		t.Fatal("value should be appended, got ", asDefObj.AllowedIPs)
+	}
+
+	if asDefObj.CacheOptions.CacheTimeout != 30 {
+		t.Fatal("cache options not merged, got ", asDefObj.CacheOptions)
+	}
+
+	if !asDefObj.CacheOptions.EnableCache {
+		t.Fatal("merge should keep existing keys")
+	}
+
+	if len(asDefObj.ResponseProcessors) != 1 || asDefObj.ResponseProcessors[0].Name != "header_injector" {
+		t.Fatal("object not appended, got ", asDefObj.ResponseProcessors)
+	}
+
+	_, err = Process(map[string]string{"tyk.io/append.name": "x"}, js)
+	if err == nil {
+		t.Fatal("appending to a non-array should fail")
+	}
+
+	_, err = Process(map[string]string{"tyk.io/merge.cache_options": "[1]"}, js)
+	if err == nil {
+		t.Fatal("merging a non-object should fail")
+	}
+}
+
+func TestProcJSONPatch(t *testing.T) {
+	patch := `[
+		{"op": "test", "path": "/name", "value": "MyGateway #myTag"},
+		{"op": "replace", "path": "/name", "value": "patched"},
+		{"op": "add", "path": "/tags/0", "value": "first"},
+		{"op": "add", "path": "/tags/-", "value": "last"},
+		{"op": "remove", "path": "/cache_options"},
+		{"op": "copy", "from": "/proxy/target_url", "path": "/config_data/upstream"},
+		{"op": "move", "from": "/disable_quota", "path": "/config_data/quota~1off"}
+	]`
+
+	def, err := Process(map[string]string{"tyk.io/json-patch": patch}, js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	asDefObj := &apidef.APIDefinition{}
+	err = json.Unmarshal([]byte(def), asDefObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if asDefObj.Name != "patched" {
+		t.Fatal("name not replaced, got ", asDefObj.Name)
+	}
+
+	if len(asDefObj.Tags) != 3 || asDefObj.Tags[0] != "first" || asDefObj.Tags[2] != "last" {
+		t.Fatal("tags not patched, got ", asDefObj.Tags)
+	}
+
+	if gjson.Get(def, "cache_options").Exists() {
+		t.Fatal("cache_options should be removed")
+	}
+
+	if asDefObj.ConfigData["upstream"] != "http://app.service:1234" || asDefObj.Proxy.TargetURL == "" {
+		t.Fatal("target not copied, got ", asDefObj.ConfigData)
+	}
+
+	if asDefObj.ConfigData["quota/off"] != true || gjson.Get(def, "disable_quota").Exists() {
+		t.Fatal("disable_quota not moved, got ", asDefObj.ConfigData)
+	}
+
+	if !asDefObj.Active || !asDefObj.DisableRateLimit {
+		t.Fatal("untouched fields should be kept")
+	}
+
+	failing := []string{
+		`[{"op": "test", "path": "/name", "value": "other"}]`,
+		`[{"op": "remove", "path": "/does_not_exist"}]`,
+		`[{"op": "add", "path": "/tags/5", "value": "x"}]`,
+		`[{"op": "move", "from": "/proxy", "path": "/proxy/inner"}]`,
+		`[{"op": "explode", "path": "/name"}]`,
+		`{"op": "add"}`,
+	}
+
+	for _, p := range failing {
+		out, err := Process(map[string]string{"tyk.io/json-patch": p}, js)
+		if err == nil {
+			t.Fatal("expected patch to fail: ", p)
+		}
+
+		if out != js {
+			t.Fatal("failed patch should leave the definition untouched: ", p)
+		}
+	}
+}
+
+func TestRecognised(t *testing.T) {
+	for _, k := range []string{"tyk.io/set.name", "tyk.io/json-patch", "string.service.tyk.io/name", "tyk.io/config-data.x"} {
+		if !Recognised(k) {
+			t.Fatalf("%s should be recognised", k)
+		}
+	}
+
+	for _, k := range []string{"tyk.io/templte", "tyk.io/json-patches", "strng.service.tyk.io/name"} {
+		if Recognised(k) {
+			t.Fatalf("%s should not be recognised", k)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	defer func() {
+		registered = registered[:0]
+	}()
+
+	Register(&PrefixProcessor{
+		Prefix: "example.com/upper.",
+		Fn: func(key, val, def string) (string, error) {
+			return sjson.Set(def, key[len("example.com/upper."):], strings.ToUpper(val))
+		},
+	})
+
+	if !Recognised("example.com/upper.name") {
+		t.Fatal("registered processor keys should be recognised")
+	}
+
+	def, err := Process(map[string]string{
+		"tyk.io/set.name":        `"built-in"`,
+		"example.com/upper.name": "custom",
+	}, js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gjson.Get(def, "name").String() != "CUSTOM" {
+		t.Fatal("registered processors should run after the built-ins, got ", gjson.Get(def, "name"))
+	}
+}
+
+func TestWrites(t *testing.T) {
+	writes := map[string]string{
+		"string.service.tyk.io/org_id":        "x",
+		"string.service.tyk.io/org-id":        "x",
+		"object.service.tyk.io/org_id.nested": "{}",
+		"tyk.io/set.org_id":                   `"x"`,
+		"tyk.io/delete.org_id":                "",
+		"tyk.io/merge.org_id.nested":          "{}",
+		"tyk.io/json-patch":                   `[{"op": "add", "path": "", "value": {}}]`,
+	}
+	for k, v := range writes {
+		if !Writes(k, v, "org_id") {
+			t.Errorf("%s should write org_id", k)
+		}
+	}
+
+	if !Writes("tyk.io/json-patch", `[{"op": "test", "path": "/name", "value": "a"}, {"op": "move", "from": "/name", "path": "/org_id"}]`, "org_id") {
+		t.Error("patches moving a value into org_id should write it")
+	}
+
+	for k, v := range map[string]string{
+		"string.service.tyk.io/org_id_x": "x",
+		"tyk.io/set.proxy.org_id":        `"x"`,
+		"tyk.io/config-data.org_id":      "x",
+		"tyk.io/json-patch":              `[{"op": "replace", "path": "/name", "value": "x"}]`,
+	} {
+		if Writes(k, v, "org_id") {
+			t.Errorf("%s should not write org_id", k)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package reporting
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk-k8s/logger"
+)
+
+// Panics and repeated sync failures are sent to a Sentry compatible DSN, e.g. Sentry or
+// GlitchTip, through the store API. Reporting is disabled without a DSN
+
+var log = logger.GetLogger("reporting")
+
+const (
+	defaultFailureThreshold = 3
+	sentryVersion           = 7
+	clientName              = "tyk-k8s/1.0"
+)
+
+type Config struct {
+	// DSN is the project DSN, https://<key>@<host>/<project>
+	DSN         string `yaml:"dsn"`
+	Environment string `yaml:"environment"`
+	// FailureThreshold is how many syncs of an object fail in a row before the failure is
+	// reported, defaults to 3
+	FailureThreshold int `yaml:"failureThreshold"`
+}
+
+type sink struct {
+	storeURL  string
+	auth      string
+	env       string
+	threshold int
+	client    *http.Client
+}
+
+var mu = sync.Mutex{}
+var current *sink
+var failures = map[string]int{}
+
+// pending lets tests wait for the reports sent in the background
+var pending sync.WaitGroup
+
+// Init parses the DSN, a nil config or an empty DSN disables reporting
+func Init(cfg *Config) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current = nil
+	failures = map[string]int{}
+	if cfg == nil || cfg.DSN == "" {
+		return nil
+	}
+
+	u, err := url.Parse(cfg.DSN)
+	if err != nil {
+		return fmt.Errorf("invalid error reporting DSN: %v", err)
+	}
+
+	project := strings.Trim(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || project == "" {
+		return fmt.Errorf("invalid error reporting DSN: expected https://<key>@<host>/<project>")
+	}
+
+	s := &sink{
+		storeURL:  fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project),
+		auth:      fmt.Sprintf("Sentry sentry_version=%d, sentry_client=%s, sentry_key=%s", sentryVersion, clientName, u.User.Username()),
+		env:       cfg.Environment,
+		threshold: cfg.FailureThreshold,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	if secret, ok := u.User.Password(); ok {
+		s.auth += ", sentry_secret=" + secret
+	}
+	if s.threshold <= 0 {
+		s.threshold = defaultFailureThreshold
+	}
+
+	current = s
+	return nil
+}
+
+func eventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// send posts the event in the background so reporting never holds up a sync
+func (s *sink) send(level, message string, tags map[string]string, extra map[string]interface{}) {
+	ev := map[string]interface{}{
+		"event_id":  eventID(),
+		"timestamp": time.Now().UTC().Format("2006-01-02T15:04:05"),
+		"level":     level,
+		"logger":    "tyk-k8s",
+		"platform":  "go",
+		"message":   message,
+		"tags":      tags,
+		"extra":     extra,
+	}
+	if s.env != "" {
+		ev["environment"] = s.env
+	}
+
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Error("failed to encode error report: ", err)
+		return
+	}
+
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+
+		req, err := http.NewRequest(http.MethodPost, s.storeURL, bytes.NewReader(body))
+		if err != nil {
+			log.Error("failed to report error: ", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Sentry-Auth", s.auth)
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			log.Error("failed to report error: ", err)
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			log.Errorf("failed to report error: status %d", resp.StatusCode)
+		}
+	}()
+}
+
+// SyncFailed counts a failed sync of an object, the failure is reported once it fails
+// the configured number of times in a row and again every time that count repeats
+func SyncFailed(key string, err error, tags map[string]string) {
+	mu.Lock()
+	s := current
+	if s == nil {
+		mu.Unlock()
+		return
+	}
+
+	failures[key]++
+	n := failures[key]
+	mu.Unlock()
+
+	if n%s.threshold != 0 {
+		return
+	}
+
+	s.send("error", err.Error(), tags, map[string]interface{}{"object": key, "consecutive_failures": n})
+}
+
+// SyncSucceeded resets the failure count of an object
+func SyncSucceeded(key string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(failures, key)
+}
+
+// Recover reports a panic and panics again, it is deferred where objects are handled so
+// the report carries their context
+func Recover(tags map[string]string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	mu.Lock()
+	s := current
+	mu.Unlock()
+
+	if s != nil {
+		s.send("fatal", fmt.Sprint("panic: ", r), tags, map[string]interface{}{"stack": string(debug.Stack())})
+		pending.Wait()
+	}
+
+	panic(r)
+}
@@ -0,0 +1,129 @@
+package reporting
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type event struct {
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Tags    map[string]string      `json:"tags"`
+	Extra   map[string]interface{} `json:"extra"`
+}
+
+func testSink(t *testing.T, threshold int) (*[]event, func()) {
+	mu := sync.Mutex{}
+	events := []event{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/42/store/" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+
+		if !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=public") {
+			t.Errorf("missing key in auth header %q", r.Header.Get("X-Sentry-Auth"))
+		}
+
+		ev := event{}
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	}))
+
+	dsn := strings.Replace(srv.URL, "http://", "http://public@", 1) + "/42"
+	if err := Init(&Config{DSN: dsn, FailureThreshold: threshold}); err != nil {
+		t.Fatal(err)
+	}
+
+	return &events, func() {
+		Init(nil)
+		srv.Close()
+	}
+}
+
+func TestInit(t *testing.T) {
+	defer Init(nil)
+
+	for _, dsn := range []string{"https://example.com/1", "https://key@example.com/", "://"} {
+		if err := Init(&Config{DSN: dsn}); err == nil {
+			t.Errorf("expected %q to be refused", dsn)
+		}
+	}
+
+	if err := Init(&Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// without a DSN nothing is reported
+	SyncFailed("Ingress/default/a", errors.New("boom"), nil)
+	SyncFailed("Ingress/default/a", errors.New("boom"), nil)
+	SyncFailed("Ingress/default/a", errors.New("boom"), nil)
+}
+
+func TestSyncFailed(t *testing.T) {
+	events, done := testSink(t, 2)
+	defer done()
+
+	tags := map[string]string{"namespace": "default", "ingress": "a"}
+	SyncFailed("Ingress/default/a", errors.New("boom"), tags)
+	pending.Wait()
+	if len(*events) != 0 {
+		t.Fatalf("expected the first failure not to be reported, got %d events", len(*events))
+	}
+
+	// a success starts the count again
+	SyncSucceeded("Ingress/default/a")
+	SyncFailed("Ingress/default/a", errors.New("boom"), tags)
+	pending.Wait()
+	if len(*events) != 0 {
+		t.Fatalf("expected the count to be reset, got %d events", len(*events))
+	}
+
+	SyncFailed("Ingress/default/a", errors.New("boom"), tags)
+	pending.Wait()
+	if len(*events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(*events))
+	}
+
+	ev := (*events)[0]
+	if ev.Level != "error" || ev.Message != "boom" || ev.Tags["ingress"] != "a" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+
+	if ev.Extra["consecutive_failures"] != float64(2) {
+		t.Errorf("expected 2 consecutive failures, got %v", ev.Extra["consecutive_failures"])
+	}
+}
+
+func TestRecover(t *testing.T) {
+	events, done := testSink(t, 0)
+	defer done()
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("expected the panic to be raised again, got %v", r)
+			}
+		}()
+		defer Recover(map[string]string{"ingress": "a"})
+		panic("boom")
+	}()
+
+	if len(*events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(*events))
+	}
+
+	ev := (*events)[0]
+	if ev.Level != "fatal" || ev.Message != "panic: boom" || ev.Extra["stack"] == nil {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
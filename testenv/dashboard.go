@@ -0,0 +1,152 @@
+package testenv
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const dashAPIs = "/api/apis"
+
+// Dashboard is an in-memory Dashboard API serving the API endpoints the controller uses,
+// requests to other endpoints get a 404 and are recorded in Unhandled
+type Dashboard struct {
+	*httptest.Server
+	Secret string
+
+	mu        sync.Mutex
+	apis      map[string]objects.DBApiDefinition
+	unhandled []string
+}
+
+type dashResponse struct {
+	Status  string
+	Message string
+	Meta    string
+}
+
+// NewDashboard starts a Dashboard that accepts requests with the secret
+func NewDashboard(secret string) *Dashboard {
+	d := &Dashboard{Secret: secret, apis: map[string]objects.DBApiDefinition{}}
+	d.Server = httptest.NewServer(http.HandlerFunc(d.serve))
+	return d
+}
+
+func (d *Dashboard) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != d.Secret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(dashResponse{Status: "Error", Message: "Not authorised"})
+		return
+	}
+
+	path := strings.TrimRight(r.URL.Path, "/")
+	if path != dashAPIs && !strings.HasPrefix(path, dashAPIs+"/") {
+		d.mu.Lock()
+		d.unhandled = append(d.unhandled, r.Method+" "+r.URL.Path)
+		d.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(path, dashAPIs), "/")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		json.NewEncoder(w).Encode(map[string]interface{}{"apis": d.sorted(), "pages": 1})
+	case r.Method == http.MethodGet:
+		api, ok := d.apis[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(api)
+	case r.Method == http.MethodPost && id == "":
+		api := objects.DBApiDefinition{}
+		if err := json.NewDecoder(r.Body).Decode(&api); err != nil {
+			d.reject(w, err)
+			return
+		}
+
+		// the Dashboard gives every API new IDs, clients keep theirs with an update
+		api.Id = bson.NewObjectId()
+		api.APIID = bson.NewObjectId().Hex()
+		d.apis[api.Id.Hex()] = api
+		json.NewEncoder(w).Encode(dashResponse{Status: "OK", Message: "API created", Meta: api.Id.Hex()})
+	case r.Method == http.MethodPut:
+		if _, ok := d.apis[id]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		api := objects.DBApiDefinition{}
+		if err := json.NewDecoder(r.Body).Decode(&api); err != nil {
+			d.reject(w, err)
+			return
+		}
+
+		api.Id = bson.ObjectIdHex(id)
+		d.apis[id] = api
+		json.NewEncoder(w).Encode(dashResponse{Status: "OK", Message: "API updated", Meta: id})
+	case r.Method == http.MethodDelete:
+		if _, ok := d.apis[id]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		delete(d.apis, id)
+		json.NewEncoder(w).Encode(dashResponse{Status: "OK", Message: "API deleted", Meta: id})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (d *Dashboard) reject(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(dashResponse{Status: "Error", Message: fmt.Sprint("invalid definition: ", err)})
+}
+
+func (d *Dashboard) sorted() []objects.DBApiDefinition {
+	apis := make([]objects.DBApiDefinition, 0, len(d.apis))
+	for _, a := range d.apis {
+		apis = append(apis, a)
+	}
+
+	sort.Slice(apis, func(i, j int) bool { return apis[i].Slug < apis[j].Slug })
+	return apis
+}
+
+// APIs returns the stored APIs sorted by slug
+func (d *Dashboard) APIs() []objects.DBApiDefinition {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sorted()
+}
+
+// Add stores an API as if it was created outside the controller, it gets an ID when it
+// has none
+func (d *Dashboard) Add(api objects.DBApiDefinition) objects.DBApiDefinition {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if api.Id == "" {
+		api.Id = bson.NewObjectId()
+	}
+	d.apis[api.Id.Hex()] = api
+	return api
+}
+
+// Unhandled returns the requests to endpoints the Dashboard doesn't serve
+func (d *Dashboard) Unhandled() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string{}, d.unhandled...)
+}
@@ -0,0 +1,178 @@
+// Package testenv runs the ingress controller against an in-memory Dashboard, so
+// integration tests can apply ingress fixtures and check the API definitions they produce
+// without a cluster or a Tyk installation.
+//
+// There is no API server in the environment, the controller is driven through Apply and
+// Remove and features that read secrets or record events through the Kubernetes API are
+// not exercised. The tyk package is configured globally, environments can't run in
+// parallel.
+package testenv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+	"github.com/TykTechnologies/tyk-k8s/ingress"
+	"github.com/TykTechnologies/tyk-k8s/tyk"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+const secret = "testenv"
+
+type Env struct {
+	T          testing.TB
+	Dashboard  *Dashboard
+	Controller *ingress.ControlServer
+}
+
+// New starts a Dashboard and points the tyk package at it, cfg configures the controller
+// and tykCfg the client, its URL and secret are set by the environment
+func New(t testing.TB, cfg *ingress.Config, tykCfg *tyk.TykConf) *Env {
+	d := NewDashboard(secret)
+
+	if tykCfg == nil {
+		tykCfg = &tyk.TykConf{}
+	}
+	c := *tykCfg
+	c.URL = d.URL
+	c.Secret = secret
+	c.IsGateway = false
+	// the Dashboard changes within a test, lookups shouldn't see an old list
+	if c.LookupCacheSeconds == 0 {
+		c.LookupCacheSeconds = -1
+	}
+
+	if err := tyk.Init(&c); err != nil {
+		d.Close()
+		t.Fatal("failed to configure the tyk client: ", err)
+	}
+
+	return &Env{T: t, Dashboard: d, Controller: ingress.NewOfflineController(cfg)}
+}
+
+// Close stops the Dashboard
+func (e *Env) Close() {
+	e.Dashboard.Close()
+}
+
+// Apply syncs the ingresses in order
+func (e *Env) Apply(ings ...*v1beta1.Ingress) {
+	for _, ing := range ings {
+		if err := e.Controller.Apply(ing); err != nil {
+			e.T.Fatalf("failed to apply %s/%s: %v", ing.Namespace, ing.Name, err)
+		}
+	}
+}
+
+// ApplyFile syncs the ingress in a YAML or JSON manifest
+func (e *Env) ApplyFile(path string) *v1beta1.Ingress {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		e.T.Fatal(err)
+	}
+
+	ing, err := DecodeIngress(raw)
+	if err != nil {
+		e.T.Fatalf("%s: %v", path, err)
+	}
+
+	e.Apply(ing)
+	return ing
+}
+
+// Remove syncs the deletion of the ingresses
+func (e *Env) Remove(ings ...*v1beta1.Ingress) {
+	for _, ing := range ings {
+		if err := e.Controller.Remove(ing); err != nil {
+			e.T.Fatalf("failed to remove %s/%s: %v", ing.Namespace, ing.Name, err)
+		}
+	}
+}
+
+// DecodeIngress reads an ingress manifest, default namespace is used when it has none
+func DecodeIngress(raw []byte) (*v1beta1.Ingress, error) {
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(raw, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ing, ok := obj.(*v1beta1.Ingress)
+	if !ok {
+		return nil, fmt.Errorf("expected an extensions/v1beta1 Ingress, got %T", obj)
+	}
+
+	if ing.Namespace == "" {
+		ing.Namespace = "default"
+	}
+
+	return ing, nil
+}
+
+// APIs returns the APIs in the Dashboard sorted by slug
+func (e *Env) APIs() []objects.DBApiDefinition {
+	return e.Dashboard.APIs()
+}
+
+// API returns the API with the slug
+func (e *Env) API(slug string) (objects.DBApiDefinition, bool) {
+	for _, a := range e.Dashboard.APIs() {
+		if a.Slug == slug {
+			return a, true
+		}
+	}
+
+	return objects.DBApiDefinition{}, false
+}
+
+// IngressAPIs returns the APIs created for an ingress
+func (e *Env) IngressAPIs(ing *v1beta1.Ingress) []objects.DBApiDefinition {
+	src := fmt.Sprintf("Ingress/%s/%s", ing.Namespace, ing.Name)
+	apis := make([]objects.DBApiDefinition, 0)
+	for _, a := range e.Dashboard.APIs() {
+		if s, _ := a.ConfigData[tyk.SourceKey].(string); s == src {
+			apis = append(apis, a)
+		}
+	}
+
+	return apis
+}
+
+// ExpectAPI fails the test unless an API listens on the path, and returns it
+func (e *Env) ExpectAPI(listenPath string) objects.DBApiDefinition {
+	for _, a := range e.Dashboard.APIs() {
+		if a.Proxy.ListenPath == listenPath {
+			return a
+		}
+	}
+
+	e.T.Fatalf("no API listens on %s, got %s", listenPath, e.listenPaths())
+	return objects.DBApiDefinition{}
+}
+
+// ExpectNoAPI fails the test when an API listens on the path
+func (e *Env) ExpectNoAPI(listenPath string) {
+	for _, a := range e.Dashboard.APIs() {
+		if a.Proxy.ListenPath == listenPath {
+			e.T.Fatalf("expected no API on %s, found %s", listenPath, a.Slug)
+		}
+	}
+}
+
+// ExpectAPICount fails the test unless the Dashboard has n APIs
+func (e *Env) ExpectAPICount(n int) {
+	if got := len(e.Dashboard.APIs()); got != n {
+		e.T.Fatalf("expected %d APIs, got %d: %s", n, got, e.listenPaths())
+	}
+}
+
+func (e *Env) listenPaths() []string {
+	paths := make([]string, 0)
+	for _, a := range e.Dashboard.APIs() {
+		paths = append(paths, a.Proxy.ListenPath)
+	}
+
+	return paths
+}
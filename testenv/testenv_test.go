@@ -0,0 +1,177 @@
+package testenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TykTechnologies/tyk-k8s/ingress"
+	"k8s.io/api/extensions/v1beta1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const fixture = `
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: shop
+  namespace: store
+  annotations:
+    kubernetes.io/ingress.class: tyk
+spec:
+  rules:
+  - host: shop.example.com
+    http:
+      paths:
+      - path: /cart
+        backend:
+          serviceName: cart
+          servicePort: 8080
+`
+
+func TestEnv(t *testing.T) {
+	env := New(t, nil, nil)
+	defer env.Close()
+
+	dir, err := ioutil.TempDir("", "testenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "shop.yaml")
+	if err := ioutil.WriteFile(path, []byte(fixture), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ing := env.ApplyFile(path)
+	api := env.ExpectAPI("/cart")
+	if api.Proxy.TargetURL != "http://cart.store:8080" || api.Domain != "shop.example.com" {
+		t.Fatalf("unexpected API: %s %s", api.Proxy.TargetURL, api.Domain)
+	}
+
+	if len(env.IngressAPIs(ing)) != 1 {
+		t.Fatal("the API should be traced to its ingress")
+	}
+
+	// a second path adds an API and keeps the first
+	updated := ing.DeepCopy()
+	rule := &updated.Spec.Rules[0].HTTP.Paths
+	*rule = append(*rule, v1beta1.HTTPIngressPath{Path: "/checkout", Backend: v1beta1.IngressBackend{
+		ServiceName: "checkout",
+		ServicePort: intstr.FromInt(8080),
+	}})
+	env.Apply(updated)
+	env.ExpectAPICount(2)
+	env.ExpectAPI("/checkout")
+
+	env.Remove(updated)
+	env.ExpectAPICount(0)
+
+	if u := env.Dashboard.Unhandled(); len(u) != 0 {
+		t.Fatal("unexpected requests: ", u)
+	}
+}
+
+func TestAnnotationUpdate(t *testing.T) {
+	env := New(t, nil, nil)
+	defer env.Close()
+
+	ing, err := DecodeIngress([]byte(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.Apply(ing)
+	if api := env.ExpectAPI("/cart"); api.Name == "renamed" {
+		t.Fatal("unexpected API name: ", api.Name)
+	}
+
+	// only the annotations change, the API still needs to be synced
+	updated := ing.DeepCopy()
+	updated.Annotations["string.service.tyk.io/name"] = "renamed"
+	env.Apply(updated)
+	if api := env.ExpectAPI("/cart"); api.Name != "renamed" {
+		t.Fatal("annotation updates should be synced, got ", api.Name)
+	}
+}
+
+func TestIgnoredClass(t *testing.T) {
+	env := New(t, nil, nil)
+	defer env.Close()
+
+	env.Apply(&v1beta1.Ingress{
+		ObjectMeta: v1.ObjectMeta{Name: "other", Namespace: "default",
+			Annotations: map[string]string{"kubernetes.io/ingress.class": "nginx"}},
+		Spec: v1beta1.IngressSpec{Backend: &v1beta1.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)}},
+	})
+
+	env.ExpectAPICount(0)
+}
+
+func TestMergedDefaultBackend(t *testing.T) {
+	env := New(t, &ingress.Config{MergeHosts: true}, nil)
+	defer env.Close()
+
+	ing, err := DecodeIngress([]byte(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ing.Spec.Backend = &v1beta1.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)}
+
+	env.Apply(ing)
+	env.ExpectAPICount(2)
+
+	catchAll := 0
+	for _, a := range env.APIs() {
+		if a.Domain == "" && a.Proxy.TargetURL == "http://web.store:80" {
+			catchAll++
+		}
+	}
+	if catchAll != 1 {
+		t.Fatal("the default backend should get a catch-all API next to the host API, got ", env.listenPaths())
+	}
+
+	updated := ing.DeepCopy()
+	updated.Spec.Backend = nil
+	env.Apply(updated)
+	env.ExpectAPICount(1)
+
+	env.Remove(updated)
+	env.ExpectAPICount(0)
+}
+
+func TestExactHostWinsOverWildcard(t *testing.T) {
+	env := New(t, nil, nil)
+	defer env.Close()
+
+	mkIng := func(name, host string) *v1beta1.Ingress {
+		return &v1beta1.Ingress{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "store",
+				Annotations: map[string]string{"kubernetes.io/ingress.class": "tyk"}},
+			Spec: v1beta1.IngressSpec{Rules: []v1beta1.IngressRule{{Host: host,
+				IngressRuleValue: v1beta1.IngressRuleValue{HTTP: &v1beta1.HTTPIngressRuleValue{
+					Paths: []v1beta1.HTTPIngressPath{{Path: "/", Backend: v1beta1.IngressBackend{
+						ServiceName: name, ServicePort: intstr.FromInt(80)}}},
+				}}}}},
+		}
+	}
+
+	wild, exact := mkIng("wild", "*.example.com"), mkIng("exact", "shop.example.com")
+	env.Apply(wild)
+	if d := env.IngressAPIs(wild)[0].Domain; d != "{subdomain:[^.]+}.example.com" {
+		t.Fatal("unexpected wildcard domain: ", d)
+	}
+
+	env.Apply(exact)
+	if d := env.IngressAPIs(wild)[0].Domain; d == "{subdomain:[^.]+}.example.com" {
+		t.Fatal("the wildcard API should leave out the exact host, got ", d)
+	}
+
+	env.Remove(exact)
+	if d := env.IngressAPIs(wild)[0].Domain; d != "{subdomain:[^.]+}.example.com" {
+		t.Fatal("the wildcard API should cover the host again, got ", d)
+	}
+}
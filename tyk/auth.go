@@ -0,0 +1,119 @@
+package tyk
+
+import (
+	"encoding/base64"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// JWTAuth replaces the auth of an API with JWT validation against a JWKS endpoint
+type JWTAuth struct {
+	JWKSURI string
+	// SigningMethod defaults to rsa, the usual algorithm of JWKS backed providers
+	SigningMethod string
+	// IdentityClaim identifies the session of a token, defaults to sub
+	IdentityClaim string
+	// PolicyClaim is the claim holding the ID of the policy applied to a token
+	PolicyClaim string
+	// ClockSkewSeconds is allowed on the iat, exp and nbf claims
+	ClockSkewSeconds uint64
+}
+
+// OpenIDAuth replaces the auth of an API with OpenID Connect, tokens of a provider are
+// accepted for the listed clients and get the access of the client's policy
+type OpenIDAuth struct {
+	Providers []OpenIDProvider
+	// SegregateByClient keeps separate rate limits and quotas per client
+	SegregateByClient bool
+}
+
+type OpenIDProvider struct {
+	Issuer string
+	// ClientPolicies maps client IDs to the ID of the policy applied to their tokens
+	ClientPolicies map[string]string
+}
+
+func applyOpenID(def *apidef.APIDefinition, o *OpenIDAuth) {
+	if o == nil {
+		return
+	}
+
+	def.UseKeylessAccess = false
+	def.UseStandardAuth = false
+	def.UseOpenID = true
+	def.BaseIdentityProvidedBy = apidef.OIDCUser
+
+	def.OpenIDOptions = apidef.OpenIDOptions{SegregateByClient: o.SegregateByClient}
+	for _, p := range o.Providers {
+		// Tyk keys the client map by the base64 encoded client ID
+		ids := map[string]string{}
+		for client, pol := range p.ClientPolicies {
+			ids[base64.StdEncoding.EncodeToString([]byte(client))] = pol
+		}
+
+		def.OpenIDOptions.Providers = append(def.OpenIDOptions.Providers, apidef.OIDProviderConfig{
+			Issuer:    p.Issuer,
+			ClientIDs: ids,
+		})
+	}
+}
+
+func applyJWT(def *apidef.APIDefinition, j *JWTAuth) {
+	if j == nil {
+		return
+	}
+
+	def.UseKeylessAccess = false
+	def.UseStandardAuth = false
+	def.EnableJWT = true
+	def.BaseIdentityProvidedBy = apidef.JWTClaim
+
+	// Tyk reads the source as a base64 encoded key or JWKS URL
+	def.JWTSource = base64.StdEncoding.EncodeToString([]byte(j.JWKSURI))
+	def.JWTSigningMethod = j.SigningMethod
+	if def.JWTSigningMethod == "" {
+		def.JWTSigningMethod = "rsa"
+	}
+
+	def.JWTIdentityBaseField = j.IdentityClaim
+	if def.JWTIdentityBaseField == "" {
+		def.JWTIdentityBaseField = "sub"
+	}
+
+	def.JWTPolicyFieldName = j.PolicyClaim
+	def.JWTIssuedAtValidationSkew = j.ClockSkewSeconds
+	def.JWTExpiresAtValidationSkew = j.ClockSkewSeconds
+	def.JWTNotBeforeValidationSkew = j.ClockSkewSeconds
+}
+
+// HMACAuth replaces the auth of an API with HMAC request signatures
+type HMACAuth struct {
+	// Algorithms limits the signature algorithms, empty allows all Tyk supports
+	Algorithms []string
+	// ClockSkewMillis is how far the signature date may be off, 0 uses the Tyk default
+	ClockSkewMillis float64
+}
+
+func applyHMAC(def *apidef.APIDefinition, h *HMACAuth) {
+	if h == nil {
+		return
+	}
+
+	def.UseKeylessAccess = false
+	def.UseStandardAuth = false
+	def.EnableSignatureChecking = true
+	def.BaseIdentityProvidedBy = apidef.HMACKey
+	def.HmacAllowedAlgorithms = h.Algorithms
+	def.HmacAllowedClockSkew = h.ClockSkewMillis
+}
+
+// applyClientCertificates only lets clients presenting one of the certificates through,
+// it works alongside the other auth methods
+func applyClientCertificates(def *apidef.APIDefinition, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+
+	def.UseMutualTLSAuth = true
+	def.ClientCertificates = append(def.ClientCertificates, ids...)
+}
@@ -0,0 +1,186 @@
+package tyk
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const endpointDashBasic = "/api/apis/keys/basic"
+
+// BasicAuthUser is a username and password provisioned as a Tyk basic auth key
+type BasicAuthUser struct {
+	Username string
+	Password string
+}
+
+// basicAuthHashes remembers the sessions already written per key so unchanged users are
+// not written again on every sync, a restart writes every user once
+var basicMu = sync.Mutex{}
+var basicAuthHashes = map[string]string{}
+
+func applyBasicAuth(def *apidef.APIDefinition, enabled bool) {
+	if !enabled {
+		return
+	}
+
+	def.UseKeylessAccess = false
+	def.UseStandardAuth = false
+	def.UseBasicAuth = true
+	def.BaseIdentityProvidedBy = apidef.BasicAuthUser
+}
+
+// basicKeyID is the key Tyk stores a basic auth user under, the org keeps usernames of
+// different orgs apart
+func basicKeyID(username string) string {
+	return org() + username
+}
+
+func accessRights(apis []objects.DBApiDefinition) map[string]interface{} {
+	rights := map[string]interface{}{}
+	for _, a := range apis {
+		versions := make([]string, 0, len(a.VersionData.Versions))
+		for v := range a.VersionData.Versions {
+			versions = append(versions, v)
+		}
+		sort.Strings(versions)
+
+		rights[a.APIID] = map[string]interface{}{
+			"api_id":   a.APIID,
+			"api_name": a.Name,
+			"versions": versions,
+		}
+	}
+
+	return rights
+}
+
+// basicKeySource returns the source recorded in an existing basic auth key, found is
+// false when the user has no key
+func basicKeySource(username string) (string, bool, error) {
+	session := struct {
+		MetaData map[string]interface{} `json:"meta_data"`
+		// the Dashboard wraps the session
+		Data *struct {
+			MetaData map[string]interface{} `json:"meta_data"`
+		} `json:"data"`
+	}{}
+
+	err := adminRequest(http.MethodGet, keyPath(basicKeyID(username), false), nil, &session)
+	if isNotFound(err) {
+		return "", false, nil
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	meta := session.MetaData
+	if session.Data != nil {
+		meta = session.Data.MetaData
+	}
+
+	src, _ := meta[SourceKey].(string)
+	return src, true, nil
+}
+
+func writeBasicUser(u BasicAuthUser, session map[string]interface{}, exists bool) error {
+	method := http.MethodPost
+	if exists {
+		method = http.MethodPut
+	}
+
+	p := endpointDashBasic + "/" + url.PathEscape(u.Username)
+	if gatewayMode() {
+		// the gateway adds the org to new users itself, updates use the full key
+		p = endpointGwKeys + "/" + url.PathEscape(u.Username)
+		if exists {
+			p = keyPath(basicKeyID(u.Username), false)
+		}
+	}
+
+	return adminRequest(method, p, session, nil)
+}
+
+// SyncBasicAuthUsers provisions a basic auth key per user granting access to the APIs, a
+// changed password or API list updates the key. Keys record the source so users of a
+// different source are never taken over, and removed users are only deleted when they
+// still belong to the source
+func SyncBasicAuthUsers(source string, users []BasicAuthUser, apis []objects.DBApiDefinition, removed []string) error {
+	rights := accessRights(apis)
+
+	basicMu.Lock()
+	defer basicMu.Unlock()
+
+	errs := make([]string, 0)
+	for _, u := range users {
+		id := basicKeyID(u.Username)
+		session := map[string]interface{}{
+			"org_id":          org(),
+			"alias":           u.Username,
+			"basic_auth_data": map[string]interface{}{"password": u.Password},
+			"access_rights":   rights,
+			"meta_data":       map[string]interface{}{SourceKey: source},
+		}
+
+		raw, err := json.Marshal(session)
+		if err != nil {
+			return err
+		}
+		hash := fmt.Sprintf("%x", sha256.Sum256(raw))
+		if basicAuthHashes[id] == hash {
+			continue
+		}
+
+		src, exists, err := basicKeySource(u.Username)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("failed to read basic auth user %s: %v", u.Username, err))
+			continue
+		}
+
+		if exists && src != source {
+			errs = append(errs, fmt.Sprintf("basic auth user %s already exists and is not managed by %s", u.Username, source))
+			continue
+		}
+
+		if err := writeBasicUser(u, session, exists); err != nil {
+			errs = append(errs, fmt.Sprintf("failed to write basic auth user %s: %v", u.Username, err))
+			continue
+		}
+
+		basicAuthHashes[id] = hash
+	}
+
+	for _, name := range removed {
+		src, exists, err := basicKeySource(name)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("failed to read basic auth user %s: %v", name, err))
+			continue
+		}
+
+		if !exists || src != source {
+			continue
+		}
+
+		if err := DeleteKey(basicKeyID(name), false); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		delete(basicAuthHashes, basicKeyID(name))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("basic auth sync failed: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
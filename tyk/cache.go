@@ -0,0 +1,108 @@
+package tyk
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const (
+	endpointDashCache = "/api/cache"
+	endpointGwCache   = "/tyk/cache"
+)
+
+// CachePaths caches the safe requests of the listed paths instead of every request, the
+// paths are regular expressions like other Tyk endpoint paths
+type CachePaths struct {
+	Paths []string
+	// TimeoutSeconds replaces the cache timeout of the template when set
+	TimeoutSeconds int64
+}
+
+func applyCachePaths(def *apidef.APIDefinition, c *CachePaths) {
+	if c == nil || len(c.Paths) == 0 {
+		return
+	}
+
+	def.CacheOptions.EnableCache = true
+	def.CacheOptions.CacheAllSafeRequests = false
+	if c.TimeoutSeconds > 0 {
+		def.CacheOptions.CacheTimeout = c.TimeoutSeconds
+	}
+
+	for vName, v := range def.VersionData.Versions {
+		v.UseExtendedPaths = true
+		v.ExtendedPaths.Cached = append(v.ExtendedPaths.Cached, c.Paths...)
+		def.VersionData.Versions[vName] = v
+	}
+}
+
+// InvalidateCache drops the cached responses of an API
+func InvalidateCache(apiID string) error {
+	p := endpointDashCache
+	if gatewayMode() {
+		p = endpointGwCache
+	}
+
+	if err := adminRequest(http.MethodDelete, p+"/"+url.PathEscape(apiID), nil, nil); err != nil {
+		return fmt.Errorf("failed to invalidate the cache of %s: %v", apiID, err)
+	}
+
+	return nil
+}
+
+// cacheTargets picks the managed APIs of the slug, or of the source when the slug is empty
+func cacheTargets(apis []objects.DBApiDefinition, slug, source string) []objects.DBApiDefinition {
+	found := make([]objects.DBApiDefinition, 0)
+	for _, a := range apis {
+		if !IsManaged(&a.APIDefinition) {
+			continue
+		}
+
+		src, _ := a.ConfigData[SourceKey].(string)
+		if (slug != "" && a.Slug == slug) || (slug == "" && src == source) {
+			found = append(found, a)
+		}
+	}
+
+	return found
+}
+
+// FlushCache invalidates the cache of the managed API with the slug, or of every managed
+// API of a source such as "Ingress/<namespace>/<name>", and returns the flushed slugs
+func FlushCache(slug, source string) ([]string, error) {
+	if (slug == "") == (source == "") {
+		return nil, errors.New("flushing the cache needs either a slug or a source")
+	}
+
+	all, err := fetchAll()
+	if err != nil {
+		return nil, err
+	}
+
+	apis := cacheTargets(all, slug, source)
+	if len(apis) == 0 {
+		return nil, fmt.Errorf("no managed API found for %s%s", slug, source)
+	}
+
+	flushed := make([]string, 0, len(apis))
+	errs := make([]string, 0)
+	for _, a := range apis {
+		if err := InvalidateCache(a.APIID); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		flushed = append(flushed, a.Slug)
+	}
+
+	if len(errs) > 0 {
+		return flushed, errors.New(strings.Join(errs, "; "))
+	}
+
+	return flushed, nil
+}
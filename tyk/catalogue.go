@@ -0,0 +1,132 @@
+package tyk
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CatalogueItem is a catalogue entry owned by a cluster resource, Source identifies the
+// resource and is recorded in the entry fields so entries can be removed with it
+type CatalogueItem struct {
+	Source string
+	Entry  CatalogueEntry
+	// Document is published as the entry documentation, an empty document removes it
+	Document []byte
+	// Keep leaves the existing entry as it is, used when the resource can't be converted
+	Keep bool
+}
+
+func entrySource(e *CatalogueEntry) string {
+	return e.Fields[SourceKey]
+}
+
+// SyncCatalogue makes the catalogue entries owned by resources of the kind match the
+// items, entries owned by other kinds or made by hand are left alone. Errors of single
+// items are returned by source so the others are still synced
+func SyncCatalogue(kind string, items []*CatalogueItem) (map[string]error, error) {
+	cat, exists, err := fetchCatalogue()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read portal catalogue: %v", err)
+	}
+
+	before, err := json.Marshal(cat)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := map[string]int{}
+	for i := range cat.APIs {
+		if src := entrySource(&cat.APIs[i]); src != "" {
+			existing[src] = i
+		}
+	}
+
+	docsMu.Lock()
+	defer docsMu.Unlock()
+
+	errs := map[string]error{}
+	wanted := map[string]struct{}{}
+	staleDocs := make([]string, 0)
+	for _, it := range items {
+		wanted[it.Source] = struct{}{}
+		if it.Keep {
+			continue
+		}
+
+		entry := it.Entry
+		if entry.Version == "" {
+			entry.Version = "v2"
+		}
+		if entry.Fields == nil {
+			entry.Fields = map[string]string{}
+		}
+		entry.Fields[SourceKey] = it.Source
+
+		idx, found := existing[it.Source]
+		oldDoc := ""
+		if found {
+			oldDoc = cat.APIs[idx].Documentation
+		}
+
+		entry.Documentation = oldDoc
+		hash := fmt.Sprintf("%x", sha256.Sum256(it.Document))
+		switch {
+		case len(it.Document) == 0:
+			entry.Documentation = ""
+		case oldDoc == "" || docHashes[it.Source] != hash:
+			docID, err := uploadDocs(entry.APIID, it.Document)
+			if err != nil {
+				errs[it.Source] = fmt.Errorf("failed to upload portal documentation: %v", err)
+				continue
+			}
+			entry.Documentation = docID
+			docHashes[it.Source] = hash
+		}
+
+		if oldDoc != "" && oldDoc != entry.Documentation {
+			staleDocs = append(staleDocs, oldDoc)
+		}
+
+		if found {
+			cat.APIs[idx] = entry
+		} else {
+			cat.APIs = append(cat.APIs, entry)
+		}
+	}
+
+	kept := make([]CatalogueEntry, 0, len(cat.APIs))
+	for _, e := range cat.APIs {
+		src := entrySource(&e)
+		if _, ok := wanted[src]; !ok && strings.HasPrefix(src, kind+"/") {
+			if e.Documentation != "" {
+				staleDocs = append(staleDocs, e.Documentation)
+			}
+			delete(docHashes, src)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	cat.APIs = kept
+
+	after, err := json.Marshal(cat)
+	if err != nil {
+		return errs, err
+	}
+
+	if string(before) != string(after) {
+		if err := saveCatalogue(cat, exists); err != nil {
+			return errs, fmt.Errorf("failed to update portal catalogue: %v", err)
+		}
+	}
+
+	for _, d := range staleDocs {
+		if err := dashboardRequest(http.MethodDelete, endpointDocs+"/"+d, nil, nil); err != nil {
+			log.Warning("failed to remove replaced portal documentation: ", err)
+		}
+	}
+
+	return errs, nil
+}
@@ -0,0 +1,163 @@
+package tyk
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TrackedCertificate is a certificate uploaded to Tyk from a key of a secret, Stale is
+// set once the secret holds a different certificate than the one Tyk was given
+type TrackedCertificate struct {
+	// Source is the "Secret/<namespace>/<name>" the certificate was read from
+	Source      string
+	Field       string
+	ID          string
+	Subject     string
+	NotAfter    time.Time
+	Fingerprint string
+	Stale       bool
+}
+
+var certMu = sync.Mutex{}
+var trackedCerts = map[string]*TrackedCertificate{}
+
+func certKey(source, field string) string {
+	return source + "#" + field
+}
+
+// leafCertificate parses the first certificate of a PEM bundle, the one Tyk serves or
+// matches clients against
+func leafCertificate(crt []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, crt = pem.Decode(crt)
+		if block == nil {
+			return nil, errors.New("no certificate found")
+		}
+
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// CertificateFingerprint returns the hex SHA256 of the first certificate of the bundle
+func CertificateFingerprint(crt []byte) (string, error) {
+	leaf, err := leafCertificate(crt)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(leaf.Raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CertificateID returns the ID Tyk stores a certificate under, the org followed by the
+// fingerprint of the first certificate of the bundle
+func CertificateID(crt []byte) (string, error) {
+	fp, err := CertificateFingerprint(crt)
+	if err != nil {
+		return "", err
+	}
+
+	return org() + fp, nil
+}
+
+// TrackCertificate records the certificate uploaded from the secret key so its expiry
+// and renewal can be monitored, uploading a new certificate replaces the old record
+func TrackCertificate(source, field, id string, crt []byte) error {
+	leaf, err := leafCertificate(crt)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(leaf.Raw)
+
+	certMu.Lock()
+	defer certMu.Unlock()
+	trackedCerts[certKey(source, field)] = &TrackedCertificate{
+		Source:      source,
+		Field:       field,
+		ID:          id,
+		Subject:     leaf.Subject.String(),
+		NotAfter:    leaf.NotAfter,
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+
+	return nil
+}
+
+// MarkCertificateStale records whether the secret has moved on from the uploaded certificate
+func MarkCertificateStale(source, field string, stale bool) {
+	certMu.Lock()
+	defer certMu.Unlock()
+	if t, ok := trackedCerts[certKey(source, field)]; ok {
+		t.Stale = stale
+	}
+}
+
+// UntrackCertificate stops monitoring a certificate, e.g. once its secret is deleted
+func UntrackCertificate(source, field string) {
+	certMu.Lock()
+	defer certMu.Unlock()
+	delete(trackedCerts, certKey(source, field))
+}
+
+// TrackedCertificates returns copies of the tracked certificates sorted by source
+func TrackedCertificates() []TrackedCertificate {
+	certMu.Lock()
+	defer certMu.Unlock()
+
+	out := make([]TrackedCertificate, 0, len(trackedCerts))
+	for _, t := range trackedCerts {
+		out = append(out, *t)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return certKey(out[i].Source, out[i].Field) < certKey(out[j].Source, out[j].Field)
+	})
+	return out
+}
+
+func metricLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// WriteCertificateMetrics writes the tracked certificates in the Prometheus text format
+func WriteCertificateMetrics(w io.Writer) error {
+	certs := TrackedCertificates()
+
+	lines := []string{
+		"# HELP tyk_k8s_certificate_expiry_timestamp_seconds Expiry of certificates uploaded to Tyk.",
+		"# TYPE tyk_k8s_certificate_expiry_timestamp_seconds gauge",
+	}
+	for _, t := range certs {
+		lines = append(lines, fmt.Sprintf(`tyk_k8s_certificate_expiry_timestamp_seconds{source="%s",field="%s",id="%s"} %d`,
+			metricLabel(t.Source), metricLabel(t.Field), metricLabel(t.ID), t.NotAfter.Unix()))
+	}
+
+	lines = append(lines,
+		"# HELP tyk_k8s_certificate_stale Whether the secret was renewed but Tyk still has the old certificate.",
+		"# TYPE tyk_k8s_certificate_stale gauge",
+	)
+	for _, t := range certs {
+		stale := 0
+		if t.Stale {
+			stale = 1
+		}
+		lines = append(lines, fmt.Sprintf(`tyk_k8s_certificate_stale{source="%s",field="%s",id="%s"} %d`,
+			metricLabel(t.Source), metricLabel(t.Field), metricLabel(t.ID), stale))
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
@@ -0,0 +1,101 @@
+package tyk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Tyk Cloud control planes are always Dashboards behind TLS. The org is read from the
+// users the API key can list, a Dashboard key belongs to a user and only sees its own
+// org, so usually only the URL and key need to be configured. Keys that see users of
+// several orgs can't tell which one to use, the org has to be configured for them
+
+type cloudUsers struct {
+	Users []struct {
+		OrgID string `json:"org_id"`
+	} `json:"users"`
+}
+
+var cloudClient = &http.Client{Timeout: 10 * time.Second}
+
+func applyCloudMode(c *TykConf) error {
+	if !strings.HasPrefix(c.URL, "https://") {
+		return configError("failed to configure cloud mode", fmt.Errorf("cloud control plane URL must use https, got %q", c.URL))
+	}
+
+	if c.IsGateway {
+		log.Warning("cloud mode uses the Dashboard API, ignoring isGateway")
+		c.IsGateway = false
+	}
+
+	if c.InsecureSkipVerify {
+		log.Warning("cloud mode always verifies TLS, ignoring insecureSkipVerify")
+		c.InsecureSkipVerify = false
+	}
+
+	c.URL = strings.TrimRight(c.URL, "/")
+	if c.Org != "" {
+		return nil
+	}
+
+	org, err := discoverOrg(c.URL, c.Secret)
+	if err != nil {
+		return fmt.Errorf("failed to discover org: %v", err)
+	}
+
+	log.Info("discovered cloud org: ", org)
+	c.Org = org
+	return nil
+}
+
+func discoverOrg(url, secret string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url+"/api/users", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", secret)
+
+	resp, err := cloudClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+
+	users := &cloudUsers{}
+	err = json.Unmarshal(body, users)
+	if err != nil {
+		return "", err
+	}
+
+	orgs := make([]string, 0, 1)
+	seen := map[string]bool{}
+	for _, u := range users.Users {
+		if u.OrgID != "" && !seen[u.OrgID] {
+			seen[u.OrgID] = true
+			orgs = append(orgs, u.OrgID)
+		}
+	}
+
+	switch len(orgs) {
+	case 0:
+		return "", errors.New("no org found for API key")
+	case 1:
+		return orgs[0], nil
+	}
+
+	return "", fmt.Errorf("API key sees users of several orgs (%s), set org to pick one", strings.Join(orgs, ", "))
+}
@@ -0,0 +1,222 @@
+package tyk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// compatFields are the classic fields gateways only know since a version, older gateways
+// fail or misbehave on them so they are removed for older targets. CompatFields in the
+// config adds fields or changes the versions, "*" in a path matches any key or element
+var compatFields = map[string]string{
+	"use_mutual_tls_auth":   "v2.4.0",
+	"client_certificates":   "v2.4.0",
+	"upstream_certificates": "v2.4.0",
+	"pinned_public_keys":    "v2.6.0",
+}
+
+// compatDowngrades rewrite fields of newer definitions into the classic fields older
+// gateways read, they run for every target as the classic definition carries only the
+// older field
+var compatDowngrades = []struct {
+	field     string
+	downgrade func(doc map[string]interface{}) bool
+}{
+	{field: "auth_configs", downgrade: downgradeAuthConfigs},
+}
+
+// downgradeAuthConfigs uses the auth token config of auth_configs as auth when the
+// definition has none
+func downgradeAuthConfigs(doc map[string]interface{}) bool {
+	configs, ok := doc["auth_configs"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	delete(doc, "auth_configs")
+	if token, ok := configs["authToken"]; ok {
+		if _, set := doc["auth"]; !set {
+			doc["auth"] = token
+		}
+	}
+	return true
+}
+
+func validateCompat(c *TykConf) error {
+	versions := map[string]string{"targetVersion": c.TargetVersion}
+	for tag, v := range c.TagVersions {
+		versions["tagVersions."+tag] = v
+	}
+	for field, v := range c.CompatFields {
+		versions["compatFields."+field] = v
+	}
+
+	for k, v := range versions {
+		if _, ok := parseVersion(v); v != "" && !ok {
+			return fmt.Errorf("%s: %s is not a version", k, v)
+		}
+	}
+
+	return nil
+}
+
+// targetVersion is the gateway version a definition is written for, the oldest version
+// of the gateway tags it is loaded by, then the configured target and last the detected
+// version. In Dashboard mode the Dashboard version stands in for its gateways
+func targetVersion(tags []string) string {
+	c := conf()
+	target := ""
+	for _, t := range tags {
+		if v, ok := c.TagVersions[t]; ok && (target == "" || versionBelow(v, target)) {
+			target = v
+		}
+	}
+
+	if target == "" {
+		target = c.TargetVersion
+	}
+
+	if target == "" {
+		target = DetectedVersion()
+	}
+
+	return target
+}
+
+// definitionTags are the tags the definition is published with
+func definitionTags(opts *APIDefOptions, doc map[string]interface{}) []string {
+	tags := append([]string{}, opts.Tags...)
+	if raw, ok := doc["tags"].([]interface{}); ok {
+		for _, t := range raw {
+			if s, ok := t.(string); ok {
+				tags = append(tags, s)
+			}
+		}
+	}
+
+	return gatewayTags(&APIDefOptions{Tags: tags, Source: opts.Source})
+}
+
+// isSet tells if a value changes the definition, zero values are what older gateways
+// read for missing fields too
+func isSet(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case string:
+		return val != ""
+	case json.Number:
+		return val.String() != "0"
+	case map[string]interface{}:
+		return len(val) > 0
+	case []interface{}:
+		return len(val) > 0
+	}
+
+	return true
+}
+
+// removeField deletes the values at the path that are set and returns their paths
+func removeField(v interface{}, path []string, prefix string) []string {
+	removed := make([]string, 0)
+	switch val := v.(type) {
+	case map[string]interface{}:
+		keys := []string{path[0]}
+		if path[0] == "*" {
+			keys = keys[:0]
+			for k := range val {
+				keys = append(keys, k)
+			}
+		}
+
+		for _, k := range keys {
+			sub, ok := val[k]
+			if !ok {
+				continue
+			}
+
+			if len(path) > 1 {
+				removed = append(removed, removeField(sub, path[1:], prefix+k+".")...)
+				continue
+			}
+
+			delete(val, k)
+			if isSet(sub) {
+				removed = append(removed, prefix+k)
+			}
+		}
+	case []interface{}:
+		if path[0] == "*" && len(path) > 1 {
+			for _, sub := range val {
+				removed = append(removed, removeField(sub, path[1:], prefix)...)
+			}
+		}
+	}
+
+	return removed
+}
+
+// applyCompat downgrades and removes the fields of a rendered definition the target
+// gateway version doesn't know, so one template set serves gateways of several versions.
+// The returned paths are what was downgraded or dropped, they are also logged
+func applyCompat(opts *APIDefOptions, def string) (string, []string) {
+	dec := json.NewDecoder(strings.NewReader(def))
+	dec.UseNumber()
+	doc := map[string]interface{}{}
+	if err := dec.Decode(&doc); err != nil || isOASDocument(doc) {
+		// invalid JSON and OAS documents are reported by the callers
+		return def, nil
+	}
+
+	downgraded := make([]string, 0)
+	for _, d := range compatDowngrades {
+		if d.downgrade(doc) {
+			downgraded = append(downgraded, d.field)
+		}
+	}
+
+	dropped := make([]string, 0)
+	target := targetVersion(definitionTags(opts, doc))
+	if target != "" {
+		fields := map[string]string{}
+		for f, v := range compatFields {
+			fields[f] = v
+		}
+		for f, v := range conf().CompatFields {
+			fields[f] = v
+		}
+
+		for f, since := range fields {
+			if versionBelow(target, since) {
+				dropped = append(dropped, removeField(doc, strings.Split(f, "."), "")...)
+			}
+		}
+	}
+
+	if len(downgraded) == 0 && len(dropped) == 0 {
+		return def, nil
+	}
+	sort.Strings(dropped)
+
+	l := syncLogger(opts.SyncID)
+	if len(downgraded) > 0 {
+		l.Warningf("downgraded fields of %s to their classic equivalents: %s", cleanSlug(opts.Slug), strings.Join(downgraded, ", "))
+	}
+	if len(dropped) > 0 {
+		l.Warningf("dropping fields of %s that gateway %s doesn't know: %s", cleanSlug(opts.Slug), target, strings.Join(dropped, ", "))
+	}
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return def, nil
+	}
+
+	return buf.String(), append(downgraded, dropped...)
+}
@@ -0,0 +1,66 @@
+package tyk
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/TykTechnologies/tyk-git/clients/dashboard"
+	"github.com/TykTechnologies/tyk-git/clients/interfaces"
+)
+
+// CredentialsFunc returns the Dashboard secret of a namespace, an empty secret uses the
+// global one
+type CredentialsFunc func(namespace string) (string, error)
+
+var credsMu = sync.RWMutex{}
+var credentialsFor CredentialsFunc
+
+// SetCredentialsFunc lets namespaces use their own Dashboard secret, so changes made for
+// a team are limited by that team's Dashboard permissions
+func SetCredentialsFunc(f CredentialsFunc) {
+	credsMu.Lock()
+	defer credsMu.Unlock()
+	credentialsFor = f
+}
+
+// clientFor returns a client using the Dashboard secret of the namespace, or cl when the
+// namespace has none. A secret that can't be read fails rather than falling back, the
+// global secret would bypass the namespace permissions
+func clientFor(cl interfaces.UniversalClient, ns string) (interfaces.UniversalClient, error) {
+	c := conf()
+	credsMu.RLock()
+	f := credentialsFor
+	credsMu.RUnlock()
+
+	if f == nil || ns == "" || c.IsGateway {
+		return cl, nil
+	}
+
+	secret, err := f(ns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dashboard credentials of namespace %s: %v", ns, err)
+	}
+
+	if secret == "" || secret == c.Secret {
+		return cl, nil
+	}
+
+	nsCl, err := dashboard.NewDashboardClient(c.URL, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tyk API client: %v", err)
+	}
+
+	if c.InsecureSkipVerify {
+		nsCl.SetInsecureTLS(c.InsecureSkipVerify)
+	}
+
+	return throttle(nsCl), nil
+}
+
+func sourceNS(opts *APIDefOptions) string {
+	if opts.Source == nil {
+		return ""
+	}
+
+	return opts.Source.Namespace
+}
@@ -0,0 +1,39 @@
+package tyk
+
+// CacheStats returns the number of entries in the in-memory caches, for the runtime
+// stats endpoint
+func CacheStats() map[string]int {
+	stats := map[string]int{}
+
+	apiIndex.mu.Lock()
+	stats["api_index"] = len(apiIndex.bySlug)
+	apiIndex.mu.Unlock()
+
+	syncMu.Lock()
+	stats["sync_log"] = len(syncLog)
+	syncMu.Unlock()
+
+	basicMu.Lock()
+	stats["basic_auth_hashes"] = len(basicAuthHashes)
+	basicMu.Unlock()
+
+	policyMu.Lock()
+	stats["policy_hashes"] = len(policyHashes)
+	policyMu.Unlock()
+
+	docsMu.Lock()
+	stats["doc_hashes"] = len(docHashes)
+	docsMu.Unlock()
+
+	certMu.Lock()
+	stats["tracked_certificates"] = len(trackedCerts)
+	certMu.Unlock()
+
+	tplMu.RLock()
+	if templates != nil {
+		stats["templates"] = len(templates.Templates())
+	}
+	tplMu.RUnlock()
+
+	return stats
+}
@@ -0,0 +1,73 @@
+package tyk
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	shuttingDownMessage = "controller is shutting down"
+	defaultDrainTimeout = 30 * time.Second
+)
+
+// ErrShuttingDown is returned by writes started after Drain, it counts as unavailable so
+// callers with an offline queue keep the change for the next instance
+var ErrShuttingDown = errors.New(shuttingDownMessage)
+
+// writeGate tracks the API writes in flight so a shutdown can wait for them
+type writeGate struct {
+	mu       sync.Mutex
+	draining bool
+	inflight sync.WaitGroup
+}
+
+var writes = &writeGate{}
+
+func (g *writeGate) enter() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.draining {
+		return ErrShuttingDown
+	}
+
+	g.inflight.Add(1)
+	return nil
+}
+
+func (g *writeGate) leave() {
+	g.inflight.Done()
+}
+
+func drainTimeout() time.Duration {
+	c := conf()
+	if c == nil || c.DrainTimeoutSeconds <= 0 {
+		return defaultDrainTimeout
+	}
+
+	return time.Duration(c.DrainTimeoutSeconds) * time.Second
+}
+
+// Drain stops accepting API writes and waits for the ones in flight to finish, at most
+// for the drain timeout
+func Drain() error {
+	writes.mu.Lock()
+	writes.draining = true
+	writes.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		writes.inflight.Wait()
+		close(done)
+	}()
+
+	timeout := drainTimeout()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("API writes still in flight after %v", timeout)
+	}
+}
@@ -0,0 +1,136 @@
+package tyk
+
+import (
+	"strings"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// RequestFilters are request handling applied to every path of an API, they carry the
+// route filters of the Gateway API and other routing resources over to Tyk middleware
+type RequestFilters struct {
+	SetHeaders    map[string]string
+	RemoveHeaders []string
+	// RewritePath replaces the path sent upstream, when RewritePrefix is set only the
+	// listen path is replaced and the rest of the request path is kept
+	RewritePath   string
+	RewritePrefix bool
+	// RewritePattern only rewrites requests matching it and lets RewritePath use its
+	// groups as $1, the listen path is kept so the pattern sees the whole request path
+	RewritePattern string
+	// RedirectTo answers every request with a redirect instead of proxying it
+	RedirectTo   string
+	RedirectCode int
+	// TimeoutSeconds is a hard timeout for upstream requests
+	TimeoutSeconds int
+	// CircuitBreaker takes the upstream out of service when too many requests fail
+	CircuitBreaker *CircuitBreaker
+	// SizeLimit rejects request bodies larger than the limit in bytes
+	SizeLimit int64
+	// AllowedIPs only lets the listed addresses and ranges through
+	AllowedIPs []string
+}
+
+type CircuitBreaker struct {
+	ThresholdPercent     float64
+	Samples              int64
+	ReturnToServiceAfter int
+}
+
+// applyFilters uses global headers for header changes, a URL rewrite for path changes
+// and a reply action for redirects
+func applyFilters(def *apidef.APIDefinition, f *RequestFilters) {
+	if f == nil {
+		return
+	}
+
+	if len(f.AllowedIPs) > 0 {
+		def.EnableIpWhiteListing = true
+		def.AllowedIPs = append(def.AllowedIPs, f.AllowedIPs...)
+	}
+
+	for vName, v := range def.VersionData.Versions {
+		if f.SizeLimit > 0 {
+			v.GlobalSizeLimit = f.SizeLimit
+		}
+
+		if len(f.SetHeaders) > 0 {
+			if v.GlobalHeaders == nil {
+				v.GlobalHeaders = map[string]string{}
+			}
+			for k, val := range f.SetHeaders {
+				v.GlobalHeaders[k] = val
+			}
+		}
+		v.GlobalHeadersRemove = append(v.GlobalHeadersRemove, f.RemoveHeaders...)
+
+		if f.RewritePath != "" {
+			v.UseExtendedPaths = true
+			// relative rewrites keep the upstream, so load balancing still applies
+			rewrite := "/" + strings.TrimLeft(f.RewritePath, "/")
+			pattern := ".*"
+			switch {
+			case f.RewritePattern != "":
+				pattern = f.RewritePattern
+				def.Proxy.StripListenPath = false
+			case f.RewritePrefix:
+				rewrite = strings.TrimRight(rewrite, "/") + "$1"
+				pattern = "^(/.*)?$"
+			}
+
+			for _, m := range routedMethods {
+				v.ExtendedPaths.URLRewrite = append(v.ExtendedPaths.URLRewrite, apidef.URLRewriteMeta{
+					Path:         "/",
+					Method:       m,
+					MatchPattern: pattern,
+					RewriteTo:    rewrite,
+				})
+			}
+		}
+
+		if f.RedirectTo != "" {
+			v.UseExtendedPaths = true
+			code := f.RedirectCode
+			if code == 0 {
+				code = 302
+			}
+
+			actions := map[string]apidef.EndpointMethodMeta{}
+			for _, m := range routedMethods {
+				actions[m] = apidef.EndpointMethodMeta{
+					Action:  apidef.Reply,
+					Code:    code,
+					Headers: map[string]string{"Location": f.RedirectTo},
+				}
+			}
+			v.ExtendedPaths.Ignored = append(v.ExtendedPaths.Ignored, apidef.EndPointMeta{
+				Path:          "/",
+				MethodActions: actions,
+			})
+		}
+
+		for _, m := range routedMethods {
+			if f.TimeoutSeconds > 0 {
+				v.UseExtendedPaths = true
+				v.ExtendedPaths.HardTimeouts = append(v.ExtendedPaths.HardTimeouts, apidef.HardTimeoutMeta{
+					Path:    "/",
+					Method:  m,
+					TimeOut: f.TimeoutSeconds,
+				})
+			}
+
+			if cb := f.CircuitBreaker; cb != nil {
+				v.UseExtendedPaths = true
+				v.ExtendedPaths.CircuitBreaker = append(v.ExtendedPaths.CircuitBreaker, apidef.CircuitBreakerMeta{
+					Path:                 "/",
+					Method:               m,
+					ThresholdPercent:     cb.ThresholdPercent,
+					Samples:              cb.Samples,
+					ReturnToServiceAfter: cb.ReturnToServiceAfter,
+				})
+			}
+		}
+
+		def.VersionData.Versions[vName] = v
+	}
+}
@@ -0,0 +1,118 @@
+package tyk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// HookConf configures an external service that receives every rendered definition before
+// it is published, the service can return a modified definition or reject it
+type HookConf struct {
+	URL            string `yaml:"url"`
+	TimeoutSeconds int    `yaml:"timeoutSeconds"`
+	// FailOpen publishes the definition unchanged when the hook can not be reached
+	FailOpen bool `yaml:"failOpen"`
+}
+
+// SourceMeta describes the object that an API was generated from
+type SourceMeta struct {
+	Kind        string            `json:"kind"`
+	Namespace   string            `json:"namespace"`
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// HookRequest is the body sent to the post-process hook
+type HookRequest struct {
+	Slug       string          `json:"slug"`
+	Source     *SourceMeta     `json:"source,omitempty"`
+	Definition json.RawMessage `json:"definition"`
+}
+
+// HookResponse is returned by the post-process hook, an empty definition keeps the
+// rendered one
+type HookResponse struct {
+	Allowed    bool            `json:"allowed"`
+	Reason     string          `json:"reason"`
+	Definition json.RawMessage `json:"definition"`
+}
+
+const defaultHookTimeout = 10 * time.Second
+
+func callPostProcessHook(def string, opts *APIDefOptions) (string, error) {
+	c := conf()
+	if c == nil || c.PostProcessHook == nil || c.PostProcessHook.URL == "" {
+		return def, nil
+	}
+
+	hc := c.PostProcessHook
+	resp, err := postToHook(hc, &HookRequest{
+		Slug:       cleanSlug(opts.Slug),
+		Source:     opts.Source,
+		Definition: json.RawMessage(def),
+	})
+	if err != nil {
+		if hc.FailOpen {
+			log.Warning("post-process hook failed, publishing unchanged definition: ", err)
+			return def, nil
+		}
+
+		return def, fmt.Errorf("post-process hook failed: %v", err)
+	}
+
+	if !resp.Allowed {
+		return def, fmt.Errorf("API %s rejected by post-process hook: %s", opts.Slug, resp.Reason)
+	}
+
+	if len(resp.Definition) == 0 {
+		return def, nil
+	}
+
+	return string(resp.Definition), nil
+}
+
+func postToHook(hc *HookConf, req *HookRequest) (*HookResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := defaultHookTimeout
+	if hc.TimeoutSeconds > 0 {
+		timeout = time.Duration(hc.TimeoutSeconds) * time.Second
+	}
+
+	cl := &http.Client{Timeout: timeout}
+	r, err := cl.Post(hc.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	rBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d: %s", r.StatusCode, string(rBody))
+	}
+
+	resp := &HookResponse{}
+	err = json.Unmarshal(rBody, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Definition) > 0 && !json.Valid(resp.Definition) {
+		return nil, errors.New("hook returned an invalid definition")
+	}
+
+	return resp, nil
+}
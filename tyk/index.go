@@ -0,0 +1,81 @@
+package tyk
+
+import (
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk-git/clients/interfaces"
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+)
+
+const defaultLookupCache = 10 * time.Second
+
+// slugIndex caches the API list by slug so a burst of slug lookups costs a single
+// fetch, writes made through this package invalidate it. A slug can match several APIs,
+// failed syncs leave duplicates behind
+type slugIndex struct {
+	mu      sync.Mutex
+	fetched time.Time
+	bySlug  map[string][]objects.DBApiDefinition
+}
+
+var apiIndex = &slugIndex{}
+
+func lookupTTL() time.Duration {
+	c := conf()
+	if c == nil || c.LookupCacheSeconds == 0 {
+		return defaultLookupCache
+	}
+
+	if c.LookupCacheSeconds < 0 {
+		return 0
+	}
+
+	return time.Duration(c.LookupCacheSeconds) * time.Second
+}
+
+func (i *slugIndex) set(apis []objects.DBApiDefinition) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.bySlug = make(map[string][]objects.DBApiDefinition, len(apis))
+	for _, a := range apis {
+		i.bySlug[a.Slug] = append(i.bySlug[a.Slug], a)
+	}
+	i.fetched = time.Now()
+}
+
+func (i *slugIndex) invalidate() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.fetched = time.Time{}
+}
+
+// lookup finds the APIs with a cleaned slug, refreshing the index when it is stale.
+// Misses are never served from the index: other shards and replicas create APIs this
+// one doesn't invalidate for, and a stale miss would create a duplicate. Stale hits
+// fail their write, which invalidates the index
+func (i *slugIndex) lookup(cl interfaces.UniversalClient, cSlug string) ([]objects.DBApiDefinition, error) {
+	i.mu.Lock()
+	fresh := !i.fetched.IsZero() && time.Since(i.fetched) < lookupTTL()
+	if matches := i.bySlug[cSlug]; fresh && len(matches) > 0 {
+		i.mu.Unlock()
+		return matches, nil
+	}
+	i.mu.Unlock()
+
+	apis, err := cl.FetchAPIs()
+	if err != nil {
+		return nil, err
+	}
+
+	i.set(apis)
+	matches := make([]objects.DBApiDefinition, 0, 1)
+	for _, a := range apis {
+		if a.Slug == cSlug {
+			matches = append(matches, a)
+		}
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,122 @@
+package tyk
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// SourceKey is the config data key that records the object an API was generated from
+const SourceKey = "tyk-k8s-source"
+
+// InventoryEntry is the controller's view of a managed API
+type InventoryEntry struct {
+	Slug   string `json:"slug"`
+	APIID  string `json:"api_id"`
+	Name   string `json:"name"`
+	Source string `json:"source,omitempty"`
+	// LastSync and Hash are only known for APIs synced since the controller started, or
+	// restored with SeedInventory
+	LastSync string `json:"last_sync,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+type syncRecord struct {
+	at   string
+	hash string
+}
+
+var syncMu = sync.Mutex{}
+var syncLog = map[string]syncRecord{}
+
+func sourceRef(s *SourceMeta) string {
+	if s == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s/%s/%s", s.Kind, s.Namespace, s.Name)
+}
+
+func markSource(def *apidef.APIDefinition, s *SourceMeta) {
+	if s == nil {
+		return
+	}
+
+	if def.ConfigData == nil {
+		def.ConfigData = map[string]interface{}{}
+	}
+
+	def.ConfigData[SourceKey] = sourceRef(s)
+}
+
+// definitionHash hashes a rendered definition before Tyk identities are applied, so the
+// hash only changes when the generated definition does
+func definitionHash(def *apidef.APIDefinition) string {
+	raw, err := json.Marshal(def)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(raw))
+}
+
+// recordSync remembers when a definition was pushed and the hash of what was pushed
+func recordSync(slug, hash string) {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+	syncLog[slug] = syncRecord{at: time.Now().UTC().Format(time.RFC3339), hash: hash}
+}
+
+// SeedInventory restores the sync times and hashes of a previously written inventory,
+// entries already synced by this process are kept
+func SeedInventory(entries []InventoryEntry) {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+	for _, e := range entries {
+		if _, ok := syncLog[e.Slug]; ok || e.LastSync == "" {
+			continue
+		}
+		syncLog[e.Slug] = syncRecord{at: e.LastSync, hash: e.Hash}
+	}
+}
+
+// Inventory lists every managed API sorted by slug, APIs removed from Tyk are dropped
+func Inventory() ([]InventoryEntry, error) {
+	apis, err := ListManaged()
+	if err != nil {
+		return nil, err
+	}
+
+	syncMu.Lock()
+	defer syncMu.Unlock()
+
+	live := map[string]struct{}{}
+	entries := make([]InventoryEntry, 0, len(apis))
+	for _, a := range apis {
+		live[a.Slug] = struct{}{}
+		src, _ := a.ConfigData[SourceKey].(string)
+		rec := syncLog[a.Slug]
+		entries = append(entries, InventoryEntry{
+			Slug:     a.Slug,
+			APIID:    a.APIID,
+			Name:     a.Name,
+			Source:   src,
+			LastSync: rec.at,
+			Hash:     rec.hash,
+		})
+	}
+
+	for slug := range syncLog {
+		if _, ok := live[slug]; !ok {
+			delete(syncLog, slug)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Slug < entries[j].Slug })
+	return entries, nil
+}
@@ -0,0 +1,172 @@
+package tyk
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+)
+
+// Keys are managed through the Dashboard or gateway API directly, the vendored clients
+// only handle APIs and policies
+
+const (
+	endpointDashKeys = "/api/keys"
+	endpointGwKeys   = "/tyk/keys"
+)
+
+// KeySpec is the session of a gateway key, access rights, rates and quotas come from the
+// policies it applies or straight from the APIs
+type KeySpec struct {
+	Alias    string
+	Policies []string
+	APIs     []objects.DBApiDefinition
+	// Expires is a unix timestamp, 0 never expires
+	Expires  int64
+	MetaData map[string]string
+	// HMAC lets the key sign requests, HMACSecret keeps the secret of an existing key
+	HMAC       bool
+	HMACSecret string
+}
+
+// Key is a created key, Hash is empty when the gateway doesn't hash keys
+type Key struct {
+	Key  string
+	Hash string
+}
+
+type keyResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	KeyID   string `json:"key_id"`
+	Key     string `json:"key"`
+	KeyHash string `json:"key_hash"`
+}
+
+func gatewayMode() bool {
+	c := conf()
+	return c != nil && c.IsGateway
+}
+
+func org() string {
+	c := conf()
+	if c == nil {
+		return ""
+	}
+
+	return c.Org
+}
+
+func (s *KeySpec) session() map[string]interface{} {
+	meta := map[string]interface{}{}
+	for k, v := range s.MetaData {
+		meta[k] = v
+	}
+
+	session := map[string]interface{}{
+		"org_id":         org(),
+		"alias":          s.Alias,
+		"apply_policies": s.Policies,
+		"expires":        s.Expires,
+		"meta_data":      meta,
+		"access_rights":  accessRights(s.APIs),
+	}
+
+	if s.HMAC {
+		session["hmac_enabled"] = true
+		if s.HMACSecret != "" {
+			session["hmac_string"] = s.HMACSecret
+		}
+	}
+
+	return session
+}
+
+func keyPath(id string, hashed bool) string {
+	p := endpointDashKeys
+	if gatewayMode() {
+		p = endpointGwKeys
+	}
+
+	p += "/" + url.PathEscape(id)
+	if hashed {
+		p += "?hashed=true"
+	}
+
+	return p
+}
+
+// CreateKey creates a key applying the policies of the spec
+func CreateKey(s *KeySpec) (*Key, error) {
+	if len(s.Policies) == 0 && len(s.APIs) == 0 {
+		return nil, errors.New("a key needs at least one policy or API")
+	}
+
+	p := endpointDashKeys
+	if gatewayMode() {
+		p = endpointGwKeys + "/create"
+	}
+
+	resp := &keyResponse{}
+	if err := adminRequest(http.MethodPost, p, s.session(), resp); err != nil {
+		return nil, fmt.Errorf("failed to create key: %v", err)
+	}
+
+	k := &Key{Key: resp.KeyID, Hash: resp.KeyHash}
+	if k.Key == "" {
+		k.Key = resp.Key
+	}
+
+	if k.Key == "" {
+		return nil, fmt.Errorf("key request completed, but with error: %v", resp.Message)
+	}
+
+	return k, nil
+}
+
+// UpdateKey replaces the session of a key, id is the key hash when hashed is set
+func UpdateKey(id string, hashed bool, s *KeySpec) error {
+	if err := adminRequest(http.MethodPut, keyPath(id, hashed), s.session(), nil); err != nil {
+		return fmt.Errorf("failed to update key: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteKey revokes a key, keys that are already gone are not an error
+func DeleteKey(id string, hashed bool) error {
+	err := adminRequest(http.MethodDelete, keyPath(id, hashed), nil, nil)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete key: %v", err)
+	}
+
+	return nil
+}
+
+// KeyHMACSecret returns the secret Tyk generated for an HMAC enabled key
+func KeyHMACSecret(key string) (string, error) {
+	session := struct {
+		HMACSecret string `json:"hmac_string"`
+		// the Dashboard wraps the session
+		Data *struct {
+			HMACSecret string `json:"hmac_string"`
+		} `json:"data"`
+	}{}
+
+	if err := adminRequest(http.MethodGet, keyPath(key, false), nil, &session); err != nil {
+		return "", fmt.Errorf("failed to read key: %v", err)
+	}
+
+	secret := session.HMACSecret
+	if session.Data != nil {
+		secret = session.Data.HMACSecret
+	}
+
+	if secret == "" {
+		return "", errors.New("the key has no HMAC secret")
+	}
+
+	return secret, nil
+}
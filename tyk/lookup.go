@@ -0,0 +1,135 @@
+package tyk
+
+import (
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const (
+	// ManagedByKey is the config data key that marks APIs generated by the controller
+	ManagedByKey     = "tyk-k8s-managed-by"
+	defaultManagedBy = "tyk-k8s"
+)
+
+// managedBy is the marker of this controller, the cluster name is part of the default so
+// clusters sharing a Dashboard don't garbage collect each other's APIs
+func managedBy() string {
+	c := conf()
+	switch {
+	case c == nil:
+		return defaultManagedBy
+	case c.ManagedBy != "":
+		return c.ManagedBy
+	case c.ClusterName != "":
+		return defaultManagedBy + "/" + c.ClusterName
+	}
+
+	return defaultManagedBy
+}
+
+// legacyManagedBy is the marker APIs got before the cluster name was part of the
+// default. They are still recognised so upgrading doesn't orphan them, and take the new
+// marker on their next update
+func legacyManagedBy() string {
+	c := conf()
+	if c == nil || c.ManagedBy != "" || c.ClusterName == "" {
+		return ""
+	}
+
+	return defaultManagedBy
+}
+
+func markManaged(def *apidef.APIDefinition) {
+	if def.ConfigData == nil {
+		def.ConfigData = map[string]interface{}{}
+	}
+
+	def.ConfigData[ManagedByKey] = managedBy()
+}
+
+// IsManaged reports whether the API was generated by this controller
+func IsManaged(def *apidef.APIDefinition) bool {
+	v, ok := def.ConfigData[ManagedByKey].(string)
+	if !ok || v == "" {
+		return false
+	}
+
+	return v == managedBy() || v == legacyManagedBy()
+}
+
+func fetchAll() ([]objects.DBApiDefinition, error) {
+	cl, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	apis, err := cl.FetchAPIs()
+	if err != nil {
+		return nil, err
+	}
+
+	apiIndex.set(apis)
+	return apis, nil
+}
+
+func hasTag(def *apidef.APIDefinition, tag string) bool {
+	for _, t := range def.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ListByTag returns every API with the gateway tag
+func ListByTag(tag string) ([]objects.DBApiDefinition, error) {
+	apis, err := fetchAll()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]objects.DBApiDefinition, 0)
+	for _, a := range apis {
+		if hasTag(&a.APIDefinition, tag) {
+			found = append(found, a)
+		}
+	}
+
+	return found, nil
+}
+
+// ListManaged returns every API generated by this controller
+func ListManaged() ([]objects.DBApiDefinition, error) {
+	apis, err := fetchAll()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]objects.DBApiDefinition, 0)
+	for _, a := range apis {
+		if IsManaged(&a.APIDefinition) {
+			found = append(found, a)
+		}
+	}
+
+	return found, nil
+}
+
+// GroupByTag groups APIs by each of their gateway tags, untagged APIs are grouped under
+// an empty tag
+func GroupByTag(apis []objects.DBApiDefinition) map[string][]objects.DBApiDefinition {
+	groups := map[string][]objects.DBApiDefinition{}
+	for _, a := range apis {
+		if len(a.Tags) == 0 {
+			groups[""] = append(groups[""], a)
+			continue
+		}
+
+		for _, t := range a.Tags {
+			groups[t] = append(groups[t], a)
+		}
+	}
+
+	return groups
+}
@@ -0,0 +1,288 @@
+package tyk
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk-git/clients/interfaces"
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// Every call to the Dashboard or gateway API is timed per endpoint and failures are
+// counted by class, so a slow or failing Dashboard can be told apart from bad requests
+// of the controller
+const (
+	ErrorTimeout = "timeout"
+	ErrorAuth    = "auth"
+	Error4xx     = "4xx"
+	Error5xx     = "5xx"
+	ErrorNetwork = "network"
+	ErrorOther   = "other"
+)
+
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// knownEndpoints label request paths without the IDs in them
+var knownEndpoints = []string{
+	endpointDashKeys, endpointGwKeys, endpointDashOAuth, endpointGwOAuth, endpointDashBasic,
+	endpointPolicies, endpointDashCache, endpointGwCache, endpointCatalogue, endpointDocs,
+	endpointGwOrgKeys,
+}
+
+type requestKey struct {
+	endpoint string
+	method   string
+}
+
+type latency struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+var requestMu = sync.Mutex{}
+var requestLatency = map[requestKey]*latency{}
+var requestErrors = map[requestKey]map[string]uint64{}
+
+var statusCode = regexp.MustCompile(`code: (\d{3})`)
+
+// authMessages are the bodies the Dashboard and gateway reject bad secrets with, the API
+// clients drop the status code of most responses
+var authMessages = []string{
+	"not authorised",
+	"unauthorized",
+	"forbidden",
+	"invalid or missing key",
+}
+
+func endpointLabel(path string) string {
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+
+	label := ""
+	for _, e := range knownEndpoints {
+		if strings.HasPrefix(path, e) && len(e) > len(label) {
+			label = e
+		}
+	}
+
+	if label == "" {
+		return path
+	}
+
+	return label
+}
+
+func statusClass(code int) string {
+	switch {
+	case code == 401 || code == 403:
+		return ErrorAuth
+	case code >= 500:
+		return Error5xx
+	case code >= 400:
+		return Error4xx
+	}
+
+	return ""
+}
+
+// errorClass sorts a failed request, status is 0 when only the error is known
+func errorClass(status int, err error) string {
+	if c := statusClass(status); c != "" {
+		return c
+	}
+
+	if err == nil {
+		return ""
+	}
+
+	e := err
+	if uErr, ok := e.(*url.Error); ok {
+		e = uErr.Err
+	}
+
+	if nErr, ok := e.(net.Error); ok {
+		if nErr.Timeout() {
+			return ErrorTimeout
+		}
+		return ErrorNetwork
+	}
+
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "timeout") || strings.Contains(msg, "deadline exceeded") {
+		return ErrorTimeout
+	}
+
+	if m := statusCode.FindStringSubmatch(msg); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		if c := statusClass(code); c != "" {
+			return c
+		}
+	}
+
+	for _, m := range authMessages {
+		if strings.Contains(msg, m) {
+			return ErrorAuth
+		}
+	}
+
+	if IsUnavailable(err) {
+		return ErrorNetwork
+	}
+
+	return ErrorOther
+}
+
+func observeRequest(method, path string, start time.Time, status int, err error) {
+	k := requestKey{endpoint: endpointLabel(path), method: method}
+	secs := time.Since(start).Seconds()
+
+	requestMu.Lock()
+	defer requestMu.Unlock()
+
+	l, ok := requestLatency[k]
+	if !ok {
+		l = &latency{buckets: make([]uint64, len(latencyBuckets))}
+		requestLatency[k] = l
+	}
+
+	for i, b := range latencyBuckets {
+		if secs <= b {
+			l.buckets[i]++
+		}
+	}
+	l.sum += secs
+	l.count++
+
+	if c := errorClass(status, err); c != "" {
+		if requestErrors[k] == nil {
+			requestErrors[k] = map[string]uint64{}
+		}
+		requestErrors[k][c]++
+	}
+}
+
+func resetRequestMetrics() {
+	requestMu.Lock()
+	defer requestMu.Unlock()
+
+	requestLatency = map[requestKey]*latency{}
+	requestErrors = map[requestKey]map[string]uint64{}
+}
+
+// WriteRequestMetrics writes the API request latencies and errors in the Prometheus text
+// format
+func WriteRequestMetrics(w io.Writer) error {
+	requestMu.Lock()
+	keys := make([]requestKey, 0, len(requestLatency))
+	for k := range requestLatency {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	lines := []string{
+		"# HELP tyk_k8s_api_request_duration_seconds Latency of Dashboard and gateway API requests.",
+		"# TYPE tyk_k8s_api_request_duration_seconds histogram",
+	}
+	for _, k := range keys {
+		l := requestLatency[k]
+		labels := fmt.Sprintf(`endpoint="%s",method="%s"`, metricLabel(k.endpoint), k.method)
+		for i, b := range latencyBuckets {
+			lines = append(lines, fmt.Sprintf(`tyk_k8s_api_request_duration_seconds_bucket{%s,le="%v"} %d`, labels, b, l.buckets[i]))
+		}
+		lines = append(lines,
+			fmt.Sprintf(`tyk_k8s_api_request_duration_seconds_bucket{%s,le="+Inf"} %d`, labels, l.count),
+			fmt.Sprintf(`tyk_k8s_api_request_duration_seconds_sum{%s} %v`, labels, l.sum),
+			fmt.Sprintf(`tyk_k8s_api_request_duration_seconds_count{%s} %d`, labels, l.count),
+		)
+	}
+
+	lines = append(lines,
+		"# HELP tyk_k8s_api_request_errors_total Failed Dashboard and gateway API requests by class.",
+		"# TYPE tyk_k8s_api_request_errors_total counter",
+	)
+	for _, k := range keys {
+		classes := make([]string, 0, len(requestErrors[k]))
+		for c := range requestErrors[k] {
+			classes = append(classes, c)
+		}
+		sort.Strings(classes)
+
+		for _, c := range classes {
+			lines = append(lines, fmt.Sprintf(`tyk_k8s_api_request_errors_total{endpoint="%s",method="%s",class="%s"} %d`,
+				metricLabel(k.endpoint), k.method, c, requestErrors[k][c]))
+		}
+	}
+	requestMu.Unlock()
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// instrumentedClient times the calls of the vendored API clients, they only return
+// errors so the class is read from the error message
+type instrumentedClient struct {
+	interfaces.UniversalClient
+}
+
+func instrument(cl interfaces.UniversalClient) interfaces.UniversalClient {
+	return &instrumentedClient{UniversalClient: cl}
+}
+
+func clientEndpoint(resource string) string {
+	if gatewayMode() {
+		return "/tyk/" + resource
+	}
+
+	return "/api/" + resource
+}
+
+func (c *instrumentedClient) CreateAPI(def *apidef.APIDefinition) (string, error) {
+	start := time.Now()
+	id, err := c.UniversalClient.CreateAPI(def)
+	observeRequest("POST", clientEndpoint("apis"), start, 0, err)
+	return id, err
+}
+
+func (c *instrumentedClient) FetchAPIs() ([]objects.DBApiDefinition, error) {
+	start := time.Now()
+	apis, err := c.UniversalClient.FetchAPIs()
+	observeRequest("GET", clientEndpoint("apis"), start, 0, err)
+	return apis, schemaError(err)
+}
+
+func (c *instrumentedClient) UpdateAPI(def *apidef.APIDefinition) error {
+	start := time.Now()
+	err := c.UniversalClient.UpdateAPI(def)
+	observeRequest("PUT", clientEndpoint("apis"), start, 0, err)
+	return err
+}
+
+func (c *instrumentedClient) DeleteAPI(id string) error {
+	start := time.Now()
+	err := c.UniversalClient.DeleteAPI(id)
+	observeRequest("DELETE", clientEndpoint("apis"), start, 0, err)
+	return err
+}
+
+func (c *instrumentedClient) CreateCertificate(cert []byte) (string, error) {
+	start := time.Now()
+	id, err := c.UniversalClient.CreateCertificate(cert)
+	observeRequest("POST", clientEndpoint("certs"), start, 0, err)
+	return id, err
+}
@@ -0,0 +1,158 @@
+package tyk
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const (
+	endpointDashOAuth = "/api/apis/oauth"
+	endpointGwOAuth   = "/tyk/oauth/clients"
+)
+
+// OAuthClientSpec is an OAuth2 client of an API, tokens issued to it get the access of
+// the policy
+type OAuthClientSpec struct {
+	RedirectURIs []string
+	PolicyID     string
+	Description  string
+	MetaData     map[string]string
+}
+
+// OAuthClient is a registered client and the secret it authenticates with
+type OAuthClient struct {
+	ClientID string
+	Secret   string
+}
+
+type oauthClientResponse struct {
+	ClientID string `json:"client_id"`
+	Secret   string `json:"secret"`
+}
+
+func (s *OAuthClientSpec) body(apiID string) map[string]interface{} {
+	meta := map[string]interface{}{}
+	for k, v := range s.MetaData {
+		meta[k] = v
+	}
+
+	b := map[string]interface{}{
+		// Tyk accepts several redirect URIs separated by semicolons
+		"redirect_uri": strings.Join(s.RedirectURIs, ";"),
+		"policy_id":    s.PolicyID,
+		"description":  s.Description,
+		"meta_data":    meta,
+	}
+
+	if gatewayMode() {
+		b["api_id"] = apiID
+	}
+
+	return b
+}
+
+func oauthPath(apiID, clientID string) string {
+	p := endpointDashOAuth
+	if gatewayMode() {
+		p = endpointGwOAuth
+	}
+
+	return p + "/" + url.PathEscape(apiID) + "/" + url.PathEscape(clientID)
+}
+
+// OAuthAPI returns the managed API of the slug an OAuth client may be registered with,
+// the API needs OAuth2 enabled and clients only attach to APIs of their own namespace
+func OAuthAPI(slug, namespace string) (*objects.DBApiDefinition, error) {
+	def, err := GetBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsManaged(&def.APIDefinition) || sourceNamespace(def) != namespace {
+		return nil, fmt.Errorf("API %s is not managed for namespace %s", slug, namespace)
+	}
+
+	if !def.UseOauth2 {
+		return nil, fmt.Errorf("API %s does not use OAuth2", slug)
+	}
+
+	return def, nil
+}
+
+// CheckGrantTypes fails when the API doesn't allow one of the grant types, Tyk sets the
+// allowed grants per API rather than per client
+func CheckGrantTypes(def *apidef.APIDefinition, grants []string) error {
+	access := map[string]bool{}
+	for _, t := range def.Oauth2Meta.AllowedAccessTypes {
+		access[string(t)] = true
+	}
+
+	authorize := map[string]bool{}
+	for _, t := range def.Oauth2Meta.AllowedAuthorizeTypes {
+		authorize[string(t)] = true
+	}
+
+	for _, g := range grants {
+		ok := false
+		switch g {
+		case "implicit":
+			ok = authorize["token"]
+		case "authorization_code":
+			ok = authorize["code"] && access[g]
+		case "refresh_token", "password", "client_credentials":
+			ok = access[g]
+		default:
+			return fmt.Errorf("unknown grant type %s", g)
+		}
+
+		if !ok {
+			return fmt.Errorf("API %s does not allow the %s grant", def.Slug, g)
+		}
+	}
+
+	return nil
+}
+
+// CreateOAuthClient registers a client with the API
+func CreateOAuthClient(apiID string, s *OAuthClientSpec) (*OAuthClient, error) {
+	p := endpointDashOAuth + "/" + url.PathEscape(apiID)
+	if gatewayMode() {
+		p = endpointGwOAuth + "/create"
+	}
+
+	resp := &oauthClientResponse{}
+	if err := adminRequest(http.MethodPost, p, s.body(apiID), resp); err != nil {
+		return nil, fmt.Errorf("failed to create oauth client: %v", err)
+	}
+
+	if resp.ClientID == "" || resp.Secret == "" {
+		return nil, errors.New("oauth client request completed, but returned no client")
+	}
+
+	return &OAuthClient{ClientID: resp.ClientID, Secret: resp.Secret}, nil
+}
+
+// UpdateOAuthClient replaces the redirect URIs, policy and metadata of a client
+func UpdateOAuthClient(apiID, clientID string, s *OAuthClientSpec) error {
+	if err := adminRequest(http.MethodPut, oauthPath(apiID, clientID), s.body(apiID), nil); err != nil {
+		return fmt.Errorf("failed to update oauth client: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteOAuthClient removes a client, clients that are already gone are not an error
+func DeleteOAuthClient(apiID, clientID string) error {
+	err := adminRequest(http.MethodDelete, oauthPath(apiID, clientID), nil, nil)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete oauth client: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package tyk
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Org limits live in the org session of the gateway, the Dashboard API has no call for
+// them so a gateway API is needed in Dashboard mode
+const endpointGwOrgKeys = "/tyk/org/keys"
+
+// GatewayAPI addresses the gateway API next to a Dashboard
+type GatewayAPI struct {
+	URL    string
+	Secret string
+}
+
+// OrgLimits are the aggregate limits of every key of an organisation, a zero Per or
+// QuotaMax leaves the rate or quota unlimited
+type OrgLimits struct {
+	Rate             float64
+	Per              float64
+	QuotaMax         int64
+	QuotaRenewalRate int64
+}
+
+func orgRequest(gw *GatewayAPI, method, orgID string, in, out interface{}) error {
+	p := endpointGwOrgKeys + "/" + url.PathEscape(orgID)
+	if gw == nil || gw.URL == "" {
+		if !gatewayMode() {
+			return errors.New("org limits need the gateway API, none is configured next to the Dashboard")
+		}
+		return adminRequest(method, p, in, out)
+	}
+
+	return apiRequest(gw.URL, "x-tyk-authorization", gw.Secret, method, p, in, out)
+}
+
+// orgLimitsSource returns the source recorded in the org session, found is false when the
+// org has none
+func orgLimitsSource(gw *GatewayAPI, orgID string) (string, bool, error) {
+	session := struct {
+		MetaData map[string]interface{} `json:"meta_data"`
+	}{}
+
+	err := orgRequest(gw, http.MethodGet, orgID, nil, &session)
+	if isNotFound(err) {
+		return "", false, nil
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	src, _ := session.MetaData[SourceKey].(string)
+	return src, true, nil
+}
+
+// SetOrgLimits writes the org session of the organisation, sessions the source didn't
+// write are refused so limits set by hand are not taken over
+func SetOrgLimits(gw *GatewayAPI, orgID, source string, l *OrgLimits) error {
+	src, exists, err := orgLimitsSource(gw, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to read org limits of %s: %v", orgID, err)
+	}
+
+	if exists && src != source {
+		return fmt.Errorf("org %s already has limits not managed by %s", orgID, source)
+	}
+
+	// -1 is unlimited for the gateway
+	rate, per := float64(-1), float64(-1)
+	if l.Per > 0 {
+		rate, per = l.Rate, l.Per
+	}
+
+	quota, renewal := int64(-1), int64(-1)
+	if l.QuotaMax != 0 {
+		quota, renewal = l.QuotaMax, l.QuotaRenewalRate
+	}
+
+	session := map[string]interface{}{
+		"org_id":             orgID,
+		"rate":               rate,
+		"per":                per,
+		"allowance":          rate,
+		"quota_max":          quota,
+		"quota_remaining":    quota,
+		"quota_renewal_rate": renewal,
+		"meta_data":          map[string]interface{}{SourceKey: source},
+	}
+
+	if err := orgRequest(gw, http.MethodPut, orgID, session, nil); err != nil {
+		return fmt.Errorf("failed to write org limits of %s: %v", orgID, err)
+	}
+
+	return nil
+}
+
+// DeleteOrgLimits removes the org session when the source wrote it
+func DeleteOrgLimits(gw *GatewayAPI, orgID, source string) error {
+	src, exists, err := orgLimitsSource(gw, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to read org limits of %s: %v", orgID, err)
+	}
+
+	if !exists || src != source {
+		return nil
+	}
+
+	err = orgRequest(gw, http.MethodDelete, orgID, nil, nil)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete org limits of %s: %v", orgID, err)
+	}
+
+	return nil
+}
+
+// OrgID is the organisation the controller manages APIs in
+func OrgID() string {
+	return org()
+}
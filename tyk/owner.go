@@ -0,0 +1,45 @@
+package tyk
+
+import (
+	"strings"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// OwnerTeamKey is the annotation, or label, naming the team that owns the source of an API.
+// The team becomes a Dashboard API category so the APIs of a team can be filtered and
+// granted together
+const OwnerTeamKey = "tyk.io/owner-team"
+
+// ownerTeam returns the team of the source as a category, the annotation wins over the
+// label. Categories end at whitespace and start with "#" in the API name, those are
+// replaced
+func ownerTeam(s *SourceMeta) string {
+	if s == nil {
+		return ""
+	}
+
+	team, ok := s.Annotations[OwnerTeamKey]
+	if !ok {
+		team = s.Labels[OwnerTeamKey]
+	}
+
+	return strings.Join(strings.Fields(strings.Replace(team, "#", " ", -1)), "-")
+}
+
+// applyOwner adds the owner team category to the API name, the Dashboard reads categories
+// from " #<category>" suffixes of the name
+func applyOwner(def *apidef.APIDefinition, s *SourceMeta) {
+	team := ownerTeam(s)
+	if team == "" {
+		return
+	}
+
+	for _, f := range strings.Fields(def.Name) {
+		if f == "#"+team {
+			return
+		}
+	}
+
+	def.Name = strings.TrimSpace(def.Name) + " #" + team
+}
@@ -0,0 +1,64 @@
+package tyk
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"strings"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// PublicKeyFingerprints returns the fingerprints Tyk pins public keys by, the hex SHA256
+// of each certificate's public key in the PEM bundle
+func PublicKeyFingerprints(bundle []byte) ([]string, error) {
+	out := make([]string, 0)
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		crt, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		pub, err := x509.MarshalPKIXPublicKey(crt.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(pub)
+		out = append(out, hex.EncodeToString(sum[:]))
+	}
+
+	if len(out) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+
+	return out, nil
+}
+
+// applyUpstreamPins requires the upstream to present one of the pinned keys, the
+// definition has no CA field so the CA is pinned rather than trusted. Verification stays
+// on, a pin with verification skipped would accept any chain that merely carries the key
+func applyUpstreamPins(def *apidef.APIDefinition, pins []string) {
+	if len(pins) == 0 {
+		return
+	}
+
+	if def.PinnedPublicKeys == nil {
+		def.PinnedPublicKeys = map[string]string{}
+	}
+
+	def.PinnedPublicKeys["*"] = strings.Join(pins, ",")
+	def.Proxy.Transport.SSLInsecureSkipVerify = false
+}
@@ -0,0 +1,178 @@
+package tyk
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const (
+	PlanCreate = "create"
+	PlanUpdate = "update"
+	PlanDelete = "delete"
+)
+
+// PlanChange is a change a sync would make, Diff lists the JSON paths of the changed
+// definition fields of an update. Values are left out, templates can fill them from
+// secrets
+type PlanChange struct {
+	Action string   `json:"action"`
+	Slug   string   `json:"slug"`
+	Source string   `json:"source,omitempty"`
+	Diff   []string `json:"diff,omitempty"`
+}
+
+// Plan is what a sync would do, nothing in it has been applied
+type Plan struct {
+	Changes []PlanChange `json:"changes"`
+	// Errors are APIs that would fail to sync
+	Errors []string `json:"errors,omitempty"`
+}
+
+// PlanAPIs compares the options with the APIs in Tyk without changing anything. Managed
+// APIs of the source kind that are neither in the options nor in keep are planned for
+// deletion, keep holds the slugs of sources whose options failed so they aren't deleted
+func PlanAPIs(svcs map[string]*APIDefOptions, kind string, keep map[string]bool) (*Plan, error) {
+	if err := Ready(); err != nil {
+		return nil, err
+	}
+
+	all, err := fetchAll()
+	if err != nil {
+		return nil, err
+	}
+
+	existing := map[string]int{}
+	for i, a := range all {
+		existing[a.Slug] = i
+	}
+
+	slugs := make([]string, 0, len(svcs))
+	for s := range svcs {
+		slugs = append(slugs, s)
+	}
+	sort.Strings(slugs)
+
+	plan := &Plan{Changes: make([]PlanChange, 0)}
+	wanted := map[string]bool{}
+	for _, s := range slugs {
+		opts := svcs[s]
+		cSlug := cleanSlug(s)
+		wanted[cSlug] = true
+
+		def, err := renderDefinition(opts)
+		if err != nil {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("%s: %v", cSlug, err))
+			continue
+		}
+
+		i, ok := existing[cSlug]
+		if !ok {
+			plan.Changes = append(plan.Changes, PlanChange{Action: PlanCreate, Slug: cSlug, Source: sourceRef(opts.Source)})
+			continue
+		}
+
+		current := all[i].APIDefinition
+		def.Id = current.Id
+		def.APIID = current.APIID
+		def.OrgID = current.OrgID
+		if diff := definitionDiff(&current, def); len(diff) > 0 {
+			plan.Changes = append(plan.Changes, PlanChange{Action: PlanUpdate, Slug: cSlug, Source: sourceRef(opts.Source), Diff: diff})
+		}
+	}
+
+	deletes := make([]PlanChange, 0)
+	for _, a := range all {
+		src, _ := a.ConfigData[SourceKey].(string)
+		if !IsManaged(&a.APIDefinition) || !strings.HasPrefix(src, kind+"/") || wanted[a.Slug] || keep[a.Slug] {
+			continue
+		}
+		deletes = append(deletes, PlanChange{Action: PlanDelete, Slug: a.Slug, Source: src})
+	}
+	sort.Slice(deletes, func(i, j int) bool { return deletes[i].Slug < deletes[j].Slug })
+	plan.Changes = append(plan.Changes, deletes...)
+
+	return plan, nil
+}
+
+// definitionDiff lists the fields that differ between two definitions, by their JSON path
+func definitionDiff(current, planned *apidef.APIDefinition) []string {
+	var a, b interface{}
+	ra, errA := json.Marshal(current)
+	rb, errB := json.Marshal(planned)
+	if errA != nil || errB != nil || json.Unmarshal(ra, &a) != nil || json.Unmarshal(rb, &b) != nil {
+		return []string{"definition can't be compared"}
+	}
+
+	// the sync ID of the last write is not part of the definition
+	for _, d := range []interface{}{a, b} {
+		if cd, ok := d.(map[string]interface{})["config_data"].(map[string]interface{}); ok {
+			delete(cd, SyncIDKey)
+		}
+	}
+
+	diff := make([]string, 0)
+	jsonDiff("", a, b, &diff)
+	return diff
+}
+
+func jsonDiff(field string, a, b interface{}, diff *[]string) {
+	am, aIsMap := a.(map[string]interface{})
+	bm, bIsMap := b.(map[string]interface{})
+	if aIsMap && bIsMap {
+		keys := make([]string, 0, len(am)+len(bm))
+		for k := range am {
+			keys = append(keys, k)
+		}
+		for k := range bm {
+			if _, ok := am[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			f := k
+			if field != "" {
+				f = field + "." + k
+			}
+			jsonDiff(f, am[k], bm[k], diff)
+		}
+		return
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+
+	*diff = append(*diff, field)
+}
+
+// String renders the plan for review, one line per change followed by its diff
+func (p *Plan) String() string {
+	lines := make([]string, 0, len(p.Changes))
+	for _, c := range p.Changes {
+		l := fmt.Sprintf("%s %s", c.Action, c.Slug)
+		if c.Source != "" {
+			l += " (" + c.Source + ")"
+		}
+		lines = append(lines, l)
+		for _, d := range c.Diff {
+			lines = append(lines, "    "+d)
+		}
+	}
+
+	for _, e := range p.Errors {
+		lines = append(lines, "error "+e)
+	}
+
+	if len(lines) == 0 {
+		return "no changes\n"
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
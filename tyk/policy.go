@@ -0,0 +1,235 @@
+package tyk
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+)
+
+// Policies are Dashboard objects, the gateway API can't create them
+const endpointPolicies = "/api/portal/policies"
+
+// PolicySpec is a partitioned policy generated for a source, only the partitions it sets
+// are enabled so it combines with organisation policies managing the others
+type PolicySpec struct {
+	Name string
+	// Rate requests are allowed every Per seconds, the rate limit partition is enabled
+	// when Per is set
+	Rate float64
+	Per  float64
+	// QuotaMax requests are allowed every QuotaRenewalRate seconds, the quota partition
+	// is enabled when QuotaMax is set
+	QuotaMax         int64
+	QuotaRenewalRate int64
+	// ACL enables the access rights partition, granting access to the APIs
+	ACL  bool
+	APIs []objects.DBApiDefinition
+}
+
+type dashboardPolicy struct {
+	MID  string   `json:"_id"`
+	ID   string   `json:"id"`
+	Tags []string `json:"tags"`
+}
+
+// policyHashes remembers the policies already written per source
+var policyMu = sync.Mutex{}
+var policyHashes = map[string]string{}
+
+// PolicyID is the explicit ID of the policy generated for a source, keys and other
+// policies can reference it before the policy exists
+func PolicyID(source string) string {
+	return fmt.Sprintf("tyk-k8s-%x", sha1.Sum([]byte(source)))[:32]
+}
+
+func policyTag(source string) string {
+	return SourceKey + ":" + source
+}
+
+func (s *PolicySpec) body(source string) map[string]interface{} {
+	rights := accessRights(s.APIs)
+
+	return map[string]interface{}{
+		"id":                 PolicyID(source),
+		"org_id":             org(),
+		"name":               s.Name,
+		"active":             true,
+		"rate":               s.Rate,
+		"per":                s.Per,
+		"quota_max":          s.QuotaMax,
+		"quota_renewal_rate": s.QuotaRenewalRate,
+		// the rights scope the limits to the APIs even when the ACL partition is off
+		"access_rights": rights,
+		"tags":          []string{policyTag(source)},
+		"partitions": map[string]bool{
+			"quota":      s.QuotaMax != 0,
+			"rate_limit": s.Per > 0,
+			"acl":        s.ACL,
+		},
+	}
+}
+
+func listPolicies() ([]dashboardPolicy, error) {
+	list := struct {
+		Data []dashboardPolicy `json:"Data"`
+	}{}
+
+	if err := dashboardRequest(http.MethodGet, endpointPolicies+"?p=-2", nil, &list); err != nil {
+		return nil, err
+	}
+
+	return list.Data, nil
+}
+
+// findPolicy returns the Dashboard policy with the explicit ID, nil when there is none
+func findPolicy(id string) (*dashboardPolicy, error) {
+	list, err := listPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range list {
+		if p.ID == id {
+			return &p, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ownedByNamespace reports whether the policy was generated for an ingress of the namespace
+func (p *dashboardPolicy) ownedByNamespace(namespace string) bool {
+	prefix := policyTag("Ingress/" + namespace + "/")
+	for _, t := range p.Tags {
+		if strings.HasPrefix(t, prefix) && p.ID == PolicyID(strings.TrimPrefix(t, SourceKey+":")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *dashboardPolicy) ownedBy(source string) bool {
+	for _, t := range p.Tags {
+		if t == policyTag(source) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SyncPolicy creates or updates the policy of the source and returns its ID, policies
+// with the ID that weren't generated for the source are refused
+func SyncPolicy(source string, s *PolicySpec) (string, error) {
+	if s.Per <= 0 && s.QuotaMax == 0 && !s.ACL {
+		return "", errors.New("a policy needs a rate limit, quota or ACL")
+	}
+
+	id := PolicyID(source)
+	body := s.body(source)
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256(raw))
+
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	if policyHashes[source] == hash {
+		return id, nil
+	}
+
+	existing, err := findPolicy(id)
+	if err != nil {
+		return "", err
+	}
+
+	st := &dashboardStatus{}
+	if existing == nil {
+		err = dashboardRequest(http.MethodPost, endpointPolicies, body, st)
+	} else if !existing.ownedBy(source) {
+		return "", fmt.Errorf("policy %s already exists and is not managed by %s", id, source)
+	} else {
+		err = dashboardRequest(http.MethodPut, endpointPolicies+"/"+url.PathEscape(existing.MID), body, st)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("failed to write policy %s: %v", id, err)
+	}
+
+	if st.Status != "OK" {
+		return "", fmt.Errorf("policy request completed, but with error: %v", st.Message)
+	}
+
+	policyHashes[source] = hash
+	return id, nil
+}
+
+// DeletePolicy removes the policy of the source, it is left alone when it is gone or was
+// not generated for the source
+func DeletePolicy(source string) error {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+
+	existing, err := findPolicy(PolicyID(source))
+	if err != nil {
+		return err
+	}
+
+	delete(policyHashes, source)
+	if existing == nil || !existing.ownedBy(source) {
+		return nil
+	}
+
+	err = dashboardRequest(http.MethodDelete, endpointPolicies+"/"+url.PathEscape(existing.MID), nil, nil)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete policy %s: %v", PolicyID(source), err)
+	}
+
+	return nil
+}
+
+// PolicyManaged reports whether a policy was written for the source since the start
+func PolicyManaged(source string) bool {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	_, ok := policyHashes[source]
+	return ok
+}
+
+// CheckPolicies refuses the policies that weren't generated for an ingress of the namespace
+// and aren't in allowed, so keys and clients of a namespace can't grant the policies of
+// other teams
+func CheckPolicies(namespace string, ids, allowed []string) error {
+	list, err := listPolicies()
+	if err != nil {
+		return err
+	}
+
+	owned := map[string]bool{}
+	for _, p := range list {
+		if p.ownedByNamespace(namespace) {
+			owned[p.ID] = true
+		}
+	}
+	for _, id := range allowed {
+		owned[id] = true
+	}
+
+	for _, id := range ids {
+		if !owned[id] {
+			return fmt.Errorf("policy %s is not managed for namespace %s", id, namespace)
+		}
+	}
+
+	return nil
+}
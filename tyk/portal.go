@@ -0,0 +1,275 @@
+package tyk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The vendored API clients have no portal calls, catalogue and documentation requests are
+// made against the Dashboard API directly
+
+const (
+	endpointCatalogue = "/api/portal/catalogue"
+	endpointDocs      = "/api/portal/documentation"
+)
+
+// CatalogueEntry is an API published in the developer portal, APIID is the ID of the
+// policy developers request keys for
+type CatalogueEntry struct {
+	Name             string            `json:"name"`
+	ShortDescription string            `json:"short_description"`
+	LongDescription  string            `json:"long_description"`
+	Show             bool              `json:"show"`
+	APIID            string            `json:"api_id"`
+	Version          string            `json:"version"`
+	Documentation    string            `json:"documentation"`
+	AuthType         string            `json:"auth_type,omitempty"`
+	Fields           map[string]string `json:"fields,omitempty"`
+}
+
+type catalogue struct {
+	ID    string           `json:"id,omitempty"`
+	OrgID string           `json:"org_id"`
+	APIs  []CatalogueEntry `json:"apis"`
+}
+
+type dashboardStatus struct {
+	Status  string
+	Message string
+	Meta    string
+}
+
+// PortalDocs is an OpenAPI document published for a catalogue entry, Source identifies
+// the resource publishing it and is recorded in the entry fields
+type PortalDocs struct {
+	Source string
+	Entry  CatalogueEntry
+	// Document is the OpenAPI or Swagger document, JSON or YAML
+	Document []byte
+}
+
+// docHashes remembers the documents already published per source so unchanged documents
+// are not uploaded again on every sync
+var docsMu = sync.Mutex{}
+var docHashes = map[string]string{}
+
+var portalClient = &http.Client{Timeout: 10 * time.Second}
+
+func dashboardRequest(method, path string, in, out interface{}) error {
+	c := conf()
+	if c != nil && c.IsGateway {
+		return errors.New("the developer portal needs the Dashboard API")
+	}
+
+	return adminRequest(method, path, in, out)
+}
+
+// adminRequest calls the Dashboard API, or the gateway API in gateway mode
+func adminRequest(method, path string, in, out interface{}) error {
+	if err := Ready(); err != nil {
+		return err
+	}
+
+	c := conf()
+	header := "Authorization"
+	if c.IsGateway {
+		header = "x-tyk-authorization"
+	}
+
+	return apiRequest(c.URL, header, c.Secret, method, path, in, out)
+}
+
+func apiRequest(base, header, secret, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(header, secret)
+	req.Header.Set("Content-Type", "application/json")
+
+	cl := portalClient
+	if c := conf(); c != nil && c.InsecureSkipVerify {
+		cl = &http.Client{
+			Timeout:   portalClient.Timeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}
+	}
+
+	waitForLimit()
+	start := time.Now()
+	resp, err := cl.Do(req)
+	if err != nil {
+		observeRequest(method, path, start, 0, err)
+		return err
+	}
+	defer resp.Body.Close()
+	observeRequest(method, path, start, resp.StatusCode, nil)
+
+	rBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API Returned error: %v (code: %v)", string(rBody), resp.StatusCode)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(rBody, out)
+}
+
+func fetchCatalogue() (*catalogue, bool, error) {
+	cat := &catalogue{}
+	err := dashboardRequest(http.MethodGet, endpointCatalogue, nil, cat)
+	if err != nil {
+		// orgs that never published anything have no catalogue yet
+		if isNotFound(err) {
+			return &catalogue{OrgID: conf().Org}, false, nil
+		}
+		return nil, false, err
+	}
+
+	return cat, cat.ID != "", nil
+}
+
+func saveCatalogue(cat *catalogue, exists bool) error {
+	method := http.MethodPost
+	if exists {
+		method = http.MethodPut
+	}
+
+	st := &dashboardStatus{}
+	if err := dashboardRequest(method, endpointCatalogue, cat, st); err != nil {
+		return err
+	}
+
+	if st.Status != "OK" {
+		return fmt.Errorf("API request completed, but with error: %v", st.Message)
+	}
+
+	return nil
+}
+
+// isNotFound reports whether a Dashboard request failed because the object doesn't exist
+func isNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "code: 404")
+}
+
+func uploadDocs(policyID string, doc []byte) (string, error) {
+	st := &dashboardStatus{}
+	err := dashboardRequest(http.MethodPost, endpointDocs, map[string]string{
+		"api_id":        policyID,
+		"doc_type":      "swagger",
+		"documentation": base64.StdEncoding.EncodeToString(doc),
+	}, st)
+	if err != nil {
+		return "", err
+	}
+
+	if st.Status != "OK" || st.Meta == "" {
+		return "", fmt.Errorf("API request completed, but with error: %v", st.Message)
+	}
+
+	return st.Meta, nil
+}
+
+// PublishDocs uploads the document and points the catalogue entry of the policy at it,
+// the entry is created when it doesn't exist. The replaced document is removed, entries
+// of the policy that weren't published by the source are refused
+func PublishDocs(d *PortalDocs) error {
+	if d.Entry.APIID == "" {
+		return errors.New("portal documentation needs the ID of the policy it is published for")
+	}
+
+	if d.Source == "" {
+		return errors.New("portal documentation needs the source publishing it")
+	}
+
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256(raw))
+
+	docsMu.Lock()
+	defer docsMu.Unlock()
+	if docHashes[d.Source] == hash {
+		return nil
+	}
+
+	cat, exists, err := fetchCatalogue()
+	if err != nil {
+		return fmt.Errorf("failed to read portal catalogue: %v", err)
+	}
+
+	idx := -1
+	for i := range cat.APIs {
+		if cat.APIs[i].APIID != d.Entry.APIID {
+			continue
+		}
+		if src := entrySource(&cat.APIs[i]); src != d.Source {
+			return fmt.Errorf("the catalogue entry of policy %s is not managed by %s", d.Entry.APIID, d.Source)
+		}
+		idx = i
+		break
+	}
+
+	docID, err := uploadDocs(d.Entry.APIID, d.Document)
+	if err != nil {
+		return fmt.Errorf("failed to upload portal documentation: %v", err)
+	}
+
+	entry := d.Entry
+	entry.Documentation = docID
+	if entry.Version == "" {
+		entry.Version = "v2"
+	}
+	entry.Fields = map[string]string{}
+	for k, v := range d.Entry.Fields {
+		entry.Fields[k] = v
+	}
+	entry.Fields[SourceKey] = d.Source
+
+	oldDoc := ""
+	if idx >= 0 {
+		oldDoc = cat.APIs[idx].Documentation
+		cat.APIs[idx] = entry
+	} else {
+		cat.APIs = append(cat.APIs, entry)
+	}
+
+	if err := saveCatalogue(cat, exists); err != nil {
+		return fmt.Errorf("failed to update portal catalogue: %v", err)
+	}
+
+	if oldDoc != "" && oldDoc != docID {
+		if err := dashboardRequest(http.MethodDelete, endpointDocs+"/"+oldDoc, nil, nil); err != nil {
+			log.Warning("failed to remove replaced portal documentation: ", err)
+		}
+	}
+
+	docHashes[d.Source] = hash
+	return nil
+}
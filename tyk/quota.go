@@ -0,0 +1,104 @@
+package tyk
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+)
+
+const quotaMessage = "has reached its quota of"
+
+// QuotaError blocks the creation of an API when its namespace already has as many
+// managed APIs as it is allowed
+type QuotaError struct {
+	Namespace string
+	Limit     int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("namespace %s %s %d APIs", e.Namespace, quotaMessage, e.Limit)
+}
+
+// IsQuotaExceeded reports whether the error is a namespace quota error, the message is
+// matched as well for the aggregated errors from UpdateAPIs
+func IsQuotaExceeded(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if _, ok := err.(*QuotaError); ok {
+		return true
+	}
+
+	return strings.Contains(err.Error(), quotaMessage)
+}
+
+// namespaceQuota returns the API cap of a namespace, false when it has none. Overrides
+// take precedence over the default and 0 or less means no cap
+func namespaceQuota(ns string) (int, bool) {
+	c := conf()
+	if c == nil {
+		return 0, false
+	}
+
+	limit := c.NamespaceQuota
+	if n, ok := c.NamespaceQuotas[ns]; ok {
+		limit = n
+	}
+
+	return limit, limit > 0
+}
+
+func quotasEnabled() bool {
+	c := conf()
+	return c != nil && (c.NamespaceQuota > 0 || len(c.NamespaceQuotas) > 0)
+}
+
+func sourceNamespace(def *objects.DBApiDefinition) string {
+	src, _ := def.ConfigData[SourceKey].(string)
+	parts := strings.SplitN(src, "/", 3)
+	if len(parts) != 3 {
+		return ""
+	}
+
+	return parts[1]
+}
+
+// quotaCounter counts the managed APIs per source namespace, APIs created before their
+// source was recorded are not counted
+type quotaCounter map[string]int
+
+func newQuotaCounter(apis []objects.DBApiDefinition) quotaCounter {
+	counts := quotaCounter{}
+	for i := range apis {
+		if !IsManaged(&apis[i].APIDefinition) {
+			continue
+		}
+		if ns := sourceNamespace(&apis[i]); ns != "" {
+			counts[ns]++
+		}
+	}
+
+	return counts
+}
+
+// reserve counts a new API against its namespace, or fails when the namespace is full
+func (q quotaCounter) reserve(opts *APIDefOptions) error {
+	if opts.Source == nil {
+		return nil
+	}
+
+	ns := opts.Source.Namespace
+	limit, ok := namespaceQuota(ns)
+	if !ok {
+		return nil
+	}
+
+	if q[ns] >= limit {
+		return &QuotaError{Namespace: ns, Limit: limit}
+	}
+
+	q[ns]++
+	return nil
+}
@@ -0,0 +1,114 @@
+package tyk
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/TykTechnologies/tyk-git/clients/gateway"
+	"github.com/TykTechnologies/tyk-git/clients/interfaces"
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/levigross/grequests"
+	"github.com/ongoingio/urljoin"
+)
+
+const gatewayAPIsPath = "/tyk/apis/"
+
+// deferredReloadClient writes APIs to the gateway without the group reload the gateway
+// client triggers after every change, Reload has to be called once the batch is done
+type deferredReloadClient struct {
+	*gateway.Client
+	url    string
+	secret string
+}
+
+func (c *deferredReloadClient) requestOptions(def *apidef.APIDefinition) *grequests.RequestOptions {
+	return &grequests.RequestOptions{
+		JSON: def,
+		Headers: map[string]string{
+			"x-tyk-authorization": c.secret,
+			"content-type":        "application/json",
+		},
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+}
+
+func (c *deferredReloadClient) CreateAPI(def *apidef.APIDefinition) (string, error) {
+	resp, err := grequests.Post(urljoin.Join(c.url, gatewayAPIsPath), c.requestOptions(def))
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("API Returned error: %v (code: %v)", resp.String(), resp.StatusCode)
+	}
+
+	status := gateway.APIMessage{}
+	if err := resp.JSON(&status); err != nil {
+		return "", err
+	}
+
+	if status.Status != "ok" {
+		return "", fmt.Errorf("API request completed, but with error: %v", status.Message)
+	}
+
+	return status.Key, nil
+}
+
+func (c *deferredReloadClient) UpdateAPI(def *apidef.APIDefinition) error {
+	if def.APIID == "" {
+		return errors.New("API ID must be set")
+	}
+
+	resp, err := grequests.Put(urljoin.Join(c.url, gatewayAPIsPath, def.APIID), c.requestOptions(def))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("API Returned error: %v (code: %v)", resp.String(), resp.StatusCode)
+	}
+
+	return nil
+}
+
+// newSyncClient returns the client used for batch syncs, with ReloadAfterSync in gateway
+// mode writes do not reload the gateway and the caller reloads once at the end
+func newSyncClient() (interfaces.UniversalClient, bool, error) {
+	c := conf()
+	cl, err := newAPIClient()
+	if err != nil {
+		return nil, false, err
+	}
+
+	gw, isGW := cl.(*gateway.Client)
+	if !c.ReloadAfterSync || !isGW {
+		return throttle(cl), false, nil
+	}
+
+	return throttle(&deferredReloadClient{Client: gw, url: c.URL, secret: c.Secret}), true, nil
+}
+
+func reloadGateways(cl interfaces.UniversalClient) {
+	if tc, ok := cl.(*throttledClient); ok {
+		cl = tc.UniversalClient
+	}
+
+	if ic, ok := cl.(*instrumentedClient); ok {
+		cl = ic.UniversalClient
+	}
+
+	dc, ok := cl.(*deferredReloadClient)
+	if !ok {
+		return
+	}
+
+	log.Info("sync complete, reloading gateway group")
+	waitForLimit()
+	start := time.Now()
+	err := dc.Reload()
+	observeRequest("GET", "/tyk/reload/group", start, 0, err)
+	if err != nil {
+		log.Error("gateway reload failed: ", err)
+	}
+}
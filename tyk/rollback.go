@@ -0,0 +1,78 @@
+package tyk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/TykTechnologies/tyk-git/clients/interfaces"
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// stagedChange is a rendered definition waiting to be applied, previous is the definition
+// it replaces and is nil for new APIs
+type stagedChange struct {
+	def      *apidef.APIDefinition
+	hash     string
+	cl       interfaces.UniversalClient
+	previous *apidef.APIDefinition
+	syncID   string
+
+	// createdID is the ID a new API is deleted by
+	createdID string
+}
+
+func (c *stagedChange) apply() error {
+	if c.previous != nil {
+		return c.cl.UpdateAPI(c.def)
+	}
+
+	id, err := createDefinition(c.cl, c.def)
+	if err != nil {
+		return err
+	}
+
+	c.createdID = id
+	return nil
+}
+
+// revert puts the previous definition back, or removes the API when it was created
+func (c *stagedChange) revert() error {
+	if c.previous != nil {
+		return c.cl.UpdateAPI(c.previous)
+	}
+
+	return c.cl.DeleteAPI(c.createdID)
+}
+
+// shouldRollback tells if enough applies of a sync failed to revert the rest
+func shouldRollback(failed, total int) bool {
+	c := conf()
+	if c == nil || c.RollbackThreshold <= 0 || total == 0 || failed == 0 {
+		return false
+	}
+
+	return float64(failed)/float64(total) >= c.RollbackThreshold
+}
+
+// rollback reverts the applied changes in reverse order, the returned error reports the
+// rollback and the changes that could not be reverted
+func rollback(applied []*stagedChange, failed, total int) error {
+	defer apiIndex.invalidate()
+
+	errs := make([]string, 0)
+	for i := len(applied) - 1; i >= 0; i-- {
+		c := applied[i]
+		if err := c.revert(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", c.def.Slug, err))
+		}
+	}
+
+	msg := fmt.Sprintf("%d of %d changes failed, rolled back %d applied changes", failed, total, len(applied)-len(errs))
+	if len(errs) > 0 {
+		msg += fmt.Sprintf(", failed to roll back %s", strings.Join(errs, ", "))
+	}
+
+	log.Error(msg)
+	return errors.New(msg)
+}
@@ -0,0 +1,123 @@
+package tyk
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// Definitions are published in the classic format of the vendored API definition, the
+// only one the API clients can write. Rendered fields it doesn't carry are dropped with a
+// warning instead of silently, and OAS documents are refused with an error naming what the
+// detected version supports, instead of being published as empty classic APIs
+const oasMinVersion = "v5.0.0"
+
+var classicType = reflect.TypeOf(apidef.APIDefinition{})
+
+// jsonFields maps the JSON names of the fields of a struct to their types, fields of
+// embedded structs are inlined as encoding/json does
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for n, ft := range jsonFields(f.Type) {
+				fields[n] = ft
+			}
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = f.Type
+	}
+
+	return fields
+}
+
+// unknownFields lists the paths of the values the type has no field for, keys are matched
+// without case like encoding/json does
+func unknownFields(v interface{}, t reflect.Type, prefix string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	found := make([]string, 0)
+	switch val := v.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := jsonFields(t)
+			for k, sub := range val {
+				ft, ok := fields[strings.ToLower(k)]
+				if !ok {
+					found = append(found, prefix+k)
+					continue
+				}
+				found = append(found, unknownFields(sub, ft, prefix+k+".")...)
+			}
+		case reflect.Map:
+			for k, sub := range val {
+				found = append(found, unknownFields(sub, t.Elem(), prefix+k+".")...)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for _, sub := range val {
+				found = append(found, unknownFields(sub, t.Elem(), prefix)...)
+			}
+		}
+	}
+
+	return found
+}
+
+func isOASDocument(doc map[string]interface{}) bool {
+	_, openapi := doc["openapi"]
+	_, ext := doc["x-tyk-api-gateway"]
+	return openapi && ext
+}
+
+// checkSchema refuses OAS documents and warns about the fields of a rendered definition
+// that the classic definition doesn't carry, the warning is also returned for tests
+func checkSchema(opts *APIDefOptions, def string) ([]string, error) {
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(def), &doc); err != nil {
+		// invalid JSON is reported with its line by the caller
+		return nil, nil
+	}
+
+	name, _ := component(conf())
+	version := DetectedVersion()
+	if isOASDocument(doc) {
+		if version != "" && versionBelow(version, oasMinVersion) {
+			return nil, fmt.Errorf("%s %s doesn't support OAS API definitions, render a classic definition", name, version)
+		}
+		return nil, fmt.Errorf("OAS API definitions can't be published by the controller, render a classic definition")
+	}
+
+	unknown := unknownFields(doc, classicType, "")
+	if len(unknown) == 0 {
+		return nil, nil
+	}
+	sort.Strings(unknown)
+
+	target := name
+	if version != "" {
+		target += " " + version
+	}
+	syncLogger(opts.SyncID).Warningf("dropping fields of %s the classic definition for %s doesn't carry: %s",
+		cleanSlug(opts.Slug), target, strings.Join(unknown, ", "))
+	return unknown, nil
+}
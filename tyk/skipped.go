@@ -0,0 +1,65 @@
+package tyk
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Reasons objects are skipped for, SkipFiltered are objects left to other controllers and
+// SkipInvalid objects the controller can't turn into APIs
+const (
+	SkipFiltered   = "filtered"
+	SkipValidation = "validation"
+	SkipInvalid    = "invalid"
+)
+
+type skipKey struct {
+	kind      string
+	namespace string
+	reason    string
+}
+
+var skippedMu = sync.Mutex{}
+var skipped = map[skipKey]int{}
+
+// RecordSkipped counts an object, or one of its paths, that no API was synced for
+func RecordSkipped(kind, namespace, reason string) {
+	skippedMu.Lock()
+	skipped[skipKey{kind: kind, namespace: namespace, reason: reason}]++
+	skippedMu.Unlock()
+}
+
+// WriteSkippedMetrics writes the skipped objects in the Prometheus text format
+func WriteSkippedMetrics(w io.Writer) error {
+	skippedMu.Lock()
+	keys := make([]skipKey, 0, len(skipped))
+	for k := range skipped {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.namespace != b.namespace {
+			return a.namespace < b.namespace
+		}
+		return a.reason < b.reason
+	})
+
+	lines := []string{
+		"# HELP tyk_k8s_skipped_objects_total Objects skipped by filters or failed validation.",
+		"# TYPE tyk_k8s_skipped_objects_total counter",
+	}
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf(`tyk_k8s_skipped_objects_total{kind="%s",namespace="%s",reason="%s"} %d`,
+			metricLabel(k.kind), metricLabel(k.namespace), k.reason, skipped[k]))
+	}
+	skippedMu.Unlock()
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
@@ -0,0 +1,76 @@
+package tyk
+
+// SyncByTag makes the managed APIs with the tag match the options, APIs with the tag that
+// are not in the options are removed
+func SyncByTag(tag string, svcs map[string]*APIDefOptions) error {
+	return SyncByTagKeep(tag, svcs, nil)
+}
+
+// SyncByTagKeep is SyncByTag that also leaves the APIs with the slugs in keep, for sources
+// that still exist but can't be rendered right now
+func SyncByTagKeep(tag string, svcs map[string]*APIDefOptions, keep map[string]bool) error {
+	if len(svcs) > 0 {
+		if err := UpdateAPIs(svcs); err != nil {
+			return err
+		}
+	}
+
+	wanted := map[string]struct{}{}
+	for slug := range svcs {
+		wanted[cleanSlug(slug)] = struct{}{}
+	}
+	for slug := range keep {
+		wanted[cleanSlug(slug)] = struct{}{}
+	}
+
+	existing, err := ListByTag(tag)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range existing {
+		if _, ok := wanted[a.Slug]; ok || !IsManaged(&a.APIDefinition) {
+			continue
+		}
+
+		if err := DeleteBySlug(a.Slug); err != nil {
+			log.Error(err)
+			continue
+		}
+		log.Info("no longer wanted, deleted: ", a.Slug)
+	}
+
+	return nil
+}
+
+func gcd(a, b int32) int32 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// maxWeightRepeat caps how often a single target is repeated in a weighted list
+const maxWeightRepeat = 100
+
+// WeightedTargets repeats each target in proportion to its weight, the gateway round
+// robins over the target list so this approximates the requested split
+func WeightedTargets(targets []string, weights []int32) []string {
+	if len(targets) < 2 {
+		return targets
+	}
+
+	g := weights[0]
+	for _, w := range weights[1:] {
+		g = gcd(g, w)
+	}
+
+	out := make([]string, 0)
+	for i, t := range targets {
+		for n := int32(0); n < weights[i]/g && n < maxWeightRepeat; n++ {
+			out = append(out, t)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,46 @@
+package tyk
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/TykTechnologies/logrus"
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// SyncIDKey is written into the config data of every API with the ID of the sync that
+// last wrote it. The API clients don't take extra headers, the definition is what the
+// Dashboard audit log records so a write is traced back to the controller logs this way
+const SyncIDKey = "tyk-k8s-sync-id"
+
+// NewSyncID returns a random ID for a reconcile
+func NewSyncID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// stampSyncID is applied after the definition is hashed, the ID changes on every sync
+// and would otherwise change the hash too
+func stampSyncID(def *apidef.APIDefinition, id string) {
+	if id == "" {
+		return
+	}
+
+	if def.ConfigData == nil {
+		def.ConfigData = map[string]interface{}{}
+	}
+
+	def.ConfigData[SyncIDKey] = id
+}
+
+func syncLogger(id string) *logrus.Entry {
+	if id == "" {
+		return log
+	}
+
+	return log.WithField("sync_id", id)
+}
@@ -0,0 +1,300 @@
+package tyk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+const templateMessage = "failed to render template"
+
+// TemplateError is a template that failed to execute, or rendered a definition that isn't
+// valid JSON. Line is the template line for execution errors and the line of the rendered
+// definition for JSON errors, 0 when it isn't known
+type TemplateError struct {
+	Template string
+	Line     int
+	Source   string
+	Err      error
+}
+
+func (e *TemplateError) Error() string {
+	at := e.Template
+	if e.Line > 0 {
+		at = fmt.Sprintf("%s line %d", at, e.Line)
+	}
+
+	if e.Source != "" {
+		return fmt.Sprintf("%s %s for %s: %v", templateMessage, at, e.Source, e.Err)
+	}
+
+	return fmt.Sprintf("%s %s: %v", templateMessage, at, e.Err)
+}
+
+// IsTemplateError reports whether the error is a template error, the message is matched as
+// well for the aggregated errors from UpdateAPIs
+func IsTemplateError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if _, ok := err.(*TemplateError); ok {
+		return true
+	}
+
+	return strings.Contains(err.Error(), templateMessage)
+}
+
+var execLine = regexp.MustCompile(`^template: [^:]+:(\d+)`)
+
+// failureKey labels the failures of a template by the namespace of the source
+type failureKey struct {
+	template  string
+	namespace string
+}
+
+// templateFailures and processorFailures count the render failures for the metrics
+// endpoint
+var failuresMu = sync.Mutex{}
+var templateFailures = map[failureKey]int{}
+var processorFailures = map[failureKey]int{}
+
+func renderKey(opts *APIDefOptions) failureKey {
+	k := failureKey{template: opts.TemplateName}
+	if opts.Definition != nil {
+		k.template = "definition"
+	}
+
+	if opts.Source != nil {
+		k.namespace = opts.Source.Namespace
+	}
+
+	return k
+}
+
+// processorFailed counts a definition the annotation processors or the post-process hook
+// failed on
+func processorFailed(opts *APIDefOptions) {
+	failuresMu.Lock()
+	processorFailures[renderKey(opts)]++
+	failuresMu.Unlock()
+}
+
+// templateError records a failed render of the options, lines of execution errors are read
+// from the text/template message
+func templateError(opts *APIDefOptions, err error) *TemplateError {
+	k := renderKey(opts)
+	e := &TemplateError{Template: k.template, Source: sourceRef(opts.Source), Err: err}
+	if m := execLine.FindStringSubmatch(err.Error()); m != nil {
+		e.Line, _ = strconv.Atoi(m[1])
+	}
+
+	failuresMu.Lock()
+	templateFailures[k]++
+	failuresMu.Unlock()
+
+	return e
+}
+
+// jsonError turns a decoding error of a rendered definition into a template error with
+// the line of the definition it happened at
+func jsonError(opts *APIDefOptions, def []byte, err error) *TemplateError {
+	e := templateError(opts, err)
+	offset := int64(-1)
+	switch je := err.(type) {
+	case *json.SyntaxError:
+		offset = je.Offset
+	case *json.UnmarshalTypeError:
+		offset = je.Offset
+	}
+
+	if offset >= 0 && offset <= int64(len(def)) {
+		e.Line = bytes.Count(def[:offset], []byte("\n")) + 1
+	}
+
+	return e
+}
+
+func sortedFailures(counts map[failureKey]int) []failureKey {
+	keys := make([]failureKey, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].template != keys[j].template {
+			return keys[i].template < keys[j].template
+		}
+		return keys[i].namespace < keys[j].namespace
+	})
+	return keys
+}
+
+// WriteTemplateMetrics writes the render failures per template and namespace in the
+// Prometheus text format
+func WriteTemplateMetrics(w io.Writer) error {
+	failuresMu.Lock()
+	lines := []string{
+		"# HELP tyk_k8s_template_failures_total Templates that failed to render a definition.",
+		"# TYPE tyk_k8s_template_failures_total counter",
+	}
+	for _, k := range sortedFailures(templateFailures) {
+		lines = append(lines, fmt.Sprintf(`tyk_k8s_template_failures_total{template="%s",namespace="%s"} %d`,
+			metricLabel(k.template), metricLabel(k.namespace), templateFailures[k]))
+	}
+
+	lines = append(lines,
+		"# HELP tyk_k8s_processor_failures_total Rendered definitions the annotation processors or post-process hook failed on.",
+		"# TYPE tyk_k8s_processor_failures_total counter",
+	)
+	for _, k := range sortedFailures(processorFailures) {
+		lines = append(lines, fmt.Sprintf(`tyk_k8s_processor_failures_total{template="%s",namespace="%s"} %d`,
+			metricLabel(k.template), metricLabel(k.namespace), processorFailures[k]))
+	}
+	failuresMu.Unlock()
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// Templates read environment-specific values with {{ configMapValue "name" "key" }} and
+// {{ secretValue "name" "key" }}, from the namespace of the source being rendered, and
+// {{ json .Value }} quotes a value for the JSON template. The functions fail until a
+// ValueFunc is set
+const (
+	ConfigMapValues = "ConfigMap"
+	SecretValues    = "Secret"
+)
+
+// ValueFunc returns the key of a ConfigMap or Secret in a namespace, it decides which
+// objects templates may read
+type ValueFunc func(kind, namespace, name, key string) (string, error)
+
+var valuesMu = sync.RWMutex{}
+var valueFor ValueFunc
+
+// SetValueFunc enables the template functions that read ConfigMaps and Secrets
+func SetValueFunc(f ValueFunc) {
+	valuesMu.Lock()
+	defer valuesMu.Unlock()
+	valueFor = f
+}
+
+// templateFuncs returns the template functions bound to the namespace of a source, they
+// are declared with an empty namespace when templates are parsed
+func templateFuncs(ns string) template.FuncMap {
+	value := func(kind string) func(name, key string) (string, error) {
+		return func(name, key string) (string, error) {
+			valuesMu.RLock()
+			f := valueFor
+			valuesMu.RUnlock()
+
+			if f == nil {
+				return "", errors.New("templates can't read " + kind + " values, lookups are not enabled")
+			}
+
+			if ns == "" {
+				return "", fmt.Errorf("%s %s can only be read for sources with a namespace", kind, name)
+			}
+
+			return f(kind, ns, name, key)
+		}
+	}
+
+	return template.FuncMap{
+		"configMapValue": value(ConfigMapValues),
+		"secretValue":    value(SecretValues),
+		"json": func(v interface{}) (string, error) {
+			raw, err := json.Marshal(v)
+			return string(raw), err
+		},
+	}
+}
+
+// tplMu guards the template set, it can be replaced at runtime when it is loaded from a
+// ConfigMap
+var tplMu = sync.RWMutex{}
+
+// LoadTemplates replaces the template set, each key is a template name as it is used in
+// the template annotation. A "default" or "default.json" key replaces the default
+// template. Nothing is replaced if any template fails to parse
+func LoadTemplates(set map[string]string) error {
+	names := make([]string, 0, len(set))
+	for n := range set {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	root := template.New("").Funcs(templateFuncs(""))
+	var dTpl *template.Template
+	for _, n := range names {
+		t, err := root.New(n).Parse(set[n])
+		if err != nil {
+			return fmt.Errorf("invalid template %s: %v", n, err)
+		}
+
+		if n == DefaultTemplate || n == DefaultTemplate+".json" {
+			dTpl = t
+		}
+	}
+
+	if dTpl == nil {
+		c := conf()
+		if c == nil {
+			c = &TykConf{}
+		}
+
+		var err error
+		dTpl, err = loadDefaultTemplate(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	tplMu.Lock()
+	defer tplMu.Unlock()
+	templates = root
+	defaultTemplate = dTpl
+
+	log.Info("loaded ", len(names), " templates")
+	return nil
+}
+
+// HasTemplate reports whether a template is loaded under the name, the default template
+// is always there
+func HasTemplate(name string) bool {
+	if name == DefaultTemplate {
+		return true
+	}
+
+	tplMu.RLock()
+	defer tplMu.RUnlock()
+	return templates != nil && templates.Lookup(name) != nil
+}
+
+// FindTemplate returns the name a loaded template is known by, a directory of templates
+// names them after their files so the ".json" suffix is tried first
+func FindTemplate(name string) (string, bool) {
+	tplMu.RLock()
+	defer tplMu.RUnlock()
+
+	if templates == nil {
+		return "", false
+	}
+
+	for _, n := range []string{name + ".json", name} {
+		if templates.Lookup(n) != nil {
+			return n, true
+		}
+	}
+
+	return "", false
+}
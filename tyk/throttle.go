@@ -0,0 +1,83 @@
+package tyk
+
+import (
+	"context"
+	"sync"
+
+	"github.com/TykTechnologies/tyk-git/clients/interfaces"
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+	"github.com/TykTechnologies/tyk/apidef"
+	"golang.org/x/time/rate"
+)
+
+// A single limiter is shared by every client so bursts of ingress changes queue up
+// instead of hitting the Dashboard concurrently
+var limiter *rate.Limiter
+var limiterMu = sync.RWMutex{}
+
+func setRateLimit(rps float64, burst int) {
+	limiterMu.Lock()
+	defer limiterMu.Unlock()
+
+	if rps <= 0 {
+		limiter = nil
+		return
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	log.Infof("limiting Tyk API requests to %v per second (burst %v)", rps, burst)
+	limiter = rate.NewLimiter(rate.Limit(rps), burst)
+}
+
+func waitForLimit() {
+	limiterMu.RLock()
+	l := limiter
+	limiterMu.RUnlock()
+
+	if l == nil {
+		return
+	}
+
+	err := l.Wait(context.Background())
+	if err != nil {
+		log.Error("rate limiter: ", err)
+	}
+}
+
+// throttledClient waits for the shared limiter before every request, the requests are
+// timed once they pass the limiter
+type throttledClient struct {
+	interfaces.UniversalClient
+}
+
+func throttle(cl interfaces.UniversalClient) interfaces.UniversalClient {
+	return &throttledClient{UniversalClient: instrument(cl)}
+}
+
+func (c *throttledClient) CreateAPI(def *apidef.APIDefinition) (string, error) {
+	waitForLimit()
+	return c.UniversalClient.CreateAPI(def)
+}
+
+func (c *throttledClient) FetchAPIs() ([]objects.DBApiDefinition, error) {
+	waitForLimit()
+	return c.UniversalClient.FetchAPIs()
+}
+
+func (c *throttledClient) UpdateAPI(def *apidef.APIDefinition) error {
+	waitForLimit()
+	return c.UniversalClient.UpdateAPI(def)
+}
+
+func (c *throttledClient) DeleteAPI(id string) error {
+	waitForLimit()
+	return c.UniversalClient.DeleteAPI(id)
+}
+
+func (c *throttledClient) CreateCertificate(cert []byte) (string, error) {
+	waitForLimit()
+	return c.UniversalClient.CreateCertificate(cert)
+}
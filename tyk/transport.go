@@ -0,0 +1,84 @@
+package tyk
+
+import (
+	"strings"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// ProxyTransport tunes the connections the gateway makes to the upstream, zero values
+// keep the gateway defaults
+type ProxyTransport struct {
+	// MinTLSVersion is a crypto/tls version such as tls.VersionTLS12
+	MinTLSVersion uint16
+	CipherSuites  []string
+	// ProxyURL is the egress proxy upstream requests are sent through
+	ProxyURL string
+}
+
+func applyTransport(def *apidef.APIDefinition, t *ProxyTransport) {
+	if t == nil {
+		return
+	}
+
+	if t.MinTLSVersion != 0 {
+		def.Proxy.Transport.SSLMinVersion = t.MinTLSVersion
+	}
+
+	if len(t.CipherSuites) > 0 {
+		def.Proxy.Transport.SSLCipherSuites = t.CipherSuites
+	}
+
+	if t.ProxyURL != "" {
+		def.Proxy.Transport.ProxyURL = t.ProxyURL
+	}
+}
+
+// Upstream protocols, Tyk picks the protocol from the scheme of the target
+const (
+	UpstreamHTTP  = "http"
+	UpstreamHTTP2 = "http2"
+	UpstreamH2C   = "h2c"
+)
+
+// upstreamSchemes maps the schemes of service targets to the scheme of the protocol,
+// loop targets and schemes that already fit are left alone
+var upstreamSchemes = map[string]map[string]string{
+	UpstreamHTTP:  {"h2c": "http"},
+	UpstreamHTTP2: {"http": "https", "h2c": "https"},
+	UpstreamH2C:   {"http": "h2c", "https": "h2c"},
+}
+
+func upstreamTarget(target, proto string) string {
+	i := strings.Index(target, "://")
+	if i < 0 {
+		return target
+	}
+
+	if s, ok := upstreamSchemes[proto][target[:i]]; ok {
+		return s + target[i:]
+	}
+
+	return target
+}
+
+// applyUpstreamProtocol rewrites the target schemes for the protocol, HTTP/2 over TLS also
+// needs proxy_enable_http2 in the gateway config. It runs after the path routes so the
+// merged host targets are rewritten too
+func applyUpstreamProtocol(def *apidef.APIDefinition, proto string) {
+	if proto == "" {
+		return
+	}
+
+	def.Proxy.TargetURL = upstreamTarget(def.Proxy.TargetURL, proto)
+	for i, t := range def.Proxy.Targets {
+		def.Proxy.Targets[i] = upstreamTarget(t, proto)
+	}
+
+	for vName, v := range def.VersionData.Versions {
+		for i, r := range v.ExtendedPaths.URLRewrite {
+			v.ExtendedPaths.URLRewrite[i].RewriteTo = upstreamTarget(r.RewriteTo, proto)
+		}
+		def.VersionData.Versions[vName] = v
+	}
+}
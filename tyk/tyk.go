@@ -5,17 +5,24 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/TykTechnologies/tyk-git/clients/dashboard"
 	"github.com/TykTechnologies/tyk-git/clients/gateway"
 	"github.com/TykTechnologies/tyk-git/clients/interfaces"
 	"github.com/TykTechnologies/tyk-git/clients/objects"
 	"github.com/TykTechnologies/tyk-k8s/logger"
+	"github.com/TykTechnologies/tyk-k8s/notify"
 	"github.com/TykTechnologies/tyk-k8s/processor"
+	"github.com/TykTechnologies/tyk/apidef"
 	"github.com/satori/go.uuid"
 	"github.com/spf13/viper"
 )
@@ -35,17 +42,76 @@ func cleanSlug(s string) string {
 }
 
 type TykConf struct {
-	URL                string `yaml:"url"`
-	Secret             string `yaml:"secret"`
-	Org                string `yaml:"org"`
-	Templates          string `yaml:"templates"`
-	IsGateway          bool   `yaml:"is_gateway"`
-	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	URL                string    `yaml:"url"`
+	Secret             string    `yaml:"secret"`
+	Org                string    `yaml:"org"`
+	Templates          string    `yaml:"templates"`
+	IsGateway          bool      `yaml:"is_gateway"`
+	InsecureSkipVerify bool      `yaml:"insecure_skip_verify"`
+	PostProcessHook    *HookConf `yaml:"postProcessHook"`
+	// ClusterName identifies this cluster in multi-cluster Dashboards, it is added to the
+	// API tags and exposed to templates
+	ClusterName string `yaml:"clusterName"`
+	// GroupTags are added to every API so MDCB data planes for this cluster load them
+	GroupTags []string `yaml:"groupTags"`
+	// ReloadAfterSync reloads the gateway group once after a batch sync instead of after
+	// every API change, Dashboards already batch reloads so this only affects gateway mode
+	ReloadAfterSync bool `yaml:"reloadAfterSync"`
+	// RequestsPerSecond limits calls to the Dashboard or gateway API, 0 disables the limit
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	Burst             int     `yaml:"burst"`
+	// LookupCacheSeconds is how long the API list is reused for slug lookups, defaults
+	// to 10 seconds and a negative value disables the cache
+	LookupCacheSeconds int `yaml:"lookupCacheSeconds"`
+	// SkipVersionCheck doesn't read the Dashboard or gateway version at Init, for proxies
+	// that don't pass the health endpoint
+	SkipVersionCheck bool `yaml:"skipVersionCheck"`
+	// TargetVersion is the gateway version definitions are written for instead of the
+	// detected version, fields older gateways don't know are removed. TagVersions sets it
+	// per gateway tag for fleets of mixed versions, the oldest version of an API's tags
+	// is used
+	TargetVersion string            `yaml:"targetVersion"`
+	TagVersions   map[string]string `yaml:"tagVersions"`
+	// CompatFields adds definition fields, as dotted paths, and the gateway version they
+	// are known since to the built in list
+	CompatFields map[string]string `yaml:"compatFields"`
+	// ManagedBy is written into the config data of every generated API so they can be
+	// told apart from hand made ones and from APIs of other clusters, only APIs with the
+	// same marker are deleted. Defaults to tyk-k8s/<clusterName>, or tyk-k8s without one.
+	// With the cluster name default, APIs still marked tyk-k8s are treated as managed and
+	// re-marked when next updated; set managedBy to tyk-k8s to keep the old marker instead
+	ManagedBy string `yaml:"managedBy"`
+	// DefaultTemplateBody replaces the built in default template, DefaultTemplateFile
+	// reads it from a file instead
+	DefaultTemplateBody string `yaml:"defaultTemplateBody"`
+	DefaultTemplateFile string `yaml:"defaultTemplateFile"`
+	// Cloud targets a Tyk Cloud control plane, the org is discovered from the API key
+	// unless Org is set
+	Cloud bool `yaml:"cloud"`
+	// NamespaceTags target APIs from a namespace at specific data plane segments
+	NamespaceTags map[string][]string `yaml:"namespaceTags"`
+	// NamespaceQuota caps the APIs the controller creates for a namespace, 0 disables
+	// the cap. NamespaceQuotas overrides it per namespace
+	NamespaceQuota  int            `yaml:"namespaceQuota"`
+	NamespaceQuotas map[string]int `yaml:"namespaceQuotas"`
+	// LenientTemplates renders template keys that don't exist as "<no value>" instead of
+	// failing the sync
+	LenientTemplates bool `yaml:"lenientTemplates"`
+	// RollbackThreshold is the fraction of failed applies in a sync, between 0 and 1, at
+	// which the changes already applied are reverted. 0 disables the rollback
+	RollbackThreshold float64 `yaml:"rollbackThreshold"`
+	// IdempotentCreates makes CreateService update the managed API with the same slug or
+	// API ID instead of creating a duplicate
+	IdempotentCreates bool `yaml:"idempotentCreates"`
+	// DrainTimeoutSeconds bounds how long a shutdown waits for API writes in flight,
+	// defaults to 30 seconds
+	DrainTimeoutSeconds int `yaml:"drainTimeoutSeconds"`
 }
 
 type APIDefOptions struct {
 	Name          string
 	Target        string
+	TargetList    []string
 	ListenPath    string
 	TemplateName  string
 	Hostname      string
@@ -56,67 +122,317 @@ type APIDefOptions struct {
 	LegacyAPIDef  *objects.DBApiDefinition
 	Annotations   map[string]string
 	CertificateID []string
+	PathRoutes    []PathRoute
+	PathType      string
+	Source        *SourceMeta
+	Filters       *RequestFilters
+	JWT           *JWTAuth
+	OpenID        *OpenIDAuth
+	HMAC          *HMACAuth
+	// ClientCertificates are the IDs of the certificates clients authenticate with
+	ClientCertificates []string
+	// UpstreamPins are public key fingerprints the upstream certificate chain must contain
+	UpstreamPins []string
+	// StripListenPath and StripVersionPath override the stripping of the template when set
+	StripListenPath  *bool
+	StripVersionPath *bool
+	// Transport tunes the upstream connections
+	Transport *ProxyTransport
+	// UpstreamProtocol is one of the Upstream protocols, empty keeps the target schemes
+	UpstreamProtocol string
+	// BatchRequests overrides the batch request support of the template when set
+	BatchRequests *bool
+	// CachePaths limits caching to some paths
+	CachePaths *CachePaths
+	// BasicAuth enables basic auth, the users are provisioned separately
+	BasicAuth bool
+	// Definition is a complete API definition used instead of the template, the slug and
+	// tags of the options are still applied to it
+	Definition json.RawMessage
+	// SyncID identifies the reconcile that built the options, it is logged and written
+	// into the config data of the API
+	SyncID string
+	// AllowedOrgs are the organisations annotations may move the API to, the org of the
+	// template is always allowed. Nil doesn't check the org
+	AllowedOrgs []string
+	// TargetCheckPath adds an uptime test per target on the path, targets failing it are
+	// taken out of the load balancing
+	TargetCheckPath string
 }
 
+// PathRoute sends requests under a path prefix to a different upstream, used when
+// several backends share a single API
+type PathRoute struct {
+	Path   string
+	Target string
+}
+
+var routedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
+
 var cfg *TykConf
 var log = logger.GetLogger("tyk-api")
 var templates *template.Template
 var defaultTemplate *template.Template
 
+const (
+	initialInitBackoff = time.Second
+	maxInitBackoff     = time.Minute
+)
+
 const (
 	DefaultTemplate = "default"
 	TemplateNameKey = "template.service.tyk.io"
+
+	PathTypePrefix                 = "Prefix"
+	PathTypeExact                  = "Exact"
+	PathTypeImplementationSpecific = "ImplementationSpecific"
+	PathTypeRegex                  = "Regex"
 )
 
-func Init(forceConf *TykConf) {
-	defaultTemplate = template.Must(template.New("default").Parse(defaultAPITemplate))
+var initErr = errors.New("tyk API client not initialised")
+var stateMu = sync.RWMutex{}
+
+// ConfigError is an invalid config or template, retrying Init doesn't fix it
+type ConfigError struct {
+	Msg string
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Msg == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Msg, e.Err)
+}
+
+func configError(msg string, err error) *ConfigError {
+	return &ConfigError{Msg: msg, Err: err}
+}
+
+// IsConfigError reports whether Init failed on the config rather than on Tyk
+func IsConfigError(err error) bool {
+	_, ok := err.(*ConfigError)
+	return ok
+}
+
+// Init loads the Tyk config, the returned error is also reported by Ready until Init
+// succeeds so callers fail their requests instead of the process exiting
+func Init(forceConf *TykConf) error {
+	err := doInit(forceConf)
+	stateMu.Lock()
+	initErr = err
+	stateMu.Unlock()
+
+	return err
+}
+
+// InitWithRetry keeps retrying Init and the version check in the background with an
+// exponential backoff, so a Dashboard that is down at start up does not stop the controller.
+// Config and template errors don't go away by retrying, they are returned instead and
+// Ready keeps reporting them
+func InitWithRetry(forceConf *TykConf) error {
+	err := initAndCheck(forceConf)
+	if err == nil {
+		return nil
+	}
+
+	if IsConfigError(err) {
+		return err
+	}
+
+	go func() {
+		backoff := initialInitBackoff
+		for err != nil {
+			log.Errorf("tyk API client not ready, retrying in %v: %v", backoff, err)
+			time.Sleep(backoff)
+
+			backoff *= 2
+			if backoff > maxInitBackoff {
+				backoff = maxInitBackoff
+			}
 
-	if forceConf != nil {
-		cfg = forceConf
+			err = initAndCheck(forceConf)
+			if IsConfigError(err) {
+				log.Error("tyk API client can't be configured: ", err)
+				return
+			}
+		}
+
+		log.Info("tyk API client ready")
+	}()
+
+	return nil
+}
+
+// initAndCheck runs Init and checks the version of the Dashboard or gateway, a version
+// that isn't supported keeps the client unusable until it is upgraded
+func initAndCheck(forceConf *TykConf) error {
+	if err := Init(forceConf); err != nil {
+		return err
 	}
 
+	err := checkVersion(conf())
+	stateMu.Lock()
+	initErr = err
+	stateMu.Unlock()
+
+	return err
+}
+
+// Ready returns the last Init error, nil once the client is usable
+func Ready() error {
+	stateMu.RLock()
+	defer stateMu.RUnlock()
+	return initErr
+}
+
+// conf returns the published config, it is built completely before it is published
+// and never changed afterwards so it can be read without holding the lock
+func conf() *TykConf {
+	stateMu.RLock()
+	defer stateMu.RUnlock()
+	return cfg
+}
+
+// loaded returns the init error while no config is published. Rendering needs the config
+// and templates but not a reachable Tyk, so offline renders don't wait for the version check
+func loaded() error {
+	stateMu.RLock()
+	defer stateMu.RUnlock()
 	if cfg == nil {
-		cfg = &TykConf{}
-		err := viper.UnmarshalKey("Tyk", cfg)
+		return initErr
+	}
+	return nil
+}
+
+func doInit(forceConf *TykConf) error {
+	base := forceConf
+	if base == nil {
+		base = conf()
+	}
+
+	if base == nil {
+		base = &TykConf{}
+		err := viper.UnmarshalKey("Tyk", base)
 		if err != nil {
-			log.Fatalf("failed to load config: %v", err)
+			return configError("failed to load config", err)
 		}
 	}
 
-	if cfg.Templates != "" {
-		log.Info("template directory detected, loading from ", cfg.Templates)
-		templates = template.Must(template.ParseGlob(path.Join(cfg.Templates, "*.json")))
+	// the config is built on a copy, readers only ever see it complete
+	c := *base
+
+	dTpl, err := loadDefaultTemplate(&c)
+	if err != nil {
+		return configError("", err)
 	}
 
-	if cfg.InsecureSkipVerify {
+	if c.Cloud {
+		err := applyCloudMode(&c)
+		if IsConfigError(err) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("failed to configure cloud mode: %v", err)
+		}
+	}
+
+	if err := validateCompat(&c); err != nil {
+		return configError("failed to load config", err)
+	}
+
+	var tpls *template.Template
+	if c.Templates != "" {
+		log.Info("template directory detected, loading from ", c.Templates)
+		tpls, err = template.New("").Funcs(templateFuncs("")).ParseGlob(path.Join(c.Templates, "*.json"))
+		if err != nil {
+			return configError("failed to load templates", err)
+		}
+	}
+
+	if c.InsecureSkipVerify {
 		log.Warning("TLS is not being validated, please ensure certificates are valid")
 	}
 
+	setRateLimit(c.RequestsPerSecond, c.Burst)
+	tplMu.Lock()
+	defaultTemplate = dTpl
+	if tpls != nil {
+		templates = tpls
+	}
+	tplMu.Unlock()
+
+	stateMu.Lock()
+	cfg = &c
+	stateMu.Unlock()
+	return nil
+}
+
+// loadDefaultTemplate parses the configured default template, falling back to the built
+// in one
+func loadDefaultTemplate(c *TykConf) (*template.Template, error) {
+	body := defaultAPITemplate
+	switch {
+	case c.DefaultTemplateBody != "":
+		log.Info("using default template from config")
+		body = c.DefaultTemplateBody
+	case c.DefaultTemplateFile != "":
+		log.Info("loading default template from ", c.DefaultTemplateFile)
+		b, err := ioutil.ReadFile(c.DefaultTemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read default template: %v", err)
+		}
+		body = string(b)
+	}
+
+	tpl, err := template.New(DefaultTemplate).Funcs(templateFuncs("")).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default template: %v", err)
+	}
+
+	return tpl, nil
+}
+
+func newClient() (interfaces.UniversalClient, error) {
+	cl, err := newAPIClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return throttle(cl), nil
 }
 
-func newClient() interfaces.UniversalClient {
+func newAPIClient() (interfaces.UniversalClient, error) {
+	if err := Ready(); err != nil {
+		return nil, err
+	}
+
+	c := conf()
 	var cl interfaces.UniversalClient
 	var err error
 
-	cl, err = dashboard.NewDashboardClient(cfg.URL, cfg.Secret)
-	if cfg.IsGateway {
-		cl, err = gateway.NewGatewayClient(cfg.URL, cfg.Secret)
+	cl, err = dashboard.NewDashboardClient(c.URL, c.Secret)
+	if c.IsGateway {
+		cl, err = gateway.NewGatewayClient(c.URL, c.Secret)
 	}
 
 	if err != nil {
-		log.Fatalf("failed to create tyk API client: %v", err)
+		return nil, fmt.Errorf("failed to create tyk API client: %v", err)
 	}
 
-	if cfg.InsecureSkipVerify {
+	if c.InsecureSkipVerify {
 		log.Warn("TLS certificate will not be verified")
-		cl.SetInsecureTLS(cfg.InsecureSkipVerify)
+		cl.SetInsecureTLS(c.InsecureSkipVerify)
 	}
 
-	return cl
+	return cl, nil
 }
 
 func getTemplate(name string) (*template.Template, error) {
-	if cfg.Templates == "" {
+	tplMu.RLock()
+	defer tplMu.RUnlock()
+
+	if conf().Templates == "" && templates == nil {
 		log.Warning("using default template")
 		return defaultTemplate, nil
 	}
@@ -127,6 +443,9 @@ func getTemplate(name string) (*template.Template, error) {
 
 	tpl := templates.Lookup(name)
 	if tpl == nil {
+		if name == DefaultTemplate {
+			return defaultTemplate, nil
+		}
 		return defaultTemplate, errors.New("template not found")
 	}
 
@@ -135,37 +454,216 @@ func getTemplate(name string) (*template.Template, error) {
 }
 
 func TemplateService(opts *APIDefOptions) ([]byte, error) {
+	if err := loaded(); err != nil {
+		return nil, err
+	}
+
+	c := conf()
 	if opts.TemplateName == "" {
 		opts.TemplateName = DefaultTemplate
 	}
 
 	defTpl, err := getTemplate(opts.TemplateName)
+	if err != nil {
+		return nil, templateError(opts, err)
+	}
+
+	// templates are shared, the clone binds the lookups to the namespace of this source
+	ns := ""
+	if opts.Source != nil {
+		ns = opts.Source.Namespace
+	}
+	defTpl, err = defTpl.Clone()
 	if err != nil {
 		return nil, err
 	}
+	defTpl.Funcs(templateFuncs(ns))
+	if !c.LenientTemplates {
+		defTpl.Option("missingkey=error")
+	}
 
 	tplVars := map[string]interface{}{
 		"Name":          opts.Name,
 		"Slug":          cleanSlug(opts.Slug),
-		"Org":           cfg.Org,
+		"Org":           c.Org,
 		"ListenPath":    opts.ListenPath,
 		"Target":        opts.Target,
-		"GatewayTags":   opts.Tags,
+		"TargetList":    opts.TargetList,
+		"GatewayTags":   gatewayTags(opts),
 		"HostName":      opts.Hostname,
 		"CertificateID": opts.CertificateID,
+		"ClusterName":   c.ClusterName,
 	}
 
 	var apiDefStr bytes.Buffer
 	err = defTpl.Execute(&apiDefStr, tplVars)
 	if err != nil {
-		return nil, err
+		return nil, templateError(opts, err)
 	}
 
 	return apiDefStr.Bytes(), nil
 }
 
+// gatewayTags adds the cluster name, the cluster group tags and the segment tags for the
+// source namespace to the API tags
+func gatewayTags(opts *APIDefOptions) []string {
+	c := conf()
+	all := make([]string, 0, len(opts.Tags))
+	all = append(all, opts.Tags...)
+	all = append(all, c.GroupTags...)
+	all = append(all, c.ClusterName)
+	if opts.Source != nil {
+		all = append(all, c.NamespaceTags[opts.Source.Namespace]...)
+	}
+
+	seen := map[string]struct{}{}
+	tags := make([]string, 0, len(all))
+	for _, t := range all {
+		if _, ok := seen[t]; ok || t == "" {
+			continue
+		}
+		seen[t] = struct{}{}
+		tags = append(tags, t)
+	}
+
+	return tags
+}
+
+// applyPathRoutes adds a URL rewrite per route and method to every version of the definition
+func applyPathRoutes(def *apidef.APIDefinition, routes []PathRoute) {
+	if len(routes) == 0 {
+		return
+	}
+
+	for vName, v := range def.VersionData.Versions {
+		v.UseExtendedPaths = true
+		for _, r := range routes {
+			// the route matches whole segments, so /api doesn't take /apiv2 or /api-internal
+			pth := "/" + strings.Trim(r.Path, "/")
+			prefix := strings.TrimRight(pth, "/")
+
+			for _, m := range routedMethods {
+				v.ExtendedPaths.URLRewrite = append(v.ExtendedPaths.URLRewrite, apidef.URLRewriteMeta{
+					Path:         pth,
+					Method:       m,
+					MatchPattern: "^" + regexp.QuoteMeta(prefix) + "(/.*)?$",
+					RewriteTo:    strings.TrimRight(r.Target, "/") + prefix + "$1",
+				})
+			}
+		}
+
+		def.VersionData.Versions[vName] = v
+	}
+}
+
+// applyTargetList balances the API over the targets whatever the template renders, so
+// custom templates without a target_list don't fall back to the single target silently
+func applyTargetList(def *apidef.APIDefinition, targets []string, checkPath string) {
+	if len(targets) == 0 {
+		return
+	}
+
+	def.Proxy.EnableLoadBalancing = true
+	def.Proxy.Targets = append([]string(nil), targets...)
+	if checkPath == "" {
+		return
+	}
+
+	def.Proxy.CheckHostAgainstUptimeTests = true
+	def.UptimeTests.CheckList = make([]apidef.HostCheckObject, 0, len(targets))
+	for _, t := range targets {
+		def.UptimeTests.CheckList = append(def.UptimeTests.CheckList, apidef.HostCheckObject{
+			CheckURL: strings.TrimRight(t, "/") + "/" + strings.TrimLeft(checkPath, "/"),
+			Method:   http.MethodGet,
+		})
+	}
+}
+
+// applyPathType translates the ingress path semantics into listen path and strip
+// behaviour, Tyk listen paths are always prefixes so exact paths are enforced with
+// a white list and regex paths are expressed as a mux variable
+func applyPathType(def *apidef.APIDefinition, pathType string) error {
+	switch pathType {
+	case "", PathTypePrefix, PathTypeImplementationSpecific:
+		return nil
+	case PathTypeExact:
+		lp := def.Proxy.ListenPath
+		def.Proxy.StripListenPath = false
+		actions := map[string]apidef.EndpointMethodMeta{}
+		for _, m := range routedMethods {
+			actions[m] = apidef.EndpointMethodMeta{Action: apidef.NoAction}
+		}
+
+		for vName, v := range def.VersionData.Versions {
+			v.UseExtendedPaths = true
+			v.ExtendedPaths.WhiteList = append(v.ExtendedPaths.WhiteList, apidef.EndPointMeta{
+				Path:          lp + "$",
+				MethodActions: actions,
+			})
+			def.VersionData.Versions[vName] = v
+		}
+
+		return nil
+	case PathTypeRegex:
+		rx := strings.TrimPrefix(def.Proxy.ListenPath, "/")
+		if _, err := regexp.Compile(rx); err != nil {
+			return fmt.Errorf("invalid regex path %v: %v", def.Proxy.ListenPath, err)
+		}
+
+		def.Proxy.ListenPath = "/{path:" + rx + "}"
+		def.Proxy.StripListenPath = false
+		return nil
+	default:
+		return fmt.Errorf("unsupported path type: %v", pathType)
+	}
+}
+
+// finaliseDefinition applies the options that are not part of the template to a definition
+func finaliseDefinition(def *apidef.APIDefinition, opts *APIDefOptions) error {
+	markManaged(def)
+	markSource(def, opts.Source)
+	applyOwner(def, opts.Source)
+	applyPathRoutes(def, opts.PathRoutes)
+	applyTargetList(def, opts.TargetList, opts.TargetCheckPath)
+	applyUpstreamProtocol(def, opts.UpstreamProtocol)
+	applyFilters(def, opts.Filters)
+	applyJWT(def, opts.JWT)
+	applyOpenID(def, opts.OpenID)
+	applyBasicAuth(def, opts.BasicAuth)
+	applyHMAC(def, opts.HMAC)
+	applyClientCertificates(def, opts.ClientCertificates)
+	applyUpstreamPins(def, opts.UpstreamPins)
+	applyTransport(def, opts.Transport)
+	applyCachePaths(def, opts.CachePaths)
+	if err := applyPathType(def, opts.PathType); err != nil {
+		return err
+	}
+
+	applyStripping(def, opts)
+	if opts.BatchRequests != nil {
+		def.EnableBatchRequestSupport = *opts.BatchRequests
+	}
+	return nil
+}
+
+// applyStripping overrides the listen path and version stripping, it runs after the path
+// type so an explicit choice wins over the path type default
+func applyStripping(def *apidef.APIDefinition, opts *APIDefOptions) {
+	if opts.StripListenPath != nil {
+		def.Proxy.StripListenPath = *opts.StripListenPath
+	}
+
+	if opts.StripVersionPath != nil {
+		def.VersionDefinition.StripPath = *opts.StripVersionPath
+	}
+}
+
 func CreateCertificate(crt, key []byte) (string, error) {
-	cl := newClient()
+	cl, err := newClient()
+	if err != nil {
+		return "", err
+	}
+
 	combined := make([]byte, 0)
 	combined = append(combined, crt...)
 	combined = append(combined, key...)
@@ -188,87 +686,368 @@ func CreateCertificate(crt, key []byte) (string, error) {
 	return id, nil
 }
 
-func CreateService(opts *APIDefOptions) (string, error) {
-	adBytes, err := TemplateService(opts)
+// RenderDefinition returns the API definition the options would be synced as, without
+// talking to Tyk
+func RenderDefinition(opts *APIDefOptions) (*apidef.APIDefinition, error) {
+	return renderDefinition(opts)
+}
+
+// renderDefinition templates the options and runs the result through the annotation
+// processor and the post-processing hook
+func renderDefinition(opts *APIDefOptions) (*apidef.APIDefinition, error) {
+	if err := loaded(); err != nil {
+		return nil, err
+	}
+
+	c := conf()
+	adBytes, err := templateOrDefinition(opts)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	postProcessedDef := string(adBytes)
-	log.Info(postProcessedDef)
 	if opts.Annotations != nil {
 		postProcessedDef, err = processor.Process(opts.Annotations, string(adBytes))
 		if err != nil {
-			return "", err
+			processorFailed(opts)
+			return nil, err
 		}
 	}
 
+	postProcessedDef, err = callPostProcessHook(postProcessedDef, opts)
+	if err != nil {
+		processorFailed(opts)
+		return nil, err
+	}
+
+	postProcessedDef, _ = applyCompat(opts, postProcessedDef)
+	if _, err := checkSchema(opts, postProcessedDef); err != nil {
+		return nil, templateError(opts, err)
+	}
+
 	apiDef := objects.NewDefinition()
 	err = json.Unmarshal([]byte(postProcessedDef), apiDef)
+	if err != nil {
+		return nil, jsonError(opts, []byte(postProcessedDef), err)
+	}
+
+	if opts.Definition != nil {
+		apiDef.Slug = cleanSlug(opts.Slug)
+		if apiDef.OrgID == "" && c != nil {
+			apiDef.OrgID = c.Org
+		}
+		apiDef.Tags = gatewayTags(&APIDefOptions{Tags: append(apiDef.Tags, opts.Tags...), Source: opts.Source})
+	}
+
+	err = finaliseDefinition(apiDef, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkOrg(opts, adBytes, apiDef.OrgID); err != nil {
+		return nil, templateError(opts, err)
+	}
+
+	// rendered definitions can hold secret values read by the template, only their hash
+	// is logged
+	syncLogger(opts.SyncID).Debugf("rendered %s: sha256 %s", apiDef.Slug, definitionHash(apiDef))
+	return apiDef, nil
+}
+
+// checkOrg refuses definitions whose org is neither the org of the template nor one of
+// the allowed orgs, whatever annotation or patch changed it
+func checkOrg(opts *APIDefOptions, rendered []byte, org string) error {
+	c := conf()
+	if opts.AllowedOrgs == nil {
+		return nil
+	}
+
+	tpl := struct {
+		OrgID string `json:"org_id"`
+	}{}
+	json.Unmarshal(rendered, &tpl)
+	if tpl.OrgID == "" && c != nil {
+		tpl.OrgID = c.Org
+	}
+
+	if org == tpl.OrgID {
+		return nil
+	}
+
+	for _, o := range opts.AllowedOrgs {
+		if o == org {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("organisation %q is not in the allowed orgs", org)
+}
+
+func templateOrDefinition(opts *APIDefOptions) ([]byte, error) {
+	if opts.Definition != nil {
+		return opts.Definition, nil
+	}
+
+	return TemplateService(opts)
+}
+
+func CreateService(opts *APIDefOptions) (string, error) {
+	if err := writes.enter(); err != nil {
+		return "", err
+	}
+	defer writes.leave()
+
+	cl, err := newClient()
 	if err != nil {
 		return "", err
 	}
 
-	cl := newClient()
+	c := conf()
+	idempotent := c != nil && c.IdempotentCreates
+	if quotasEnabled() || idempotent {
+		apis, err := cl.FetchAPIs()
+		if err != nil {
+			return "", err
+		}
 
+		if existing := existingAPI(apis, opts); idempotent && existing != nil {
+			return upsertService(cl, existing, opts)
+		}
+
+		if quotasEnabled() {
+			if err := newQuotaCounter(apis).reserve(opts); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	cl, err = clientFor(cl, sourceNS(opts))
+	if err != nil {
+		return "", err
+	}
+
+	return createService(cl, opts)
+}
+
+// existingAPI returns the API the options would duplicate, matched by slug or by the API
+// ID of a complete definition
+func existingAPI(apis []objects.DBApiDefinition, opts *APIDefOptions) *objects.DBApiDefinition {
+	apiID := ""
+	if opts.Definition != nil {
+		ids := struct {
+			APIID string `json:"api_id"`
+		}{}
+		json.Unmarshal(opts.Definition, &ids)
+		apiID = ids.APIID
+	}
+
+	slug := cleanSlug(opts.Slug)
+	for i := range apis {
+		if apis[i].Slug == slug || (apiID != "" && apis[i].APIID == apiID) {
+			return &apis[i]
+		}
+	}
+
+	return nil
+}
+
+// upsertService updates an existing API with the options, only managed APIs are taken over
+func upsertService(cl interfaces.UniversalClient, existing *objects.DBApiDefinition, opts *APIDefOptions) (string, error) {
+	if !IsManaged(&existing.APIDefinition) {
+		return "", fmt.Errorf("API %s already exists and is not managed", existing.Slug)
+	}
+
+	apiDef, err := renderDefinition(opts)
+	if err != nil {
+		return "", err
+	}
+
+	hash := definitionHash(apiDef)
+	stampSyncID(apiDef, opts.SyncID)
+
+	// Retain identity
+	apiDef.Id = existing.Id
+	apiDef.APIID = existing.APIID
+	apiDef.OrgID = existing.OrgID
+
+	ucl, err := clientFor(cl, sourceNS(opts))
+	if err != nil {
+		return "", err
+	}
+
+	defer apiIndex.invalidate()
+	if err := ucl.UpdateAPI(apiDef); err != nil {
+		return "", err
+	}
+
+	syncLogger(opts.SyncID).Info("updated existing API instead of creating: ", existing.Slug)
+	recordSync(apiDef.Slug, hash)
+	notifyChange(notify.APIUpdated, apiDef, opts.SyncID)
+	return ucl.GetActiveID(apiDef), nil
+}
+
+func createService(cl interfaces.UniversalClient, opts *APIDefOptions) (string, error) {
+	apiDef, err := renderDefinition(opts)
+	if err != nil {
+		return "", err
+	}
+
+	hash := definitionHash(apiDef)
+	stampSyncID(apiDef, opts.SyncID)
+	id, err := createDefinition(cl, apiDef)
+	if err != nil {
+		return "", err
+	}
+
+	syncLogger(opts.SyncID).Info("created: ", apiDef.Slug)
+	recordSync(apiDef.Slug, hash)
+	notifyChange(notify.APICreated, apiDef, opts.SyncID)
+	return id, nil
+}
+
+// createDefinition creates a rendered definition and returns the ID it is deleted by
+func createDefinition(cl interfaces.UniversalClient, apiDef *apidef.APIDefinition) (string, error) {
 	// IDs are not generated by the GW
-	_, isGW := cl.(*gateway.Client)
-	if isGW {
+	defer apiIndex.invalidate()
+	if conf().IsGateway {
 		log.Warning("setting new API ID for gateway")
 		apiDef.APIID = uuid.NewV4().String()
 	}
 
 	return cl.CreateAPI(apiDef)
+}
 
+// notifyChange sends an applied change to the notification webhooks
+func notifyChange(kind string, apiDef *apidef.APIDefinition, syncID string) {
+	src, _ := apiDef.ConfigData[SourceKey].(string)
+	notify.Send(notify.Event{Type: kind, API: apiDef.Slug, APIID: apiDef.APIID, Source: src, SyncID: syncID})
 }
 
+// DeleteBySlug removes the API of a slug. When duplicates share the slug every managed
+// one is removed and the others are left alone
 func DeleteBySlug(slug string) error {
-	cl := newClient()
+	if err := writes.enter(); err != nil {
+		return err
+	}
+	defer writes.leave()
 
-	allServices, err := cl.FetchAPIs()
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	matches, err := apiIndex.lookup(cl, cleanSlug(slug))
 	if err != nil {
 		return err
 	}
 
-	cSlug := cleanSlug(slug)
-	for _, s := range allServices {
-		if cSlug == s.Slug {
-			log.Warning("found API entry, deleting: ", s.Id.Hex())
-			return cl.DeleteAPI(cl.GetActiveID(&s.APIDefinition))
+	if len(matches) == 0 {
+		return fmt.Errorf("service with name %s not found for removal, remove manually", slug)
+	}
+
+	targets := matches
+	if len(matches) > 1 {
+		targets = managedOnly(matches)
+		log.Warningf("%d APIs share the slug %s, deleting the %d managed ones", len(matches), slug, len(targets))
+		if len(targets) == 0 {
+			return fmt.Errorf("%d unmanaged APIs share the slug %s, remove manually", len(matches), slug)
+		}
+	}
+
+	defer apiIndex.invalidate()
+	errs := make([]string, 0)
+	for i := range targets {
+		s := &targets[i]
+
+		// the source recorded on the API picks the credentials of its namespace
+		dcl, err := clientFor(cl, sourceNamespace(s))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		log.Warning("found API entry, deleting: ", s.Id.Hex())
+		if err := dcl.DeleteAPI(dcl.GetActiveID(&s.APIDefinition)); err != nil {
+			errs = append(errs, err.Error())
+			continue
 		}
+		notifyChange(notify.APIDeleted, &s.APIDefinition, "")
 	}
 
-	return fmt.Errorf("service with name %s not found for removal, remove manually", slug)
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func managedOnly(apis []objects.DBApiDefinition) []objects.DBApiDefinition {
+	managed := make([]objects.DBApiDefinition, 0, len(apis))
+	for _, a := range apis {
+		if IsManaged(&a.APIDefinition) {
+			managed = append(managed, a)
+		}
+	}
+
+	return managed
 }
 
 func UpdateAPIs(svcs map[string]*APIDefOptions) error {
-	cl := newClient()
+	if err := writes.enter(); err != nil {
+		return err
+	}
+	defer writes.leave()
+
+	cl, deferReload, err := newSyncClient()
+	if err != nil {
+		return err
+	}
 
 	allServices, err := cl.FetchAPIs()
 	if err != nil {
 		return err
 	}
+	defer apiIndex.invalidate()
 
 	errs := make([]error, 0)
 	toUpdate := map[string]*APIDefOptions{}
 	toCreate := map[string]*APIDefOptions{}
+	refused := map[string]bool{}
 
-	// To update
+	bySlug := map[string][]objects.DBApiDefinition{}
+	for _, a := range allServices {
+		bySlug[a.Slug] = append(bySlug[a.Slug], a)
+	}
+
+	// To update, only managed APIs are taken over like in upsertService. Failed syncs can
+	// leave duplicates behind, the first managed one is updated
 	for ingressID, o := range svcs {
 		cSlug := cleanSlug(ingressID)
-		for _, s := range allServices {
-			if cSlug == s.Slug {
-				o.LegacyAPIDef = &s
-				toUpdate[cSlug] = o
-			}
+		matches := bySlug[cSlug]
+		if len(matches) == 0 {
+			continue
 		}
+
+		managed := managedOnly(matches)
+		if len(managed) == 0 {
+			errs = append(errs, fmt.Errorf("API %s already exists and is not managed", cSlug))
+			refused[cSlug] = true
+			continue
+		}
+
+		if len(matches) > 1 {
+			log.Warningf("%d APIs share the slug %s, updating the managed one %s", len(matches), cSlug, managed[0].APIID)
+		}
+
+		o.LegacyAPIDef = &managed[0]
+		toUpdate[cSlug] = o
 	}
 
 	// To create
 	for ingressID, o := range svcs {
 		cSlug := cleanSlug(ingressID)
 		_, updatingAlready := toUpdate[cSlug]
-		if updatingAlready {
+		if updatingAlready || refused[cSlug] {
 			// skip
 			continue
 		}
@@ -276,79 +1055,154 @@ func UpdateAPIs(svcs map[string]*APIDefOptions) error {
 		toCreate[cSlug] = o
 	}
 
+	// every definition is rendered before any is applied, so a broken template doesn't
+	// leave the batch half applied
+	staged := make([]*stagedChange, 0, len(svcs))
 	for _, opts := range toUpdate {
-		adBytes, err := TemplateService(opts)
+		apiDef, err := renderDefinition(opts)
 		if err != nil {
 			errs = append(errs, err)
 			continue
 		}
 
-		apiDef := objects.NewDefinition()
-		err = json.Unmarshal(adBytes, apiDef)
-		if err != nil {
-			errs = append(errs, err)
-			continue
-		}
+		hash := definitionHash(apiDef)
+		stampSyncID(apiDef, opts.SyncID)
 
 		// Retain identity
 		apiDef.Id = opts.LegacyAPIDef.Id
 		apiDef.APIID = opts.LegacyAPIDef.APIID
 		apiDef.OrgID = opts.LegacyAPIDef.OrgID
 
-		err = cl.UpdateAPI(apiDef)
+		ucl, err := clientFor(cl, sourceNS(opts))
 		if err != nil {
 			errs = append(errs, err)
 			continue
 		}
 
+		staged = append(staged, &stagedChange{def: apiDef, hash: hash, cl: ucl, previous: &opts.LegacyAPIDef.APIDefinition, syncID: opts.SyncID})
+	}
+
+	// creations are counted in slug order so the same APIs are blocked on every sync
+	quota := newQuotaCounter(allServices)
+	createSlugs := make([]string, 0, len(toCreate))
+	for cSlug := range toCreate {
+		createSlugs = append(createSlugs, cSlug)
 	}
+	sort.Strings(createSlugs)
 
-	for _, opts := range toCreate {
-		id, err := CreateService(opts)
+	for _, cSlug := range createSlugs {
+		opts := toCreate[cSlug]
+		if err := quota.reserve(opts); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		ccl, err := clientFor(cl, sourceNS(opts))
 		if err != nil {
 			errs = append(errs, err)
 			continue
 		}
 
-		log.Info("created: ", id)
+		apiDef, err := renderDefinition(opts)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		hash := definitionHash(apiDef)
+		stampSyncID(apiDef, opts.SyncID)
+		staged = append(staged, &stagedChange{def: apiDef, hash: hash, cl: ccl, syncID: opts.SyncID})
 	}
 
-	if len(errs) > 0 {
-		msg := ""
-		for i, e := range errs {
-			if i != 0 {
-				msg = e.Error()
+	applied := make([]*stagedChange, 0, len(staged))
+	for _, c := range staged {
+		if err := c.apply(); err != nil {
+			syncLogger(c.syncID).Errorf("failed to apply %s: %v", c.def.Slug, err)
+			errs = append(errs, err)
+			continue
+		}
+
+		applied = append(applied, c)
+	}
+
+	if failed := len(staged) - len(applied); shouldRollback(failed, len(staged)) && len(applied) > 0 {
+		errs = append(errs, rollback(applied, failed, len(staged)))
+	} else {
+		for _, c := range applied {
+			recordSync(c.def.Slug, c.hash)
+			if c.createdID != "" {
+				syncLogger(c.syncID).Info("created: ", c.createdID)
+				notifyChange(notify.APICreated, c.def, c.syncID)
+			} else {
+				syncLogger(c.syncID).Info("updated: ", c.def.Slug)
+				notifyChange(notify.APIUpdated, c.def, c.syncID)
 			}
-			msg += "; " + msg
 		}
+	}
 
-		return fmt.Errorf(msg)
+	if deferReload && len(toUpdate)+len(toCreate) > 0 {
+		reloadGateways(cl)
+	}
+
+	if len(errs) > 0 {
+		msgs := make([]string, 0, len(errs))
+		for _, e := range errs {
+			msgs = append(msgs, e.Error())
+		}
+
+		return errors.New(strings.Join(msgs, "; "))
 	}
 
 	return nil
 
 }
 
+// GetBySlug returns the API of a slug, when duplicates share the slug a managed one is
+// preferred and the duplicates are logged
 func GetBySlug(slug string) (*objects.DBApiDefinition, error) {
-	cl := newClient()
-
-	allServices, err := cl.FetchAPIs()
+	matches, err := GetAllBySlug(slug)
 	if err != nil {
 		return nil, err
 	}
 
-	cSlug := cleanSlug(slug)
-	for _, s := range allServices {
-		if cSlug == s.Slug {
-			return &s, nil
-		}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("service with name %s not found", slug)
+	}
+
+	if len(matches) == 1 {
+		return &matches[0], nil
+	}
+
+	ids := make([]string, 0, len(matches))
+	for i := range matches {
+		ids = append(ids, matches[i].APIID)
+	}
+	log.Warningf("%d APIs share the slug %s: %s", len(matches), slug, strings.Join(ids, ", "))
+
+	if managed := managedOnly(matches); len(managed) > 0 {
+		return &managed[0], nil
 	}
 
-	return nil, fmt.Errorf("service with name %s not found", slug)
+	return &matches[0], nil
+}
+
+// GetAllBySlug returns every API with the slug, empty when there is none
+func GetAllBySlug(slug string) ([]objects.DBApiDefinition, error) {
+	cl, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return apiIndex.lookup(cl, cleanSlug(slug))
 }
 
 func DeleteByID(id string) error {
-	cl := newClient()
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	defer apiIndex.invalidate()
 	return cl.DeleteAPI(id)
 }
 
@@ -383,7 +1237,9 @@ var defaultAPITemplate = `
     "proxy": {
         "listen_path": "{{.ListenPath}}",
         "target_url": "{{.Target}}",
-        "strip_listen_path": true
+        "strip_listen_path": true,
+        "enable_load_balancing": {{ if .TargetList }}true{{ else }}false{{ end }},
+        "target_list": [{{ range $i, $e := .TargetList }}{{ if $i }},{{ end }}"{{ $e }}"{{ end }}]
     },
 	"domain": "{{.HostName}}",
 	"response_processors": [],
@@ -2,10 +2,49 @@ package tyk
 
 import (
 	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/TykTechnologies/tyk-git/clients/interfaces"
+	"github.com/TykTechnologies/tyk-git/clients/objects"
+	"github.com/TykTechnologies/tyk/apidef"
 	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// withDashboard serves the Dashboard API, or the gateway API with c.IsGateway, from
+// handler and points the client at it. The config and API index are restored when the
+// test ends
+func withDashboard(t *testing.T, handler http.HandlerFunc, c *TykConf) *httptest.Server {
+	srv := httptest.NewServer(handler)
+	oldCfg := cfg
+	t.Cleanup(func() {
+		srv.Close()
+		cfg = oldCfg
+		Init(oldCfg)
+		apiIndex.invalidate()
+	})
+
+	c.URL = srv.URL
+	if err := Init(c); err != nil {
+		t.Fatal(err)
+	}
+	apiIndex.invalidate()
+
+	return srv
+}
+
 func TestInit(t *testing.T) {
 	viper.SetConfigFile("./test.yaml")
 	err := viper.ReadConfig(bytes.NewReader([]byte(sampleConf)))
@@ -13,8 +52,15 @@ func TestInit(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	Init(nil)
-	newClient()
+	err = Init(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = newClient()
+	if err != nil {
+		t.Fatal(err)
+	}
 
 }
 
@@ -33,3 +79,2382 @@ Injector:
   createRoutes: false
 
 `
+
+func TestApplyPathRoutes(t *testing.T) {
+	def := objects.NewDefinition()
+	def.VersionData.Versions = map[string]apidef.VersionInfo{
+		"Default": {Name: "Default"},
+	}
+
+	applyPathRoutes(def, []PathRoute{{Path: "/api/", Target: "http://api.ns:80"}})
+
+	v := def.VersionData.Versions["Default"]
+	if !v.UseExtendedPaths {
+		t.Fatal("extended paths should be enabled")
+	}
+
+	if len(v.ExtendedPaths.URLRewrite) != len(routedMethods) {
+		t.Fatalf("expected a rewrite per method, got %v", len(v.ExtendedPaths.URLRewrite))
+	}
+
+	rw := v.ExtendedPaths.URLRewrite[0]
+	if rw.Path != "/api" || rw.MatchPattern != "^/api(/.*)?$" || rw.RewriteTo != "http://api.ns:80/api$1" {
+		t.Fatalf("unexpected rewrite: %+v", rw)
+	}
+
+	re := regexp.MustCompile(rw.MatchPattern)
+	for pth, want := range map[string]bool{
+		"/api": true, "/api/": true, "/api/users": true,
+		"/apiv2": false, "/api-internal/users": false, "/ap": false,
+	} {
+		if re.MatchString(pth) != want {
+			t.Fatalf("route /api matching %s should be %v", pth, want)
+		}
+	}
+
+	root := objects.NewDefinition()
+	root.VersionData.Versions = map[string]apidef.VersionInfo{"Default": {Name: "Default"}}
+	applyPathRoutes(root, []PathRoute{{Path: "/", Target: "http://web.ns:80/"}})
+	rw = root.VersionData.Versions["Default"].ExtendedPaths.URLRewrite[0]
+	if rw.Path != "/" || !regexp.MustCompile(rw.MatchPattern).MatchString("/anything") || rw.RewriteTo != "http://web.ns:80$1" {
+		t.Fatalf("unexpected root rewrite: %+v", rw)
+	}
+}
+
+func TestApplyPathType(t *testing.T) {
+	newDef := func(lp string) *apidef.APIDefinition {
+		def := objects.NewDefinition()
+		def.Proxy.ListenPath = lp
+		def.Proxy.StripListenPath = true
+		def.VersionData.Versions = map[string]apidef.VersionInfo{
+			"Default": {Name: "Default"},
+		}
+		return def
+	}
+
+	prefix := newDef("/foo")
+	if err := applyPathType(prefix, PathTypePrefix); err != nil {
+		t.Fatal(err)
+	}
+
+	if prefix.Proxy.ListenPath != "/foo" || !prefix.Proxy.StripListenPath {
+		t.Fatal("prefix paths should be unchanged")
+	}
+
+	exact := newDef("/foo")
+	if err := applyPathType(exact, PathTypeExact); err != nil {
+		t.Fatal(err)
+	}
+
+	wl := exact.VersionData.Versions["Default"].ExtendedPaths.WhiteList
+	if exact.Proxy.StripListenPath || len(wl) != 1 || wl[0].Path != "/foo$" {
+		t.Fatalf("exact path should be white listed, got %+v", wl)
+	}
+
+	rx := newDef("/api/v[0-9]+")
+	if err := applyPathType(rx, PathTypeRegex); err != nil {
+		t.Fatal(err)
+	}
+
+	if rx.Proxy.ListenPath != "/{path:api/v[0-9]+}" || rx.Proxy.StripListenPath {
+		t.Fatal("unexpected regex listen path: ", rx.Proxy.ListenPath)
+	}
+
+	if err := applyPathType(newDef("/api/(["), PathTypeRegex); err == nil {
+		t.Fatal("expected invalid regex to fail")
+	}
+
+	if err := applyPathType(newDef("/foo"), "Fuzzy"); err == nil {
+		t.Fatal("expected unsupported path type to fail")
+	}
+}
+
+func TestPostProcessHook(t *testing.T) {
+	var got HookRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		if got.Source != nil && got.Source.Namespace == "blocked" {
+			fmt.Fprint(w, `{"allowed": false, "reason": "namespace is blocked"}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"allowed": true, "definition": {"name": "mutated"}}`)
+	}))
+	defer srv.Close()
+
+	oldCfg := cfg
+	defer func() { cfg = oldCfg }()
+	cfg = &TykConf{PostProcessHook: &HookConf{URL: srv.URL}}
+
+	opts := &APIDefOptions{Slug: "foo", Source: &SourceMeta{Kind: "Ingress", Namespace: "default", Name: "foo"}}
+	def, err := callPostProcessHook(`{"name": "rendered"}`, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if def != `{"name": "mutated"}` {
+		t.Fatal("hook definition should replace the rendered one, got ", def)
+	}
+
+	if got.Slug != "foo" || got.Source.Name != "foo" || string(got.Definition) != `{"name":"rendered"}` {
+		t.Fatalf("unexpected hook request: %+v", got)
+	}
+
+	opts.Source.Namespace = "blocked"
+	_, err = callPostProcessHook(`{"name": "rendered"}`, opts)
+	if err == nil || !strings.Contains(err.Error(), "namespace is blocked") {
+		t.Fatal("expected hook to veto the definition, got ", err)
+	}
+
+	cfg.PostProcessHook.URL = "http://127.0.0.1:1"
+	_, err = callPostProcessHook(`{"name": "rendered"}`, opts)
+	if err == nil {
+		t.Fatal("unreachable hook should fail closed")
+	}
+
+	cfg.PostProcessHook.FailOpen = true
+	def, err = callPostProcessHook(`{"name": "rendered"}`, opts)
+	if err != nil || def != `{"name": "rendered"}` {
+		t.Fatal("unreachable hook should fail open when configured, got ", def, err)
+	}
+}
+
+func TestGatewayTags(t *testing.T) {
+	oldCfg := cfg
+	defer func() { cfg = oldCfg }()
+	cfg = &TykConf{
+		GroupTags:     []string{"edge-eu", "ingress"},
+		NamespaceTags: map[string][]string{"payments": {"segment-pci"}},
+	}
+
+	opts := &APIDefOptions{Tags: []string{"ingress"}}
+	tags := gatewayTags(opts)
+	if strings.Join(tags, ",") != "ingress,edge-eu" {
+		t.Fatal("unexpected tags: ", tags)
+	}
+
+	opts.Source = &SourceMeta{Namespace: "payments"}
+	tags = gatewayTags(opts)
+	if strings.Join(tags, ",") != "ingress,edge-eu,segment-pci" {
+		t.Fatal("unexpected tags: ", tags)
+	}
+
+	if len(opts.Tags) != 1 {
+		t.Fatal("option tags should not be modified")
+	}
+
+	cfg.ClusterName = "prod-eu"
+	tags = gatewayTags(opts)
+	if strings.Join(tags, ",") != "ingress,edge-eu,prod-eu,segment-pci" {
+		t.Fatal("cluster name should be added to the tags, got ", tags)
+	}
+
+	out, err := TemplateService(&APIDefOptions{Name: "foo", Tags: []string{"ingress"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	def := objects.NewDefinition()
+	if err := json.Unmarshal(out, def); err != nil {
+		t.Fatal(err)
+	}
+
+	if def.Name != "foo #ingress #edge-eu #prod-eu" {
+		t.Fatal("cluster name should be part of the API name, got ", def.Name)
+	}
+}
+
+func TestCloudMode(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/users" && r.Header.Get("Authorization") == "admin-key" {
+			fmt.Fprint(w, `{"users": [{"org_id": "cloud-org"}, {"org_id": "other-org"}]}`)
+			return
+		}
+
+		if r.URL.Path != "/api/users" || r.Header.Get("Authorization") != "key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		fmt.Fprint(w, `{"users": [{"org_id": "cloud-org"}, {"org_id": "cloud-org"}]}`)
+	}))
+	defer srv.Close()
+
+	org, err := discoverOrg(srv.URL, "key")
+	if err == nil {
+		t.Fatal("cloud discovery should verify TLS, got org ", org)
+	}
+
+	oldClient := cloudClient
+	defer func() { cloudClient = oldClient }()
+	cloudClient = srv.Client()
+
+	c := &TykConf{URL: srv.URL, Secret: "key"}
+	if err := applyCloudMode(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Org != "cloud-org" {
+		t.Fatal("org not discovered, got ", c.Org)
+	}
+
+	// keys that see several orgs can't pick one
+	if _, err := discoverOrg(srv.URL, "admin-key"); err == nil || !strings.Contains(err.Error(), "other-org") {
+		t.Fatal("ambiguous orgs should fail discovery, got ", err)
+	}
+
+	c.Secret = "wrong"
+	c.Org = ""
+	if err := applyCloudMode(c); err == nil {
+		t.Fatal("discovery with a bad key should fail")
+	}
+
+	if err := applyCloudMode(&TykConf{URL: "http://dash"}); err == nil {
+		t.Fatal("plain http control planes should be rejected")
+	}
+
+	c = &TykConf{URL: "https://dash/", Org: "set", IsGateway: true, InsecureSkipVerify: true}
+	if err := applyCloudMode(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.IsGateway || c.InsecureSkipVerify || c.URL != "https://dash" || c.Org != "set" {
+		t.Fatalf("unexpected cloud config: %+v", c)
+	}
+}
+
+func TestDeferredReload(t *testing.T) {
+	var reloads, writes int32
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/tyk/reload/group":
+			atomic.AddInt32(&reloads, 1)
+			fmt.Fprint(w, `{"status": "ok"}`)
+		case r.Method == http.MethodGet:
+			fmt.Fprint(w, `[{"api_id": "existing", "slug": "existing", "proxy": {"listen_path": "/existing/"}, "config_data": {"tyk-k8s-managed-by": "tyk-k8s"}}]`)
+		default:
+			atomic.AddInt32(&writes, 1)
+			fmt.Fprint(w, `{"status": "ok", "key": "new"}`)
+		}
+	}, &TykConf{IsGateway: true, ReloadAfterSync: true})
+
+	err := UpdateAPIs(map[string]*APIDefOptions{
+		"existing": {Name: "existing", Slug: "existing", ListenPath: "/existing/", Target: "http://a"},
+		"new-one":  {Name: "new", Slug: "new-one", ListenPath: "/new/", Target: "http://b"},
+		"new-two":  {Name: "new", Slug: "new-two", ListenPath: "/new-two/", Target: "http://c"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, r := atomic.LoadInt32(&writes), atomic.LoadInt32(&reloads)
+	if w != 3 || r != 1 {
+		t.Fatalf("expected 3 writes and a single reload, got %v writes and %v reloads", w, r)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	setRateLimit(20, 1)
+	defer setRateLimit(0, 0)
+
+	// reservations at a fixed time are spaced by the rate, whatever the machine load
+	now := time.Now()
+	for i, want := range []time.Duration{0, 50 * time.Millisecond, 100 * time.Millisecond} {
+		r := limiter.ReserveN(now, 1)
+		if !r.OK() || r.DelayFrom(now) != want {
+			t.Fatalf("request %d should wait %v, got %v", i, want, r.DelayFrom(now))
+		}
+	}
+
+	setRateLimit(0, 0)
+	if limiter != nil {
+		t.Fatal("requests should not be throttled without a limit")
+	}
+	waitForLimit()
+}
+
+func TestInitErrors(t *testing.T) {
+	oldCfg := cfg
+	defer func() {
+		cfg = oldCfg
+		Init(oldCfg)
+	}()
+
+	err := Init(&TykConf{URL: "http://dash", Cloud: true})
+	if err == nil {
+		t.Fatal("expected invalid cloud config to fail")
+	}
+
+	if Ready() == nil {
+		t.Fatal("client should not be ready after a failed init")
+	}
+
+	if _, err := GetBySlug("foo"); err == nil {
+		t.Fatal("requests should fail while the client is not ready")
+	}
+
+	for name, c := range map[string]*TykConf{
+		"cloud":     {URL: "http://dash", Cloud: true},
+		"templates": {URL: "http://dash", Templates: "./missing"},
+		"compat":    {URL: "http://dash", TargetVersion: "latest"},
+	} {
+		if err := InitWithRetry(c); !IsConfigError(err) {
+			t.Fatalf("%s: config errors should be returned without retrying, got %v", name, err)
+		}
+
+		if !IsConfigError(Ready()) {
+			t.Fatalf("%s: the config error should be reported by Ready", name)
+		}
+	}
+
+	if err := Init(&TykConf{URL: "http://dash"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if Ready() != nil {
+		t.Fatal("client should be ready after init")
+	}
+}
+
+func TestRenderNotLoaded(t *testing.T) {
+	oldCfg, oldErr := cfg, initErr
+	defer func() {
+		cfg, initErr = oldCfg, oldErr
+	}()
+
+	cfg, initErr = nil, errors.New("failed to load config")
+	opts := &APIDefOptions{Name: "foo", Slug: "foo", ListenPath: "/foo", Target: "http://foo"}
+	if _, err := RenderDefinition(opts); err != initErr {
+		t.Fatal("rendering without a config should return the init error, got ", err)
+	}
+
+	if _, err := PlanAPIs(map[string]*APIDefOptions{"foo": opts}, "ingress", nil); err != initErr {
+		t.Fatal("planning without a config should return the init error, got ", err)
+	}
+}
+
+type countingClient struct {
+	interfaces.UniversalClient
+	fetches int
+	apis    []objects.DBApiDefinition
+}
+
+func (c *countingClient) FetchAPIs() ([]objects.DBApiDefinition, error) {
+	c.fetches++
+	return c.apis, nil
+}
+
+func TestSlugIndex(t *testing.T) {
+	a := objects.DBApiDefinition{APIDefinition: apidef.APIDefinition{Slug: "a"}}
+	b := objects.DBApiDefinition{APIDefinition: apidef.APIDefinition{Slug: "b"}}
+	cl := &countingClient{apis: []objects.DBApiDefinition{a, b, a}}
+	idx := &slugIndex{}
+
+	want := map[string]int{"a": 2, "b": 1}
+	for _, slug := range []string{"a", "b", "a"} {
+		matches, err := idx.lookup(cl, slug)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(matches) != want[slug] {
+			t.Fatalf("unexpected lookup result for %s: %v", slug, matches)
+		}
+	}
+
+	if cl.fetches != 1 {
+		t.Fatal("lookups should share a single fetch, got ", cl.fetches)
+	}
+
+	idx.invalidate()
+	if _, err := idx.lookup(cl, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if cl.fetches != 2 {
+		t.Fatal("invalidated index should be refreshed, got ", cl.fetches)
+	}
+
+	// another replica creates the API after the index was fetched
+	cl.apis = append(cl.apis, objects.DBApiDefinition{APIDefinition: apidef.APIDefinition{Slug: "c"}})
+	matches, err := idx.lookup(cl, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matches) != 1 || cl.fetches != 3 {
+		t.Fatalf("misses should be looked up again, got %v after %d fetches", matches, cl.fetches)
+	}
+}
+
+func TestManagedLookup(t *testing.T) {
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"api_id": "1", "slug": "a", "tags": ["ingress", "edge"], "config_data": {"tyk-k8s-managed-by": "cluster-a"}},
+			{"api_id": "2", "slug": "b", "tags": ["ingress"], "config_data": {"tyk-k8s-managed-by": "cluster-b"}},
+			{"api_id": "3", "slug": "c"}
+		]`)
+	}, &TykConf{IsGateway: true, ManagedBy: "cluster-a"})
+
+	managed, err := ListManaged()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(managed) != 1 || managed[0].Slug != "a" {
+		t.Fatalf("expected only the API of this cluster, got %v", managed)
+	}
+
+	tagged, err := ListByTag("ingress")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tagged) != 2 {
+		t.Fatalf("expected 2 ingress APIs, got %v", len(tagged))
+	}
+
+	groups := GroupByTag(append(tagged, objects.DBApiDefinition{}))
+	if len(groups["ingress"]) != 2 || len(groups["edge"]) != 1 || len(groups[""]) != 1 {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+
+	def := objects.NewDefinition()
+	markManaged(def)
+	if !IsManaged(def) {
+		t.Fatal("marked definition should be managed")
+	}
+}
+
+func TestManagedByCluster(t *testing.T) {
+	oldCfg := cfg
+	defer func() {
+		cfg = oldCfg
+	}()
+
+	cfg = &TykConf{ClusterName: "eu-west"}
+	def := objects.NewDefinition()
+	markManaged(def)
+	if def.ConfigData[ManagedByKey] != "tyk-k8s/eu-west" {
+		t.Fatal("the cluster name should be part of the marker, got ", def.ConfigData[ManagedByKey])
+	}
+
+	cfg = &TykConf{ClusterName: "us-east"}
+	if IsManaged(def) {
+		t.Fatal("APIs of other clusters should not be managed")
+	}
+
+	cfg = &TykConf{ClusterName: "us-east", ManagedBy: "tyk-k8s/eu-west"}
+	if !IsManaged(def) {
+		t.Fatal("managedBy should override the cluster marker")
+	}
+
+	// APIs marked before the cluster name was set stay managed after upgrading
+	legacy := objects.NewDefinition()
+	legacy.ConfigData = map[string]interface{}{ManagedByKey: "tyk-k8s"}
+	cfg = &TykConf{ClusterName: "eu-west"}
+	if !IsManaged(legacy) {
+		t.Fatal("the old marker should still be recognised")
+	}
+
+	markManaged(legacy)
+	if legacy.ConfigData[ManagedByKey] != "tyk-k8s/eu-west" {
+		t.Fatal("updates should move APIs to the new marker, got ", legacy.ConfigData[ManagedByKey])
+	}
+
+	legacy.ConfigData[ManagedByKey] = "tyk-k8s"
+	cfg = &TykConf{ClusterName: "eu-west", ManagedBy: "team-a"}
+	if IsManaged(legacy) {
+		t.Fatal("an explicit managedBy should not claim the old marker")
+	}
+}
+
+func TestDefaultTemplateOverride(t *testing.T) {
+	oldCfg := cfg
+	defer func() {
+		cfg = oldCfg
+		Init(oldCfg)
+	}()
+
+	err := Init(&TykConf{DefaultTemplateBody: `{"name": "{{.Name}}", "slug": "{{.Slug}}"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := TemplateService(&APIDefOptions{Name: "foo", Slug: "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != `{"name": "foo", "slug": "bar"}` {
+		t.Fatal("inline default template not used, got ", string(out))
+	}
+
+	f, err := ioutil.TempFile("", "default-tpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"name": "file-{{.Name}}"}`)
+	f.Close()
+
+	err = Init(&TykConf{DefaultTemplateFile: f.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err = TemplateService(&APIDefOptions{Name: "foo"})
+	if err != nil || string(out) != `{"name": "file-foo"}` {
+		t.Fatal("default template file not used, got ", string(out), err)
+	}
+
+	if err := Init(&TykConf{DefaultTemplateBody: "{{.Name"}); err == nil {
+		t.Fatal("invalid default template should fail init")
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	oldCfg := cfg
+	defer func() {
+		cfg = oldCfg
+		Init(oldCfg)
+		tplMu.Lock()
+		templates = nil
+		tplMu.Unlock()
+	}()
+	Init(&TykConf{})
+
+	err := LoadTemplates(map[string]string{
+		"default.json": `{"name": "cm-default-{{.Name}}"}`,
+		"open.json":    `{"name": "cm-open-{{.Name}}"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := TemplateService(&APIDefOptions{Name: "foo"})
+	if err != nil || string(out) != `{"name": "cm-default-foo"}` {
+		t.Fatal("default template not loaded from set, got ", string(out), err)
+	}
+
+	out, err = TemplateService(&APIDefOptions{Name: "foo", TemplateName: "open.json"})
+	if err != nil || string(out) != `{"name": "cm-open-foo"}` {
+		t.Fatal("named template not loaded from set, got ", string(out), err)
+	}
+
+	if n, ok := FindTemplate("open"); !ok || n != "open.json" {
+		t.Fatal("expected the template to be found with its suffix, got ", n, ok)
+	}
+
+	if _, ok := FindTemplate("jwt"); ok {
+		t.Fatal("templates that aren't loaded should not be found")
+	}
+
+	if _, err := TemplateService(&APIDefOptions{TemplateName: "missing.json"}); err == nil {
+		t.Fatal("unknown template should fail")
+	}
+
+	err = LoadTemplates(map[string]string{"open.json": "{{.Name"})
+	if err == nil {
+		t.Fatal("invalid template set should fail")
+	}
+
+	out, _ = TemplateService(&APIDefOptions{Name: "foo", TemplateName: "open.json"})
+	if string(out) != `{"name": "cm-open-foo"}` {
+		t.Fatal("failed load should keep the previous templates, got ", string(out))
+	}
+}
+
+func TestApplyFilters(t *testing.T) {
+	def := objects.NewDefinition()
+	def.VersionData.Versions = map[string]apidef.VersionInfo{
+		"Default": {Name: "Default"},
+	}
+
+	applyFilters(def, &RequestFilters{
+		SetHeaders:    map[string]string{"X-Env": "prod"},
+		RemoveHeaders: []string{"X-Debug"},
+		RewritePath:   "/v2/",
+		RewritePrefix: true,
+	})
+
+	v := def.VersionData.Versions["Default"]
+	if v.GlobalHeaders["X-Env"] != "prod" || len(v.GlobalHeadersRemove) != 1 {
+		t.Fatalf("unexpected headers: %v %v", v.GlobalHeaders, v.GlobalHeadersRemove)
+	}
+
+	rw := v.ExtendedPaths.URLRewrite[0]
+	if !v.UseExtendedPaths || rw.MatchPattern != "^(/.*)?$" || rw.RewriteTo != "/v2$1" {
+		t.Fatalf("unexpected rewrite: %+v", rw)
+	}
+
+	applyFilters(def, &RequestFilters{RedirectTo: "https://example.com/"})
+	v = def.VersionData.Versions["Default"]
+	reply := v.ExtendedPaths.Ignored[0].MethodActions["GET"]
+	if reply.Action != apidef.Reply || reply.Code != 302 || reply.Headers["Location"] != "https://example.com/" {
+		t.Fatalf("unexpected redirect: %+v", reply)
+	}
+}
+
+func TestApplyTimeoutsAndBreakers(t *testing.T) {
+	def := objects.NewDefinition()
+	def.VersionData.Versions = map[string]apidef.VersionInfo{
+		"Default": {Name: "Default"},
+	}
+
+	applyFilters(def, &RequestFilters{
+		TimeoutSeconds: 5,
+		CircuitBreaker: &CircuitBreaker{ThresholdPercent: 0.5, Samples: 10, ReturnToServiceAfter: 30},
+		SizeLimit:      1024,
+		AllowedIPs:     []string{"10.0.0.0/8"},
+	})
+
+	if !def.EnableIpWhiteListing || len(def.AllowedIPs) != 1 {
+		t.Fatalf("unexpected allowed IPs: %v", def.AllowedIPs)
+	}
+
+	v := def.VersionData.Versions["Default"]
+	if len(v.ExtendedPaths.HardTimeouts) != len(routedMethods) || v.ExtendedPaths.HardTimeouts[0].TimeOut != 5 {
+		t.Fatalf("unexpected timeouts: %+v", v.ExtendedPaths.HardTimeouts)
+	}
+
+	cb := v.ExtendedPaths.CircuitBreaker[0]
+	if cb.ThresholdPercent != 0.5 || cb.Samples != 10 || cb.ReturnToServiceAfter != 30 {
+		t.Fatalf("unexpected circuit breaker: %+v", cb)
+	}
+
+	if v.GlobalSizeLimit != 1024 {
+		t.Fatalf("unexpected size limit: %d", v.GlobalSizeLimit)
+	}
+}
+
+func TestRenderRawDefinition(t *testing.T) {
+	oldCfg := cfg
+	defer func() { cfg = oldCfg }()
+	cfg = &TykConf{Org: "org-1", ClusterName: "eu"}
+
+	opts := &APIDefOptions{
+		Slug:       "raw one",
+		Tags:       []string{"operator"},
+		Definition: json.RawMessage(`{"name": "raw", "tags": ["mine"], "proxy": {"listen_path": "/raw", "target_url": "http://raw"}}`),
+	}
+
+	def, err := RenderDefinition(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if def.Name != "raw" || def.Proxy.ListenPath != "/raw" || def.Slug != cleanSlug("raw one") || def.OrgID != "org-1" {
+		t.Fatalf("raw definition should be used as is, got %+v", def)
+	}
+
+	if strings.Join(def.Tags, ",") != "mine,operator,eu" {
+		t.Fatal("option tags should be added to the definition tags, got ", def.Tags)
+	}
+
+	if !IsManaged(def) {
+		t.Fatal("raw definitions should be marked as managed")
+	}
+}
+
+func TestInventory(t *testing.T) {
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `[
+				{"api_id": "a1", "slug": "synced", "name": "synced", "config_data": {"tyk-k8s-managed-by": "tyk-k8s", "tyk-k8s-source": "Ingress/default/web"}},
+				{"api_id": "a2", "slug": "seeded", "name": "seeded", "config_data": {"tyk-k8s-managed-by": "tyk-k8s"}},
+				{"api_id": "a3", "slug": "manual", "name": "manual"}
+			]`)
+			return
+		}
+		fmt.Fprint(w, `{"status": "ok", "key": "new"}`)
+	}, &TykConf{IsGateway: true, LookupCacheSeconds: -1})
+
+	err := UpdateAPIs(map[string]*APIDefOptions{
+		"synced": {Name: "synced", Slug: "synced", ListenPath: "/web/", Target: "http://web",
+			Source: &SourceMeta{Kind: "Ingress", Namespace: "default", Name: "web"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SeedInventory([]InventoryEntry{
+		{Slug: "seeded", LastSync: "2020-01-01T00:00:00Z", Hash: "abc"},
+		{Slug: "synced", LastSync: "2020-01-01T00:00:00Z", Hash: "stale"},
+		{Slug: "gone", LastSync: "2020-01-01T00:00:00Z", Hash: "def"},
+	})
+
+	entries, err := Inventory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[0].Slug != "seeded" || entries[1].Slug != "synced" {
+		t.Fatalf("only managed APIs should be listed, got %+v", entries)
+	}
+
+	if entries[0].Hash != "abc" || entries[0].LastSync != "2020-01-01T00:00:00Z" {
+		t.Fatalf("seeded entries should be restored, got %+v", entries[0])
+	}
+
+	if entries[1].Source != "Ingress/default/web" || entries[1].Hash == "stale" || entries[1].Hash == "" ||
+		entries[1].APIID != "a1" {
+		t.Fatalf("synced entries should keep their own sync, got %+v", entries[1])
+	}
+
+	if _, ok := syncLog["gone"]; ok {
+		t.Fatal("APIs removed from Tyk should be forgotten")
+	}
+}
+
+func TestNamespaceQuota(t *testing.T) {
+	var creates int32
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `[
+				{"api_id": "a1", "slug": "a1", "config_data": {"tyk-k8s-managed-by": "tyk-k8s", "tyk-k8s-source": "Ingress/team-a/one"}},
+				{"api_id": "a2", "slug": "a2", "config_data": {"tyk-k8s-managed-by": "tyk-k8s", "tyk-k8s-source": "Ingress/team-a/two"}},
+				{"api_id": "a3", "slug": "a3", "config_data": {"tyk-k8s-source": "Ingress/team-b/manual"}}
+			]`)
+			return
+		}
+		atomic.AddInt32(&creates, 1)
+		fmt.Fprint(w, `{"status": "ok", "key": "new"}`)
+	}, &TykConf{IsGateway: true, NamespaceQuota: 2, NamespaceQuotas: map[string]int{"team-c": 0}})
+
+	src := func(ns string) *SourceMeta { return &SourceMeta{Kind: "Ingress", Namespace: ns, Name: "new"} }
+	err := UpdateAPIs(map[string]*APIDefOptions{
+		"a-new":  {Name: "a", Slug: "a-new", ListenPath: "/a/", Target: "http://a", Source: src("team-a")},
+		"b-new":  {Name: "b", Slug: "b-new", ListenPath: "/b/", Target: "http://b", Source: src("team-b")},
+		"b-new2": {Name: "b", Slug: "b-new2", ListenPath: "/b2/", Target: "http://b", Source: src("team-b")},
+		"b-new3": {Name: "b", Slug: "b-new3", ListenPath: "/b3/", Target: "http://b", Source: src("team-b")},
+		"c-new":  {Name: "c", Slug: "c-new", ListenPath: "/c/", Target: "http://c", Source: src("team-c")},
+	})
+	if !IsQuotaExceeded(err) {
+		t.Fatal("expected a quota error, got ", err)
+	}
+
+	if strings.Count(err.Error(), quotaMessage) != 2 || !strings.Contains(err.Error(), "team-a") ||
+		!strings.Contains(err.Error(), "team-b") {
+		t.Fatal("team-a and the third team-b API should be blocked, got ", err)
+	}
+
+	if atomic.LoadInt32(&creates) != 3 {
+		t.Fatal("unmanaged APIs don't count and overrides lift the cap, got creates: ", creates)
+	}
+
+	_, err = CreateService(&APIDefOptions{Name: "a", Slug: "a-new", ListenPath: "/a/", Target: "http://a", Source: src("team-a")})
+	if _, ok := err.(*QuotaError); !ok {
+		t.Fatal("expected a quota error, got ", err)
+	}
+}
+
+func TestNamespaceCredentials(t *testing.T) {
+	var mu sync.Mutex
+	posted := map[string]string{}
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `{"apis": [], "pages": 1}`)
+			return
+		}
+
+		def := objects.DBApiDefinition{}
+		json.NewDecoder(r.Body).Decode(&def)
+		mu.Lock()
+		posted[def.Slug] = r.Header.Get("Authorization")
+		mu.Unlock()
+		fmt.Fprint(w, `{"Status": "OK", "Meta": "5d8e2b3c9c6b4c0001a3b4c5"}`)
+	}, &TykConf{Secret: "global", Org: "org"})
+
+	SetCredentialsFunc(func(ns string) (string, error) {
+		switch ns {
+		case "team-a":
+			return "team-a-secret", nil
+		case "broken":
+			return "", errors.New("forbidden")
+		}
+		return "", nil
+	})
+	defer SetCredentialsFunc(nil)
+
+	src := func(ns string) *SourceMeta { return &SourceMeta{Kind: "Ingress", Namespace: ns, Name: "web"} }
+	err := UpdateAPIs(map[string]*APIDefOptions{
+		"a": {Name: "a", Slug: "a", ListenPath: "/a/", Target: "http://a", Source: src("team-a")},
+		"b": {Name: "b", Slug: "b", ListenPath: "/b/", Target: "http://b", Source: src("team-b")},
+		"c": {Name: "c", Slug: "c", ListenPath: "/c/", Target: "http://c", Source: src("broken")},
+	})
+	if err == nil || !strings.Contains(err.Error(), "namespace broken") {
+		t.Fatal("unreadable credentials should fail the namespace, got ", err)
+	}
+
+	if posted["a"] != "team-a-secret" || posted["b"] != "global" {
+		t.Fatalf("unexpected credentials used: %v", posted)
+	}
+
+	if _, ok := posted["c"]; ok {
+		t.Fatal("the global secret should not be used when the namespace secret can't be read")
+	}
+}
+
+func TestPublishDocs(t *testing.T) {
+	var mu sync.Mutex
+	calls := make([]string, 0)
+	var saved catalogue
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == endpointCatalogue:
+			fmt.Fprintf(w, `{"id": "cat", "org_id": "org", "apis": [
+				{"name": "other", "api_id": "pol-2", "documentation": "doc-2", "fields": {"%[1]s": "Ingress/other/web"}},
+				{"name": "petstore", "api_id": "pol-1", "documentation": "doc-old", "fields": {"%[1]s": "Ingress/shop/petstore"}}
+			]}`, SourceKey)
+		case r.Method == http.MethodPost && r.URL.Path == endpointDocs:
+			body := map[string]string{}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["api_id"] != "pol-1" || body["doc_type"] != "swagger" {
+				t.Errorf("unexpected documentation request: %v", body)
+			}
+			fmt.Fprint(w, `{"Status": "OK", "Meta": "doc-new"}`)
+		case r.Method == http.MethodPut && r.URL.Path == endpointCatalogue:
+			json.NewDecoder(r.Body).Decode(&saved)
+			fmt.Fprint(w, `{"Status": "OK"}`)
+		default:
+			fmt.Fprint(w, `{"Status": "OK"}`)
+		}
+	}, &TykConf{Secret: "s", Org: "org"})
+
+	d := &PortalDocs{
+		Source:   "Ingress/shop/petstore",
+		Entry:    CatalogueEntry{Name: "petstore", APIID: "pol-1", Show: true},
+		Document: []byte(`{"openapi": "3.0.0"}`),
+	}
+	if err := PublishDocs(d); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(saved.APIs) != 2 || saved.APIs[1].Documentation != "doc-new" || saved.APIs[1].Version != "v2" ||
+		saved.APIs[0].Documentation != "doc-2" || saved.APIs[1].Fields[SourceKey] != d.Source {
+		t.Fatalf("only the policy entry should point at the new document: %+v", saved.APIs)
+	}
+
+	if calls[len(calls)-1] != "DELETE "+endpointDocs+"/doc-old" {
+		t.Fatal("the replaced document should be removed, got ", calls)
+	}
+
+	n := len(calls)
+	if err := PublishDocs(d); err != nil || len(calls) != n {
+		t.Fatal("unchanged documents should not be published again")
+	}
+
+	// another ingress naming the policy of an entry can't take it over
+	theirs := &PortalDocs{
+		Source:   "Ingress/shop/thief",
+		Entry:    CatalogueEntry{Name: "thief", APIID: "pol-2"},
+		Document: []byte(`{"openapi": "3.0.0"}`),
+	}
+	if err := PublishDocs(theirs); err == nil {
+		t.Fatal("entries published by other sources should be refused")
+	}
+
+	for _, c := range calls[n:] {
+		if c != "GET "+endpointCatalogue {
+			t.Fatal("refused entries should not be written, got ", calls[n:])
+		}
+	}
+}
+
+func TestSyncCatalogue(t *testing.T) {
+	var mu sync.Mutex
+	deleted := make([]string, 0)
+	var saved *catalogue
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet:
+			fmt.Fprint(w, `{"id": "cat", "apis": [
+				{"name": "manual", "api_id": "pol-0"},
+				{"name": "ingress", "api_id": "pol-i", "fields": {"tyk-k8s-source": "Ingress/shop/web"}},
+				{"name": "gone", "api_id": "pol-g", "documentation": "doc-g", "fields": {"tyk-k8s-source": "PortalCatalogue/shop/gone"}},
+				{"name": "broken", "api_id": "pol-b", "fields": {"tyk-k8s-source": "PortalCatalogue/shop/broken"}}
+			]}`)
+		case r.Method == http.MethodPost:
+			fmt.Fprint(w, `{"Status": "OK", "Meta": "doc-new"}`)
+		case r.Method == http.MethodPut:
+			saved = &catalogue{}
+			json.NewDecoder(r.Body).Decode(saved)
+			fmt.Fprint(w, `{"Status": "OK"}`)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			fmt.Fprint(w, `{"Status": "OK"}`)
+		}
+	}, &TykConf{Secret: "s", Org: "org"})
+
+	errs, err := SyncCatalogue("PortalCatalogue", []*CatalogueItem{
+		{Source: "PortalCatalogue/shop/petstore", Entry: CatalogueEntry{Name: "petstore", APIID: "pol-1"}, Document: []byte(`{}`)},
+		{Source: "PortalCatalogue/shop/broken", Keep: true},
+	})
+	if err != nil || len(errs) != 0 {
+		t.Fatal(err, errs)
+	}
+
+	names := make([]string, 0)
+	for _, e := range saved.APIs {
+		names = append(names, e.Name)
+	}
+	if strings.Join(names, ",") != "manual,ingress,broken,petstore" {
+		t.Fatal("only removed entries of the kind should be dropped, got ", names)
+	}
+
+	if e := saved.APIs[3]; e.Documentation != "doc-new" || e.Fields[SourceKey] != "PortalCatalogue/shop/petstore" {
+		t.Fatalf("unexpected new entry: %+v", e)
+	}
+
+	if len(deleted) != 1 || deleted[0] != endpointDocs+"/doc-g" {
+		t.Fatal("the documents of removed entries should be deleted, got ", deleted)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	var mu sync.Mutex
+	calls := make([]string, 0)
+	var session map[string]interface{}
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		auth := r.Header.Get("Authorization") + r.Header.Get("x-tyk-authorization")
+		calls = append(calls, r.Method+" "+r.URL.RequestURI()+" "+auth)
+		if r.Body != nil {
+			session = map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&session)
+		}
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/keys":
+			fmt.Fprint(w, `{"key_id": "k1", "key_hash": "h1"}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/tyk/keys/create":
+			fmt.Fprint(w, `{"key": "k2", "status": "ok", "action": "added"}`)
+		case r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/gone"):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			fmt.Fprint(w, `{"status": "ok"}`)
+		}
+	}, &TykConf{Secret: "s", Org: "org"})
+
+	if _, err := CreateKey(&KeySpec{}); err == nil {
+		t.Fatal("keys without policies should be refused")
+	}
+
+	spec := &KeySpec{Alias: "shop/web", Policies: []string{"pol-1"}, Expires: 100, MetaData: map[string]string{"team": "shop"}}
+	k, err := CreateKey(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k.Key != "k1" || k.Hash != "h1" {
+		t.Fatalf("unexpected key: %+v", k)
+	}
+
+	if session["org_id"] != "org" || session["alias"] != "shop/web" || session["expires"] != float64(100) ||
+		session["meta_data"].(map[string]interface{})["team"] != "shop" {
+		t.Fatalf("unexpected session: %v", session)
+	}
+
+	if err := UpdateKey("h1", true, spec); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteKey("gone", true); err != nil {
+		t.Fatal("keys that are already gone should not fail the delete: ", err)
+	}
+
+	cfg.IsGateway = true
+	k, err = CreateKey(spec)
+	if err != nil || k.Key != "k2" || k.Hash != "" {
+		t.Fatal("unexpected gateway key: ", k, err)
+	}
+
+	if err := DeleteKey("k2", false); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"POST /api/keys s",
+		"PUT /api/keys/h1?hashed=true s",
+		"DELETE /api/keys/gone?hashed=true s",
+		"POST /tyk/keys/create s",
+		"DELETE /tyk/keys/k2 s",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Fatal("unexpected calls: ", calls)
+	}
+}
+
+func TestOAuthClients(t *testing.T) {
+	var mu sync.Mutex
+	calls := make([]string, 0)
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `[
+				{"api_id": "o1", "slug": "shop-oauth", "use_oauth2": true, "config_data": {"tyk-k8s-managed-by": "tyk-k8s", "tyk-k8s-source": "Ingress/shop/web"},
+				 "oauth_meta": {"allowed_access_types": ["authorization_code", "refresh_token"], "allowed_authorize_types": ["code"]}},
+				{"api_id": "k1", "slug": "shop-keys", "config_data": {"tyk-k8s-managed-by": "tyk-k8s", "tyk-k8s-source": "Ingress/shop/keys"}}
+			]`)
+			return
+		}
+
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		calls = append(calls, fmt.Sprint(r.Method, " ", r.URL.Path, " ", body["api_id"], " ", body["redirect_uri"]))
+		fmt.Fprint(w, `{"client_id": "c1", "secret": "s1"}`)
+	}, &TykConf{IsGateway: true})
+
+	def, err := OAuthAPI("shop-oauth", "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OAuthAPI("shop-oauth", "other"); err == nil {
+		t.Fatal("clients should only attach to APIs of their namespace")
+	}
+
+	if _, err := OAuthAPI("shop-keys", "shop"); err == nil {
+		t.Fatal("clients need an OAuth2 API")
+	}
+
+	if err := CheckGrantTypes(&def.APIDefinition, []string{"authorization_code", "refresh_token"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, g := range []string{"implicit", "client_credentials", "device_code"} {
+		if err := CheckGrantTypes(&def.APIDefinition, []string{g}); err == nil {
+			t.Fatalf("the %s grant should be refused", g)
+		}
+	}
+
+	spec := &OAuthClientSpec{RedirectURIs: []string{"https://a/cb", "https://b/cb"}, PolicyID: "pol-1"}
+	cl, err := CreateOAuthClient(def.APIID, spec)
+	if err != nil || cl.ClientID != "c1" || cl.Secret != "s1" {
+		t.Fatal("unexpected client: ", cl, err)
+	}
+
+	if err := UpdateOAuthClient(def.APIID, cl.ClientID, spec); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteOAuthClient(def.APIID, cl.ClientID); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"POST /tyk/oauth/clients/create o1 https://a/cb;https://b/cb",
+		"PUT /tyk/oauth/clients/o1/c1 o1 https://a/cb;https://b/cb",
+		"DELETE /tyk/oauth/clients/o1/c1 <nil> <nil>",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Fatal("unexpected calls: ", calls)
+	}
+}
+
+func TestApplyJWT(t *testing.T) {
+	def := objects.NewDefinition()
+	def.UseKeylessAccess = true
+	applyJWT(def, nil)
+	if def.EnableJWT || !def.UseKeylessAccess {
+		t.Fatal("definitions without JWT options should be untouched")
+	}
+
+	applyJWT(def, &JWTAuth{JWKSURI: "https://idp/jwks.json", PolicyClaim: "pol", ClockSkewSeconds: 5})
+	if !def.EnableJWT || def.UseKeylessAccess || def.JWTSigningMethod != "rsa" || def.JWTIdentityBaseField != "sub" ||
+		def.JWTPolicyFieldName != "pol" || def.JWTExpiresAtValidationSkew != 5 || def.BaseIdentityProvidedBy != apidef.JWTClaim {
+		t.Fatalf("unexpected jwt settings: %+v", def)
+	}
+
+	if def.JWTSource != "aHR0cHM6Ly9pZHAvandrcy5qc29u" {
+		t.Fatal("the JWKS URI should be base64 encoded, got ", def.JWTSource)
+	}
+}
+
+func TestApplyOpenID(t *testing.T) {
+	def := objects.NewDefinition()
+	def.UseKeylessAccess = true
+	applyOpenID(def, &OpenIDAuth{Providers: []OpenIDProvider{
+		{Issuer: "https://idp", ClientPolicies: map[string]string{"web": "pol-1"}},
+	}})
+
+	if !def.UseOpenID || def.UseKeylessAccess || def.BaseIdentityProvidedBy != apidef.OIDCUser {
+		t.Fatalf("unexpected auth settings: %+v", def)
+	}
+
+	p := def.OpenIDOptions.Providers
+	if len(p) != 1 || p[0].Issuer != "https://idp" || p[0].ClientIDs["d2Vi"] != "pol-1" {
+		t.Fatal("client IDs should be base64 encoded, got ", p)
+	}
+}
+
+func TestBasicAuthUsers(t *testing.T) {
+	var mu sync.Mutex
+	calls := make([]string, 0)
+	var written map[string]interface{}
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/keys/orgbob":
+			fmt.Fprint(w, `{"data": {"meta_data": {"tyk-k8s-source": "Secret/other/users"}}}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/keys/orgcarol":
+			fmt.Fprint(w, `{"data": {"meta_data": {"tyk-k8s-source": "Secret/shop/users"}}}`)
+		case r.Method == http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost:
+			written = map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&written)
+			fmt.Fprint(w, `{"status": "ok"}`)
+		default:
+			fmt.Fprint(w, `{"status": "ok"}`)
+		}
+	}, &TykConf{Secret: "s", Org: "org"})
+
+	api := objects.DBApiDefinition{APIDefinition: *objects.NewDefinition()}
+	api.APIID, api.Name = "a1", "shop"
+	api.VersionData.Versions = map[string]apidef.VersionInfo{"Default": {}}
+
+	users := []BasicAuthUser{{Username: "alice", Password: "pw"}, {Username: "bob", Password: "pw"}}
+	err := SyncBasicAuthUsers("Secret/shop/users", users, []objects.DBApiDefinition{api}, []string{"carol"})
+	if err == nil || !strings.Contains(err.Error(), "bob already exists") {
+		t.Fatal("users of other sources should not be taken over, got ", err)
+	}
+
+	want := []string{
+		"GET /api/keys/orgalice",
+		"POST /api/apis/keys/basic/alice",
+		"GET /api/keys/orgbob",
+		"GET /api/keys/orgcarol",
+		"DELETE /api/keys/orgcarol",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Fatal("unexpected calls: ", calls)
+	}
+
+	rights := written["access_rights"].(map[string]interface{})["a1"].(map[string]interface{})
+	if written["basic_auth_data"].(map[string]interface{})["password"] != "pw" || rights["versions"].([]interface{})[0] != "Default" {
+		t.Fatal("unexpected session: ", written)
+	}
+
+	calls = calls[:0]
+	if err := SyncBasicAuthUsers("Secret/shop/users", users[:1], []objects.DBApiDefinition{api}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 0 {
+		t.Fatal("unchanged users should not be written again, got ", calls)
+	}
+
+	def := objects.NewDefinition()
+	applyBasicAuth(def, true)
+	if !def.UseBasicAuth || def.UseKeylessAccess || def.BaseIdentityProvidedBy != apidef.BasicAuthUser {
+		t.Fatalf("unexpected auth settings: %+v", def)
+	}
+}
+
+func TestHMACKeys(t *testing.T) {
+	var session map[string]interface{}
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `{"data": {"hmac_string": "signing-secret"}}`)
+			return
+		}
+		session = map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&session)
+		fmt.Fprint(w, `{"key_id": "k1"}`)
+	}, &TykConf{Secret: "s", Org: "org"})
+
+	api := objects.DBApiDefinition{APIDefinition: *objects.NewDefinition()}
+	api.APIID = "a1"
+	k, err := CreateKey(&KeySpec{APIs: []objects.DBApiDefinition{api}, HMAC: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session["hmac_enabled"] != true || session["access_rights"].(map[string]interface{})["a1"] == nil {
+		t.Fatal("unexpected session: ", session)
+	}
+
+	if s, err := KeyHMACSecret(k.Key); err != nil || s != "signing-secret" {
+		t.Fatal("unexpected hmac secret: ", s, err)
+	}
+
+	def := objects.NewDefinition()
+	applyHMAC(def, &HMACAuth{Algorithms: []string{"hmac-sha256"}, ClockSkewMillis: 500})
+	if !def.EnableSignatureChecking || def.UseStandardAuth || def.HmacAllowedClockSkew != 500 || def.BaseIdentityProvidedBy != apidef.HMACKey {
+		t.Fatalf("unexpected hmac settings: %+v", def)
+	}
+}
+
+func TestApplyClientCertificates(t *testing.T) {
+	def := objects.NewDefinition()
+	applyClientCertificates(def, nil)
+	if def.UseMutualTLSAuth {
+		t.Fatal("definitions without client certificates should be untouched")
+	}
+
+	def.UseKeylessAccess = true
+	applyClientCertificates(def, []string{"c1", "c2"})
+	if !def.UseMutualTLSAuth || strings.Join(def.ClientCertificates, ",") != "c1,c2" || !def.UseKeylessAccess {
+		t.Fatalf("unexpected mtls settings: %+v", def)
+	}
+}
+
+func TestUpstreamPins(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	pins, err := PublicKeyFingerprints(append([]byte("comment\n"), bundle...))
+	if err != nil || len(pins) != 1 || len(pins[0]) != 64 {
+		t.Fatal("expected the fingerprint of the certificate, got ", pins, err)
+	}
+
+	if _, err := PublicKeyFingerprints([]byte("not a bundle")); err == nil {
+		t.Fatal("bundles without certificates should fail")
+	}
+
+	def := objects.NewDefinition()
+	def.Proxy.Transport.SSLInsecureSkipVerify = true
+	applyUpstreamPins(def, append(pins, "abc"))
+	if def.PinnedPublicKeys["*"] != pins[0]+",abc" || def.Proxy.Transport.SSLInsecureSkipVerify {
+		t.Fatalf("unexpected pinning settings: %v %v", def.PinnedPublicKeys, def.Proxy.Transport.SSLInsecureSkipVerify)
+	}
+}
+
+func TestTrackCertificate(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	crt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := TrackCertificate("Secret/default/tls", "tls.crt", "org1abc", crt); err != nil {
+		t.Fatal(err)
+	}
+	defer UntrackCertificate("Secret/default/tls", "tls.crt")
+
+	fp, err := CertificateFingerprint(crt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := TrackedCertificates()
+	if len(certs) != 1 || certs[0].Fingerprint != fp || !certs[0].NotAfter.Equal(srv.Certificate().NotAfter) {
+		t.Fatalf("unexpected tracked certificates: %+v", certs)
+	}
+
+	MarkCertificateStale("Secret/default/tls", "tls.crt", true)
+	buf := &bytes.Buffer{}
+	if err := WriteCertificateMetrics(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := `tyk_k8s_certificate_stale{source="Secret/default/tls",field="tls.crt",id="org1abc"} 1`
+	expiry := fmt.Sprintf(`tyk_k8s_certificate_expiry_timestamp_seconds{source="Secret/default/tls",field="tls.crt",id="org1abc"} %d`, srv.Certificate().NotAfter.Unix())
+	if !strings.Contains(buf.String(), stale) || !strings.Contains(buf.String(), expiry) {
+		t.Fatal("unexpected metrics: ", buf.String())
+	}
+
+	if err := TrackCertificate("Secret/default/bad", "tls.crt", "x", []byte("nope")); err == nil {
+		t.Fatal("certificates that can't be parsed should not be tracked")
+	}
+}
+
+func TestApplyStripping(t *testing.T) {
+	def := objects.NewDefinition()
+	def.Proxy.StripListenPath = true
+	def.VersionDefinition.StripPath = true
+
+	applyStripping(def, &APIDefOptions{})
+	if !def.Proxy.StripListenPath || !def.VersionDefinition.StripPath {
+		t.Fatal("definitions without overrides should keep the template stripping")
+	}
+
+	no := false
+	applyStripping(def, &APIDefOptions{StripListenPath: &no, StripVersionPath: &no})
+	if def.Proxy.StripListenPath || def.VersionDefinition.StripPath {
+		t.Fatal("expected the stripping to be turned off")
+	}
+}
+
+func TestApplyTransport(t *testing.T) {
+	def := objects.NewDefinition()
+	applyTransport(def, nil)
+	if def.Proxy.Transport.SSLMinVersion != 0 || def.Proxy.Transport.ProxyURL != "" {
+		t.Fatal("definitions without transport settings should be untouched")
+	}
+
+	applyTransport(def, &ProxyTransport{MinTLSVersion: 771, CipherSuites: []string{"TLS_RSA_WITH_AES_128_GCM_SHA256"}, ProxyURL: "http://egress:3128"})
+	tr := def.Proxy.Transport
+	if tr.SSLMinVersion != 771 || len(tr.SSLCipherSuites) != 1 || tr.ProxyURL != "http://egress:3128" {
+		t.Fatalf("unexpected transport: %+v", tr)
+	}
+}
+
+func TestApplyTargetList(t *testing.T) {
+	def := objects.NewDefinition()
+	applyTargetList(def, nil, "/healthz")
+	if def.Proxy.EnableLoadBalancing || len(def.UptimeTests.CheckList) != 0 {
+		t.Fatal("definitions without targets should be untouched")
+	}
+
+	applyTargetList(def, []string{"http://10.0.0.1:80", "http://10.0.0.2:80/"}, "")
+	if !def.Proxy.EnableLoadBalancing || len(def.Proxy.Targets) != 2 || def.Proxy.CheckHostAgainstUptimeTests {
+		t.Fatalf("unexpected proxy: %+v", def.Proxy)
+	}
+
+	applyTargetList(def, []string{"http://10.0.0.1:80", "http://10.0.0.2:80/"}, "healthz")
+	checks := def.UptimeTests.CheckList
+	if !def.Proxy.CheckHostAgainstUptimeTests || len(checks) != 2 || checks[1].CheckURL != "http://10.0.0.2:80/healthz" || checks[0].Method != http.MethodGet {
+		t.Fatalf("unexpected uptime tests: %+v", checks)
+	}
+}
+
+func TestApplyUpstreamProtocol(t *testing.T) {
+	def := objects.NewDefinition()
+	def.Proxy.TargetURL = "http://grpc.default:50051"
+	def.Proxy.Targets = []string{"http://10.0.0.1:50051", "tyk://self"}
+	def.VersionData.Versions = map[string]apidef.VersionInfo{"Default": {Name: "Default"}}
+	applyPathRoutes(def, []PathRoute{{Path: "/svc", Target: "http://svc.default:80"}})
+
+	applyUpstreamProtocol(def, UpstreamH2C)
+	if def.Proxy.TargetURL != "h2c://grpc.default:50051" || def.Proxy.Targets[0] != "h2c://10.0.0.1:50051" || def.Proxy.Targets[1] != "tyk://self" {
+		t.Fatalf("unexpected targets: %v %v", def.Proxy.TargetURL, def.Proxy.Targets)
+	}
+
+	if rw := def.VersionData.Versions["Default"].ExtendedPaths.URLRewrite[0].RewriteTo; !strings.HasPrefix(rw, "h2c://svc.default:80") {
+		t.Fatal("route targets should be rewritten, got ", rw)
+	}
+
+	applyUpstreamProtocol(def, UpstreamHTTP2)
+	if def.Proxy.TargetURL != "https://grpc.default:50051" {
+		t.Fatal("http2 upstreams should use TLS, got ", def.Proxy.TargetURL)
+	}
+
+	applyUpstreamProtocol(def, UpstreamHTTP)
+	if def.Proxy.TargetURL != "https://grpc.default:50051" {
+		t.Fatal("http upstreams should keep TLS targets, got ", def.Proxy.TargetURL)
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	var mu sync.Mutex
+	calls := make([]string, 0)
+	var body map[string]interface{}
+	policies := `{"Data": []}`
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls = append(calls, r.Method+" "+r.URL.RequestURI())
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			body = map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&body)
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprint(w, policies)
+		default:
+			fmt.Fprint(w, `{"Status": "OK", "Meta": "m1"}`)
+		}
+	}, &TykConf{Secret: "s", Org: "org"})
+
+	src := "Ingress/shop/web"
+	apis := []objects.DBApiDefinition{{APIDefinition: apidef.APIDefinition{APIID: "a1", Name: "web"}}}
+	if _, err := SyncPolicy(src, &PolicySpec{APIs: apis}); err == nil {
+		t.Fatal("policies without partitions should be refused")
+	}
+
+	id, err := SyncPolicy(src, &PolicySpec{Name: "shop/web", Rate: 100, Per: 60, APIs: apis})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := body["partitions"].(map[string]interface{})
+	if id != PolicyID(src) || body["id"] != id || parts["rate_limit"] != true || parts["quota"] != false || parts["acl"] != false {
+		t.Fatalf("unexpected policy %s: %v", id, body)
+	}
+
+	if _, ok := body["access_rights"].(map[string]interface{})["a1"]; !ok {
+		t.Fatal("the policy should be scoped to the APIs: ", body)
+	}
+
+	// unchanged policies are not written again
+	n := len(calls)
+	if _, err := SyncPolicy(src, &PolicySpec{Name: "shop/web", Rate: 100, Per: 60, APIs: apis}); err != nil || len(calls) != n {
+		t.Fatal("unchanged policies should not be written, got ", calls[n:], err)
+	}
+
+	policies = fmt.Sprintf(`{"Data": [{"_id": "m1", "id": "%s", "tags": ["%s"]}]}`, id, policyTag(src))
+	if _, err := SyncPolicy(src, &PolicySpec{Name: "shop/web", QuotaMax: 1000, QuotaRenewalRate: 3600, APIs: apis}); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls[len(calls)-1] != "PUT /api/portal/policies/m1" {
+		t.Fatal("existing policies should be updated, got ", calls[len(calls)-1])
+	}
+
+	if err := DeletePolicy(src); err != nil || calls[len(calls)-1] != "DELETE /api/portal/policies/m1" {
+		t.Fatal("expected the policy to be deleted, got ", calls[len(calls)-1], err)
+	}
+
+	policies = fmt.Sprintf(`{"Data": [{"_id": "m2", "id": "%s", "tags": []}]}`, id)
+	if _, err := SyncPolicy(src, &PolicySpec{ACL: true, APIs: apis}); err == nil {
+		t.Fatal("policies of other sources should be refused")
+	}
+
+	if err := DeletePolicy(src); err != nil || strings.HasPrefix(calls[len(calls)-1], "DELETE") {
+		t.Fatal("policies of other sources should not be deleted, got ", calls[len(calls)-1], err)
+	}
+
+	policies = fmt.Sprintf(`{"Data": [{"_id": "m1", "id": "%s", "tags": ["%s"]}, {"_id": "m2", "id": "%s", "tags": ["%s"]}]}`,
+		id, policyTag(src), PolicyID("Ingress/admin/web"), policyTag("Ingress/admin/web"))
+	if err := CheckPolicies("shop", []string{id, "org-pol"}, []string{"org-pol"}); err != nil {
+		t.Fatal("policies of the namespace and allowed ones should be accepted: ", err)
+	}
+	if err := CheckPolicies("shop", []string{PolicyID("Ingress/admin/web")}, nil); err == nil {
+		t.Fatal("policies of other namespaces should be refused")
+	}
+	if err := CheckPolicies("sho", []string{id}, nil); err == nil {
+		t.Fatal("namespaces should not match by prefix")
+	}
+
+	cfg.IsGateway = true
+	if _, err := SyncPolicy(src, &PolicySpec{ACL: true, APIs: apis}); err == nil {
+		t.Fatal("policies need the Dashboard")
+	}
+}
+
+func TestOrgLimits(t *testing.T) {
+	var mu sync.Mutex
+	calls := make([]string, 0)
+	var session map[string]interface{}
+	existing := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls = append(calls, r.Method+" "+r.URL.Path+" "+r.Header.Get("x-tyk-authorization"))
+		switch r.Method {
+		case http.MethodGet:
+			if existing == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprint(w, existing)
+		case http.MethodPut:
+			session = map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&session)
+			fmt.Fprint(w, `{"status": "ok"}`)
+		default:
+			fmt.Fprint(w, `{"status": "ok"}`)
+		}
+	}))
+	defer srv.Close()
+
+	oldCfg := cfg
+	defer func() { cfg = oldCfg }()
+	cfg = &TykConf{URL: "http://dashboard", Secret: "s", Org: "org"}
+	Init(cfg)
+
+	src := "OrgRateLimit/platform/tenant"
+	if err := SetOrgLimits(nil, "tenant", src, &OrgLimits{Rate: 10, Per: 1}); err == nil {
+		t.Fatal("org limits need a gateway API next to a Dashboard")
+	}
+
+	gw := &GatewayAPI{URL: srv.URL, Secret: "gw"}
+	if err := SetOrgLimits(gw, "tenant", src, &OrgLimits{Rate: 10, Per: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls[len(calls)-1] != "PUT /tyk/org/keys/tenant gw" || session["rate"] != float64(10) || session["quota_max"] != float64(-1) {
+		t.Fatalf("unexpected org session %v: %v", calls, session)
+	}
+
+	existing = `{"meta_data": {"tyk-k8s-source": "OrgRateLimit/other/limit"}}`
+	if err := SetOrgLimits(gw, "tenant", src, &OrgLimits{Rate: 10, Per: 1}); err == nil {
+		t.Fatal("org sessions of other sources should be refused")
+	}
+
+	n := len(calls)
+	if err := DeleteOrgLimits(gw, "tenant", src); err != nil || len(calls) != n+1 {
+		t.Fatal("org sessions of other sources should not be deleted, got ", calls[n:], err)
+	}
+
+	existing = `{"meta_data": {"tyk-k8s-source": "OrgRateLimit/platform/tenant"}}`
+	if err := DeleteOrgLimits(gw, "tenant", src); err != nil || calls[len(calls)-1] != "DELETE /tyk/org/keys/tenant gw" {
+		t.Fatal("expected the org session to be deleted, got ", calls[len(calls)-1], err)
+	}
+}
+
+func TestApplyCachePaths(t *testing.T) {
+	def := objects.NewDefinition()
+	def.CacheOptions.CacheAllSafeRequests = true
+	def.CacheOptions.CacheTimeout = 60
+	def.VersionData.Versions = map[string]apidef.VersionInfo{"Default": {Name: "Default"}}
+
+	applyCachePaths(def, &CachePaths{Paths: []string{"/products", "/catalogue/.*"}, TimeoutSeconds: 300})
+	v := def.VersionData.Versions["Default"]
+	if !def.CacheOptions.EnableCache || def.CacheOptions.CacheAllSafeRequests || def.CacheOptions.CacheTimeout != 300 ||
+		!v.UseExtendedPaths || strings.Join(v.ExtendedPaths.Cached, ",") != "/products,/catalogue/.*" {
+		t.Fatalf("unexpected cache settings: %+v %+v", def.CacheOptions, v.ExtendedPaths.Cached)
+	}
+}
+
+func TestFlushCache(t *testing.T) {
+	calls := make([]string, 0)
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		fmt.Fprint(w, `{"status": "ok"}`)
+	}, &TykConf{Secret: "s", Org: "org"})
+
+	if err := InvalidateCache("abc"); err != nil || calls[0] != "DELETE /api/cache/abc" {
+		t.Fatal("expected the dashboard cache to be flushed, got ", calls, err)
+	}
+
+	cfg.IsGateway = true
+	if err := InvalidateCache("abc"); err != nil || calls[1] != "DELETE /tyk/cache/abc" {
+		t.Fatal("expected the gateway cache to be flushed, got ", calls, err)
+	}
+
+	if _, err := FlushCache("", ""); err == nil {
+		t.Fatal("a flush needs a slug or a source")
+	}
+
+	apis := make([]objects.DBApiDefinition, 0)
+	for _, a := range []struct{ slug, source string }{
+		{"products", "Ingress/default/shop"},
+		{"orders", "Ingress/default/shop"},
+		{"users", "Ingress/default/accounts"},
+	} {
+		def := objects.NewDefinition()
+		def.Slug = a.slug
+		markManaged(def)
+		def.ConfigData[SourceKey] = a.source
+		apis = append(apis, objects.DBApiDefinition{APIDefinition: *def})
+	}
+	apis = append(apis, objects.DBApiDefinition{APIDefinition: apidef.APIDefinition{Slug: "products"}})
+
+	if got := cacheTargets(apis, "products", ""); len(got) != 1 {
+		t.Fatal("only the managed API of the slug should be flushed, got ", len(got))
+	}
+
+	if got := cacheTargets(apis, "", "Ingress/default/shop"); len(got) != 2 || got[1].Slug != "orders" {
+		t.Fatal("expected the APIs of the ingress, got ", len(got))
+	}
+}
+
+func TestBatchRequests(t *testing.T) {
+	def := objects.NewDefinition()
+	if err := finaliseDefinition(def, &APIDefOptions{}); err != nil || def.EnableBatchRequestSupport {
+		t.Fatal("batch requests should stay off without the option, got ", err)
+	}
+
+	yes := true
+	if err := finaliseDefinition(def, &APIDefOptions{BatchRequests: &yes}); err != nil || !def.EnableBatchRequestSupport {
+		t.Fatal("expected batch requests to be enabled, got ", err)
+	}
+}
+
+func TestApplyOwner(t *testing.T) {
+	def := objects.NewDefinition()
+	def.Name = "shop-web #ingress"
+	applyOwner(def, &SourceMeta{Labels: map[string]string{OwnerTeamKey: "payments"}})
+	if def.Name != "shop-web #ingress #payments" {
+		t.Fatal("expected the team category from the label, got ", def.Name)
+	}
+
+	applyOwner(def, &SourceMeta{Labels: map[string]string{OwnerTeamKey: "payments"}})
+	if def.Name != "shop-web #ingress #payments" {
+		t.Fatal("the category should only be added once, got ", def.Name)
+	}
+
+	def.Name = "shop-web"
+	applyOwner(def, &SourceMeta{
+		Labels:      map[string]string{OwnerTeamKey: "payments"},
+		Annotations: map[string]string{OwnerTeamKey: "Checkout #Team"},
+	})
+	if def.Name != "shop-web #Checkout-Team" {
+		t.Fatal("the annotation should win and be a single category, got ", def.Name)
+	}
+
+	def.Name = "shop-web"
+	applyOwner(def, &SourceMeta{})
+	if def.Name != "shop-web" {
+		t.Fatal("APIs without an owner should keep their name, got ", def.Name)
+	}
+}
+
+func TestTemplateValues(t *testing.T) {
+	oldCfg := cfg
+	defer func() {
+		cfg = oldCfg
+		Init(oldCfg)
+		SetValueFunc(nil)
+		tplMu.Lock()
+		templates = nil
+		tplMu.Unlock()
+	}()
+	Init(&TykConf{})
+
+	err := LoadTemplates(map[string]string{
+		"values.json": `{"name": "{{.Name}}", "pw": {{ json (secretValue "creds" "password") }}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &APIDefOptions{Name: "foo", TemplateName: "values.json", Source: &SourceMeta{Namespace: "shop"}}
+	if _, err := TemplateService(opts); err == nil {
+		t.Fatal("lookups should fail until they are enabled")
+	}
+
+	SetValueFunc(func(kind, ns, name, key string) (string, error) {
+		return kind + ":" + ns + "/" + name + "/" + key + `"`, nil
+	})
+
+	out, err := TemplateService(opts)
+	if err != nil || string(out) != `{"name": "foo", "pw": "Secret:shop/creds/password\""}` {
+		t.Fatal("expected the value of the source namespace, got ", string(out), err)
+	}
+
+	if _, err := TemplateService(&APIDefOptions{Name: "foo", TemplateName: "values.json"}); err == nil {
+		t.Fatal("sources without a namespace should not read values")
+	}
+}
+
+func TestStrictTemplates(t *testing.T) {
+	oldCfg := cfg
+	defer func() {
+		cfg = oldCfg
+		Init(oldCfg)
+		tplMu.Lock()
+		templates = nil
+		tplMu.Unlock()
+	}()
+	Init(&TykConf{})
+
+	if err := LoadTemplates(map[string]string{"typo.json": `{"domain": "{{.Hostnme}}"}`}); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &APIDefOptions{Name: "foo", TemplateName: "typo.json"}
+	if _, err := TemplateService(opts); err == nil || !strings.Contains(err.Error(), "Hostnme") {
+		t.Fatal("missing keys should fail the render, got ", err)
+	}
+
+	cfg.LenientTemplates = true
+	out, err := TemplateService(opts)
+	if err != nil || string(out) != `{"domain": "<no value>"}` {
+		t.Fatal("lenient templates should render missing keys, got ", string(out), err)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	oldCfg := cfg
+	defer func() {
+		cfg = oldCfg
+		Init(oldCfg)
+		tplMu.Lock()
+		templates = nil
+		tplMu.Unlock()
+	}()
+	Init(&TykConf{})
+
+	err := LoadTemplates(map[string]string{
+		"exec.json":   "{\n\"name\": \"{{ .Name.Broken }}\"\n}",
+		"broken.json": "{\n\"name\": \"{{.Name}}\",\n}",
+		"tpl.json":    "{\"name\": \"{{.Name}}\"}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := &SourceMeta{Kind: "Ingress", Namespace: "shop", Name: "web"}
+	_, err = renderDefinition(&APIDefOptions{Name: "foo", TemplateName: "exec.json", Source: src})
+	te, ok := err.(*TemplateError)
+	if !ok || te.Template != "exec.json" || te.Line != 2 || te.Source != "Ingress/shop/web" {
+		t.Fatalf("expected a template error on line 2, got %#v", err)
+	}
+
+	_, err = renderDefinition(&APIDefOptions{Name: "foo", TemplateName: "broken.json", Source: src})
+	te, ok = err.(*TemplateError)
+	if !ok || te.Line != 3 || !IsTemplateError(errors.New("a; "+err.Error())) {
+		t.Fatalf("expected a JSON error on line 3, got %#v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTemplateMetrics(&buf); err != nil || !strings.Contains(buf.String(), `tyk_k8s_template_failures_total{template="broken.json",namespace="shop"} 1`) {
+		t.Fatal("expected the failure to be counted, got ", buf.String(), err)
+	}
+
+	_, err = renderDefinition(&APIDefOptions{Name: "foo", TemplateName: "tpl.json", Source: src,
+		Annotations: map[string]string{"tyk.io/json-patch": "not a patch"}})
+	if err == nil {
+		t.Fatal("expected the processor to fail")
+	}
+
+	buf.Reset()
+	if err := WriteTemplateMetrics(&buf); err != nil || !strings.Contains(buf.String(), `tyk_k8s_processor_failures_total{template="tpl.json",namespace="shop"} 1`) {
+		t.Fatal("expected the processor failure to be counted, got ", buf.String(), err)
+	}
+}
+
+func TestSkippedMetrics(t *testing.T) {
+	RecordSkipped("Ingress", "shop", SkipValidation)
+	RecordSkipped("Ingress", "shop", SkipValidation)
+	RecordSkipped("Ingress", "other", SkipFiltered)
+
+	var buf bytes.Buffer
+	if err := WriteSkippedMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range []string{
+		`tyk_k8s_skipped_objects_total{kind="Ingress",namespace="other",reason="filtered"} 1`,
+		`tyk_k8s_skipped_objects_total{kind="Ingress",namespace="shop",reason="validation"} 2`,
+	} {
+		if !strings.Contains(buf.String(), l) {
+			t.Errorf("expected %s in:\n%s", l, buf.String())
+		}
+	}
+}
+
+func TestPlanAPIs(t *testing.T) {
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("plans should not change anything, got %s %s", r.Method, r.URL.Path)
+		}
+
+		fmt.Fprint(w, `[
+			{"api_id": "1", "slug": "web", "name": "old", "config_data": {"tyk-k8s-managed-by": "cluster-a", "tyk-k8s-source": "Ingress/shop/web"}},
+			{"api_id": "2", "slug": "gone", "config_data": {"tyk-k8s-managed-by": "cluster-a", "tyk-k8s-source": "Ingress/shop/gone"}},
+			{"api_id": "3", "slug": "failed", "config_data": {"tyk-k8s-managed-by": "cluster-a", "tyk-k8s-source": "Ingress/shop/failed"}},
+			{"api_id": "4", "slug": "route", "config_data": {"tyk-k8s-managed-by": "cluster-a", "tyk-k8s-source": "HTTPRoute/shop/route"}}
+		]`)
+	}, &TykConf{IsGateway: true, ManagedBy: "cluster-a"})
+
+	src := &SourceMeta{Kind: "Ingress", Namespace: "shop", Name: "web"}
+	plan, err := PlanAPIs(map[string]*APIDefOptions{
+		"web": {Name: "web", Slug: "web", ListenPath: "/", Target: "http://web.shop", Source: src},
+		"new": {Name: "new", Slug: "new", ListenPath: "/new", Target: "http://new.shop", Source: src},
+	}, "Ingress", map[string]bool{"failed": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]string, 0)
+	for _, c := range plan.Changes {
+		got = append(got, c.Action+" "+c.Slug)
+	}
+	if strings.Join(got, ",") != "create new,update web,delete gone" {
+		t.Fatal("unexpected plan ", got)
+	}
+
+	diff := strings.Join(plan.Changes[1].Diff, "\n")
+	if !strings.Contains(diff, "name") || strings.Contains(diff, "old") {
+		t.Fatal("expected the name change without its values in the diff, got ", plan.Changes[1].Diff)
+	}
+
+	if !strings.Contains(plan.String(), "delete gone (Ingress/shop/gone)") {
+		t.Fatal("unexpected plan output ", plan.String())
+	}
+}
+
+func TestCertificateID(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	oldCfg := cfg
+	defer func() { cfg = oldCfg }()
+	cfg = &TykConf{Org: "org"}
+
+	crt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	fp, _ := CertificateFingerprint(crt)
+	if id, err := CertificateID(crt); err != nil || id != "org"+fp {
+		t.Fatal("expected the org and fingerprint, got ", id, err)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	var mu sync.Mutex
+	calls := make([]string, 0)
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `[{"api_id": "a1", "slug": "web", "name": "previous", "config_data": {"tyk-k8s-managed-by": "tyk-k8s"}}]`)
+			return
+		}
+
+		def := apidef.APIDefinition{}
+		json.NewDecoder(r.Body).Decode(&def)
+		mu.Lock()
+		calls = append(calls, strings.TrimSpace(fmt.Sprint(r.Method, " ", strings.Trim(r.URL.Path, "/"), " ", def.Name)))
+		mu.Unlock()
+
+		if def.Slug == "broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"status": "error", "message": "boom"}`)
+			return
+		}
+		fmt.Fprint(w, `{"status": "ok", "key": "new"}`)
+	}, &TykConf{IsGateway: true, LookupCacheSeconds: -1, RollbackThreshold: 0.5})
+
+	svcs := func() map[string]*APIDefOptions {
+		return map[string]*APIDefOptions{
+			"web":    {Name: "current", Slug: "web", ListenPath: "/web/", Target: "http://web"},
+			"added":  {Name: "added", Slug: "added", ListenPath: "/added/", Target: "http://added"},
+			"broken": {Name: "broken", Slug: "broken", ListenPath: "/broken/", Target: "http://broken"},
+		}
+	}
+
+	err := UpdateAPIs(svcs())
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatal("the failed create should be reported, got ", err)
+	}
+
+	if strings.Contains(err.Error(), "rolled back") {
+		t.Fatal("one failure in three is under the threshold, got ", err)
+	}
+
+	calls = calls[:0]
+	cfg.RollbackThreshold = 0.3
+	err = UpdateAPIs(svcs())
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 changes failed, rolled back 2 applied changes") {
+		t.Fatal("expected a rollback, got ", err)
+	}
+
+	want := []string{
+		"PUT tyk/apis//a1 current",
+		"POST tyk/apis added",
+		"POST tyk/apis broken",
+		"DELETE tyk/apis/new",
+		"PUT tyk/apis//a1 previous",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Fatal("unexpected calls: ", calls)
+	}
+}
+
+func TestIdempotentCreates(t *testing.T) {
+	var mu sync.Mutex
+	calls := make([]string, 0)
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `[
+				{"api_id": "a1", "slug": "web", "config_data": {"tyk-k8s-managed-by": "tyk-k8s"}},
+				{"api_id": "a2", "slug": "manual"}
+			]`)
+			return
+		}
+
+		mu.Lock()
+		calls = append(calls, fmt.Sprint(r.Method, " ", strings.Trim(r.URL.Path, "/")))
+		mu.Unlock()
+		fmt.Fprint(w, `{"status": "ok", "key": "new"}`)
+	}, &TykConf{IsGateway: true, LookupCacheSeconds: -1})
+
+	opts := func(slug string) *APIDefOptions {
+		return &APIDefOptions{Name: slug, Slug: slug, ListenPath: "/" + slug + "/", Target: "http://" + slug}
+	}
+
+	if id, err := CreateService(opts("web")); err != nil || id != "new" {
+		t.Fatal("without the option a duplicate is created, got ", id, err)
+	}
+
+	cfg.IdempotentCreates = true
+	if id, err := CreateService(opts("web")); err != nil || id != "a1" {
+		t.Fatal("the managed API should be updated, got ", id, err)
+	}
+
+	def := opts("renamed")
+	def.Definition = json.RawMessage(`{"api_id": "a1", "name": "renamed", "proxy": {"listen_path": "/renamed/"}}`)
+	if id, err := CreateService(def); err != nil || id != "a1" {
+		t.Fatal("definitions should be matched by API ID, got ", id, err)
+	}
+
+	if _, err := CreateService(opts("manual")); err == nil {
+		t.Fatal("unmanaged APIs should not be taken over")
+	}
+
+	if id, err := CreateService(opts("other")); err != nil || id != "new" {
+		t.Fatal("unknown slugs should be created, got ", id, err)
+	}
+
+	want := []string{"POST tyk/apis", "PUT tyk/apis//a1", "PUT tyk/apis//a1", "POST tyk/apis"}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Fatal("unexpected calls: ", calls)
+	}
+}
+
+func TestDuplicateSlugs(t *testing.T) {
+	var mu sync.Mutex
+	deleted, written := make([]string, 0), make([]string, 0)
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `[
+				{"api_id": "a1", "slug": "web"},
+				{"api_id": "a2", "slug": "web", "config_data": {"tyk-k8s-managed-by": "tyk-k8s"}},
+				{"api_id": "a3", "slug": "web", "config_data": {"tyk-k8s-managed-by": "tyk-k8s"}},
+				{"api_id": "m1", "slug": "manual"},
+				{"api_id": "m2", "slug": "manual"}
+			]`)
+			return
+		}
+
+		mu.Lock()
+		if r.Method == http.MethodDelete {
+			deleted = append(deleted, strings.Trim(r.URL.Path, "/"))
+		} else {
+			written = append(written, r.Method+" "+path.Base(r.URL.Path))
+		}
+		mu.Unlock()
+		fmt.Fprint(w, `{"status": "ok", "key": "done"}`)
+	}, &TykConf{IsGateway: true, LookupCacheSeconds: -1})
+
+	err := UpdateAPIs(map[string]*APIDefOptions{
+		"web":    {Name: "web", Slug: "web", ListenPath: "/web/", Target: "http://web"},
+		"manual": {Name: "manual", Slug: "manual", ListenPath: "/manual/", Target: "http://manual"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "manual already exists and is not managed") {
+		t.Fatal("unmanaged APIs should not be taken over, got ", err)
+	}
+
+	if strings.Join(written, ",") != "PUT a2" {
+		t.Fatal("only the first managed duplicate should be updated, got ", written)
+	}
+
+	all, err := GetAllBySlug("web")
+	if err != nil || len(all) != 3 {
+		t.Fatal("every candidate should be returned, got ", all, err)
+	}
+
+	def, err := GetBySlug("web")
+	if err != nil || def.APIID != "a2" {
+		t.Fatal("a managed duplicate should be preferred, got ", def, err)
+	}
+
+	if err := DeleteBySlug("web"); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(deleted, ",") != "tyk/apis/a2,tyk/apis/a3" {
+		t.Fatal("only the managed duplicates should be deleted, got ", deleted)
+	}
+
+	if err := DeleteBySlug("manual"); err == nil {
+		t.Fatal("unmanaged duplicates should be left for manual removal")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+
+		close(started)
+		<-release
+		fmt.Fprint(w, `{"status": "ok", "key": "new"}`)
+	}, &TykConf{IsGateway: true, DrainTimeoutSeconds: 1})
+	defer func() { writes = &writeGate{} }()
+
+	synced := make(chan error, 1)
+	go func() {
+		synced <- UpdateAPIs(map[string]*APIDefOptions{
+			"web": {Name: "web", Slug: "web", ListenPath: "/web/", Target: "http://web"},
+		})
+	}()
+	<-started
+
+	drained := make(chan error, 1)
+	go func() { drained <- Drain() }()
+
+	// Drain flags the gate before it waits, retry until new writes are refused
+	var err error
+	for i := 0; i < 100; i++ {
+		if err = DeleteBySlug("web"); err == ErrShuttingDown {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != ErrShuttingDown || !IsUnavailable(err) {
+		t.Fatal("writes after the drain should be refused as unavailable, got ", err)
+	}
+
+	select {
+	case err := <-drained:
+		t.Fatal("the drain should wait for the write in flight, got ", err)
+	default:
+	}
+
+	close(release)
+	if err := <-synced; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	apiIndex.set([]objects.DBApiDefinition{
+		{APIDefinition: apidef.APIDefinition{Slug: "a"}},
+		{APIDefinition: apidef.APIDefinition{Slug: "a"}},
+		{APIDefinition: apidef.APIDefinition{Slug: "b"}},
+	})
+	defer apiIndex.invalidate()
+
+	stats := CacheStats()
+	if stats["api_index"] != 2 {
+		t.Fatal("duplicate slugs share an index entry, got ", stats)
+	}
+
+	for _, k := range []string{"sync_log", "basic_auth_hashes", "policy_hashes", "doc_hashes", "tracked_certificates"} {
+		if _, ok := stats[k]; !ok {
+			t.Fatalf("missing %s in %v", k, stats)
+		}
+	}
+}
+
+func TestSyncIDStamp(t *testing.T) {
+	var mu sync.Mutex
+	stamped := map[string]interface{}{}
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+
+		def := apidef.APIDefinition{}
+		json.NewDecoder(r.Body).Decode(&def)
+		mu.Lock()
+		stamped[def.Slug] = def.ConfigData[SyncIDKey]
+		mu.Unlock()
+		fmt.Fprint(w, `{"status": "ok", "key": "new"}`)
+	}, &TykConf{IsGateway: true, LookupCacheSeconds: -1})
+
+	opts := &APIDefOptions{Name: "web", Slug: "web", ListenPath: "/web/", Target: "http://web", SyncID: "abc123"}
+	plain, err := RenderDefinition(&APIDefOptions{Name: "web", Slug: "web", ListenPath: "/web/", Target: "http://web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateAPIs(map[string]*APIDefOptions{"web": opts}); err != nil {
+		t.Fatal(err)
+	}
+
+	if stamped["web"] != "abc123" {
+		t.Fatal("the sync ID should be written into the config data, got ", stamped)
+	}
+
+	if syncLog["web"].hash != definitionHash(plain) {
+		t.Fatal("the sync ID should not change the definition hash")
+	}
+
+	current := *plain
+	current.ConfigData = map[string]interface{}{SyncIDKey: "old"}
+	for k, v := range plain.ConfigData {
+		current.ConfigData[k] = v
+	}
+	if diff := definitionDiff(&current, plain); len(diff) != 0 {
+		t.Fatal("the sync ID should not show up in plans, got ", diff)
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	withDashboard(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tyk/apis/" || r.URL.Path == "/tyk/apis" {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"status": "error", "message": "Attempted administrative access with invalid or missing key!"}`)
+	}, &TykConf{IsGateway: true, LookupCacheSeconds: -1})
+	resetRequestMetrics()
+	defer resetRequestMetrics()
+
+	if _, err := ListManaged(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteKey("k1", false); err == nil {
+		t.Fatal("expected the key delete to be refused")
+	}
+
+	out := &bytes.Buffer{}
+	if err := WriteRequestMetrics(out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range []string{
+		`tyk_k8s_api_request_duration_seconds_count{endpoint="/tyk/apis",method="GET"} 1`,
+		`tyk_k8s_api_request_duration_seconds_count{endpoint="/tyk/keys",method="DELETE"} 1`,
+		`tyk_k8s_api_request_errors_total{endpoint="/tyk/keys",method="DELETE",class="auth"} 1`,
+	} {
+		if !strings.Contains(out.String(), l+"\n") {
+			t.Errorf("expected %s in:\n%s", l, out.String())
+		}
+	}
+
+	if strings.Contains(out.String(), `errors_total{endpoint="/tyk/apis"`) {
+		t.Error("successful requests should not be counted as errors")
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	cases := []struct {
+		status int
+		err    error
+		class  string
+	}{
+		{404, nil, Error4xx},
+		{401, nil, ErrorAuth},
+		{502, nil, Error5xx},
+		{0, errors.New("API Returned error: boom (code: 500)"), Error5xx},
+		{0, errors.New(`API Returned error: {"Status":"Error","Message":"Not authorised"}`), ErrorAuth},
+		{0, errors.New("net/http: request canceled (Client.Timeout exceeded while awaiting headers)"), ErrorTimeout},
+		{0, errors.New("dial tcp: connection refused"), ErrorNetwork},
+		{0, errors.New("API request completed, but with error: bad definition"), ErrorOther},
+		{200, nil, ""},
+	}
+
+	for _, c := range cases {
+		if got := errorClass(c.status, c.err); got != c.class {
+			t.Errorf("expected %d %v to be %q, got %q", c.status, c.err, c.class, got)
+		}
+	}
+}
+
+func TestDashboardContract(t *testing.T) {
+	oldCfg := cfg
+	defer func() { cfg = oldCfg }()
+
+	dirs, err := ioutil.ReadDir("testdata/contract")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range dirs {
+		name := d.Name()
+		dir := "testdata/contract/" + name
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch strings.Trim(r.URL.Path, "/") {
+			case "hello":
+				http.ServeFile(w, r, dir+"/hello.json")
+			case "api/apis", "tyk/apis":
+				http.ServeFile(w, r, dir+"/apis.json")
+			default:
+				t.Errorf("%s: unexpected request %s %s", name, r.Method, r.URL.Path)
+			}
+		}))
+
+		err := initAndCheck(&TykConf{URL: srv.URL, IsGateway: strings.HasPrefix(name, "gateway-"), LookupCacheSeconds: -1})
+		version := name[strings.LastIndex(name, "-")+1:]
+
+		switch {
+		case strings.HasPrefix(name, "unsupported-"):
+			if !IsUnsupportedVersion(err) || !strings.Contains(err.Error(), "Dashboard "+version) {
+				t.Errorf("%s: expected the version to be refused, got %v", name, err)
+			}
+			if Ready() != err {
+				t.Errorf("%s: an unsupported version should keep the client unusable", name)
+			}
+		case strings.HasPrefix(name, "mismatch-"):
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if _, err := ListManaged(); !IsUnsupportedVersion(err) {
+				t.Errorf("%s: expected the schema mismatch to be reported as an unsupported version, got %v", name, err)
+			}
+		default:
+			if err != nil || DetectedVersion() != version {
+				t.Fatalf("%s: expected version %s, got %q %v", name, version, DetectedVersion(), err)
+			}
+
+			apis, err := ListManaged()
+			if err != nil || len(apis) != 1 {
+				t.Fatalf("%s: expected the recorded API, got %v %v", name, apis, err)
+			}
+
+			a := apis[0]
+			if a.Slug != "contract" || a.APIID != "c1" || a.Proxy.ListenPath != "/contract/" || a.Domain != "contract.example.com" ||
+				a.ConfigData[SourceKey] != "Ingress/default/contract" {
+				t.Errorf("%s: unexpected API %+v", name, a.APIDefinition)
+			}
+		}
+
+		srv.Close()
+	}
+
+	Init(&TykConf{IsGateway: true})
+}
+
+func TestParseVersion(t *testing.T) {
+	cases := map[string]bool{
+		"v2.9.4":        true,
+		"3":             false,
+		"v3.0.0":        false,
+		"v3.0.0-rc1":    false,
+		"4.3":           false,
+		"v10.1.0":       false,
+		"not a version": false,
+	}
+
+	for v, below := range cases {
+		if versionBelow(v, MinDashboardVersion) != below {
+			t.Errorf("expected %s below %s to be %v", v, MinDashboardVersion, below)
+		}
+	}
+}
+
+func TestDefinitionSchema(t *testing.T) {
+	oldCfg := cfg
+	defer func() {
+		cfg = oldCfg
+		versionMu.Lock()
+		detectedVersion = ""
+		versionMu.Unlock()
+	}()
+	cfg = &TykConf{}
+
+	unknown, err := checkSchema(&APIDefOptions{Slug: "web"}, `{
+		"name": "web",
+		"graphql": {"enabled": true},
+		"cors": {"enable": true, "max_age_x": 1},
+		"proxy": {"listen_path": "/web/", "preserve_host": true},
+		"version_data": {"versions": {"Default": {"name": "Default", "paths_x": []}}},
+		"response_processors": [{"name": "header_injector", "when": "always"}],
+		"config_data": {"anything": {"goes": true}}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"cors.max_age_x", "graphql", "proxy.preserve_host", "response_processors.when", "version_data.versions.Default.paths_x"}
+	if strings.Join(unknown, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, unknown)
+	}
+
+	oas := `{"openapi": "3.0.3", "info": {"title": "web"}, "x-tyk-api-gateway": {}}`
+	versionMu.Lock()
+	detectedVersion = "v3.2.0"
+	versionMu.Unlock()
+	if _, err := checkSchema(&APIDefOptions{}, oas); err == nil || !strings.Contains(err.Error(), "Dashboard v3.2.0 doesn't support OAS") {
+		t.Fatal("expected OAS to be refused for the version, got ", err)
+	}
+
+	versionMu.Lock()
+	detectedVersion = "v5.0.0"
+	versionMu.Unlock()
+	if _, err := checkSchema(&APIDefOptions{}, oas); err == nil || !strings.Contains(err.Error(), "can't be published") {
+		t.Fatal("expected OAS to be refused, got ", err)
+	}
+}
+
+func TestDefinitionCompat(t *testing.T) {
+	oldCfg := cfg
+	defer func() {
+		cfg = oldCfg
+		versionMu.Lock()
+		detectedVersion = ""
+		versionMu.Unlock()
+	}()
+	cfg = &TykConf{
+		TagVersions:  map[string]string{"edge-old": "v2.5.0"},
+		CompatFields: map[string]string{"version_data.versions.*.extended_paths.validate_json": "v2.7.0"},
+	}
+	if err := validateCompat(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	def := `{
+		"name": "web",
+		"tags": ["edge-old"],
+		"auth_configs": {"authToken": {"auth_header_name": "X-Key"}},
+		"use_mutual_tls_auth": false,
+		"client_certificates": [],
+		"pinned_public_keys": {"*": "abc"},
+		"proxy": {"listen_path": "/web/"},
+		"version_data": {"versions": {"Default": {"extended_paths": {"validate_json": [{"path": "/"}]}}}}
+	}`
+
+	out, changed := applyCompat(&APIDefOptions{Slug: "web"}, def)
+	expected := []string{"auth_configs", "pinned_public_keys", "version_data.versions.Default.extended_paths.validate_json"}
+	if strings.Join(changed, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, changed)
+	}
+
+	ad := &apidef.APIDefinition{}
+	if err := json.Unmarshal([]byte(out), ad); err != nil {
+		t.Fatal(err)
+	}
+	if ad.Auth.AuthHeaderName != "X-Key" || len(ad.PinnedPublicKeys) > 0 || ad.Proxy.ListenPath != "/web/" {
+		t.Fatalf("unexpected definition %s", out)
+	}
+	if !strings.Contains(out, "use_mutual_tls_auth") || strings.Contains(out, "validate_json") {
+		t.Fatalf("expected only the fields of newer versions to be removed, got %s", out)
+	}
+
+	// newer targets keep the fields, the downgrade still applies
+	versionMu.Lock()
+	detectedVersion = "v3.2.0"
+	versionMu.Unlock()
+	_, changed = applyCompat(&APIDefOptions{}, `{"pinned_public_keys": {"*": "abc"}, "auth_configs": {}}`)
+	if strings.Join(changed, ",") != "auth_configs" {
+		t.Fatalf("expected only the downgrade, got %v", changed)
+	}
+
+	cfg.TargetVersion = "v2.3.0"
+	_, changed = applyCompat(&APIDefOptions{}, `{"client_certificates": ["a"]}`)
+	if strings.Join(changed, ",") != "client_certificates" {
+		t.Fatalf("expected the configured target to apply, got %v", changed)
+	}
+
+	if err := validateCompat(&TykConf{TagVersions: map[string]string{"edge": "latest"}}); err == nil {
+		t.Fatal("expected an invalid version to be refused")
+	}
+}
+
+func TestRenderedOrg(t *testing.T) {
+	oldCfg := cfg
+	defer func() { cfg = oldCfg }()
+	Init(&TykConf{Org: "main", IsGateway: true})
+
+	opts := func(ann map[string]string) *APIDefOptions {
+		return &APIDefOptions{Name: "web", Slug: "web", ListenPath: "/", Target: "http://web", Annotations: ann, AllowedOrgs: []string{"bu-retail"}}
+	}
+
+	if _, err := renderDefinition(opts(nil)); err != nil {
+		t.Fatal("the template org should be allowed, got ", err)
+	}
+
+	def, err := renderDefinition(opts(map[string]string{"string.service.tyk.io/org_id": "bu-retail"}))
+	if err != nil || def.OrgID != "bu-retail" {
+		t.Fatal("allowed orgs should be rendered, got ", err)
+	}
+
+	// the final org is checked whatever annotation changed it
+	patch := `[{"op": "copy", "from": "/name", "path": "/org_id"}]`
+	if _, err := renderDefinition(opts(map[string]string{"tyk.io/json-patch": patch})); err == nil || !strings.Contains(err.Error(), "not in the allowed orgs") {
+		t.Fatal("expected the rendered org to be refused, got ", err)
+	}
+}
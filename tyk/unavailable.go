@@ -0,0 +1,45 @@
+package tyk
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// unavailableMessages are matched against errors that have lost their type, e.g. the
+// aggregated errors from UpdateAPIs or status errors from the API clients
+var unavailableMessages = []string{
+	"connection refused",
+	"no such host",
+	"i/o timeout",
+	"connection reset",
+	"code: 502",
+	"code: 503",
+	"code: 504",
+	shuttingDownMessage,
+}
+
+// IsUnavailable reports whether the error means the Dashboard or gateway could not be
+// reached, as opposed to the request being rejected
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if uErr, ok := err.(*url.Error); ok {
+		err = uErr.Err
+	}
+
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, m := range unavailableMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,202 @@
+package tyk
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The version of the Dashboard or gateway is read from its health endpoint at start up,
+// versions older than the ones the contract tests in testdata/contract cover are refused. List responses
+// that no longer decode are reported as unsupported versions too, so a schema change shows
+// up as such instead of as a JSON error
+const (
+	MinDashboardVersion = "v3.0.0"
+	MinGatewayVersion   = "v3.0.0"
+
+	versionEndpoint    = "/hello"
+	unsupportedMessage = "version is not supported"
+)
+
+// VersionError is a Dashboard or gateway the controller can't work with, Version is empty
+// when it couldn't be detected
+type VersionError struct {
+	Component string
+	Version   string
+	Min       string
+	Err       error
+}
+
+func (e *VersionError) Error() string {
+	v := e.Version
+	if v == "" {
+		v = "(unknown)"
+	}
+
+	if e.Err != nil {
+		return fmt.Sprintf("%s %s %s: %v", e.Component, v, unsupportedMessage, e.Err)
+	}
+
+	return fmt.Sprintf("%s %s %s, %s or later is required", e.Component, v, unsupportedMessage, e.Min)
+}
+
+// IsUnsupportedVersion reports whether the error is a version error, the message is
+// matched as well for the aggregated errors from UpdateAPIs
+func IsUnsupportedVersion(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if _, ok := err.(*VersionError); ok {
+		return true
+	}
+
+	return strings.Contains(err.Error(), unsupportedMessage)
+}
+
+type helloResponse struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+}
+
+var versionClient = &http.Client{Timeout: 10 * time.Second}
+
+var versionMu = sync.RWMutex{}
+var detectedVersion string
+
+// DetectedVersion returns the version found at Init, empty when it wasn't detected
+func DetectedVersion() string {
+	versionMu.RLock()
+	defer versionMu.RUnlock()
+	return detectedVersion
+}
+
+func component(c *TykConf) (string, string) {
+	if c.IsGateway {
+		return "gateway", MinGatewayVersion
+	}
+
+	return "Dashboard", MinDashboardVersion
+}
+
+var semver = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseVersion reads the major, minor and patch numbers, pre-release suffixes are ignored
+func parseVersion(v string) ([3]int, bool) {
+	n := [3]int{}
+	m := semver.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return n, false
+	}
+
+	for i := 0; i < 3; i++ {
+		n[i], _ = strconv.Atoi(m[i+1])
+	}
+
+	return n, true
+}
+
+func versionBelow(v, min string) bool {
+	a, ok := parseVersion(v)
+	b, _ := parseVersion(min)
+	if !ok {
+		return false
+	}
+
+	for i := 0; i < 3; i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+
+	return false
+}
+
+// fetchVersion returns the version the health endpoint reports, empty when it has none.
+// Versions before the endpoint reported them can't be told apart from newer ones behind
+// proxies that hide it, both are allowed
+func fetchVersion(c *TykConf) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(c.URL, "/")+versionEndpoint, nil)
+	if err != nil {
+		return "", err
+	}
+
+	cl := versionClient
+	if c.InsecureSkipVerify {
+		cl = &http.Client{
+			Timeout:   versionClient.Timeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}
+	}
+
+	resp, err := cl.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil
+	}
+
+	h := &helloResponse{}
+	if err := json.Unmarshal(body, h); err != nil {
+		return "", nil
+	}
+
+	return h.Version, nil
+}
+
+// checkVersion detects the version after Init, an unreachable endpoint fails the check so
+// it is retried like any other unavailable Dashboard
+func checkVersion(c *TykConf) error {
+	if c.SkipVersionCheck {
+		return nil
+	}
+
+	name, min := component(c)
+	v, err := fetchVersion(c)
+	if err != nil {
+		return fmt.Errorf("failed to detect %s version: %v", name, err)
+	}
+
+	versionMu.Lock()
+	detectedVersion = v
+	versionMu.Unlock()
+
+	if v == "" {
+		log.Warningf("%s version could not be detected, assuming it is supported", name)
+		return nil
+	}
+
+	if versionBelow(v, min) {
+		return &VersionError{Component: name, Version: v, Min: min}
+	}
+
+	log.Infof("connected to %s %s", name, v)
+	return nil
+}
+
+// schemaError turns a list response that doesn't decode into the expected types into a
+// version error
+func schemaError(err error) error {
+	c := conf()
+	if _, ok := err.(*json.UnmarshalTypeError); !ok || c == nil {
+		return err
+	}
+
+	name, min := component(c)
+	return &VersionError{Component: name, Version: DetectedVersion(), Min: min, Err: fmt.Errorf("unexpected API list: %v", err)}
+}
@@ -0,0 +1,107 @@
+package webserver
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultAdminAddr = "127.0.0.1:9798"
+	defaultDebugAddr = "127.0.0.1:6060"
+)
+
+var admin *WebServer
+var debug *WebServer
+
+// Admin serves the operational endpoints, they change the controller and show what it
+// renders so they aren't served next to the webhooks, which are reachable by the API
+// server and usually the whole cluster
+func Admin() *WebServer {
+	if admin == nil {
+		admin = newServer(nil)
+	}
+
+	return admin
+}
+
+// ConfigAdmin configures the admin listener from the server config, addresses other
+// pods can reach are refused without a token
+func ConfigAdmin(cfg *Config) error {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	addr := cfg.AdminAddr
+	if addr == "" {
+		addr = defaultAdminAddr
+	}
+
+	if cfg.AdminToken == "" && !isLoopback(addr) {
+		return fmt.Errorf("adminAddr %s can be reached from outside the pod, set adminToken", addr)
+	}
+
+	Admin().Config(&Config{Addr: addr, CertFile: cfg.CertFile, KeyFile: cfg.KeyFile})
+	Admin().token = cfg.AdminToken
+	return nil
+}
+
+// Debug serves pprof and the runtime stats, profiles expose the internals of the process
+// so they are only served to the pod itself
+func Debug() *WebServer {
+	if debug == nil {
+		debug = newServer(nil)
+	}
+
+	return debug
+}
+
+// ConfigDebug configures the debug listener from the server config
+func ConfigDebug(cfg *Config) error {
+	addr := defaultDebugAddr
+	if cfg != nil && cfg.DebugAddr != "" {
+		addr = cfg.DebugAddr
+	}
+
+	if !isLoopback(addr) {
+		return fmt.Errorf("debugAddr %s is not a loopback address", addr)
+	}
+
+	Debug().Config(&Config{Addr: addr})
+	return nil
+}
+
+// isLoopback tells if a listen address only accepts connections from the host
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *WebServer) handler() http.Handler {
+	if s.token == "" {
+		return s.mux
+	}
+
+	expected := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(strings.TrimSpace(r.Header.Get("Authorization")))
+		if subtle.ConstantTimeCompare(got, expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		s.mux.ServeHTTP(w, r)
+	})
+}
@@ -19,6 +19,15 @@ type Config struct {
 	Addr     string `yaml:"addr"`     // webhook server port
 	CertFile string `yaml:"certFile"` // path to the x509 certificate for https
 	KeyFile  string `yaml:"keyFile"`  // path to the x509 private key matching `CertFile`
+	Debug    bool   `yaml:"debug"`    // serve pprof and runtime stats under /debug
+	// DebugAddr is the listener of the debug routes, it must be a loopback address and
+	// defaults to 127.0.0.1:6060
+	DebugAddr string `yaml:"debugAddr"`
+	// AdminAddr serves the operational endpoints apart from the webhooks, defaults to
+	// 127.0.0.1:9798. Addresses reachable from outside the pod need AdminToken
+	AdminAddr string `yaml:"adminAddr"`
+	// AdminToken is the bearer token the admin endpoints require when set
+	AdminToken string `yaml:"adminToken"`
 }
 
 type WebServer struct {
@@ -26,6 +35,8 @@ type WebServer struct {
 	mux    *mux.Router
 	cfg    *Config
 	srv    *http.Server
+	// token is required as a bearer token on every route when set
+	token string
 }
 
 func newServer(cfg *Config) *WebServer {
@@ -66,7 +77,7 @@ func (s *WebServer) Start() {
 
 	srv := &http.Server{
 		Addr:    s.cfg.Addr,
-		Handler: s.mux,
+		Handler: s.handler(),
 	}
 
 	s.srv = srv
@@ -80,7 +91,12 @@ func (s *WebServer) Start() {
 }
 
 func (s *WebServer) Stop() error {
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	if s.srv == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 	err := s.srv.Shutdown(ctx)
 	if err != nil {
 		return err
@@ -2,6 +2,7 @@ package webserver
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -34,3 +35,56 @@ func TestServer(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestAdmin(t *testing.T) {
+	defer func() { admin = nil }()
+
+	if err := ConfigAdmin(&Config{AdminAddr: ":9798"}); err == nil {
+		t.Fatal("expected an address reachable from other pods to need a token")
+	}
+
+	for _, addr := range []string{"", "localhost:9798", "[::1]:9798"} {
+		if err := ConfigAdmin(&Config{AdminAddr: addr}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ConfigAdmin(&Config{AdminAddr: ":9798", AdminToken: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	Admin().AddRoute("GET", "/plan", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	})
+
+	srv := httptest.NewServer(Admin().handler())
+	defer srv.Close()
+
+	for auth, code := range map[string]int{"": 401, "Bearer wrong": 401, "Bearer secret": 200} {
+		req, _ := http.NewRequest("GET", srv.URL+"/plan", nil)
+		req.Header.Set("Authorization", auth)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != code {
+			t.Errorf("expected %d with %q, got %d", code, auth, res.StatusCode)
+		}
+	}
+}
+
+func TestDebug(t *testing.T) {
+	defer func() { debug = nil }()
+
+	for _, addr := range []string{":6060", "0.0.0.0:6060", "10.0.0.1:6060"} {
+		if err := ConfigDebug(&Config{DebugAddr: addr}); err == nil {
+			t.Errorf("expected %s to be refused", addr)
+		}
+	}
+
+	if err := ConfigDebug(&Config{}); err != nil || Debug().cfg.Addr != defaultDebugAddr {
+		t.Fatal("expected the default loopback address, got ", err)
+	}
+}